@@ -0,0 +1,96 @@
+package accounts
+
+/*
+lists the AWS accounts a check runs in, each account is reached by assuming
+a role in it
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+)
+
+// Account is reached by assuming RoleArn, the zero Account uses the
+// credentials of the session
+type Account struct {
+	Alias   string
+	ID      string
+	RoleArn string
+}
+
+// Label identifies the account in check output, alias:id when the account
+// has an alias
+func (a Account) Label() string {
+	if len(a.Alias) == 0 {
+		return a.ID
+	}
+	return fmt.Sprintf("%s:%s", a.Alias, a.ID)
+}
+
+// Options lists the accounts a check runs in, zero values run the check with
+// the credentials of the session only
+type Options struct {
+	// RoleArns are assumed one per account, the account ID is taken from the ARN
+	RoleArns []string
+	// File is a JSON object mapping account aliases to role ARNs
+	File string
+}
+
+// PluginConfigOptions returns the account options
+func (o *Options) PluginConfigOptions() []*sensu.PluginConfigOption {
+	return []*sensu.PluginConfigOption{
+		{Path: "account-role-arns", Env: "AWS_ACCOUNT_ROLE_ARNS", Argument: "account-role-arns", Default: []string{}, Usage: "Comma separated list of role ARNs, the check runs once per account with the role assumed", Value: &o.RoleArns},
+		{Path: "accounts-file", Env: "AWS_ACCOUNTS_FILE", Argument: "accounts-file", Default: "", Usage: "JSON file mapping account aliases to role ARNs", Value: &o.File},
+	}
+}
+
+// List returns the accounts of File, sorted by alias, followed by the
+// accounts of RoleArns. It returns no account when neither is set
+func (o *Options) List() ([]Account, error) {
+	accounts := []Account{}
+	if len(o.File) > 0 {
+		data, err := ioutil.ReadFile(o.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read accounts file: %v", err)
+		}
+		aliases := make(map[string]string)
+		if err := json.Unmarshal(data, &aliases); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal accounts file %s: %v", o.File, err)
+		}
+		names := []string{}
+		for alias := range aliases {
+			names = append(names, alias)
+		}
+		sort.Strings(names)
+		for _, alias := range names {
+			account, err := New(aliases[alias])
+			if err != nil {
+				return nil, err
+			}
+			account.Alias = alias
+			accounts = append(accounts, account)
+		}
+	}
+	for _, roleArn := range o.RoleArns {
+		account, err := New(roleArn)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, nil
+}
+
+// New returns the account of roleArn
+func New(roleArn string) (Account, error) {
+	parts := strings.SplitN(roleArn, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "iam" || len(parts[4]) == 0 {
+		return Account{}, fmt.Errorf("invalid role arn %q", roleArn)
+	}
+	return Account{ID: parts[4], RoleArn: roleArn}, nil
+}
@@ -0,0 +1,80 @@
+package accounts
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	file, err := ioutil.TempFile("", "accounts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(`{"staging": "arn:aws:iam::222222222222:role/sensu", "prod": "arn:aws:iam::111111111111:role/sensu"}`)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Name        string
+		Options     Options
+		ExpAccounts []Account
+		ExpError    bool
+	}{
+		{
+			Name:        "no accounts",
+			ExpAccounts: []Account{},
+		},
+		{
+			Name:    "role arns",
+			Options: Options{RoleArns: []string{"arn:aws:iam::333333333333:role/sensu"}},
+			ExpAccounts: []Account{
+				{ID: "333333333333", RoleArn: "arn:aws:iam::333333333333:role/sensu"},
+			},
+		},
+		{
+			Name:    "accounts file and role arns",
+			Options: Options{File: file.Name(), RoleArns: []string{"arn:aws:iam::333333333333:role/sensu"}},
+			ExpAccounts: []Account{
+				{Alias: "prod", ID: "111111111111", RoleArn: "arn:aws:iam::111111111111:role/sensu"},
+				{Alias: "staging", ID: "222222222222", RoleArn: "arn:aws:iam::222222222222:role/sensu"},
+				{ID: "333333333333", RoleArn: "arn:aws:iam::333333333333:role/sensu"},
+			},
+		},
+		{
+			Name:     "invalid role arn",
+			Options:  Options{RoleArns: []string{"sensu"}},
+			ExpError: true,
+		},
+		{
+			Name:     "missing accounts file",
+			Options:  Options{File: file.Name() + ".missing"},
+			ExpError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			accounts, err := test.Options.List()
+			if got, want := err != nil, test.ExpError; got != want {
+				t.Fatalf("conflicting error expectations: got (err != nil) == %v, want %v", got, want)
+			}
+			if got, want := accounts, test.ExpAccounts; !test.ExpError && !reflect.DeepEqual(got, want) {
+				t.Errorf("bad accounts: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLabel(t *testing.T) {
+	if got, want := (Account{ID: "111111111111"}).Label(), "111111111111"; got != want {
+		t.Errorf("bad label: got %q, want %q", got, want)
+	}
+	if got, want := (Account{Alias: "prod", ID: "111111111111"}).Label(), "prod:111111111111"; got != want {
+		t.Errorf("bad label: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,214 @@
+package aws_session
+
+/*
+builds aws sessions from a set of options covering shared config profiles,
+chained assume role, MFA, web identity tokens and custom endpoints
+*/
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+)
+
+// Override is merged into the config of every session created by New, after
+// the options. Tests set it to point every client at a fake endpoint with
+// static credentials
+var Override *aws.Config
+
+// Options configures the session returned by New, zero values fall back to
+// the default AWS credential chain
+type Options struct {
+	Region string
+	// Profile selects a profile from the shared config and credentials files
+	Profile string
+	// RoleArns are assumed in order, each one with the credentials of the previous
+	RoleArns []string
+	// ExternalID is passed when assuming the last role of RoleArns and the
+	// role of every account of the regions package
+	ExternalID string
+	// RoleSessionName defaults to sensu-aws-<unix time>
+	RoleSessionName string
+	// SessionDuration of assumed role credentials in seconds, 0 uses the STS default
+	SessionDuration int
+	// MFASerial and MFAToken are passed when assuming the first role of RoleArns
+	MFASerial string
+	MFAToken  string
+	// WebIdentityTokenFile and WebIdentityRoleArn default to the
+	// AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN environment variables
+	WebIdentityTokenFile string
+	WebIdentityRoleArn   string
+	// MaxRetries of failed and throttled calls, RetryMinDelay and
+	// RetryMaxDelay bound the jittered backoff between them in milliseconds
+	MaxRetries    int
+	RetryMinDelay int
+	RetryMaxDelay int
+	// RateLimit caps the calls per second of every session of the process,
+	// 0 disables the limit
+	RateLimit float64
+	// Endpoint overrides the service endpoint URL of every client, S3 buckets
+	// are addressed by path as custom endpoints rarely resolve bucket subdomains
+	Endpoint string
+}
+
+// New creates a session from opts
+func New(opts Options) (*session.Session, error) {
+	// a custom CA bundle is installed on the HTTP client of the session, a
+	// client of its own keeps sessions created concurrently from sharing
+	// http.DefaultClient
+	config := aws.Config{HTTPClient: &http.Client{}}
+	if len(opts.Region) > 0 {
+		config.Region = aws.String(opts.Region)
+	}
+	if len(opts.Endpoint) > 0 {
+		config.Endpoint = aws.String(opts.Endpoint)
+		config.S3ForcePathStyle = aws.Bool(true)
+	}
+	minDelay := time.Duration(opts.RetryMinDelay) * time.Millisecond
+	maxDelay := time.Duration(opts.RetryMaxDelay) * time.Millisecond
+	if maxDelay < minDelay {
+		maxDelay = minDelay
+	}
+	config.Retryer = Retryer{NumMaxRetries: opts.MaxRetries, MinDelay: minDelay, MaxDelay: maxDelay}
+	if Override != nil {
+		config.MergeIn(Override)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            config,
+		Profile:           opts.Profile,
+		SharedConfigState: session.SharedConfigEnable,
+		AssumeRoleTokenProvider: func() (string, error) {
+			if len(opts.MFAToken) == 0 {
+				return "", fmt.Errorf("profile %q requires an MFA token", opts.Profile)
+			}
+			return opts.MFAToken, nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create aws session: %v", err)
+	}
+	if opts.RateLimit > 0 {
+		limiter := sharedLimiter(opts.RateLimit)
+		sess.Handlers.Send.PushFront(func(*request.Request) {
+			limiter.Wait()
+		})
+	}
+	sess.Handlers.AfterRetry.PushBack(throttled)
+
+	tokenFile, webIdentityRoleArn := opts.WebIdentityTokenFile, opts.WebIdentityRoleArn
+	if len(tokenFile) == 0 {
+		tokenFile = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+	if len(webIdentityRoleArn) == 0 {
+		webIdentityRoleArn = os.Getenv("AWS_ROLE_ARN")
+	}
+	if len(tokenFile) > 0 {
+		if len(webIdentityRoleArn) == 0 {
+			return nil, fmt.Errorf("web identity token file %q requires a role arn", tokenFile)
+		}
+		provider := &webIdentityProvider{
+			client:          sts.New(sess),
+			roleArn:         webIdentityRoleArn,
+			roleSessionName: opts.SessionName(),
+			tokenFile:       tokenFile,
+			duration:        opts.SessionDuration,
+		}
+		sess = sess.Copy(&aws.Config{Credentials: credentials.NewCredentials(provider)})
+	}
+
+	for i, roleArn := range opts.RoleArns {
+		first, last := i == 0, i == len(opts.RoleArns)-1
+		credentials := stscreds.NewCredentials(sess, roleArn, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = opts.SessionName()
+			if opts.SessionDuration > 0 {
+				p.Duration = time.Duration(opts.SessionDuration) * time.Second
+			}
+			if last && len(opts.ExternalID) > 0 {
+				p.ExternalID = aws.String(opts.ExternalID)
+			}
+			if first && len(opts.MFASerial) > 0 {
+				p.SerialNumber = aws.String(opts.MFASerial)
+				p.TokenCode = aws.String(opts.MFAToken)
+			}
+		})
+		sess = sess.Copy(&aws.Config{Credentials: credentials})
+	}
+	return sess, nil
+}
+
+// SessionName returns RoleSessionName, sensu-aws-<unix time> when empty
+func (opts Options) SessionName() string {
+	if len(opts.RoleSessionName) > 0 {
+		return opts.RoleSessionName
+	}
+	return fmt.Sprintf("sensu-aws-%d", time.Now().Unix())
+}
+
+// PluginConfigOptions returns the session options, except the region which
+// every plugin defines itself
+func (opts *Options) PluginConfigOptions() []*sensu.PluginConfigOption {
+	return []*sensu.PluginConfigOption{
+		{Path: "profile", Env: "AWS_PROFILE", Argument: "profile", Default: "", Usage: "AWS shared config profile", Value: &opts.Profile},
+		{Path: "role-arn", Env: "AWS_ASSUME_ROLE_ARN", Argument: "role-arn", Default: []string{}, Usage: "Role ARN(s) to assume, several ARNs are assumed in order (role chaining)", Value: &opts.RoleArns},
+		{Path: "external-id", Env: "AWS_EXTERNAL_ID", Argument: "external-id", Default: "", Usage: "External ID used when assuming the last role and the account roles", Value: &opts.ExternalID},
+		{Path: "role-session-name", Env: "AWS_ROLE_SESSION_NAME", Argument: "role-session-name", Default: "", Usage: "Session name used when assuming roles", Value: &opts.RoleSessionName},
+		{Path: "session-duration", Env: "AWS_SESSION_DURATION", Argument: "session-duration", Default: 0, Usage: "Duration of assumed role sessions in seconds", Value: &opts.SessionDuration},
+		{Path: "mfa-serial", Env: "AWS_MFA_SERIAL", Argument: "mfa-serial", Default: "", Usage: "MFA device serial number used when assuming the first role", Value: &opts.MFASerial},
+		{Path: "mfa-token", Env: "AWS_MFA_TOKEN", Argument: "mfa-token", Default: "", Usage: "MFA token code", Value: &opts.MFAToken},
+		{Path: "web-identity-token-file", Env: "AWS_WEB_IDENTITY_TOKEN_FILE", Argument: "web-identity-token-file", Default: "", Usage: "Web identity token file", Value: &opts.WebIdentityTokenFile},
+		{Path: "web-identity-role-arn", Env: "AWS_ROLE_ARN", Argument: "web-identity-role-arn", Default: "", Usage: "Role ARN assumed with the web identity token", Value: &opts.WebIdentityRoleArn},
+		{Path: "max-retries", Env: "AWS_MAX_RETRIES", Argument: "max-retries", Default: DefaultMaxRetries, Usage: "Maximum number of retries of failed and throttled AWS API calls", Value: &opts.MaxRetries},
+		{Path: "retry-min-delay", Env: "AWS_RETRY_MIN_DELAY", Argument: "retry-min-delay", Default: DefaultRetryMinDelay, Usage: "Base delay in milliseconds of the jittered exponential backoff between retries", Value: &opts.RetryMinDelay},
+		{Path: "retry-max-delay", Env: "AWS_RETRY_MAX_DELAY", Argument: "retry-max-delay", Default: DefaultRetryMaxDelay, Usage: "Maximum delay in milliseconds between retries", Value: &opts.RetryMaxDelay},
+		{Path: "rate-limit", Env: "AWS_RATE_LIMIT", Argument: "rate-limit", Default: float64(0), Usage: "Maximum number of AWS API calls per second across all regions and accounts, 0 for no limit", Value: &opts.RateLimit},
+		{Path: "endpoint-url", Env: "AWS_ENDPOINT_URL", Argument: "endpoint-url", Default: "", Usage: "Custom AWS service endpoint URL", Value: &opts.Endpoint},
+	}
+}
+
+// webIdentityProvider exchanges the token in tokenFile for role credentials,
+// the file is read again on every refresh as the token is rotated
+type webIdentityProvider struct {
+	credentials.Expiry
+	client          *sts.STS
+	roleArn         string
+	roleSessionName string
+	tokenFile       string
+	duration        int
+}
+
+func (p *webIdentityProvider) Retrieve() (credentials.Value, error) {
+	token, err := ioutil.ReadFile(p.tokenFile)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("failed to read web identity token file: %v", err)
+	}
+	input := &sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.roleArn),
+		RoleSessionName:  aws.String(p.roleSessionName),
+		WebIdentityToken: aws.String(strings.TrimSpace(string(token))),
+	}
+	if p.duration > 0 {
+		input.DurationSeconds = aws.Int64(int64(p.duration))
+	}
+	output, err := p.client.AssumeRoleWithWebIdentity(input)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("failed to assume role %s with web identity: %v", p.roleArn, err)
+	}
+	p.SetExpiration(*output.Credentials.Expiration, time.Minute)
+	return credentials.Value{
+		AccessKeyID:     *output.Credentials.AccessKeyId,
+		SecretAccessKey: *output.Credentials.SecretAccessKey,
+		SessionToken:    *output.Credentials.SessionToken,
+		ProviderName:    "WebIdentityProvider",
+	}, nil
+}
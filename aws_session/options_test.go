@@ -0,0 +1,106 @@
+package aws_session
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestNew(t *testing.T) {
+	os.Unsetenv("AWS_ROLE_ARN")
+	os.Unsetenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+
+	tests := []struct {
+		Name        string
+		Options     Options
+		ExpError    bool
+		ExpRegion   string
+		ExpEndpoint string
+	}{
+		{
+			Name:      "region only",
+			Options:   Options{Region: "eu-west-1"},
+			ExpRegion: "eu-west-1",
+		},
+		{
+			Name:        "custom endpoint",
+			Options:     Options{Region: "us-east-1", Endpoint: "http://127.0.0.1:4566"},
+			ExpRegion:   "us-east-1",
+			ExpEndpoint: "http://127.0.0.1:4566",
+		},
+		{
+			Name: "chained roles",
+			Options: Options{
+				Region:     "us-east-1",
+				RoleArns:   []string{"arn:aws:iam::111111111111:role/a", "arn:aws:iam::222222222222:role/b"},
+				ExternalID: "id",
+			},
+			ExpRegion: "us-east-1",
+		},
+		{
+			Name:     "web identity without role",
+			Options:  Options{Region: "us-east-1", WebIdentityTokenFile: "/tmp/token"},
+			ExpError: true,
+		},
+		{
+			Name:      "web identity",
+			Options:   Options{Region: "us-east-1", WebIdentityTokenFile: "/tmp/token", WebIdentityRoleArn: "arn:aws:iam::111111111111:role/a"},
+			ExpRegion: "us-east-1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			sess, err := New(test.Options)
+			if got, want := err != nil, test.ExpError; got != want {
+				t.Fatalf("conflicting error expectations: got (err != nil) == %v, want %v", got, want)
+			}
+			if err != nil {
+				return
+			}
+			if got, want := *sess.Config.Region, test.ExpRegion; got != want {
+				t.Errorf("bad region: got %q, want %q", got, want)
+			}
+			if len(test.ExpEndpoint) > 0 {
+				if got, want := *sess.Config.Endpoint, test.ExpEndpoint; got != want {
+					t.Errorf("bad endpoint: got %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestOverride(t *testing.T) {
+	Override = aws.NewConfig().WithEndpoint("http://127.0.0.1:4566").WithRegion("eu-west-1")
+	defer func() { Override = nil }()
+
+	sess, err := New(Options{Region: "us-east-1", Endpoint: "http://127.0.0.1:9000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := *sess.Config.Endpoint, "http://127.0.0.1:4566"; got != want {
+		t.Errorf("bad endpoint: got %q, want %q", got, want)
+	}
+	if got, want := *sess.Config.Region, "eu-west-1"; got != want {
+		t.Errorf("bad region: got %q, want %q", got, want)
+	}
+	if !aws.BoolValue(sess.Config.S3ForcePathStyle) {
+		t.Error("expected path style S3 addressing with a custom endpoint")
+	}
+}
+
+func TestHTTPClientNotShared(t *testing.T) {
+	first, err := New(Options{Region: "us-east-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := New(Options{Region: "eu-west-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Config.HTTPClient == second.Config.HTTPClient || first.Config.HTTPClient == http.DefaultClient {
+		t.Error("expected every session to have an HTTP client of its own")
+	}
+}
@@ -0,0 +1,140 @@
+package aws_session
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+const (
+	// DefaultMaxRetries is the number of retries of a failed or throttled
+	// call used by the plugin options
+	DefaultMaxRetries = 3
+	// DefaultRetryMinDelay and DefaultRetryMaxDelay bound the backoff
+	// between retries used by the plugin options, in milliseconds
+	DefaultRetryMinDelay = 100
+	DefaultRetryMaxDelay = 20000
+)
+
+// Retryer retries failed and throttled calls with exponential backoff and
+// full jitter: the delay before retry n is random between zero and
+// MinDelay*2^n, capped at MaxDelay. A Retry-After header is honoured up to
+// MaxDelay
+type Retryer struct {
+	NumMaxRetries int
+	MinDelay      time.Duration
+	MaxDelay      time.Duration
+}
+
+// MaxRetries returns the number of retries of a call
+func (r Retryer) MaxRetries() int {
+	return r.NumMaxRetries
+}
+
+// ShouldRetry reports whether the call failed with a retryable error, as the
+// SDK default retryer does
+func (r Retryer) ShouldRetry(req *request.Request) bool {
+	return client.DefaultRetryer{NumMaxRetries: r.NumMaxRetries}.ShouldRetry(req)
+}
+
+// RetryRules returns the delay before retrying req
+func (r Retryer) RetryRules(req *request.Request) time.Duration {
+	if req.HTTPResponse != nil {
+		if seconds, err := strconv.Atoi(req.HTTPResponse.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			if delay := time.Duration(seconds) * time.Second; delay < r.MaxDelay {
+				return delay
+			}
+			return r.MaxDelay
+		}
+	}
+	ceiling := r.MaxDelay
+	if req.RetryCount < 32 {
+		if delay := r.MinDelay << uint(req.RetryCount); delay > 0 && delay < ceiling {
+			ceiling = delay
+		}
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// Limiter spaces calls evenly to stay under a rate, it is safe for
+// concurrent use
+type Limiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// NewLimiter returns a limiter allowing rate calls per second
+func NewLimiter(rate float64) *Limiter {
+	return &Limiter{interval: time.Duration(float64(time.Second) / rate)}
+}
+
+// Wait blocks until the next call is allowed
+func (l *Limiter) Wait() {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	time.Sleep(wait)
+}
+
+var (
+	limitersMu sync.Mutex
+	limiters   = make(map[float64]*Limiter)
+)
+
+// sharedLimiter returns the limiter of rate shared by every session of the
+// process, so that the rate holds across regions and accounts checked
+// concurrently
+func sharedLimiter(rate float64) *Limiter {
+	limitersMu.Lock()
+	defer limitersMu.Unlock()
+	limiter, ok := limiters[rate]
+	if !ok {
+		limiter = NewLimiter(rate)
+		limiters[rate] = limiter
+	}
+	return limiter
+}
+
+// IsThrottle reports whether err is a throttling error of an AWS API
+func IsThrottle(err error) bool {
+	awsErr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	if failure, ok := err.(awserr.RequestFailure); ok && failure.StatusCode() == 429 {
+		return true
+	}
+	return request.IsErrorThrottle(awsErr)
+}
+
+// throttled rewrites the error of calls still throttled after the last
+// retry, so that the check reports which call was throttled and how often
+// it was retried. It runs after the SDK retry handler, which clears the
+// error of calls it retries
+func throttled(req *request.Request) {
+	if req.Error == nil || !IsThrottle(req.Error) {
+		return
+	}
+	awsErr := req.Error.(awserr.Error)
+	message := fmt.Sprintf("throttled by %s %s after %d retries: %s", req.ClientInfo.ServiceName, req.Operation.Name, req.RetryCount, awsErr.Message())
+	if failure, ok := req.Error.(awserr.RequestFailure); ok {
+		req.Error = awserr.NewRequestFailure(awserr.New(awsErr.Code(), message, awsErr.OrigErr()), failure.StatusCode(), failure.RequestID())
+		return
+	}
+	req.Error = awserr.New(awsErr.Code(), message, awsErr.OrigErr())
+}
@@ -0,0 +1,111 @@
+package aws_session
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+func newRequest(err error, retryCount int) *request.Request {
+	req := request.New(aws.Config{}, metadata.ClientInfo{ServiceName: "monitoring"}, request.Handlers{}, nil,
+		&request.Operation{Name: "GetMetricStatistics"}, nil, nil)
+	req.Error = err
+	req.RetryCount = retryCount
+	req.HTTPResponse = &http.Response{StatusCode: 400, Header: http.Header{}}
+	return req
+}
+
+func TestRetryRules(t *testing.T) {
+	retryer := Retryer{NumMaxRetries: 5, MinDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	tests := []struct {
+		RetryCount int
+		RetryAfter string
+		ExpMax     time.Duration
+	}{
+		{RetryCount: 0, ExpMax: 10 * time.Millisecond},
+		{RetryCount: 1, ExpMax: 20 * time.Millisecond},
+		{RetryCount: 2, ExpMax: 40 * time.Millisecond},
+		{RetryCount: 3, ExpMax: 50 * time.Millisecond},
+		{RetryCount: 40, ExpMax: 50 * time.Millisecond},
+		{RetryCount: 0, RetryAfter: "10", ExpMax: 50 * time.Millisecond},
+	}
+	for _, test := range tests {
+		req := newRequest(nil, test.RetryCount)
+		req.HTTPResponse.Header.Set("Retry-After", test.RetryAfter)
+		for i := 0; i < 100; i++ {
+			if got := retryer.RetryRules(req); got < 0 || got > test.ExpMax {
+				t.Fatalf("bad delay for retry %d: got %v, want at most %v", test.RetryCount, got, test.ExpMax)
+			}
+		}
+		if len(test.RetryAfter) > 0 && retryer.RetryRules(req) != test.ExpMax {
+			t.Errorf("Retry-After not honoured: got %v, want %v", retryer.RetryRules(req), test.ExpMax)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(100)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		limiter.Wait()
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("calls not spaced: 6 calls at 100/s took %v", elapsed)
+	}
+	if sharedLimiter(100) != sharedLimiter(100) {
+		t.Error("expected the limiter of a rate to be shared")
+	}
+}
+
+func TestThrottled(t *testing.T) {
+	tests := []struct {
+		Name       string
+		Error      error
+		ExpMessage string
+		ExpCode    string
+	}{
+		{
+			Name:       "throttling",
+			Error:      awserr.NewRequestFailure(awserr.New("Throttling", "Rate exceeded", nil), 400, "id"),
+			ExpMessage: "throttled by monitoring GetMetricStatistics after 3 retries: Rate exceeded",
+			ExpCode:    "Throttling",
+		},
+		{
+			Name:       "too many requests",
+			Error:      awserr.NewRequestFailure(awserr.New("TooManyRequestsException", "slow down", nil), 429, "id"),
+			ExpMessage: "throttled by monitoring GetMetricStatistics after 3 retries: slow down",
+			ExpCode:    "TooManyRequestsException",
+		},
+		{
+			Name:       "other error",
+			Error:      awserr.New("AccessDenied", "denied", nil),
+			ExpMessage: "denied",
+			ExpCode:    "AccessDenied",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			req := newRequest(test.Error, 3)
+			throttled(req)
+			awsErr, ok := req.Error.(awserr.Error)
+			if !ok {
+				t.Fatalf("bad error type %T", req.Error)
+			}
+			if got, want := awsErr.Message(), test.ExpMessage; got != want {
+				t.Errorf("bad message: got %q, want %q", got, want)
+			}
+			if got, want := awsErr.Code(), test.ExpCode; got != want {
+				t.Errorf("bad code: got %q, want %q", got, want)
+			}
+		})
+	}
+	if IsThrottle(errors.New("Throttling")) {
+		t.Error("expected a plain error not to be a throttling error")
+	}
+}
@@ -10,13 +10,17 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 )
 
+// CreateAwsSession creates a session using the region of the default
+// credential chain (AWS_REGION or the shared config)
 func CreateAwsSession() *session.Session {
-
-	// Create a Session with a custom region
-	aws_session := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String("us-east-2"),
-	}))
-
+	aws_session, err := New(Options{
+		MaxRetries:    DefaultMaxRetries,
+		RetryMinDelay: DefaultRetryMinDelay,
+		RetryMaxDelay: DefaultRetryMaxDelay,
+	})
+	if err != nil {
+		panic(err)
+	}
 	return aws_session
 }
 
@@ -2,11 +2,13 @@ package awsclient
 
 /*
 creates iam,ec2,elb,rds,sts, cloudwatch, s3, alb client
-with valid awssession with roleArn support for rds and cloudwatch clients
+with valid awssession with roleArn support for every client
 */
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -22,232 +24,239 @@ import (
 	"github.com/aws/aws-sdk-go/service/sts"
 )
 
-func newIAM(awsSession *session.Session) *iam.IAM {
-	iamClient := iam.New(awsSession)
-	return iamClient
-}
-
-func newEC2(awsSession *session.Session) *ec2.EC2 {
-	ec2Client := ec2.New(awsSession)
-	return ec2Client
-}
-
-func newCloudWatch(awsSession *session.Session) *cloudwatch.CloudWatch {
-	cloudwatchClient := cloudwatch.New(awsSession)
-	return cloudwatchClient
-}
-
-func newS3(awsSession *session.Session) *s3.S3 {
-	s3Client := s3.New(awsSession)
-	return s3Client
-}
-
-func newRDS(awsSession *session.Session) *rds.RDS {
-	rdsClient := rds.New(awsSession)
-	return rdsClient
-}
-
-func newELB(awsSession *session.Session) *elb.ELB {
-	elbClient := elb.New(awsSession)
-	return elbClient
-}
-
-func newELBV2(awsSession *session.Session) *elbv2.ELBV2 {
-	elbv2Client := elbv2.New(awsSession)
-	return elbv2Client
-}
-
-func newSTS(awsSession *session.Session) *sts.STS {
-	stsClient := sts.New(awsSession)
-	return stsClient
-}
+// errorOutput receives the errors of the deprecated constructors, stdout is
+// the check output. It is replaced in tests
+var errorOutput io.Writer = os.Stderr
 
+// Deprecated: use Factory.ELB
 func GetElbClient(awsSession *session.Session) (bool, *elb.ELB) {
-	var elbClient *elb.ELB
-	if awsSession != nil {
-		elbClient = newELB(awsSession)
-	} else {
-		fmt.Println("Error while getting aws session")
-		return false, nil
-	}
-
-	if elbClient == nil {
-		fmt.Println("Error while getting elb client session")
+	client, err := NewFactory(awsSession).ELB()
+	if err != nil {
+		fmt.Fprintln(errorOutput, err)
 		return false, nil
 	}
-
-	return true, elbClient
+	return true, client
 }
 
+// Deprecated: use Factory.ELBV2
 func GetElbV2Client(awsSession *session.Session) (bool, *elbv2.ELBV2) {
-	var elbClient *elbv2.ELBV2
-	if awsSession != nil {
-		elbClient = newELBV2(awsSession)
-	} else {
-		fmt.Println("Error while getting aws session")
-		return false, nil
-	}
-
-	if elbClient == nil {
-		fmt.Println("Error while getting elbv2 client session")
+	client, err := NewFactory(awsSession).ELBV2()
+	if err != nil {
+		fmt.Fprintln(errorOutput, err)
 		return false, nil
 	}
-
-	return true, elbClient
+	return true, client
 }
 
+// Deprecated: use Factory.EC2
 func GetEC2Client(awsSession *session.Session) (bool, *ec2.EC2) {
-	var ec2Client *ec2.EC2
-	if awsSession != nil {
-		ec2Client = newEC2(awsSession)
-	} else {
-		fmt.Println("Error while getting aws session")
-		return false, nil
-	}
-
-	if ec2Client == nil {
-		fmt.Println("Error while getting ec2 client session")
+	client, err := NewFactory(awsSession).EC2()
+	if err != nil {
+		fmt.Fprintln(errorOutput, err)
 		return false, nil
 	}
-
-	return true, ec2Client
+	return true, client
 }
 
+// Deprecated: use Factory.CloudWatch
 func GetCloudWatchClient(awsSession *session.Session) (bool, *cloudwatch.CloudWatch) {
-	var cloudWatClient *cloudwatch.CloudWatch
-	if awsSession != nil {
-		cloudWatClient = newCloudWatch(awsSession)
-	} else {
-		fmt.Println("Error while getting aws session")
+	client, err := NewFactory(awsSession).CloudWatch()
+	if err != nil {
+		fmt.Fprintln(errorOutput, err)
 		return false, nil
 	}
+	return true, client
+}
 
-	if cloudWatClient == nil {
-		fmt.Println("Error while getting cloudwatch client session")
+// Deprecated: use Factory.RDS
+func GetRDSClient(awsSession *session.Session) (bool, *rds.RDS) {
+	client, err := NewFactory(awsSession).RDS()
+	if err != nil {
+		fmt.Fprintln(errorOutput, err)
 		return false, nil
 	}
-
-	return true, cloudWatClient
+	return true, client
 }
 
-func GetRDSClient(awsSession *session.Session) (bool, *rds.RDS) {
-	var rdsClient *rds.RDS
-	if awsSession != nil {
-		rdsClient = newRDS(awsSession)
-	} else {
-		fmt.Println("Error while getting aws session")
+// Deprecated: use Factory.S3
+func GetS3Client(awsSession *session.Session) (bool, *s3.S3) {
+	client, err := NewFactory(awsSession).S3()
+	if err != nil {
+		fmt.Fprintln(errorOutput, err)
 		return false, nil
 	}
+	return true, client
+}
 
-	if rdsClient == nil {
-		fmt.Println("Error while getting rds client session")
+// Deprecated: use Factory.IAM
+func GetIAMClient(awsSession *session.Session) (bool, *iam.IAM) {
+	client, err := NewFactory(awsSession).IAM()
+	if err != nil {
+		fmt.Fprintln(errorOutput, err)
 		return false, nil
 	}
-
-	return true, rdsClient
+	return true, client
 }
 
-func GetS3Client(awsSession *session.Session) (bool, *s3.S3) {
-	var s3Client *s3.S3
-	if awsSession != nil {
-		s3Client = newS3(awsSession)
-	} else {
-		fmt.Println("Error while getting aws session")
+// Deprecated: use Factory.ELB with Factory.RoleArn
+func GetElbClientWithRoleArn(awsSession *session.Session, roleArn string) (bool, *elb.ELB) {
+	factory := NewFactory(awsSession)
+	factory.RoleArn = roleArn
+	client, err := factory.ELB()
+	if err != nil {
+		fmt.Fprintln(errorOutput, err)
 		return false, nil
 	}
+	return true, client
+}
 
-	if s3Client == nil {
-		fmt.Println("Error while getting s3 client session")
+// Deprecated: use Factory.ELBV2 with Factory.RoleArn
+func GetElbV2ClientWithRoleArn(awsSession *session.Session, roleArn string) (bool, *elbv2.ELBV2) {
+	factory := NewFactory(awsSession)
+	factory.RoleArn = roleArn
+	client, err := factory.ELBV2()
+	if err != nil {
+		fmt.Fprintln(errorOutput, err)
 		return false, nil
 	}
-
-	return true, s3Client
+	return true, client
 }
 
-func getSTSClient(awsSession *session.Session) (bool, *sts.STS) {
-	var stsClient *sts.STS
-	if awsSession != nil {
-		stsClient = newSTS(awsSession)
-	} else {
-		fmt.Println("Error while getting aws session")
+// Deprecated: use Factory.EC2 with Factory.RoleArn
+func GetEC2ClientWithRoleArn(awsSession *session.Session, roleArn string) (bool, *ec2.EC2) {
+	factory := NewFactory(awsSession)
+	factory.RoleArn = roleArn
+	client, err := factory.EC2()
+	if err != nil {
+		fmt.Fprintln(errorOutput, err)
 		return false, nil
 	}
+	return true, client
+}
 
-	if stsClient == nil {
-		fmt.Println("Error while getting sts client session")
+// Deprecated: use Factory.CloudWatch with Factory.RoleArn
+func GetCloudWatchClientWithRoleArn(awsSession *session.Session, roleArn string) (bool, *cloudwatch.CloudWatch) {
+	factory := NewFactory(awsSession)
+	factory.RoleArn = roleArn
+	client, err := factory.CloudWatch()
+	if err != nil {
+		fmt.Fprintln(errorOutput, err)
 		return false, nil
 	}
-
-	return true, stsClient
+	return true, client
 }
 
+// Deprecated: use Factory.RDS with Factory.RoleArn
 func GetRDSClientWithRoleArn(awsSession *session.Session, roleArn string) (bool, *rds.RDS) {
-	suceess, stscredentials := getStsCredentials(awsSession, roleArn)
-	var rdsClient *rds.RDS
-	if !suceess {
+	factory := NewFactory(awsSession)
+	factory.RoleArn = roleArn
+	client, err := factory.RDS()
+	if err != nil {
+		fmt.Fprintln(errorOutput, err)
 		return false, nil
 	}
-	if stscredentials != nil {
-		provider := NewAssumeRoleCredentialsProvider(stscredentials)
-		rdsClient = rds.New(awsSession,
-			&aws.Config{Credentials: credentials.NewCredentials(provider)})
-	}
-	return false, rdsClient
+	return true, client
 }
 
-func GetCloudWatchClientWithRoleArn(awsSession *session.Session, roleArn string) (bool, *cloudwatch.CloudWatch) {
-	success, stscredentials := getStsCredentials(awsSession, roleArn)
-	var cloudWatchClient *cloudwatch.CloudWatch
-	if !success {
+// Deprecated: use Factory.S3 with Factory.RoleArn
+func GetS3ClientWithRoleArn(awsSession *session.Session, roleArn string) (bool, *s3.S3) {
+	factory := NewFactory(awsSession)
+	factory.RoleArn = roleArn
+	client, err := factory.S3()
+	if err != nil {
+		fmt.Fprintln(errorOutput, err)
 		return false, nil
 	}
-	if stscredentials != nil {
-		provider := NewAssumeRoleCredentialsProvider(stscredentials)
-		cloudWatchClient = cloudwatch.New(awsSession,
-			&aws.Config{Credentials: credentials.NewCredentials(provider)})
-	}
-	return false, cloudWatchClient
+	return true, client
 }
 
-func getStsCredentials(awsSession *session.Session, roleArn string) (bool, *sts.Credentials) {
-	success, stsClient := getSTSClient(awsSession)
-	if !success {
+// Deprecated: use Factory.IAM with Factory.RoleArn
+func GetIAMClientWithRoleArn(awsSession *session.Session, roleArn string) (bool, *iam.IAM) {
+	factory := NewFactory(awsSession)
+	factory.RoleArn = roleArn
+	client, err := factory.IAM()
+	if err != nil {
+		fmt.Fprintln(errorOutput, err)
 		return false, nil
 	}
-	roleInput := &sts.AssumeRoleInput{}
-	roleInput.RoleArn = aws.String(roleArn)
-	roleInput.RoleSessionName = aws.String(fmt.Sprintf("role@%v", time.Now().Unix()))
-	roleOutput, err := stsClient.AssumeRole(roleInput)
+	return true, client
+}
+
+// getRoleSession copies awsSession with the credentials of provider, the
+// credentials are retrieved once so that STS errors surface here
+func getRoleSession(awsSession *session.Session, provider *AssumeRoleCredentialsProvider) (*session.Session, error) {
+	roleCredentials := credentials.NewCredentials(provider)
+	if _, err := roleCredentials.Get(); err != nil {
+		return nil, err
+	}
+	return awsSession.Copy(&aws.Config{Credentials: roleCredentials}), nil
+}
+
+// GetAssumeRoleCredentials returns credentials for roleArn that are refreshed
+// through STS before they expire
+func GetAssumeRoleCredentials(awsSession *session.Session, roleArn string) (*credentials.Credentials, error) {
+	stsClient, err := NewFactory(awsSession).STS()
 	if err != nil {
-		return false, nil
+		return nil, err
 	}
-	if roleOutput != nil {
-		return true, roleOutput.Credentials
+	roleCredentials := credentials.NewCredentials(NewAssumeRoleCredentialsProvider(stsClient, roleArn))
+	if _, err := roleCredentials.Get(); err != nil {
+		return nil, err
 	}
-	return false, nil
+	return roleCredentials, nil
+}
+
+// AssumeRoler is the part of the STS client used by AssumeRoleCredentialsProvider
+type AssumeRoler interface {
+	AssumeRole(*sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error)
 }
 
-func NewAssumeRoleCredentialsProvider(credentials *sts.Credentials) *AssumeRoleCredentialsProvider {
+func NewAssumeRoleCredentialsProvider(client AssumeRoler, roleArn string) *AssumeRoleCredentialsProvider {
 	return &AssumeRoleCredentialsProvider{
-		AssumeRoleCredentials: credentials,
+		Client:       client,
+		RoleArn:      roleArn,
+		ExpiryWindow: time.Minute,
 	}
 }
 
+// AssumeRoleCredentialsProvider assumes RoleArn whenever the cached
+// credentials are within ExpiryWindow of their expiration
 type AssumeRoleCredentialsProvider struct {
-	AssumeRoleCredentials *sts.Credentials
+	credentials.Expiry
+	Client          AssumeRoler
+	RoleArn         string
+	RoleSessionName string
+	ExternalID      string
+	// Duration of the role session, 0 uses the STS default
+	Duration     time.Duration
+	ExpiryWindow time.Duration
 }
 
-func (c AssumeRoleCredentialsProvider) Retrieve() (credentials.Value, error) {
+func (c *AssumeRoleCredentialsProvider) Retrieve() (credentials.Value, error) {
+	roleInput := &sts.AssumeRoleInput{}
+	roleInput.RoleArn = aws.String(c.RoleArn)
+	roleInput.RoleSessionName = aws.String(c.RoleSessionName)
+	if len(c.RoleSessionName) == 0 {
+		roleInput.RoleSessionName = aws.String(fmt.Sprintf("role@%v", time.Now().Unix()))
+	}
+	if len(c.ExternalID) > 0 {
+		roleInput.ExternalId = aws.String(c.ExternalID)
+	}
+	if c.Duration > 0 {
+		roleInput.DurationSeconds = aws.Int64(int64(c.Duration / time.Second))
+	}
+	roleOutput, err := c.Client.AssumeRole(roleInput)
+	if err != nil {
+		return credentials.Value{ProviderName: "AssumeRoleCredentialsProvider"}, fmt.Errorf("failed to assume role %s: %v", c.RoleArn, err)
+	}
+	if roleOutput == nil || roleOutput.Credentials == nil {
+		return credentials.Value{ProviderName: "AssumeRoleCredentialsProvider"}, fmt.Errorf("failed to assume role %s: no credentials returned", c.RoleArn)
+	}
+	c.SetExpiration(*roleOutput.Credentials.Expiration, c.ExpiryWindow)
 	return credentials.Value{
-		AccessKeyID:     *c.AssumeRoleCredentials.AccessKeyId,
-		SecretAccessKey: *c.AssumeRoleCredentials.SecretAccessKey,
-		SessionToken:    *c.AssumeRoleCredentials.SessionToken,
+		AccessKeyID:     *roleOutput.Credentials.AccessKeyId,
+		SecretAccessKey: *roleOutput.Credentials.SecretAccessKey,
+		SessionToken:    *roleOutput.Credentials.SessionToken,
 		ProviderName:    "AssumeRoleCredentialsProvider",
 	}, nil
-
-}
-
-func (c AssumeRoleCredentialsProvider) IsExpired() bool {
-	return c.AssumeRoleCredentials.Expiration.After(time.Now())
-
 }
@@ -0,0 +1,118 @@
+package awsclient
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/stretchr/testify/mock"
+)
+
+type stsClient struct {
+	mock.Mock
+}
+
+func (s *stsClient) AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	args := s.Called(input)
+	out, _ := args.Get(0).(*sts.AssumeRoleOutput)
+	return out, args.Error(1)
+}
+
+func roleOutput(key string, expiration time.Time) *sts.AssumeRoleOutput {
+	return &sts.AssumeRoleOutput{
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(key),
+			SecretAccessKey: aws.String("secret"),
+			SessionToken:    aws.String("token"),
+			Expiration:      aws.Time(expiration),
+		},
+	}
+}
+
+func TestAssumeRoleCredentialsProvider(t *testing.T) {
+	tests := []struct {
+		Name       string
+		ClientFunc func(testing.TB) *stsClient
+		ExpKeys    []string
+		ExpCalls   int
+		ExpError   bool
+	}{
+		{
+			Name: "credentials are cached until they expire",
+			ClientFunc: func(t testing.TB) *stsClient {
+				t.Helper()
+				client := new(stsClient)
+				client.On("AssumeRole", mock.Anything).Return(roleOutput("a", time.Now().Add(time.Hour)), nil).Once()
+				return client
+			},
+			ExpKeys:  []string{"a", "a"},
+			ExpCalls: 1,
+		},
+		{
+			Name: "expired credentials are refreshed",
+			ClientFunc: func(t testing.TB) *stsClient {
+				t.Helper()
+				client := new(stsClient)
+				client.On("AssumeRole", mock.Anything).Return(roleOutput("a", time.Now().Add(30*time.Second)), nil).Once()
+				client.On("AssumeRole", mock.Anything).Return(roleOutput("b", time.Now().Add(time.Hour)), nil).Once()
+				return client
+			},
+			ExpKeys:  []string{"a", "b"},
+			ExpCalls: 2,
+		},
+		{
+			Name: "sts errors are surfaced",
+			ClientFunc: func(t testing.TB) *stsClient {
+				t.Helper()
+				client := new(stsClient)
+				client.On("AssumeRole", mock.Anything).Return(nil, errors.New("AccessDenied"))
+				return client
+			},
+			ExpCalls: 1,
+			ExpError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			client := test.ClientFunc(t)
+			roleCredentials := credentials.NewCredentials(NewAssumeRoleCredentialsProvider(client, "arn:aws:iam::111111111111:role/a"))
+			if test.ExpError {
+				if _, err := roleCredentials.Get(); err == nil {
+					t.Error("expected an error")
+				}
+			}
+			for _, key := range test.ExpKeys {
+				value, err := roleCredentials.Get()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := value.AccessKeyID, key; got != want {
+					t.Errorf("bad access key: got %q, want %q", got, want)
+				}
+			}
+			client.AssertNumberOfCalls(t, "AssumeRole", test.ExpCalls)
+		})
+	}
+}
+
+func TestDeprecatedConstructorErrors(t *testing.T) {
+	var output bytes.Buffer
+	errorOutput = &output
+	defer func() {
+		errorOutput = os.Stderr
+	}()
+
+	ok, client := GetEC2Client(nil)
+	if ok || client != nil {
+		t.Fatalf("bad client: got (%v, %v), want (false, nil)", ok, client)
+	}
+	if got, want := output.String(), "aws session is required to create clients\n"; got != want {
+		t.Errorf("bad error output: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,175 @@
+package awsclient
+
+/*
+creates service clients from an aws session, optionally assuming a role and
+overriding the region, endpoint and retry count of every client
+*/
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/savingsplans"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/sensu/sensu-aws/aws_session"
+)
+
+// Factory creates service clients, it is safe for concurrent use
+type Factory struct {
+	Session *session.Session
+	// RoleArn is assumed once and shared by every client of the factory,
+	// with ExternalID, RoleSessionName and RoleDuration when set
+	RoleArn         string
+	ExternalID      string
+	RoleSessionName string
+	RoleDuration    time.Duration
+	Region          string
+	Endpoint        string
+	// MaxRetries defaults to aws.UseServiceDefaultRetries, which keeps the
+	// retries of the session
+	MaxRetries int
+
+	mu          sync.Mutex
+	roleSession *session.Session
+}
+
+func NewFactory(awsSession *session.Session) *Factory {
+	return &Factory{
+		Session:    awsSession,
+		MaxRetries: aws.UseServiceDefaultRetries,
+	}
+}
+
+func (f *Factory) EC2() (*ec2.EC2, error) {
+	sess, config, err := f.config()
+	if err != nil {
+		return nil, err
+	}
+	return ec2.New(sess, config), nil
+}
+
+func (f *Factory) ELB() (*elb.ELB, error) {
+	sess, config, err := f.config()
+	if err != nil {
+		return nil, err
+	}
+	return elb.New(sess, config), nil
+}
+
+func (f *Factory) ELBV2() (*elbv2.ELBV2, error) {
+	sess, config, err := f.config()
+	if err != nil {
+		return nil, err
+	}
+	return elbv2.New(sess, config), nil
+}
+
+func (f *Factory) CloudWatch() (*cloudwatch.CloudWatch, error) {
+	sess, config, err := f.config()
+	if err != nil {
+		return nil, err
+	}
+	return cloudwatch.New(sess, config), nil
+}
+
+func (f *Factory) RDS() (*rds.RDS, error) {
+	sess, config, err := f.config()
+	if err != nil {
+		return nil, err
+	}
+	return rds.New(sess, config), nil
+}
+
+func (f *Factory) S3() (*s3.S3, error) {
+	sess, config, err := f.config()
+	if err != nil {
+		return nil, err
+	}
+	return s3.New(sess, config), nil
+}
+
+func (f *Factory) IAM() (*iam.IAM, error) {
+	sess, config, err := f.config()
+	if err != nil {
+		return nil, err
+	}
+	return iam.New(sess, config), nil
+}
+
+// CostExplorer clients call the global endpoint whatever the region
+func (f *Factory) CostExplorer() (*costexplorer.CostExplorer, error) {
+	sess, config, err := f.config()
+	if err != nil {
+		return nil, err
+	}
+	return costexplorer.New(sess, config), nil
+}
+
+// SavingsPlans clients call the global endpoint whatever the region
+func (f *Factory) SavingsPlans() (*savingsplans.SavingsPlans, error) {
+	sess, config, err := f.config()
+	if err != nil {
+		return nil, err
+	}
+	return savingsplans.New(sess, config), nil
+}
+
+func (f *Factory) STS() (*sts.STS, error) {
+	sess, config, err := f.config()
+	if err != nil {
+		return nil, err
+	}
+	return sts.New(sess, config), nil
+}
+
+// config returns the session to create clients from, with the role
+// credentials applied, and the per client overrides
+func (f *Factory) config() (*session.Session, *aws.Config, error) {
+	if f.Session == nil {
+		return nil, nil, errors.New("aws session is required to create clients")
+	}
+	config := aws.NewConfig().WithMaxRetries(f.MaxRetries)
+	if retryer, ok := f.Session.Config.Retryer.(aws_session.Retryer); ok && f.MaxRetries != aws.UseServiceDefaultRetries {
+		retryer.NumMaxRetries = f.MaxRetries
+		config.Retryer = retryer
+	}
+	if len(f.Region) > 0 {
+		config.Region = aws.String(f.Region)
+	}
+	if len(f.Endpoint) > 0 {
+		config.Endpoint = aws.String(f.Endpoint)
+	}
+	if len(f.RoleArn) == 0 {
+		return f.Session, config, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.roleSession == nil {
+		stsClient, err := NewFactory(f.Session).STS()
+		if err != nil {
+			return nil, nil, err
+		}
+		provider := NewAssumeRoleCredentialsProvider(stsClient, f.RoleArn)
+		provider.ExternalID = f.ExternalID
+		provider.RoleSessionName = f.RoleSessionName
+		provider.Duration = f.RoleDuration
+		roleSession, err := getRoleSession(f.Session, provider)
+		if err != nil {
+			return nil, nil, err
+		}
+		f.roleSession = roleSession
+	}
+	return f.roleSession, config, nil
+}
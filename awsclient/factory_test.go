@@ -0,0 +1,68 @@
+package awsclient
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+func TestFactory(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-east-1")}))
+
+	tests := []struct {
+		Name        string
+		Factory     *Factory
+		ExpError    bool
+		ExpRegion   string
+		ExpEndpoint string
+		ExpRetries  int
+	}{
+		{
+			Name:     "no session",
+			Factory:  NewFactory(nil),
+			ExpError: true,
+		},
+		{
+			Name:       "session defaults",
+			Factory:    NewFactory(sess),
+			ExpRegion:  "us-east-1",
+			ExpRetries: aws.UseServiceDefaultRetries,
+		},
+		{
+			Name: "overrides",
+			Factory: &Factory{
+				Session:    sess,
+				Region:     "eu-west-1",
+				Endpoint:   "http://127.0.0.1:4566",
+				MaxRetries: 5,
+			},
+			ExpRegion:   "eu-west-1",
+			ExpEndpoint: "http://127.0.0.1:4566",
+			ExpRetries:  5,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			client, err := test.Factory.EC2()
+			if got, want := err != nil, test.ExpError; got != want {
+				t.Fatalf("conflicting error expectations: got (err != nil) == %v, want %v", got, want)
+			}
+			if err != nil {
+				return
+			}
+			if got, want := aws.StringValue(client.Config.Region), test.ExpRegion; got != want {
+				t.Errorf("bad region: got %q, want %q", got, want)
+			}
+			if len(test.ExpEndpoint) > 0 {
+				if got, want := client.Endpoint, test.ExpEndpoint; got != want {
+					t.Errorf("bad endpoint: got %q, want %q", got, want)
+				}
+			}
+			if got, want := aws.IntValue(client.Config.MaxRetries), test.ExpRetries; got != want {
+				t.Errorf("bad retries: got %d, want %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package awstest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/private/protocol"
+	"github.com/aws/aws-sdk-go/private/protocol/json/jsonutil"
+)
+
+const requestID = "awstest-request"
+
+// writeOutput encodes output with the protocol of the service: EC2 query,
+// AWS query, REST XML for S3 or JSON for Cost Explorer and Savings Plans
+func writeOutput(w http.ResponseWriter, req *Request, output interface{}) error {
+	if raw, ok := output.(*Raw); ok {
+		for name, values := range raw.Header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(raw.StatusCode)
+		_, err := io.WriteString(w, raw.Body)
+		return err
+	}
+
+	// a nil output is an empty response
+	value := reflect.Indirect(reflect.ValueOf(output))
+	if value.IsValid() && value.Kind() != reflect.Struct {
+		return fmt.Errorf("awstest: %s %s output is a %T, not an output shape", req.Service, req.Operation, output)
+	}
+	if req.Service == S3 {
+		if !value.IsValid() {
+			return nil
+		}
+		return writeREST(w, value)
+	}
+	if isJSON(req) {
+		body := []byte("{}")
+		if value.IsValid() {
+			var err error
+			if body, err = jsonutil.BuildJSON(output); err != nil {
+				return err
+			}
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.Header().Set("X-Amzn-Requestid", requestID)
+		_, err := w.Write(body)
+		return err
+	}
+
+	var body bytes.Buffer
+	if value.IsValid() {
+		encodeMembers(&body, value)
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	if req.Service == EC2 {
+		_, err := fmt.Fprintf(w, "<%sResponse><requestId>%s</requestId>%s</%[1]sResponse>", req.Operation, requestID, body.String())
+		return err
+	}
+	w.Header().Set("X-Amzn-Requestid", requestID)
+	_, err := fmt.Fprintf(w, "<%sResponse><%[1]sResult>%s</%[1]sResult><ResponseMetadata><RequestId>%s</RequestId></ResponseMetadata></%[1]sResponse>",
+		req.Operation, body.String(), requestID)
+	return err
+}
+
+// writeREST writes the header members of value as headers and its payload,
+// or its other members, as the body
+func writeREST(w http.ResponseWriter, value reflect.Value) error {
+	var payload string
+	if field, ok := value.Type().FieldByName("_"); ok {
+		payload = field.Tag.Get("payload")
+	}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		member := reflect.Indirect(value.Field(i))
+		if field.PkgPath != "" || !member.IsValid() {
+			continue
+		}
+		switch field.Tag.Get("location") {
+		case "header":
+			w.Header().Set(field.Tag.Get("locationName"), headerValue(member, field.Tag))
+		case "headers":
+			for _, key := range member.MapKeys() {
+				w.Header().Set(field.Tag.Get("locationName")+key.String(), reflect.Indirect(member.MapIndex(key)).String())
+			}
+		}
+	}
+	w.Header().Set("X-Amz-Request-Id", requestID)
+
+	if len(payload) > 0 {
+		member := reflect.Indirect(value.FieldByName(payload))
+		if !member.IsValid() {
+			return nil
+		}
+		switch data := member.Interface().(type) {
+		case string:
+			_, err := io.WriteString(w, data)
+			return err
+		case []byte:
+			_, err := w.Write(data)
+			return err
+		case io.Reader:
+			_, err := io.Copy(w, data)
+			return err
+		}
+	}
+
+	var body bytes.Buffer
+	if len(payload) > 0 {
+		field, _ := value.Type().FieldByName(payload)
+		encodeValue(&body, value.FieldByName(payload), field.Tag.Get("locationName"), field.Tag)
+		_, err := w.Write(body.Bytes())
+		return err
+	}
+	encodeMembers(&body, value)
+	if body.Len() == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(w, "<%s>%s</%[1]s>", value.Type().Name(), body.String())
+	return err
+}
+
+// encodeMembers writes the body members of the output shape value as XML
+// elements named after their locationName
+func encodeMembers(w *bytes.Buffer, value reflect.Value) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.PkgPath != "" || len(field.Tag.Get("location")) > 0 {
+			continue
+		}
+		name := field.Tag.Get("locationName")
+		if len(name) == 0 {
+			name = field.Name
+		}
+		encodeValue(w, value.Field(i), name, field.Tag)
+	}
+}
+
+// encodeValue writes value as the element name, lists are wrapped in name
+// with one locationNameList element, member by default, per item unless
+// they are flattened
+func encodeValue(w *bytes.Buffer, value reflect.Value, name string, tag reflect.StructTag) {
+	if value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+	switch value.Interface().(type) {
+	case time.Time, []byte:
+		writeElement(w, name, scalar(value, tag))
+		return
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		fmt.Fprintf(w, "<%s>", name)
+		encodeMembers(w, value)
+		fmt.Fprintf(w, "</%s>", name)
+	case reflect.Slice:
+		if value.IsNil() {
+			return
+		}
+		if len(tag.Get("flattened")) > 0 {
+			for i := 0; i < value.Len(); i++ {
+				encodeValue(w, value.Index(i), name, "")
+			}
+			return
+		}
+		item := tag.Get("locationNameList")
+		if len(item) == 0 {
+			item = "member"
+		}
+		fmt.Fprintf(w, "<%s>", name)
+		for i := 0; i < value.Len(); i++ {
+			encodeValue(w, value.Index(i), item, "")
+		}
+		fmt.Fprintf(w, "</%s>", name)
+	case reflect.Map:
+		fmt.Fprintf(w, "<%s>", name)
+		for _, key := range value.MapKeys() {
+			w.WriteString("<entry>")
+			writeElement(w, "key", key.String())
+			encodeValue(w, value.MapIndex(key), "value", "")
+			w.WriteString("</entry>")
+		}
+		fmt.Fprintf(w, "</%s>", name)
+	default:
+		writeElement(w, name, scalar(value, tag))
+	}
+}
+
+func writeElement(w *bytes.Buffer, name string, text string) {
+	fmt.Fprintf(w, "<%s>", name)
+	_ = xml.EscapeText(w, []byte(text))
+	fmt.Fprintf(w, "</%s>", name)
+}
+
+func scalar(value reflect.Value, tag reflect.StructTag) string {
+	switch converted := value.Interface().(type) {
+	case time.Time:
+		format := tag.Get("timestampFormat")
+		if len(format) == 0 {
+			format = protocol.ISO8601TimeFormatName
+		}
+		return protocol.FormatTime(format, converted)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(converted)
+	case float64:
+		return strconv.FormatFloat(converted, 'f', -1, 64)
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+func headerValue(member reflect.Value, tag reflect.StructTag) string {
+	switch value := member.Interface().(type) {
+	case string:
+		return value
+	case int64:
+		return strconv.FormatInt(value, 10)
+	case bool:
+		return strconv.FormatBool(value)
+	case time.Time:
+		format := tag.Get("timestampFormat")
+		if len(format) == 0 {
+			format = protocol.RFC822TimeFormatName
+		}
+		return protocol.FormatTime(format, value)
+	}
+	return fmt.Sprint(member.Interface())
+}
+
+// writeError writes err as an error response of the protocol of the
+// service, errors that are not an *Error are internal failures
+func writeError(w http.ResponseWriter, req *Request, err error) {
+	awsErr, ok := err.(*Error)
+	if !ok {
+		awsErr = &Error{StatusCode: http.StatusInternalServerError, Code: "InternalFailure", Message: err.Error()}
+	}
+	status := awsErr.StatusCode
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+
+	if isJSON(req) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.Header().Set("X-Amzn-Errortype", awsErr.Code)
+		w.Header().Set("X-Amzn-Requestid", requestID)
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"__type": awsErr.Code, "message": awsErr.Message})
+		return
+	}
+
+	var body bytes.Buffer
+	encoder := xml.NewEncoder(&body)
+	switch req.Service {
+	case EC2:
+		_ = encoder.Encode(struct {
+			XMLName   xml.Name `xml:"Response"`
+			Code      string   `xml:"Errors>Error>Code"`
+			Message   string   `xml:"Errors>Error>Message"`
+			RequestID string   `xml:"RequestID"`
+		}{Code: awsErr.Code, Message: awsErr.Message, RequestID: requestID})
+	case S3:
+		_ = encoder.Encode(struct {
+			XMLName   xml.Name `xml:"Error"`
+			Code      string   `xml:"Code"`
+			Message   string   `xml:"Message"`
+			RequestID string   `xml:"RequestId"`
+		}{Code: awsErr.Code, Message: awsErr.Message, RequestID: requestID})
+	default:
+		_ = encoder.Encode(struct {
+			XMLName   xml.Name `xml:"ErrorResponse"`
+			Type      string   `xml:"Error>Type"`
+			Code      string   `xml:"Error>Code"`
+			Message   string   `xml:"Error>Message"`
+			RequestID string   `xml:"RequestId"`
+		}{Type: "Sender", Code: awsErr.Code, Message: awsErr.Message, RequestID: requestID})
+	}
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(status)
+	_, _ = w.Write(body.Bytes())
+}
+
+// isJSON reports whether req is a call of a JSON protocol service
+func isJSON(req *Request) bool {
+	return req.Service == CostExplorer || req.Service == SavingsPlans
+}
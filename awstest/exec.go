@@ -0,0 +1,66 @@
+package awstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// execArgs holds the JSON encoded plugin arguments of a test binary started
+// by Exec
+const execArgs = "AWSTEST_EXEC_ARGS"
+
+// Main runs the tests, or main when the test binary was started by Exec, so
+// that the test binary of a plugin can stand in for the plugin binary. It is
+// called from TestMain
+func Main(m *testing.M, main func()) {
+	if encoded, ok := os.LookupEnv(execArgs); ok {
+		var args []string
+		if err := json.Unmarshal([]byte(encoded), &args); err != nil {
+			panic(err)
+		}
+		os.Args = append(os.Args[:1], args...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// Exec runs the plugin of the current test binary, which has to call Main
+// from TestMain, with args against the server and returns its stdout and exit
+// status. The AWS variables of the test environment are not passed on
+func (s *Server) Exec(t testing.TB, args ...string) (string, int) {
+	t.Helper()
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := []string{}
+	for _, variable := range os.Environ() {
+		if !strings.HasPrefix(variable, "AWS_") {
+			env = append(env, variable)
+		}
+	}
+	env = append(env, s.Env()...)
+	env = append(env, execArgs+"="+string(encoded))
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = env
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if stderr.Len() > 0 {
+		t.Logf("%s", stderr.String())
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return stdout.String(), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stdout.String(), 0
+}
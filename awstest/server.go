@@ -0,0 +1,376 @@
+package awstest
+
+/*
+emulates the EC2, ELB, ELBv2, RDS, CloudWatch, S3, STS, Cost Explorer and
+Savings Plans endpoints in an in-process HTTP server, so that checks can be
+tested end to end against scripted or recorded responses without network
+access
+*/
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/sensu/sensu-aws/aws_session"
+)
+
+// Services emulated by the server, as used by Handle and Request.Service
+const (
+	EC2          = "ec2"
+	ELB          = "elb"
+	ELBV2        = "elbv2"
+	RDS          = "rds"
+	CloudWatch   = "cloudwatch"
+	S3           = "s3"
+	STS          = "sts"
+	CostExplorer = "ce"
+	SavingsPlans = "savingsplans"
+)
+
+const (
+	// AccountID is the account of the default STS responses
+	AccountID = "123456789012"
+	// AccessKeyID and SecretAccessKey are the static credentials clients sign
+	// their requests with
+	AccessKeyID     = "AKIDAWSTEST"
+	SecretAccessKey = "awstest"
+)
+
+// credentialScope extracts the region and signing name of a SigV4
+// Authorization header
+var credentialScope = regexp.MustCompile(`Credential=[^/]+/\d+/([^/]+)/([^/]+)/aws4_request`)
+
+// Request is an API call received by the server
+type Request struct {
+	Service   string
+	Operation string
+	Region    string
+	// Params holds the parameters of query protocol calls and the query
+	// string of S3 calls, e.g. InstanceId.1 or prefix
+	Params url.Values
+	// Bucket and Key are set for S3 calls
+	Bucket string
+	Key    string
+	// Body holds the JSON request of Cost Explorer and Savings Plans calls
+	Body   []byte
+	Header http.Header
+}
+
+// Handler answers a call with an SDK output shape, e.g. a
+// *ec2.DescribeInstancesOutput, with a Raw response, with nil for an empty
+// response or with an error, an *Error is returned to the client as an AWS
+// error response
+type Handler func(req *Request) (interface{}, error)
+
+// Raw is a response written as is, e.g. a recorded response body
+type Raw struct {
+	StatusCode int
+	Header     http.Header
+	Body       string
+}
+
+// Error is an AWS error response
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Server is a fake AWS endpoint, it is safe for concurrent use
+type Server struct {
+	URL string
+
+	server   *httptest.Server
+	mu       sync.Mutex
+	handlers map[string]Handler
+	requests []*Request
+}
+
+// NewServer starts a server answering STS AssumeRole and GetCallerIdentity
+// calls, every other call has to be scripted with Handle
+func NewServer() *Server {
+	s := &Server{handlers: make(map[string]Handler)}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL
+	s.Handle(STS, "AssumeRole", assumeRole)
+	s.Respond(STS, "GetCallerIdentity", &sts.GetCallerIdentityOutput{
+		Account: aws.String(AccountID),
+		Arn:     aws.String("arn:aws:iam::" + AccountID + ":user/awstest"),
+		UserId:  aws.String(AccessKeyID),
+	})
+	return s
+}
+
+// Close shuts the server down
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Handle answers the operation of service with handler, replacing any
+// previous handler
+func (s *Server) Handle(service string, operation string, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[service+"/"+operation] = handler
+}
+
+// Respond answers every call of the operation of service with output
+func (s *Server) Respond(service string, operation string, output interface{}) {
+	s.Handle(service, operation, func(*Request) (interface{}, error) {
+		return output, nil
+	})
+}
+
+// Sequence answers the calls of the operation of service with responses in
+// order, repeating the last one. A response that is an error is returned as
+// such, e.g. to throttle the first call
+func (s *Server) Sequence(service string, operation string, responses ...interface{}) {
+	var mu sync.Mutex
+	calls := 0
+	s.Handle(service, operation, func(*Request) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		response := responses[len(responses)-1]
+		if calls < len(responses) {
+			response = responses[calls]
+		}
+		calls++
+		if err, ok := response.(error); ok {
+			return nil, err
+		}
+		return response, nil
+	})
+}
+
+// LoadFixtures answers calls with the recorded response bodies found in dir
+// as <service>/<Operation>.xml, e.g. ec2/DescribeInstances.xml
+func (s *Server) LoadFixtures(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*", "*.xml"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		body, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		service := filepath.Base(filepath.Dir(file))
+		operation := strings.TrimSuffix(filepath.Base(file), ".xml")
+		s.Respond(service, operation, &Raw{StatusCode: http.StatusOK, Body: string(body)})
+	}
+	return nil
+}
+
+// Requests returns the calls received so far, in order
+func (s *Server) Requests() []*Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Request{}, s.requests...)
+}
+
+// Calls returns the number of calls of the operation of service received so
+// far
+func (s *Server) Calls(service string, operation string) int {
+	calls := 0
+	for _, req := range s.Requests() {
+		if req.Service == service && req.Operation == operation {
+			calls++
+		}
+	}
+	return calls
+}
+
+// Config returns the client config pointing at the server with static
+// credentials
+func (s *Server) Config() *aws.Config {
+	return aws.NewConfig().
+		WithEndpoint(s.URL).
+		WithCredentials(credentials.NewStaticCredentials(AccessKeyID, SecretAccessKey, "")).
+		WithS3ForcePathStyle(true)
+}
+
+// Install points every session created by aws_session.New at the server
+// until the returned function is called
+func (s *Server) Install() func() {
+	previous := aws_session.Override
+	aws_session.Override = s.Config()
+	return func() {
+		aws_session.Override = previous
+	}
+}
+
+// Env returns the environment of a plugin process calling the server
+// instead of AWS
+func (s *Server) Env() []string {
+	return []string{
+		"AWS_ENDPOINT_URL=" + s.URL,
+		"AWS_ACCESS_KEY_ID=" + AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY=" + SecretAccessKey,
+		"AWS_CONFIG_FILE=" + os.DevNull,
+		"AWS_SHARED_CREDENTIALS_FILE=" + os.DevNull,
+		"AWS_EC2_METADATA_DISABLED=true",
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	req, err := parseRequest(r)
+	if err != nil {
+		writeError(w, &Request{}, &Error{StatusCode: http.StatusBadRequest, Code: "InvalidRequest", Message: err.Error()})
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	handler, ok := s.handlers[req.Service+"/"+req.Operation]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, req, &Error{
+			StatusCode: http.StatusBadRequest,
+			Code:       "InvalidAction",
+			Message:    fmt.Sprintf("awstest: no handler for %s %s", req.Service, req.Operation),
+		})
+		return
+	}
+
+	output, err := handler(req)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+	if err := writeOutput(w, req, output); err != nil {
+		writeError(w, req, err)
+	}
+}
+
+// parseRequest identifies the service from the SigV4 credential scope and
+// the operation from the Action parameter, from the method, path and
+// sub-resource for S3, or from the target header or path for JSON protocols
+func parseRequest(r *http.Request) (*Request, error) {
+	scope := credentialScope.FindStringSubmatch(r.Header.Get("Authorization"))
+	if scope == nil {
+		return nil, fmt.Errorf("unsigned request %s %s", r.Method, r.URL.Path)
+	}
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	req := &Request{
+		Region: scope[1],
+		Header: r.Header,
+		Params: r.Form,
+	}
+	switch scope[2] {
+	case "s3":
+		req.Service = S3
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+		req.Bucket = parts[0]
+		if len(parts) > 1 {
+			req.Key = parts[1]
+		}
+		req.Operation = s3Operation(r.Method, req)
+		return req, nil
+	case "ec2":
+		req.Service = EC2
+	case "elasticloadbalancing":
+		req.Service = ELB
+		if r.Form.Get("Version") == "2015-12-01" {
+			req.Service = ELBV2
+		}
+	case "rds":
+		req.Service = RDS
+	case "monitoring":
+		req.Service = CloudWatch
+	case "sts":
+		req.Service = STS
+	case "ce", "savingsplans":
+		// JSON protocols name the operation in the target header or the path
+		req.Service = scope[2]
+		req.Operation = strings.TrimPrefix(r.URL.Path, "/")
+		if target := r.Header.Get("X-Amz-Target"); len(target) > 0 {
+			req.Operation = target[strings.LastIndex(target, ".")+1:]
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+		return req, nil
+	default:
+		return nil, fmt.Errorf("unsupported service %s", scope[2])
+	}
+	req.Operation = r.Form.Get("Action")
+	return req, nil
+}
+
+// s3SubResources maps the sub-resources of bucket GET calls to their
+// operation
+var s3SubResources = []struct {
+	SubResource string
+	Operation   string
+}{
+	{"acl", "GetBucketAcl"},
+	{"encryption", "GetBucketEncryption"},
+	{"lifecycle", "GetBucketLifecycleConfiguration"},
+	{"location", "GetBucketLocation"},
+	{"logging", "GetBucketLogging"},
+	{"policy", "GetBucketPolicy"},
+	{"policyStatus", "GetBucketPolicyStatus"},
+	{"publicAccessBlock", "GetPublicAccessBlock"},
+	{"tagging", "GetBucketTagging"},
+	{"versioning", "GetBucketVersioning"},
+	{"website", "GetBucketWebsite"},
+}
+
+func s3Operation(method string, req *Request) string {
+	switch {
+	case len(req.Bucket) == 0:
+		return "ListBuckets"
+	case len(req.Key) > 0 && method == http.MethodHead:
+		return "HeadObject"
+	case len(req.Key) > 0:
+		return "GetObject"
+	case method == http.MethodHead:
+		return "HeadBucket"
+	}
+	for _, sub := range s3SubResources {
+		if _, ok := req.Params[sub.SubResource]; ok {
+			return sub.Operation
+		}
+	}
+	if req.Params.Get("list-type") == "2" {
+		return "ListObjectsV2"
+	}
+	return "ListObjects"
+}
+
+// assumeRole returns credentials for any role, valid for an hour
+func assumeRole(req *Request) (interface{}, error) {
+	roleArn := req.Params.Get("RoleArn")
+	return &sts.AssumeRoleOutput{
+		AssumedRoleUser: &sts.AssumedRoleUser{
+			Arn:           aws.String(roleArn + "/" + req.Params.Get("RoleSessionName")),
+			AssumedRoleId: aws.String("AROAAWSTEST:" + req.Params.Get("RoleSessionName")),
+		},
+		Credentials: &sts.Credentials{
+			AccessKeyId:     aws.String(AccessKeyID),
+			SecretAccessKey: aws.String(SecretAccessKey),
+			SessionToken:    aws.String(roleArn),
+			Expiration:      aws.Time(time.Now().Add(time.Hour)),
+		},
+	}, nil
+}
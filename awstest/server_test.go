@@ -0,0 +1,352 @@
+package awstest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/savingsplans"
+	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/awsclient"
+)
+
+func newFactory(t *testing.T, roleArns ...string) *awsclient.Factory {
+	t.Helper()
+	sess, err := aws_session.New(aws_session.Options{Region: "eu-west-1", RoleArns: roleArns})
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory := awsclient.NewFactory(sess)
+	factory.MaxRetries = 0
+	return factory
+}
+
+func TestQueryProtocols(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	defer server.Install()()
+
+	launchTime := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	server.Respond(EC2, "DescribeInstances", &ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{
+			Instances: []*ec2.Instance{{
+				InstanceId: aws.String("i-1"),
+				LaunchTime: aws.Time(launchTime),
+				Tags:       []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("web")}},
+			}},
+		}},
+	})
+	server.Respond(ELB, "DescribeInstanceHealth", &elb.DescribeInstanceHealthOutput{
+		InstanceStates: []*elb.InstanceState{{InstanceId: aws.String("i-1"), State: aws.String("InService")}},
+	})
+	server.Respond(ELBV2, "DescribeTargetGroups", &elbv2.DescribeTargetGroupsOutput{
+		TargetGroups: []*elbv2.TargetGroup{{TargetGroupName: aws.String("web")}},
+	})
+	server.Respond(RDS, "DescribeDBInstances", &rds.DescribeDBInstancesOutput{
+		DBInstances: []*rds.DBInstance{{DBInstanceIdentifier: aws.String("db-1")}},
+	})
+	server.Respond(CloudWatch, "GetMetricData", &cloudwatch.GetMetricDataOutput{
+		MetricDataResults: []*cloudwatch.MetricDataResult{{
+			Id:         aws.String("cpu"),
+			Timestamps: []*time.Time{aws.Time(launchTime)},
+			Values:     []*float64{aws.Float64(12.5)},
+		}},
+	})
+
+	factory := newFactory(t)
+	ec2Client, _ := factory.EC2()
+	instances, err := ec2Client.DescribeInstances(&ec2.DescribeInstancesInput{InstanceIds: aws.StringSlice([]string{"i-1"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := instances.Reservations[0].Instances[0]
+	if got, want := aws.StringValue(instance.InstanceId), "i-1"; got != want {
+		t.Errorf("bad instance id: got %q, want %q", got, want)
+	}
+	if got, want := aws.TimeValue(instance.LaunchTime), launchTime; !got.Equal(want) {
+		t.Errorf("bad launch time: got %v, want %v", got, want)
+	}
+	if got, want := aws.StringValue(instance.Tags[0].Value), "web"; got != want {
+		t.Errorf("bad tag: got %q, want %q", got, want)
+	}
+
+	elbClient, _ := factory.ELB()
+	health, err := elbClient.DescribeInstanceHealth(&elb.DescribeInstanceHealthInput{LoadBalancerName: aws.String("lb")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := aws.StringValue(health.InstanceStates[0].State), "InService"; got != want {
+		t.Errorf("bad instance state: got %q, want %q", got, want)
+	}
+
+	elbv2Client, _ := factory.ELBV2()
+	groups, err := elbv2Client.DescribeTargetGroups(&elbv2.DescribeTargetGroupsInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := aws.StringValue(groups.TargetGroups[0].TargetGroupName), "web"; got != want {
+		t.Errorf("bad target group: got %q, want %q", got, want)
+	}
+
+	rdsClient, _ := factory.RDS()
+	databases, err := rdsClient.DescribeDBInstances(&rds.DescribeDBInstancesInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := aws.StringValue(databases.DBInstances[0].DBInstanceIdentifier), "db-1"; got != want {
+		t.Errorf("bad db instance: got %q, want %q", got, want)
+	}
+
+	cloudwatchClient, _ := factory.CloudWatch()
+	data, err := cloudwatchClient.GetMetricData(&cloudwatch.GetMetricDataInput{
+		StartTime: aws.Time(launchTime),
+		EndTime:   aws.Time(launchTime),
+		MetricDataQueries: []*cloudwatch.MetricDataQuery{{
+			Id:         aws.String("cpu"),
+			Expression: aws.String("SEARCH('CPUUtilization', 'Average', 60)"),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := aws.Float64Value(data.MetricDataResults[0].Values[0]), 12.5; got != want {
+		t.Errorf("bad value: got %v, want %v", got, want)
+	}
+	if got, want := aws.TimeValue(data.MetricDataResults[0].Timestamps[0]), launchTime; !got.Equal(want) {
+		t.Errorf("bad timestamp: got %v, want %v", got, want)
+	}
+
+	requests := server.Requests()
+	if got, want := len(requests), 5; got != want {
+		t.Fatalf("bad request count: got %d, want %d", got, want)
+	}
+	if got, want := requests[0].Params.Get("InstanceId.1"), "i-1"; got != want {
+		t.Errorf("bad instance id parameter: got %q, want %q", got, want)
+	}
+	if got, want := requests[0].Region, "eu-west-1"; got != want {
+		t.Errorf("bad region: got %q, want %q", got, want)
+	}
+}
+
+func TestS3(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	defer server.Install()()
+
+	modified := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	server.Respond(S3, "ListBuckets", &s3.ListBucketsOutput{
+		Buckets: []*s3.Bucket{{Name: aws.String("logs")}, {Name: aws.String("backups")}},
+	})
+	server.Respond(S3, "HeadObject", &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(1024),
+		LastModified:  aws.Time(modified),
+	})
+	server.Respond(S3, "GetBucketPolicy", &s3.GetBucketPolicyOutput{Policy: aws.String(`{"Statement":[]}`)})
+	server.Respond(S3, "GetBucketTagging", &s3.GetBucketTaggingOutput{
+		TagSet: []*s3.Tag{{Key: aws.String("team"), Value: aws.String("ops")}},
+	})
+	server.Handle(S3, "HeadBucket", func(req *Request) (interface{}, error) {
+		return nil, &Error{StatusCode: http.StatusNotFound, Code: "NotFound"}
+	})
+
+	client, _ := newFactory(t).S3()
+	buckets, err := client.ListBuckets(&s3.ListBucketsInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(buckets.Buckets), 2; got != want {
+		t.Errorf("bad bucket count: got %d, want %d", got, want)
+	}
+
+	object, err := client.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("logs"), Key: aws.String("2019/app.log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := aws.Int64Value(object.ContentLength), int64(1024); got != want {
+		t.Errorf("bad content length: got %d, want %d", got, want)
+	}
+	if got, want := aws.TimeValue(object.LastModified), modified; !got.Equal(want) {
+		t.Errorf("bad last modified: got %v, want %v", got, want)
+	}
+
+	policy, err := client.GetBucketPolicy(&s3.GetBucketPolicyInput{Bucket: aws.String("logs")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := aws.StringValue(policy.Policy), `{"Statement":[]}`; got != want {
+		t.Errorf("bad policy: got %q, want %q", got, want)
+	}
+
+	tagging, err := client.GetBucketTagging(&s3.GetBucketTaggingInput{Bucket: aws.String("logs")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := aws.StringValue(tagging.TagSet[0].Value), "ops"; got != want {
+		t.Errorf("bad tag: got %q, want %q", got, want)
+	}
+
+	_, err = client.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String("missing")})
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != "NotFound" {
+		t.Errorf("bad error: got %v, want NotFound", err)
+	}
+
+	requests := server.Requests()
+	if got, want := requests[1].Bucket+"/"+requests[1].Key, "logs/2019/app.log"; got != want {
+		t.Errorf("bad object: got %q, want %q", got, want)
+	}
+}
+
+func TestJSONProtocols(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	defer server.Install()()
+
+	server.Respond(SavingsPlans, "DescribeSavingsPlans", &savingsplans.DescribeSavingsPlansOutput{
+		SavingsPlans: []*savingsplans.SavingsPlan{{
+			SavingsPlanId: aws.String("sp-1"),
+			Tags:          map[string]*string{"team": aws.String("ops")},
+		}},
+	})
+	server.Handle(CostExplorer, "GetSavingsPlansUtilizationDetails", func(req *Request) (interface{}, error) {
+		return nil, &Error{Code: "DataUnavailableException", Message: "no data"}
+	})
+
+	plansClient, _ := newFactory(t).SavingsPlans()
+	plans, err := plansClient.DescribeSavingsPlans(&savingsplans.DescribeSavingsPlansInput{States: aws.StringSlice([]string{"active"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := aws.StringValue(plans.SavingsPlans[0].SavingsPlanId), "sp-1"; got != want {
+		t.Errorf("bad savings plan: got %q, want %q", got, want)
+	}
+	if got, want := aws.StringValue(plans.SavingsPlans[0].Tags["team"]), "ops"; got != want {
+		t.Errorf("bad tag: got %q, want %q", got, want)
+	}
+	if got, want := string(server.Requests()[0].Body), `{"states":["active"]}`; got != want {
+		t.Errorf("bad request body: got %s, want %s", got, want)
+	}
+
+	ceClient, _ := newFactory(t).CostExplorer()
+	_, err = ceClient.GetSavingsPlansUtilizationDetails(&costexplorer.GetSavingsPlansUtilizationDetailsInput{
+		TimePeriod: &costexplorer.DateInterval{Start: aws.String("2020-01-01"), End: aws.String("2020-01-08")},
+	})
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != "DataUnavailableException" || awsErr.Message() != "no data" {
+		t.Errorf("bad error: got %v, want DataUnavailableException", err)
+	}
+	if got, want := server.Calls(CostExplorer, "GetSavingsPlansUtilizationDetails"), 1; got != want {
+		t.Errorf("bad call count: got %d, want %d", got, want)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	defer server.Install()()
+
+	server.Sequence(EC2, "DescribeRegions",
+		&Error{StatusCode: http.StatusBadRequest, Code: "UnauthorizedOperation", Message: "denied"},
+		&ec2.DescribeRegionsOutput{Regions: []*ec2.Region{{RegionName: aws.String("eu-west-1")}}},
+	)
+	server.Handle(RDS, "DescribeDBInstances", func(req *Request) (interface{}, error) {
+		return nil, &Error{StatusCode: http.StatusNotFound, Code: "DBInstanceNotFound", Message: "db-2 not found"}
+	})
+
+	factory := newFactory(t)
+	ec2Client, _ := factory.EC2()
+	_, err := ec2Client.DescribeRegions(&ec2.DescribeRegionsInput{})
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != "UnauthorizedOperation" || awsErr.Message() != "denied" {
+		t.Errorf("bad error: got %v, want UnauthorizedOperation", err)
+	}
+	regions, err := ec2Client.DescribeRegions(&ec2.DescribeRegionsInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(regions.Regions), 1; got != want {
+		t.Errorf("bad region count: got %d, want %d", got, want)
+	}
+
+	rdsClient, _ := factory.RDS()
+	_, err = rdsClient.DescribeDBInstances(&rds.DescribeDBInstancesInput{})
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != "DBInstanceNotFound" {
+		t.Errorf("bad error: got %v, want DBInstanceNotFound", err)
+	}
+
+	_, err = ec2Client.DescribeVolumes(&ec2.DescribeVolumesInput{})
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != "InvalidAction" {
+		t.Errorf("bad error: got %v, want InvalidAction", err)
+	}
+	if got, want := server.Calls(EC2, "DescribeRegions"), 2; got != want {
+		t.Errorf("bad call count: got %d, want %d", got, want)
+	}
+}
+
+func TestAssumeRole(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	defer server.Install()()
+
+	server.Respond(EC2, "DescribeRegions", &ec2.DescribeRegionsOutput{})
+	ec2Client, _ := newFactory(t, "arn:aws:iam::111111111111:role/sensu").EC2()
+	if _, err := ec2Client.DescribeRegions(&ec2.DescribeRegionsInput{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := server.Calls(STS, "AssumeRole"), 1; got != want {
+		t.Errorf("bad AssumeRole call count: got %d, want %d", got, want)
+	}
+	requests := server.Requests()
+	if got, want := requests[len(requests)-1].Header.Get("X-Amz-Security-Token"), "arn:aws:iam::111111111111:role/sensu"; got != want {
+		t.Errorf("call not signed with the role credentials: got token %q, want %q", got, want)
+	}
+}
+
+func TestLoadFixtures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "awstest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, EC2), 0755); err != nil {
+		t.Fatal(err)
+	}
+	recorded := `<DescribeVolumesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <requestId>59dbff89-35bd-4eac-99ed-be587EXAMPLE</requestId>
+  <volumeSet>
+    <item>
+      <volumeId>vol-1</volumeId>
+      <size>80</size>
+      <volumeType>gp2</volumeType>
+    </item>
+  </volumeSet>
+</DescribeVolumesResponse>`
+	if err := ioutil.WriteFile(filepath.Join(dir, EC2, "DescribeVolumes.xml"), []byte(recorded), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer()
+	defer server.Close()
+	defer server.Install()()
+	if err := server.LoadFixtures(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	ec2Client, _ := newFactory(t).EC2()
+	volumes, err := ec2Client.DescribeVolumes(&ec2.DescribeVolumesInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := aws.Int64Value(volumes.Volumes[0].Size), int64(80); got != want {
+		t.Errorf("bad volume size: got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,163 @@
+package events
+
+/*
+turns the per-resource findings of a check result into Sensu events with one
+proxy entity per AWS resource, so that every resource has its own history,
+silencing and handlers
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-aws/result"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+const (
+	// Agent posts the events to the Sensu agent events API
+	Agent = "agent"
+	// JSON writes the events to stdout, one JSON document per line
+	JSON = "json"
+
+	// DefaultAgentURL is the events endpoint of a local Sensu agent
+	DefaultAgentURL = "http://127.0.0.1:3031/events"
+	// ResourceLabel is the entity label holding the resource the entity was
+	// created for, before its name was made a valid entity name
+	ResourceLabel = "aws_resource"
+)
+
+var unsafeName = regexp.MustCompile(`[^\w\.\-]`)
+
+// out is replaced in tests
+var out io.Writer = os.Stdout
+
+// Options selects how proxy entity events are emitted, zero values emit none
+type Options struct {
+	// Mode is Agent, JSON or empty to disable proxy entity events
+	Mode      string
+	AgentURL  string
+	Namespace string
+	// Interval is the check interval reported in the events, in seconds
+	Interval uint32
+	Handlers []string
+}
+
+// PluginConfigOptions returns the proxy entity event options
+func (o *Options) PluginConfigOptions() []*sensu.PluginConfigOption {
+	return []*sensu.PluginConfigOption{
+		{Path: "proxy-events", Env: "PROXY_EVENTS", Argument: "proxy-events", Default: "", Usage: "Emit one event per resource with a proxy entity, either agent (post to the agent events API) or json (write to stdout)", Value: &o.Mode},
+		{Path: "agent-api-url", Env: "AGENT_API_URL", Argument: "agent-api-url", Default: DefaultAgentURL, Usage: "Sensu agent events API URL used by --proxy-events=agent", Value: &o.AgentURL},
+		{Path: "event-namespace", Env: "EVENT_NAMESPACE", Argument: "event-namespace", Default: "default", Usage: "Namespace of the proxy entity events", Value: &o.Namespace},
+		{Path: "event-interval", Env: "EVENT_INTERVAL", Argument: "event-interval", Default: uint32(60), Usage: "Check interval in seconds reported in the proxy entity events", Value: &o.Interval},
+		{Path: "event-handlers", Env: "EVENT_HANDLERS", Argument: "event-handlers", Default: []string{}, Usage: "Comma separated list of handlers of the proxy entity events", Value: &o.Handlers},
+	}
+}
+
+// EntityName returns resource with the characters not allowed in entity
+// names replaced by underscores
+func EntityName(resource string) string {
+	return unsafeName.ReplaceAllString(resource, "_")
+}
+
+// Events returns one event named check per resource of res, the status and
+// output of every event only cover the findings of its resource. Findings
+// without a resource are left to the event of the check itself
+func (o *Options) Events(check string, res *result.Result) []*corev2.Event {
+	byResource := make(map[string]*result.Result)
+	resources := []string{}
+	for _, finding := range res.Findings() {
+		if len(finding.Resource) == 0 {
+			continue
+		}
+		resourceRes, ok := byResource[finding.Resource]
+		if !ok {
+			resourceRes = result.New(check)
+			byResource[finding.Resource] = resourceRes
+			resources = append(resources, finding.Resource)
+		}
+		resourceRes.Add(finding.Status, finding.Resource, "%s", finding.Message)
+	}
+	sort.Strings(resources)
+
+	now := time.Now().Unix()
+	events := []*corev2.Event{}
+	for _, resource := range resources {
+		resourceRes := byResource[resource]
+		var output bytes.Buffer
+		_ = resourceRes.Write(&output)
+
+		entity := corev2.NewEntity(corev2.NewObjectMeta(EntityName(resource), o.Namespace))
+		entity.EntityClass = corev2.EntityProxyClass
+		entity.Labels = map[string]string{ResourceLabel: resource}
+
+		event := corev2.NewEvent(corev2.NewObjectMeta("", o.Namespace))
+		event.Timestamp = now
+		event.Entity = entity
+		event.Check = &corev2.Check{
+			ObjectMeta:      corev2.NewObjectMeta(check, o.Namespace),
+			Status:          uint32(resourceRes.Status()),
+			Output:          output.String(),
+			Interval:        o.Interval,
+			Handlers:        o.Handlers,
+			Executed:        now,
+			Issued:          now,
+			ProxyEntityName: entity.Name,
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// Emit emits the events of res according to Mode, it does nothing when Mode
+// is empty
+func (o *Options) Emit(check string, res *result.Result) error {
+	switch o.Mode {
+	case "":
+		return nil
+	case Agent, JSON:
+	default:
+		return fmt.Errorf("invalid proxy events mode %q, expected %s or %s", o.Mode, Agent, JSON)
+	}
+	for _, event := range o.Events(check, res) {
+		if err := event.Validate(); err != nil {
+			return fmt.Errorf("invalid event for %s: %v", event.Entity.Name, err)
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if o.Mode == JSON {
+			if _, err := fmt.Fprintln(out, string(data)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := o.post(data); err != nil {
+			return fmt.Errorf("failed to post event for %s: %v", event.Entity.Name, err)
+		}
+	}
+	return nil
+}
+
+func (o *Options) post(data []byte) error {
+	response, err := http.Post(o.AgentURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(response.Body)
+		return fmt.Errorf("%s: %s", response.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
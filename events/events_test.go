@@ -0,0 +1,145 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-aws/result"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+func testResult() *result.Result {
+	res := result.New("check-test")
+	res.OK("i-1", "cpuBalance 10")
+	res.Warning("us-east-1/i-2", "below warning threshold")
+	res.Critical("us-east-1/i-2", "below critical threshold")
+	res.Unknown("", "region not checked")
+	return res
+}
+
+func TestEvents(t *testing.T) {
+	options := Options{Namespace: "default", Interval: 60, Handlers: []string{"slack"}}
+	events := options.Events("check-test", testResult())
+	if got, want := len(events), 2; got != want {
+		t.Fatalf("bad event count: got %d, want %d", got, want)
+	}
+
+	tests := []struct {
+		Entity    string
+		Resource  string
+		ExpStatus uint32
+		ExpOutput string
+	}{
+		{
+			Entity:    "i-1",
+			Resource:  "i-1",
+			ExpStatus: 0,
+			ExpOutput: "check-test OK: i-1: cpuBalance 10\n",
+		},
+		{
+			Entity:    "us-east-1_i-2",
+			Resource:  "us-east-1/i-2",
+			ExpStatus: 2,
+			ExpOutput: "check-test CRITICAL: us-east-1/i-2: below critical threshold\n" +
+				"CRITICAL us-east-1/i-2: below critical threshold\n" +
+				"WARNING us-east-1/i-2: below warning threshold\n",
+		},
+	}
+	for i, test := range tests {
+		event := events[i]
+		if err := event.Validate(); err != nil {
+			t.Errorf("invalid event for %s: %v", test.Entity, err)
+		}
+		if got, want := event.Entity.Name, test.Entity; got != want {
+			t.Errorf("bad entity name: got %q, want %q", got, want)
+		}
+		if got, want := event.Entity.EntityClass, corev2.EntityProxyClass; got != want {
+			t.Errorf("bad entity class: got %q, want %q", got, want)
+		}
+		if got, want := event.Entity.Labels[ResourceLabel], test.Resource; got != want {
+			t.Errorf("bad resource label: got %q, want %q", got, want)
+		}
+		if got, want := event.Check.Status, test.ExpStatus; got != want {
+			t.Errorf("bad status for %s: got %d, want %d", test.Entity, got, want)
+		}
+		if got, want := event.Check.Output, test.ExpOutput; got != want {
+			t.Errorf("bad output for %s: got %q, want %q", test.Entity, got, want)
+		}
+		if got, want := event.Check.ProxyEntityName, test.Entity; got != want {
+			t.Errorf("bad proxy entity name: got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestEmitJSON(t *testing.T) {
+	var buf bytes.Buffer
+	out = &buf
+	defer func() { out = os.Stdout }()
+
+	options := Options{Mode: JSON, Namespace: "default", Interval: 60}
+	if err := options.Emit("check-test", testResult()); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if got, want := len(lines), 2; got != want {
+		t.Fatalf("bad line count: got %d, want %d", got, want)
+	}
+	var event corev2.Event
+	if err := json.Unmarshal([]byte(lines[1]), &event); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := event.Entity.Name, "us-east-1_i-2"; got != want {
+		t.Errorf("bad entity name: got %q, want %q", got, want)
+	}
+}
+
+func TestEmitAgent(t *testing.T) {
+	tests := []struct {
+		Name       string
+		StatusCode int
+		ExpError   bool
+	}{
+		{Name: "accepted", StatusCode: http.StatusAccepted},
+		{Name: "rejected", StatusCode: http.StatusBadRequest, ExpError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			entities := []string{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, _ := ioutil.ReadAll(r.Body)
+				var event corev2.Event
+				if err := json.Unmarshal(data, &event); err == nil {
+					entities = append(entities, event.Entity.Name)
+				}
+				w.WriteHeader(test.StatusCode)
+			}))
+			defer server.Close()
+
+			options := Options{Mode: Agent, AgentURL: server.URL, Namespace: "default", Interval: 60}
+			err := options.Emit("check-test", testResult())
+			if got, want := err != nil, test.ExpError; got != want {
+				t.Fatalf("conflicting error expectations: got (err != nil) == %v, want %v", got, want)
+			}
+			if !test.ExpError && len(entities) != 2 {
+				t.Errorf("bad posted entities: got %v, want 2 entities", entities)
+			}
+		})
+	}
+}
+
+func TestEmitDisabled(t *testing.T) {
+	options := Options{}
+	if err := options.Emit("check-test", testResult()); err != nil {
+		t.Fatal(err)
+	}
+	options.Mode = "xml"
+	if err := options.Emit("check-test", testResult()); err == nil {
+		t.Error("expected an error for an invalid mode")
+	}
+}
@@ -3,11 +3,13 @@ module github.com/sensu/sensu-aws
 go 1.13
 
 require (
-	github.com/aws/aws-sdk-go v1.19.11
+	github.com/aws/aws-sdk-go v1.34.34
+	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/robfig/cron v1.2.0 // indirect
 	github.com/sensu-community/sensu-plugin-sdk v0.6.0
 	github.com/sensu/sensu-aws-ec2-deregistration-handler v0.1.0 // indirect
 	github.com/sensu/sensu-go v5.10.1+incompatible
 	github.com/spf13/cobra v0.0.5
+	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.4.0
 )
@@ -0,0 +1,206 @@
+package metric
+
+/*
+buffers metric points and renders them in one of the formats understood by
+Sensu's output metric extraction
+*/
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+)
+
+// Format is a Sensu output_metric_format
+type Format string
+
+const (
+	GraphitePlaintext Format = "graphite_plaintext"
+	InfluxDBLine      Format = "influxdb_line"
+	OpenTSDBLine      Format = "opentsdb_line"
+	PrometheusText    Format = "prometheus_text"
+	NagiosPerfdata    Format = "nagios_perfdata"
+)
+
+// Formats lists the supported formats
+var Formats = []Format{GraphitePlaintext, InfluxDBLine, OpenTSDBLine, PrometheusText, NagiosPerfdata}
+
+var (
+	graphiteUnsafe   = regexp.MustCompile(`[^a-zA-Z0-9_\-]`)
+	openTSDBUnsafe   = regexp.MustCompile(`[^a-zA-Z0-9_\-./]`)
+	prometheusUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+	influxEscaper    = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+)
+
+// FormatOption returns the metric-format option read into value
+func FormatOption(value *string) *sensu.PluginConfigOption {
+	return &sensu.PluginConfigOption{
+		Path:     "metric-format",
+		Env:      "METRIC_FORMAT",
+		Argument: "metric-format",
+		Default:  string(GraphitePlaintext),
+		Usage:    "Metric output format: graphite_plaintext, influxdb_line, opentsdb_line, prometheus_text, nagios_perfdata",
+		Value:    value,
+	}
+}
+
+// ParseFormat validates a format name
+func ParseFormat(format string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == format {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("invalid metric format %q, expected one of %v", format, Formats)
+}
+
+// Tag is a metric dimension, e.g. a CloudWatch dimension name and value
+type Tag struct {
+	Name  string
+	Value string
+}
+
+// Point is a single metric value
+type Point struct {
+	Name      string
+	Value     float64
+	Timestamp time.Time
+	Tags      []Tag
+}
+
+// Writer collects points, it is safe for concurrent use
+type Writer struct {
+	Format Format
+	// Scheme is prepended to every metric name
+	Scheme string
+	mu     sync.Mutex
+	points []Point
+}
+
+func NewWriter(format string, scheme string) (*Writer, error) {
+	f, err := ParseFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{Format: f, Scheme: scheme}, nil
+}
+
+// Add records a point, a zero timestamp is replaced by the current time
+func (w *Writer) Add(name string, value float64, timestamp time.Time, tags ...Tag) {
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.points = append(w.points, Point{Name: name, Value: value, Timestamp: timestamp, Tags: tags})
+}
+
+// Points returns the recorded points
+func (w *Writer) Points() []Point {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	points := make([]Point, len(w.points))
+	copy(points, w.points)
+	return points
+}
+
+// Flush writes the recorded points to stdout
+func (w *Writer) Flush() error {
+	return w.Write(os.Stdout)
+}
+
+// Write renders the recorded points to out
+func (w *Writer) Write(out io.Writer) error {
+	points := w.Points()
+	if len(points) == 0 {
+		return nil
+	}
+	var lines []string
+	switch w.Format {
+	case GraphitePlaintext:
+		for _, p := range points {
+			lines = append(lines, fmt.Sprintf("%s %s %d", w.path(p), formatValue(p.Value), p.Timestamp.Unix()))
+		}
+	case InfluxDBLine:
+		for _, p := range points {
+			measurement, field := w.Scheme, p.Name
+			if len(measurement) == 0 {
+				measurement, field = p.Name, "value"
+			}
+			var b strings.Builder
+			b.WriteString(influxEscaper.Replace(measurement))
+			for _, tag := range p.Tags {
+				fmt.Fprintf(&b, ",%s=%s", influxEscaper.Replace(tag.Name), influxEscaper.Replace(tag.Value))
+			}
+			fmt.Fprintf(&b, " %s=%s %d", influxEscaper.Replace(field), formatValue(p.Value), p.Timestamp.UnixNano())
+			lines = append(lines, b.String())
+		}
+	case OpenTSDBLine:
+		for _, p := range points {
+			var b strings.Builder
+			fmt.Fprintf(&b, "%s %d %s", openTSDBUnsafe.ReplaceAllString(w.name(p.Name, "."), "_"), p.Timestamp.Unix(), formatValue(p.Value))
+			for _, tag := range p.Tags {
+				fmt.Fprintf(&b, " %s=%s", openTSDBUnsafe.ReplaceAllString(tag.Name, "_"), openTSDBUnsafe.ReplaceAllString(tag.Value, "_"))
+			}
+			lines = append(lines, b.String())
+		}
+	case PrometheusText:
+		typed := make(map[string]bool)
+		for _, p := range points {
+			name := prometheusUnsafe.ReplaceAllString(w.name(p.Name, "_"), "_")
+			if !typed[name] {
+				typed[name] = true
+				lines = append(lines, fmt.Sprintf("# TYPE %s gauge", name))
+			}
+			var labels []string
+			for _, tag := range p.Tags {
+				labels = append(labels, fmt.Sprintf("%s=%q", prometheusUnsafe.ReplaceAllString(tag.Name, "_"), tag.Value))
+			}
+			if len(labels) > 0 {
+				name = fmt.Sprintf("%s{%s}", name, strings.Join(labels, ","))
+			}
+			lines = append(lines, fmt.Sprintf("%s %s %d", name, formatValue(p.Value), p.Timestamp.UnixNano()/int64(time.Millisecond)))
+		}
+	case NagiosPerfdata:
+		var perfdata []string
+		for _, p := range points {
+			perfdata = append(perfdata, fmt.Sprintf("%s=%s;;;;", w.path(p), formatValue(p.Value)))
+		}
+		lines = append(lines, fmt.Sprintf("OK: %d metric(s) | %s", len(points), strings.Join(perfdata, " ")))
+	default:
+		return fmt.Errorf("invalid metric format %q", w.Format)
+	}
+	_, err := fmt.Fprintln(out, strings.Join(lines, "\n"))
+	return err
+}
+
+// path builds a dotted name from the scheme, the tag values and the point name
+func (w *Writer) path(p Point) string {
+	parts := []string{}
+	if len(w.Scheme) > 0 {
+		parts = append(parts, w.Scheme)
+	}
+	for _, tag := range p.Tags {
+		parts = append(parts, graphiteUnsafe.ReplaceAllString(tag.Value, "_"))
+	}
+	parts = append(parts, graphiteUnsafe.ReplaceAllString(p.Name, "_"))
+	return strings.Join(parts, ".")
+}
+
+func (w *Writer) name(name string, separator string) string {
+	if len(w.Scheme) == 0 {
+		return name
+	}
+	return strings.Join([]string{strings.Replace(w.Scheme, ".", separator, -1), name}, separator)
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,80 @@
+package metric
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	timestamp := time.Unix(1600000000, 0)
+	tests := []struct {
+		Format   string
+		Scheme   string
+		Output   string
+		ExpError bool
+	}{
+		{
+			Format: "graphite_plaintext",
+			Scheme: "sensu.aws.elb",
+			Output: "sensu.aws.elb.my_elb.Latency 0.25 1600000000\n" +
+				"sensu.aws.elb.my_elb.RequestCount 10 1600000000\n",
+		},
+		{
+			Format: "influxdb_line",
+			Scheme: "sensu.aws.elb",
+			Output: "sensu.aws.elb,LoadBalancerName=my.elb Latency=0.25 1600000000000000000\n" +
+				"sensu.aws.elb,LoadBalancerName=my.elb RequestCount=10 1600000000000000000\n",
+		},
+		{
+			Format: "influxdb_line",
+			Output: "Latency,LoadBalancerName=my.elb value=0.25 1600000000000000000\n" +
+				"RequestCount,LoadBalancerName=my.elb value=10 1600000000000000000\n",
+		},
+		{
+			Format: "opentsdb_line",
+			Scheme: "sensu.aws.elb",
+			Output: "sensu.aws.elb.Latency 1600000000 0.25 LoadBalancerName=my.elb\n" +
+				"sensu.aws.elb.RequestCount 1600000000 10 LoadBalancerName=my.elb\n",
+		},
+		{
+			Format: "prometheus_text",
+			Scheme: "sensu.aws.elb",
+			Output: "# TYPE sensu_aws_elb_Latency gauge\n" +
+				"sensu_aws_elb_Latency{LoadBalancerName=\"my.elb\"} 0.25 1600000000000\n" +
+				"# TYPE sensu_aws_elb_RequestCount gauge\n" +
+				"sensu_aws_elb_RequestCount{LoadBalancerName=\"my.elb\"} 10 1600000000000\n",
+		},
+		{
+			Format: "nagios_perfdata",
+			Scheme: "elb",
+			Output: "OK: 2 metric(s) | elb.my_elb.Latency=0.25;;;; elb.my_elb.RequestCount=10;;;;\n",
+		},
+		{
+			Format:   "json",
+			ExpError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Format, func(t *testing.T) {
+			w, err := NewWriter(test.Format, test.Scheme)
+			if got, want := err != nil, test.ExpError; got != want {
+				t.Fatalf("conflicting error expectations: got (err != nil) == %v, want %v", got, want)
+			}
+			if err != nil {
+				return
+			}
+			tag := Tag{Name: "LoadBalancerName", Value: "my.elb"}
+			w.Add("Latency", 0.25, timestamp, tag)
+			w.Add("RequestCount", 10, timestamp, tag)
+			var buf bytes.Buffer
+			if err := w.Write(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), test.Output; got != want {
+				t.Errorf("bad output: got %q, want %q", got, want)
+			}
+		})
+	}
+}
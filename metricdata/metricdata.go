@@ -0,0 +1,413 @@
+package metricdata
+
+/*
+fetches CloudWatch metrics with GetMetricData, batching the statistics and
+metric math expressions of a check into as few requests as possible
+*/
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// MaxQueries is the maximum number of queries of a GetMetricData request
+const MaxQueries = 500
+
+// identifier matches the quoted strings and the identifiers of a metric math
+// expression, query IDs start with a lowercase letter while functions are
+// uppercase
+var identifier = regexp.MustCompile(`'[^']*'|"[^"]*"|\b[a-z]\w*\b`)
+
+// percentile matches extended statistics such as p99 or p99.9
+var percentile = regexp.MustCompile(`^[pP](\d+(\.\d+)?)$`)
+
+// statistics maps the lowercase names of the standard statistics to their
+// CloudWatch name
+var statistics = map[string]string{
+	"average":     cloudwatch.StatisticAverage,
+	"maximum":     cloudwatch.StatisticMaximum,
+	"minimum":     cloudwatch.StatisticMinimum,
+	"samplecount": cloudwatch.StatisticSampleCount,
+	"sum":         cloudwatch.StatisticSum,
+}
+
+// ParseStat returns the CloudWatch name of a statistic given in any case,
+// e.g. Average for average, or of a percentile such as p99 or P99.9
+func ParseStat(stat string) (string, error) {
+	if name, ok := statistics[strings.ToLower(stat)]; ok {
+		return name, nil
+	}
+	if match := percentile.FindStringSubmatch(stat); match != nil {
+		if value, err := strconv.ParseFloat(match[1], 64); err == nil && value <= 100 {
+			return "p" + match[1], nil
+		}
+	}
+	return "", fmt.Errorf("invalid statistic %q, expected Average, Maximum, Minimum, SampleCount, Sum or a percentile such as p99", stat)
+}
+
+// Client is implemented by *cloudwatch.CloudWatch
+type Client interface {
+	GetMetricDataPages(*cloudwatch.GetMetricDataInput, func(*cloudwatch.GetMetricDataOutput, bool) bool) error
+}
+
+// Query is a statistic of a metric or, when Expression is set, a metric math
+// expression over the other queries of its group
+type Query struct {
+	// ID names the query in the expressions of its group, it must start with
+	// a lowercase letter and defaults to m<index in the group>
+	ID         string
+	Namespace  string
+	MetricName string
+	Dimensions []*cloudwatch.Dimension
+	// Stat is a statistic such as Average, Sum, Maximum or p99
+	Stat string
+	Unit string
+	// Period in seconds, defaults to the period of the batch
+	Period     int64
+	Expression string
+	Label      string
+	// Hidden queries are only inputs of expressions and return no series
+	Hidden bool
+}
+
+// queryID matches the IDs CloudWatch accepts for metric data queries
+var queryID = regexp.MustCompile(`^[a-z]\w*$`)
+
+// ParseQueries parses metric queries separated by ';', each written
+// id:namespace:metric-name:statistic[:dimensions], e.g.
+// m1:AWS/ELB:RequestCount:Sum:LoadBalancerName=web. The namespace and
+// dimensions may be left empty for the caller to fill in
+func ParseQueries(spec string) ([]Query, error) {
+	var queries []Query
+	ids := make(map[string]bool)
+	for _, part := range strings.Split(spec, ";") {
+		if len(strings.TrimSpace(part)) == 0 {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimSpace(part), ":", 5)
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("invalid metric query %q, expected id:namespace:metric-name:statistic[:dimensions]", part)
+		}
+		query := Query{ID: fields[0], Namespace: fields[1], MetricName: fields[2]}
+		if !queryID.MatchString(query.ID) {
+			return nil, fmt.Errorf("invalid metric query id %q, it must start with a lowercase letter followed by letters, digits or _", query.ID)
+		}
+		if ids[query.ID] {
+			return nil, fmt.Errorf("duplicate metric query id %q", query.ID)
+		}
+		ids[query.ID] = true
+		if len(query.MetricName) == 0 {
+			return nil, fmt.Errorf("invalid metric query %q, the metric name is empty", part)
+		}
+		var err error
+		if query.Stat, err = ParseStat(fields[3]); err != nil {
+			return nil, err
+		}
+		if len(fields) == 5 {
+			if query.Dimensions, err = ParseDimensions(fields[4]); err != nil {
+				return nil, err
+			}
+		}
+		queries = append(queries, query)
+	}
+	return queries, nil
+}
+
+// Dimension returns a metric dimension
+func Dimension(name string, value string) *cloudwatch.Dimension {
+	return &cloudwatch.Dimension{Name: aws.String(name), Value: aws.String(value)}
+}
+
+// ParseDimensions parses a comma delimited list of Name=Value dimensions,
+// e.g. LoadBalancerName=web,AvailabilityZone=us-east-1a. Values may contain
+// '=' but not ','
+func ParseDimensions(spec string) ([]*cloudwatch.Dimension, error) {
+	var dimensions []*cloudwatch.Dimension
+	for _, pair := range strings.Split(spec, ",") {
+		if len(strings.TrimSpace(pair)) == 0 {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || len(name) == 0 {
+			return nil, fmt.Errorf("invalid dimension %q, expected Name=Value", pair)
+		}
+		dimensions = append(dimensions, Dimension(name, strings.TrimSpace(parts[1])))
+	}
+	return dimensions, nil
+}
+
+// Datapoint is a value of a series at a timestamp
+type Datapoint struct {
+	Timestamp time.Time
+	Value     float64
+}
+
+// NoDataError is returned for a series without datapoints, which CloudWatch
+// returns for idle resources and metrics that are late or not published
+type NoDataError struct {
+	Label string
+}
+
+func (e *NoDataError) Error() string {
+	if len(e.Label) == 0 {
+		return "no datapoints returned from CloudWatch"
+	}
+	return fmt.Sprintf("no %s datapoints returned from CloudWatch", e.Label)
+}
+
+// IsNoData reports whether err is a *NoDataError
+func IsNoData(err error) bool {
+	_, ok := err.(*NoDataError)
+	return ok
+}
+
+// Series holds the datapoints of a query, newest first
+type Series struct {
+	Label      string
+	Timestamps []time.Time
+	Values     []float64
+	// StatusCode is Complete, or PartialData when more datapoints were
+	// available than returned
+	StatusCode string
+	Messages   []string
+	// Others holds the further series of expressions returning several, such
+	// as SEARCH or ANOMALY_DETECTION_BAND, told apart by their label
+	Others []*Series
+}
+
+// Latest returns the datapoint of s with the newest timestamp, whatever the
+// order and number of its datapoints, or a *NoDataError when s has none
+func (s *Series) Latest() (Datapoint, error) {
+	if s == nil {
+		return Datapoint{}, &NoDataError{}
+	}
+	latest := -1
+	for i := range s.Values {
+		if i < len(s.Timestamps) && (latest < 0 || s.Timestamps[i].After(s.Timestamps[latest])) {
+			latest = i
+		}
+	}
+	if latest < 0 {
+		return Datapoint{}, &NoDataError{Label: s.Label}
+	}
+	return Datapoint{Timestamp: s.Timestamps[latest], Value: s.Values[latest]}, nil
+}
+
+// Latest fetches the newest datapoint of query over the period seconds
+// ending fetchAge ago, Stat may be any statistic accepted by ParseStat
+func Latest(client Client, query Query, fetchAge time.Duration, period int64) (Datapoint, error) {
+	stat, err := ParseStat(query.Stat)
+	if err != nil {
+		return Datapoint{}, err
+	}
+	query.Stat = stat
+	batch := NewFetchBatch(fetchAge, period)
+	key := batch.Add(query)[0]
+	series, err := batch.Fetch(client)
+	if err != nil {
+		return Datapoint{}, err
+	}
+	return series[key].Latest()
+}
+
+// Batch collects the queries of a check over the same time range
+type Batch struct {
+	Start  time.Time
+	End    time.Time
+	Period int64
+
+	groups [][]*cloudwatch.MetricDataQuery
+	err    error
+}
+
+// NewBatch returns a batch of the period seconds long datapoints of the
+// last age
+func NewBatch(age time.Duration, period int64) *Batch {
+	end := time.Now()
+	return &Batch{Start: end.Add(-age), End: end, Period: period}
+}
+
+// NewFetchBatch returns a batch of the period seconds ending fetchAge ago,
+// to leave CloudWatch time to aggregate the datapoints of the period
+func NewFetchBatch(fetchAge time.Duration, period int64) *Batch {
+	end := time.Now().Add(-fetchAge)
+	return &Batch{Start: end.Add(-time.Duration(period) * time.Second), End: end, Period: period}
+}
+
+// Add adds a group of queries fetched in the same request, so that the
+// expressions of the group can refer to its other queries by ID, and returns
+// the key of each query in the series returned by Fetch
+func (b *Batch) Add(group ...Query) []string {
+	prefix := fmt.Sprintf("g%d_", len(b.groups))
+	ids := make(map[string]bool)
+	for i := range group {
+		if len(group[i].ID) == 0 {
+			group[i].ID = fmt.Sprintf("m%d", i)
+		}
+		ids[group[i].ID] = true
+	}
+	if len(group) > MaxQueries && b.err == nil {
+		b.err = fmt.Errorf("a group of %d metric queries exceeds the limit of %d per request", len(group), MaxQueries)
+	}
+
+	keys := make([]string, len(group))
+	queries := make([]*cloudwatch.MetricDataQuery, len(group))
+	for i, query := range group {
+		keys[i] = prefix + query.ID
+		dataQuery := &cloudwatch.MetricDataQuery{
+			Id:         aws.String(keys[i]),
+			ReturnData: aws.Bool(!query.Hidden),
+		}
+		if len(query.Label) > 0 {
+			dataQuery.Label = aws.String(query.Label)
+		}
+		if len(query.Expression) > 0 {
+			dataQuery.Expression = aws.String(identifier.ReplaceAllStringFunc(query.Expression, func(token string) string {
+				if ids[token] {
+					return prefix + token
+				}
+				return token
+			}))
+		} else {
+			period := query.Period
+			if period == 0 {
+				period = b.Period
+			}
+			dataQuery.MetricStat = &cloudwatch.MetricStat{
+				Metric: &cloudwatch.Metric{
+					Namespace:  aws.String(query.Namespace),
+					MetricName: aws.String(query.MetricName),
+					Dimensions: query.Dimensions,
+				},
+				Period: aws.Int64(period),
+				Stat:   aws.String(query.Stat),
+			}
+			if len(query.Unit) > 0 {
+				dataQuery.MetricStat.Unit = aws.String(query.Unit)
+			}
+		}
+		queries[i] = dataQuery
+	}
+	b.groups = append(b.groups, queries)
+	return keys
+}
+
+// Len returns the number of queries of the batch
+func (b *Batch) Len() int {
+	count := 0
+	for _, group := range b.groups {
+		count += len(group)
+	}
+	return count
+}
+
+// Requests returns the queries of each GetMetricData request of the batch,
+// groups are packed in order into requests of at most MaxQueries queries
+func (b *Batch) Requests() [][]*cloudwatch.MetricDataQuery {
+	requests := [][]*cloudwatch.MetricDataQuery{}
+	current := []*cloudwatch.MetricDataQuery{}
+	for _, group := range b.groups {
+		if len(current)+len(group) > MaxQueries && len(current) > 0 {
+			requests = append(requests, current)
+			current = []*cloudwatch.MetricDataQuery{}
+		}
+		current = append(current, group...)
+	}
+	if len(current) > 0 {
+		requests = append(requests, current)
+	}
+	return requests
+}
+
+// Fetch runs the queries of the batch and returns their series by key,
+// queries without datapoints have an empty series and hidden queries none
+func (b *Batch) Fetch(client Client) (map[string]*Series, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	series := make(map[string]*Series)
+	for _, queries := range b.Requests() {
+		input := &cloudwatch.GetMetricDataInput{
+			StartTime:         aws.Time(b.Start),
+			EndTime:           aws.Time(b.End),
+			ScanBy:            aws.String(cloudwatch.ScanByTimestampDescending),
+			MetricDataQueries: queries,
+		}
+		err := client.GetMetricDataPages(input, func(page *cloudwatch.GetMetricDataOutput, lastPage bool) bool {
+			for _, data := range page.MetricDataResults {
+				key := aws.StringValue(data.Id)
+				label := aws.StringValue(data.Label)
+				s, ok := series[key]
+				if !ok {
+					s = &Series{Label: label}
+					series[key] = s
+				} else {
+					s = s.labelled(label)
+				}
+				s.StatusCode = aws.StringValue(data.StatusCode)
+				for i, value := range data.Values {
+					if i < len(data.Timestamps) {
+						s.Timestamps = append(s.Timestamps, aws.TimeValue(data.Timestamps[i]))
+						s.Values = append(s.Values, aws.Float64Value(value))
+					}
+				}
+				for _, message := range data.Messages {
+					s.Messages = append(s.Messages, aws.StringValue(message.Value))
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, query := range queries {
+			if _, ok := series[*query.Id]; !ok && aws.BoolValue(query.ReturnData) {
+				series[*query.Id] = &Series{Label: label(query)}
+			}
+		}
+	}
+	return series, nil
+}
+
+// labelled returns the series of s or of its others with label, adding it to
+// the others when there is none, as the datapoints of each series of a query
+// may be spread over several pages
+func (s *Series) labelled(label string) *Series {
+	if s.Label == label {
+		return s
+	}
+	for _, other := range s.Others {
+		if other.Label == label {
+			return other
+		}
+	}
+	other := &Series{Label: label}
+	s.Others = append(s.Others, other)
+	return other
+}
+
+// All returns s followed by its others
+func (s *Series) All() []*Series {
+	if s == nil {
+		return nil
+	}
+	return append([]*Series{s}, s.Others...)
+}
+
+// label returns the label CloudWatch gives to the series of query
+func label(query *cloudwatch.MetricDataQuery) string {
+	switch {
+	case query.Label != nil:
+		return *query.Label
+	case query.MetricStat != nil:
+		return aws.StringValue(query.MetricStat.Metric.MetricName)
+	}
+	return aws.StringValue(query.Id)
+}
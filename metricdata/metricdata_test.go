@@ -0,0 +1,470 @@
+package metricdata
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// fakeClient answers each query with the datapoints of its ID, split in
+// pages of one datapoint, and records the requests
+type fakeClient struct {
+	datapoints map[string][]float64
+	requests   []*cloudwatch.GetMetricDataInput
+}
+
+func (f *fakeClient) GetMetricDataPages(input *cloudwatch.GetMetricDataInput, fn func(*cloudwatch.GetMetricDataOutput, bool) bool) error {
+	f.requests = append(f.requests, input)
+	var pages []*cloudwatch.GetMetricDataOutput
+	for _, query := range input.MetricDataQueries {
+		if !aws.BoolValue(query.ReturnData) {
+			continue
+		}
+		for i, value := range f.datapoints[*query.Id] {
+			pages = append(pages, &cloudwatch.GetMetricDataOutput{
+				MetricDataResults: []*cloudwatch.MetricDataResult{{
+					Id:         query.Id,
+					Label:      query.Label,
+					StatusCode: aws.String(cloudwatch.StatusCodeComplete),
+					Timestamps: []*time.Time{aws.Time(input.EndTime.Add(-time.Duration(i) * time.Minute))},
+					Values:     []*float64{aws.Float64(value)},
+				}},
+			})
+		}
+	}
+	for i, page := range pages {
+		if !fn(page, i == len(pages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestAdd(t *testing.T) {
+	batch := NewBatch(10*time.Minute, 60)
+	batch.Add(Query{Namespace: "AWS/EC2", MetricName: "CPUUtilization", Stat: "Average"})
+	keys := batch.Add(
+		Query{ID: "errors", Namespace: "AWS/ELB", MetricName: "HTTPCode_ELB_5XX", Stat: "Sum", Hidden: true},
+		Query{ID: "requests", Namespace: "AWS/ELB", MetricName: "RequestCount", Stat: "Sum", Period: 300, Hidden: true},
+		Query{ID: "rate", Expression: `100 * errors / requests + FILL(errors, 0) + SEARCH('{AWS/ELB} errors', "Sum", 60)`, Label: "rate"},
+	)
+
+	if got, want := fmt.Sprint(keys), "[g1_errors g1_requests g1_rate]"; got != want {
+		t.Errorf("bad keys: got %s, want %s", got, want)
+	}
+	if got, want := batch.Len(), 4; got != want {
+		t.Errorf("bad query count: got %d, want %d", got, want)
+	}
+	queries := batch.Requests()[0]
+	if got, want := aws.StringValue(queries[0].Id), "g0_m0"; got != want {
+		t.Errorf("bad default id: got %q, want %q", got, want)
+	}
+	if got, want := aws.Int64Value(queries[1].MetricStat.Period), int64(60); got != want {
+		t.Errorf("bad default period: got %d, want %d", got, want)
+	}
+	if got, want := aws.Int64Value(queries[2].MetricStat.Period), int64(300); got != want {
+		t.Errorf("bad period: got %d, want %d", got, want)
+	}
+	if aws.BoolValue(queries[1].ReturnData) {
+		t.Error("hidden query returns data")
+	}
+	expression := `100 * g1_errors / g1_requests + FILL(g1_errors, 0) + SEARCH('{AWS/ELB} errors', "Sum", 60)`
+	if got, want := aws.StringValue(queries[3].Expression), expression; got != want {
+		t.Errorf("bad expression: got %q, want %q", got, want)
+	}
+	if queries[3].MetricStat != nil {
+		t.Error("expression has a metric stat")
+	}
+}
+
+func TestRequests(t *testing.T) {
+	tests := []struct {
+		Name        string
+		GroupSizes  []int
+		ExpRequests []int
+	}{
+		{
+			Name:        "empty",
+			ExpRequests: []int{},
+		},
+		{
+			Name:        "one request",
+			GroupSizes:  []int{1, 2, 497},
+			ExpRequests: []int{500},
+		},
+		{
+			Name:        "single queries over the limit",
+			GroupSizes:  repeat(1, 1001),
+			ExpRequests: []int{500, 500, 1},
+		},
+		{
+			Name:        "groups are not split",
+			GroupSizes:  []int{300, 300, 200},
+			ExpRequests: []int{300, 500},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			batch := NewBatch(time.Hour, 60)
+			for _, size := range test.GroupSizes {
+				batch.Add(make([]Query, size)...)
+			}
+			sizes := []int{}
+			for _, queries := range batch.Requests() {
+				sizes = append(sizes, len(queries))
+			}
+			if got, want := fmt.Sprint(sizes), fmt.Sprint(test.ExpRequests); got != want {
+				t.Errorf("bad request sizes: got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func repeat(value int, count int) []int {
+	values := make([]int, count)
+	for i := range values {
+		values[i] = value
+	}
+	return values
+}
+
+func TestFetch(t *testing.T) {
+	client := &fakeClient{datapoints: map[string][]float64{
+		"g0_m0": {3, 2, 1},
+		"g1_m1": {7},
+	}}
+	batch := NewBatch(10*time.Minute, 60)
+	keys := batch.Add(Query{MetricName: "CPUUtilization", Stat: "Average", Label: "cpu"})
+	keys = append(keys, batch.Add(
+		Query{MetricName: "NetworkIn", Stat: "Sum", Hidden: true},
+		Query{MetricName: "NetworkOut", Stat: "Sum"},
+		Query{MetricName: "DiskReadOps", Stat: "Sum"},
+	)...)
+	for i := 0; i < MaxQueries; i++ {
+		batch.Add(Query{MetricName: "StatusCheckFailed", Stat: "Maximum"})
+	}
+
+	series, err := batch.Fetch(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(client.requests), 2; got != want {
+		t.Errorf("bad request count: got %d, want %d", got, want)
+	}
+	if got, want := aws.StringValue(client.requests[0].ScanBy), cloudwatch.ScanByTimestampDescending; got != want {
+		t.Errorf("bad scan order: got %q, want %q", got, want)
+	}
+
+	cpu := series[keys[0]]
+	if got, want := fmt.Sprint(cpu.Values), "[3 2 1]"; got != want {
+		t.Errorf("bad values: got %s, want %s", got, want)
+	}
+	if got, want := cpu.Label, "cpu"; got != want {
+		t.Errorf("bad label: got %q, want %q", got, want)
+	}
+	point, err := cpu.Latest()
+	if err != nil || point.Value != 3 || !point.Timestamp.Equal(batch.End) {
+		t.Errorf("bad latest datapoint: got %v (%v), want 3 at %v", point, err, batch.End)
+	}
+
+	if _, ok := series[keys[1]]; ok {
+		t.Error("hidden query has a series")
+	}
+	if point, err := series[keys[2]].Latest(); err != nil || point.Value != 7 {
+		t.Errorf("bad latest value: got %v (%v), want 7", point.Value, err)
+	}
+	if _, err := series[keys[3]].Latest(); !IsNoData(err) {
+		t.Errorf("bad error for a query without datapoints: got %v", err)
+	} else if got, want := err.Error(), "no DiskReadOps datapoints returned from CloudWatch"; got != want {
+		t.Errorf("bad error: got %q, want %q", got, want)
+	}
+	if got, want := len(series), 3+MaxQueries; got != want {
+		t.Errorf("bad series count: got %d, want %d", got, want)
+	}
+}
+
+func TestFetchGroupTooLarge(t *testing.T) {
+	client := &fakeClient{}
+	batch := NewBatch(time.Hour, 60)
+	batch.Add(make([]Query, MaxQueries+1)...)
+	if _, err := batch.Fetch(client); err == nil {
+		t.Fatal("expected an error")
+	}
+	if got, want := len(client.requests), 0; got != want {
+		t.Errorf("bad request count: got %d, want %d", got, want)
+	}
+}
+
+func TestParseStat(t *testing.T) {
+	tests := []struct {
+		Stat   string
+		Exp    string
+		ExpErr bool
+	}{
+		{Stat: "average", Exp: "Average"},
+		{Stat: "MAXIMUM", Exp: "Maximum"},
+		{Stat: "Minimum", Exp: "Minimum"},
+		{Stat: "samplecount", Exp: "SampleCount"},
+		{Stat: "sum", Exp: "Sum"},
+		{Stat: "p99", Exp: "p99"},
+		{Stat: "P99.9", Exp: "p99.9"},
+		{Stat: "p100", Exp: "p100"},
+		{Stat: "p100.1", ExpErr: true},
+		{Stat: "p", ExpErr: true},
+		{Stat: "median", ExpErr: true},
+		{Stat: "", ExpErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Stat, func(t *testing.T) {
+			stat, err := ParseStat(test.Stat)
+			if got, want := err != nil, test.ExpErr; got != want {
+				t.Fatalf("bad error: got %v", err)
+			}
+			if got, want := stat, test.Exp; got != want {
+				t.Errorf("bad statistic: got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestParseDimensions(t *testing.T) {
+	tests := []struct {
+		Spec   string
+		Exp    string
+		ExpErr bool
+	}{
+		{Spec: "", Exp: "[]"},
+		{Spec: "LoadBalancerName=web", Exp: "[LoadBalancerName=web]"},
+		{Spec: "LoadBalancerName=web, AvailabilityZone=us-east-1a,", Exp: "[LoadBalancerName=web AvailabilityZone=us-east-1a]"},
+		{Spec: "Query=a=b", Exp: "[Query=a=b]"},
+		{Spec: "Empty=", Exp: "[Empty=]"},
+		{Spec: "LoadBalancerName", ExpErr: true},
+		{Spec: "=web", ExpErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Spec, func(t *testing.T) {
+			dimensions, err := ParseDimensions(test.Spec)
+			if got, want := err != nil, test.ExpErr; got != want {
+				t.Fatalf("bad error: got %v", err)
+			}
+			if err != nil {
+				return
+			}
+			pairs := []string{}
+			for _, dimension := range dimensions {
+				pairs = append(pairs, *dimension.Name+"="+*dimension.Value)
+			}
+			if got, want := fmt.Sprint(pairs), test.Exp; got != want {
+				t.Errorf("bad dimensions: got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestSeriesLatest(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		Name      string
+		Series    *Series
+		ExpValue  float64
+		ExpTime   time.Time
+		ExpNoData bool
+	}{
+		{
+			Name:      "nil",
+			ExpNoData: true,
+		},
+		{
+			Name:      "empty",
+			Series:    &Series{Label: "CPUUtilization"},
+			ExpNoData: true,
+		},
+		{
+			Name:     "single datapoint",
+			Series:   &Series{Timestamps: []time.Time{now}, Values: []float64{4}},
+			ExpValue: 4,
+			ExpTime:  now,
+		},
+		{
+			Name: "oldest first",
+			Series: &Series{
+				Timestamps: []time.Time{now.Add(-2 * time.Minute), now.Add(-time.Minute), now},
+				Values:     []float64{1, 2, 3},
+			},
+			ExpValue: 3,
+			ExpTime:  now,
+		},
+		{
+			Name: "unordered",
+			Series: &Series{
+				Timestamps: []time.Time{now.Add(-time.Minute), now, now.Add(-2 * time.Minute)},
+				Values:     []float64{2, 3, 1},
+			},
+			ExpValue: 3,
+			ExpTime:  now,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			point, err := test.Series.Latest()
+			if got, want := IsNoData(err), test.ExpNoData; got != want {
+				t.Fatalf("bad error: got %v", err)
+			}
+			if got, want := point.Value, test.ExpValue; got != want {
+				t.Errorf("bad value: got %v, want %v", got, want)
+			}
+			if got, want := point.Timestamp, test.ExpTime; !got.Equal(want) {
+				t.Errorf("bad timestamp: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLatest(t *testing.T) {
+	client := &fakeClient{datapoints: map[string][]float64{"g0_m0": {12.5, 10}}}
+	query := Query{Namespace: "AWS/RDS", MetricName: "CPUUtilization", Stat: "P99"}
+	point, err := Latest(client, query, 5*time.Minute, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := point.Value, 12.5; got != want {
+		t.Errorf("bad value: got %v, want %v", got, want)
+	}
+
+	input := client.requests[0]
+	if got, want := aws.StringValue(input.MetricDataQueries[0].MetricStat.Stat), "p99"; got != want {
+		t.Errorf("bad statistic: got %q, want %q", got, want)
+	}
+	if got, want := input.EndTime.Sub(*input.StartTime), 5*time.Minute; got != want {
+		t.Errorf("bad time range: got %v, want %v", got, want)
+	}
+	if age := time.Since(*input.EndTime); age < 5*time.Minute || age > 6*time.Minute {
+		t.Errorf("bad end time: %v ago, want 5m", age)
+	}
+
+	if _, err := Latest(&fakeClient{}, Query{MetricName: "FreeableMemory", Stat: "Average"}, 0, 60); !IsNoData(err) {
+		t.Errorf("bad error without datapoints: got %v", err)
+	}
+	if _, err := Latest(client, Query{MetricName: "FreeableMemory", Stat: "mean"}, 0, 60); err == nil || IsNoData(err) {
+		t.Errorf("bad error for an invalid statistic: got %v", err)
+	}
+}
+
+func TestParseQueries(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Spec   string
+		Exp    string
+		ExpErr bool
+	}{
+		{
+			Name: "empty",
+			Exp:  "[]",
+		},
+		{
+			Name: "queries",
+			Spec: "m1:AWS/ELB:RequestCount:sum:LoadBalancerName=web; errors::HTTPCode_ELB_5XX:P99;",
+			Exp:  "[m1 AWS/ELB RequestCount Sum 1 errors  HTTPCode_ELB_5XX p99 0]",
+		},
+		{
+			Name: "arn dimension",
+			Spec: "m1:AWS/SNS:NumberOfNotificationsFailed:Sum:TopicArn=arn:aws:sns:us-east-1:123456789012:alerts",
+			Exp:  "[m1 AWS/SNS NumberOfNotificationsFailed Sum 1]",
+		},
+		{
+			Name:   "missing statistic",
+			Spec:   "m1:AWS/ELB:RequestCount",
+			ExpErr: true,
+		},
+		{
+			Name:   "invalid statistic",
+			Spec:   "m1:AWS/ELB:RequestCount:median",
+			ExpErr: true,
+		},
+		{
+			Name:   "uppercase id",
+			Spec:   "M1:AWS/ELB:RequestCount:Sum",
+			ExpErr: true,
+		},
+		{
+			Name:   "duplicate id",
+			Spec:   "m1:AWS/ELB:RequestCount:Sum;m1:AWS/ELB:Latency:Average",
+			ExpErr: true,
+		},
+		{
+			Name:   "missing metric name",
+			Spec:   "m1:AWS/ELB::Sum",
+			ExpErr: true,
+		},
+		{
+			Name:   "invalid dimensions",
+			Spec:   "m1:AWS/ELB:RequestCount:Sum:web",
+			ExpErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			queries, err := ParseQueries(test.Spec)
+			if got, want := err != nil, test.ExpErr; got != want {
+				t.Fatalf("bad error: got %v", err)
+			}
+			if err != nil {
+				return
+			}
+			fields := []interface{}{}
+			for _, query := range queries {
+				fields = append(fields, query.ID, query.Namespace, query.MetricName, query.Stat, len(query.Dimensions))
+			}
+			if got, want := fmt.Sprint(fields), test.Exp; got != want {
+				t.Errorf("bad queries: got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+// bandClient returns the upper and lower series of a band expression on
+// alternate pages
+type bandClient struct{}
+
+func (bandClient) GetMetricDataPages(input *cloudwatch.GetMetricDataInput, fn func(*cloudwatch.GetMetricDataOutput, bool) bool) error {
+	id := input.MetricDataQueries[0].Id
+	for i, label := range []string{"upper", "lower", "upper", "lower"} {
+		output := &cloudwatch.GetMetricDataOutput{MetricDataResults: []*cloudwatch.MetricDataResult{{
+			Id:         id,
+			Label:      aws.String(label),
+			Timestamps: []*time.Time{aws.Time(input.EndTime.Add(-time.Duration(i/2) * time.Minute))},
+			Values:     []*float64{aws.Float64(float64(i))},
+		}}}
+		if !fn(output, i == 3) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestFetchSeveralSeries(t *testing.T) {
+	batch := NewBatch(time.Hour, 60)
+	key := batch.Add(Query{Expression: "ANOMALY_DETECTION_BAND(m1)"})[0]
+	series, err := batch.Fetch(bandClient{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := series[key].All()
+	if got, want := len(all), 2; got != want {
+		t.Fatalf("bad series count: got %d, want %d", got, want)
+	}
+	for i, exp := range []string{"upper [0 2]", "lower [1 3]"} {
+		if got, want := fmt.Sprintf("%s %v", all[i].Label, all[i].Values), exp; got != want {
+			t.Errorf("bad series: got %s, want %s", got, want)
+		}
+	}
+}
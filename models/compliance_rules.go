@@ -0,0 +1,19 @@
+package models
+
+// ComplianceRules are the policies of check-ec2-compliance, zero values are
+// not checked
+type ComplianceRules struct {
+	RequiredTags        []RequiredTag `json:"required_tags"`
+	InstanceTypes       []string      `json:"instance_types"`
+	InstanceFamilies    []string      `json:"instance_families"`
+	RequireIMDSv2       bool          `json:"require_imdsv2"`
+	RequireEBSOptimized bool          `json:"require_ebs_optimized"`
+	PrivateSubnets      []string      `json:"private_subnets"`
+	ExcludeTags         []Tag         `json:"exclude_tags"`
+}
+
+// RequiredTag must be set, to one of Values when there are any
+type RequiredTag struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
@@ -0,0 +1,124 @@
+package plugin
+
+/*
+runs checks and metrics as sensu plugin SDK checks, so that every option can
+be set by flag, environment variable or check and entity annotation, and
+keeps accepting the snake_case flags of earlier releases
+*/
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-aws/result"
+	corev2 "github.com/sensu/sensu-go/api/core/v2"
+)
+
+// KeyspacePrefix is prepended to the plugin name to build the annotation
+// keyspace of its options
+const KeyspacePrefix = "sensu.io/plugins/sensu-aws/"
+
+// NewConfig returns the configuration of the plugin name
+func NewConfig(name string, short string) *sensu.PluginConfig {
+	return &sensu.PluginConfig{
+		Name:     name,
+		Short:    short,
+		Timeout:  10,
+		Keyspace: KeyspacePrefix + name,
+	}
+}
+
+// Check runs a check plugin, run records its findings in a result named
+// after the plugin which is written to stdout. validate may be nil, an error
+// it returns exits with UNKNOWN before run is called
+func Check(config *sensu.PluginConfig, options []*sensu.PluginConfigOption, validate func() error, run func(res *result.Result)) {
+	execute(config, options, validate, run, true)
+}
+
+// Metrics runs a metrics plugin, run writes its metrics to stdout itself and
+// the result is only written when it is worse than OK
+func Metrics(config *sensu.PluginConfig, options []*sensu.PluginConfigOption, validate func() error, run func(res *result.Result)) {
+	execute(config, options, validate, run, false)
+}
+
+func execute(config *sensu.PluginConfig, options []*sensu.PluginConfigOption, validate func() error, run func(res *result.Result), writeOK bool) {
+	os.Args = Aliases(os.Args, options, os.Stderr)
+	validator := func(*corev2.Event) (int, error) {
+		if validate == nil {
+			return int(result.OK), nil
+		}
+		if err := validate(); err != nil {
+			return int(result.Unknown), err
+		}
+		return int(result.OK), nil
+	}
+	executor := func(*corev2.Event) (int, error) {
+		res := result.New(config.Name)
+		run(res)
+		if writeOK || res.Status() != result.OK {
+			_ = res.Write(os.Stdout)
+		}
+		return int(res.Status()), nil
+	}
+	sensu.NewGoCheck(config, options, validator, executor, readEvent()).Execute()
+}
+
+// Aliases returns args with the snake_case spelling of the kebab-case
+// options replaced, e.g. --aws_region=us-east-1 by --aws-region=us-east-1,
+// and writes a deprecation notice for each of them to warnings
+func Aliases(args []string, options []*sensu.PluginConfigOption, warnings io.Writer) []string {
+	arguments := make(map[string]bool)
+	for _, option := range options {
+		arguments[option.Argument] = true
+	}
+	aliased := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(aliased, args[i:]...)
+		}
+		if !strings.HasPrefix(arg, "--") || !strings.Contains(arg, "_") {
+			aliased = append(aliased, arg)
+			continue
+		}
+		name, value := arg[2:], ""
+		if j := strings.Index(name, "="); j >= 0 {
+			name, value = name[:j], name[j:]
+		}
+		argument := strings.Replace(name, "_", "-", -1)
+		if arguments[name] || !arguments[argument] {
+			aliased = append(aliased, arg)
+			continue
+		}
+		fmt.Fprintf(warnings, "Flag --%s has been deprecated, use --%s instead\n", name, argument)
+		aliased = append(aliased, "--"+argument+value)
+	}
+	return aliased
+}
+
+// readEvent reports whether a Sensu event is piped to the plugin, as it is
+// for checks with stdin enabled, so that check and entity annotations can
+// override options. Stdin is read ahead so that empty input is ignored
+func readEvent() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeNamedPipe == 0 && !info.Mode().IsRegular() {
+		return false
+	}
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil || len(strings.TrimSpace(string(data))) == 0 {
+		return false
+	}
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return false
+	}
+	go func() {
+		_, _ = writer.Write(data)
+		writer.Close()
+	}()
+	os.Stdin = reader
+	return true
+}
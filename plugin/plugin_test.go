@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+)
+
+func TestAliases(t *testing.T) {
+	var region, bucket string
+	var verbose bool
+	options := []*sensu.PluginConfigOption{
+		{Argument: "aws-region", Value: &region},
+		{Argument: "bucket_name", Value: &bucket},
+		{Argument: "verbose", Value: &verbose},
+	}
+
+	tests := []struct {
+		Name        string
+		Args        []string
+		ExpArgs     []string
+		ExpWarnings string
+	}{
+		{
+			Name:    "kebab-case",
+			Args:    []string{"check", "--aws-region", "us-east-1", "-v"},
+			ExpArgs: []string{"check", "--aws-region", "us-east-1", "-v"},
+		},
+		{
+			Name:        "snake_case",
+			Args:        []string{"check", "--aws_region", "us-east-1"},
+			ExpArgs:     []string{"check", "--aws-region", "us-east-1"},
+			ExpWarnings: "Flag --aws_region has been deprecated, use --aws-region instead\n",
+		},
+		{
+			Name:        "snake_case with value",
+			Args:        []string{"check", "--aws_region=us-east-1"},
+			ExpArgs:     []string{"check", "--aws-region=us-east-1"},
+			ExpWarnings: "Flag --aws_region has been deprecated, use --aws-region instead\n",
+		},
+		{
+			Name:    "snake_case option",
+			Args:    []string{"check", "--bucket_name", "logs"},
+			ExpArgs: []string{"check", "--bucket_name", "logs"},
+		},
+		{
+			Name:    "unknown flag",
+			Args:    []string{"check", "--max_items", "10"},
+			ExpArgs: []string{"check", "--max_items", "10"},
+		},
+		{
+			Name:    "after terminator",
+			Args:    []string{"check", "--", "--aws_region"},
+			ExpArgs: []string{"check", "--", "--aws_region"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			var warnings bytes.Buffer
+			args := Aliases(test.Args, options, &warnings)
+			if got, want := args, test.ExpArgs; !reflect.DeepEqual(got, want) {
+				t.Errorf("bad args: got %v, want %v", got, want)
+			}
+			if got, want := warnings.String(), test.ExpWarnings; got != want {
+				t.Errorf("bad warnings: got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestNewConfig(t *testing.T) {
+	config := NewConfig("check-s3-bucket", "Checks a bucket")
+	if got, want := config.Keyspace, "sensu.io/plugins/sensu-aws/check-s3-bucket"; got != want {
+		t.Errorf("bad keyspace: got %q, want %q", got, want)
+	}
+}
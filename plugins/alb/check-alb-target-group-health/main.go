@@ -2,28 +2,26 @@ package main
 
 import (
 	"errors"
-	"fmt"
-	"log"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/elbv2"
 	"github.com/sensu-community/sensu-plugin-sdk/sensu"
-	corev2 "github.com/sensu/sensu-go/api/core/v2"
+	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/events"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/regions"
+	"github.com/sensu/sensu-aws/result"
 )
 
 var (
-	targetGroups []string
-	awsRegion    string
-	critical     bool
-	awsSession   *session.Session
+	targetGroups   []string
+	critical       bool
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	eventOptions   events.Options
 
-	config = &sensu.PluginConfig{
-		Name:     "check-alb-target-group-health",
-		Short:    "The Sensu Go Aws ALB check for health management",
-		Timeout:  10,
-		Keyspace: "sensu.io/plugins/sensu-aws/check-alb-target-group-health",
-	}
+	config = plugin.NewConfig("check-alb-target-group-health", "The Sensu Go Aws ALB check for health management")
 
 	options = []*sensu.PluginConfigOption{
 		{
@@ -33,14 +31,6 @@ var (
 			Usage:    "The ALB target group(s) to check",
 			Value:    &targetGroups,
 		},
-		{
-			Path:     "aws-region",
-			Env:      "AWS_REGION",
-			Argument: "aws-region",
-			Usage:    "AWS Region",
-			Default:  "us-east-1",
-			Value:    &awsRegion,
-		},
 		{
 			Path:     "critical",
 			Env:      "CRITICAL",
@@ -59,40 +49,44 @@ type ELBClient interface {
 }
 
 func checkHealth(client ELBClient, targets []string, critical bool) (int, error) {
+	res := result.New("")
+	checkTargetGroups(res, client, targets, critical)
+	return int(res.Status()), res.Err()
+}
+
+// checkTargetGroups records a finding per target group in res
+func checkTargetGroups(res *result.Result, client ELBClient, targets []string, critical bool) {
 	targetGroups, err := getTargetGroups(client, targets)
 	if err != nil {
-		return 2, err
+		res.Error("", err)
+		return
+	}
+	status := result.Warning
+	if critical {
+		status = result.Critical
 	}
-	unhealthyTargetGroups := make(map[string]int)
-	unhealthyTargets := make(map[string][]string)
 	for _, targetGroup := range targetGroups {
 		healthInput := &elbv2.DescribeTargetHealthInput{}
 		healthInput.TargetGroupArn = targetGroup.TargetGroupArn
 		healthOutput, err := client.DescribeTargetHealth(healthInput)
 		if err != nil {
-			return 2, err
-		}
-		if !(healthOutput != nil && healthOutput.TargetHealthDescriptions != nil && len(healthOutput.TargetHealthDescriptions) > 0) {
-			continue
+			res.Error("", err)
+			return
 		}
-		for _, target := range healthOutput.TargetHealthDescriptions {
-			if *target.TargetHealth.State == "unhealthy" {
-				unhealthyTargetGroups[*targetGroup.TargetGroupName] = unhealthyTargetGroups[*targetGroup.TargetGroupName] + 1
-				unhealthyTargets[*targetGroup.TargetGroupName] = append(unhealthyTargets[*targetGroup.TargetGroupName], *target.Target.Id)
+		unhealthyTargets := []string{}
+		if healthOutput != nil {
+			for _, target := range healthOutput.TargetHealthDescriptions {
+				if *target.TargetHealth.State == "unhealthy" {
+					unhealthyTargets = append(unhealthyTargets, *target.Target.Id)
+				}
 			}
 		}
+		if len(unhealthyTargets) > 0 {
+			res.Add(status, *targetGroup.TargetGroupName, "%d unhealthy members - %v", len(unhealthyTargets), unhealthyTargets)
+		} else {
+			res.OK(*targetGroup.TargetGroupName, "no unhealthy members")
+		}
 	}
-	if len(unhealthyTargetGroups) <= 0 {
-		return 0, nil
-	}
-	status := 1
-	if critical {
-		status = 2
-	}
-	for targetGroup, count := range unhealthyTargetGroups {
-		log.Println(fmt.Sprintf("Target group '%s' has %d unhealthy members - %v", targetGroup, count, unhealthyTargets[targetGroup]))
-	}
-	return status, errors.New("one or more target groups is unhealthy")
 }
 
 func getTargetGroups(client ELBClient, targetGroups []string) ([]*elbv2.TargetGroup, error) {
@@ -110,17 +104,22 @@ func getTargetGroups(client ELBClient, targetGroups []string) ([]*elbv2.TargetGr
 }
 
 func main() {
-	validator := func(*corev2.Event) (int, error) {
-		return 0, nil
-	}
-	executor := func(*corev2.Event) (int, error) {
-		session, err := session.NewSession(&aws.Config{
-			Region: &awsRegion,
-		})
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, eventOptions.PluginConfigOptions()...)
+	plugin.Check(config, options, nil, run)
+}
+
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, func(factory *awsclient.Factory, res *result.Result) {
+		client, err := factory.ELBV2()
 		if err != nil {
-			return 2, err
+			res.Error("", err)
+			return
 		}
-		return checkHealth(elbv2.New(session), targetGroups, critical)
+		checkTargetGroups(res, client, targetGroups, critical)
+	})
+	if err := eventOptions.Emit(config.Name, res); err != nil {
+		res.Error("", err)
 	}
-	sensu.NewGoCheck(config, options, validator, executor, false).Execute()
 }
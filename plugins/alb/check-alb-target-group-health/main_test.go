@@ -4,9 +4,11 @@ import (
 	"errors"
 	"io/ioutil"
 	"log"
+	"reflect"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/sensu/sensu-aws/result"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -47,7 +49,7 @@ func TestCheckHealth(t *testing.T) {
 				return new(elbClient)
 			},
 			MockExpect: func(testing.TB, *mock.Mock) {},
-			ExpStatus:  2,
+			ExpStatus:  3,
 			ExpError:   true,
 		},
 		{
@@ -67,7 +69,7 @@ func TestCheckHealth(t *testing.T) {
 				client.AssertCalled(t, "DescribeTargetGroups", mock.Anything)
 				client.AssertNotCalled(t, "DescribeTargetHealth", mock.Anything)
 			},
-			ExpStatus: 2,
+			ExpStatus: 3,
 			ExpError:  true,
 		},
 		{
@@ -99,7 +101,7 @@ func TestCheckHealth(t *testing.T) {
 				client.AssertCalled(t, "DescribeTargetGroups", mock.Anything)
 				client.AssertNumberOfCalls(t, "DescribeTargetHealth", 1)
 			},
-			ExpStatus: 2,
+			ExpStatus: 3,
 			ExpError:  true,
 		},
 		{
@@ -279,3 +281,38 @@ func TestCheckHealth(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckTargetGroupsFindings(t *testing.T) {
+	groupNameA := "group A"
+	groupNameB := "group B"
+	healthy := "healthy"
+	unhealthy := "unhealthy"
+	target := "i-1"
+	client := new(elbClient)
+	client.On("DescribeTargetGroups", mock.Anything).Return(&elbv2.DescribeTargetGroupsOutput{
+		TargetGroups: []*elbv2.TargetGroup{
+			{TargetGroupName: &groupNameA, TargetGroupArn: &groupNameA},
+			{TargetGroupName: &groupNameB, TargetGroupArn: &groupNameB},
+		},
+	}, nil)
+	client.On("DescribeTargetHealth", &elbv2.DescribeTargetHealthInput{TargetGroupArn: &groupNameA}).Return(&elbv2.DescribeTargetHealthOutput{
+		TargetHealthDescriptions: []*elbv2.TargetHealthDescription{
+			{TargetHealth: &elbv2.TargetHealth{State: &healthy}, Target: &elbv2.TargetDescription{Id: &target}},
+		},
+	}, nil)
+	client.On("DescribeTargetHealth", &elbv2.DescribeTargetHealthInput{TargetGroupArn: &groupNameB}).Return(&elbv2.DescribeTargetHealthOutput{
+		TargetHealthDescriptions: []*elbv2.TargetHealthDescription{
+			{TargetHealth: &elbv2.TargetHealth{State: &unhealthy}, Target: &elbv2.TargetDescription{Id: &target}},
+		},
+	}, nil)
+
+	res := result.New("")
+	checkTargetGroups(res, client, []string{groupNameA, groupNameB}, true)
+	expFindings := []result.Finding{
+		{Resource: groupNameB, Status: result.Critical, Message: "1 unhealthy members - [i-1]"},
+		{Resource: groupNameA, Status: result.OK, Message: "no unhealthy members"},
+	}
+	if got, want := res.Findings(), expFindings; !reflect.DeepEqual(got, want) {
+		t.Errorf("bad findings: got %v, want %v", got, want)
+	}
+}
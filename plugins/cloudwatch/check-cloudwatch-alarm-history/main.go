@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/regions"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/utils"
+)
+
+/*
+#
+# check-cloudwatch-alarm-history
+#
+# DESCRIPTION:
+#   This plugin reads the state history of CloudWatch alarms and raises an
+#   alert for alarms flapping between states or stuck in ALARM, with the
+#   state transitions of each alarm over the window.
+#
+# OUTPUT:
+#   plain-text
+#
+# PLATFORMS:
+#   MAC OS
+#
+# USAGE:
+#   ./check-cloudwatch-alarm-history --window=3600 --max-transitions=4
+#   ./check-cloudwatch-alarm-history --alarm-name-prefix=prod- --max-transitions=0 --max-alarm-duration=86400
+#   ./check-cloudwatch-alarm-history --alarm-name=web-cpu --window=21600 --max-transitions=6 --flapping-status=critical
+#
+# NOTES:
+#   An alarm is flapping when it changes state more than --max-transitions
+#   times within the last --window seconds, and stuck when it has been in
+#   ALARM for more than --max-alarm-duration seconds. Either test is disabled
+#   by setting it to 0.
+#
+# LICENSE:
+#   TODO
+#
+*/
+
+var (
+	sessionOptions   aws_session.Options
+	regionOptions    regions.Options
+	pager            utils.Pager
+	alarmName        string
+	alarmNamePrefix  string
+	excludeAlarms    []string
+	window           int64
+	maxTransitions   int
+	maxAlarmDuration int64
+	flappingStatus   string
+	stuckStatus      string
+
+	config = plugin.NewConfig("check-cloudwatch-alarm-history", "The Sensu Go Aws Cloudwatch handler for alarm history")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "alarm-name",
+			Env:      "ALARM_NAME",
+			Argument: "alarm-name",
+			Default:  "",
+			Usage:    "Only check the alarm with this name",
+			Value:    &alarmName,
+		},
+		{
+			Path:     "alarm-name-prefix",
+			Env:      "ALARM_NAME_PREFIX",
+			Argument: "alarm-name-prefix",
+			Default:  "",
+			Usage:    "Only check alarms whose name starts with this prefix",
+			Value:    &alarmNamePrefix,
+		},
+		{
+			Path:     "exclude-alarms",
+			Env:      "EXCLUDE_ALARMS",
+			Argument: "exclude-alarms",
+			Default:  []string{},
+			Usage:    "Comma delimited list of alarms to exclude",
+			Value:    &excludeAlarms,
+		},
+		{
+			Path:     "window",
+			Env:      "WINDOW",
+			Argument: "window",
+			Default:  int64(3600),
+			Usage:    "Window of alarm history to read in seconds",
+			Value:    &window,
+		},
+		{
+			Path:     "max-transitions",
+			Env:      "MAX_TRANSITIONS",
+			Argument: "max-transitions",
+			Default:  4,
+			Usage:    "Alert when an alarm changes state more than this many times in the window, 0 to disable",
+			Value:    &maxTransitions,
+		},
+		{
+			Path:     "max-alarm-duration",
+			Env:      "MAX_ALARM_DURATION",
+			Argument: "max-alarm-duration",
+			Default:  int64(0),
+			Usage:    "Alert when an alarm has been in ALARM for more than this many seconds, 0 to disable",
+			Value:    &maxAlarmDuration,
+		},
+		{
+			Path:     "flapping-status",
+			Env:      "FLAPPING_STATUS",
+			Argument: "flapping-status",
+			Default:  "warning",
+			Usage:    "Status of flapping alarms: ok, warning, critical or unknown",
+			Value:    &flappingStatus,
+		},
+		{
+			Path:     "stuck-status",
+			Env:      "STUCK_STATUS",
+			Argument: "stuck-status",
+			Default:  "critical",
+			Usage:    "Status of alarms stuck in ALARM: ok, warning, critical or unknown",
+			Value:    &stuckStatus,
+		},
+	}
+)
+
+// CloudWatchClient represents the CloudWatch dependencies of the check
+type CloudWatchClient interface {
+	DescribeAlarmsPages(*cloudwatch.DescribeAlarmsInput, func(*cloudwatch.DescribeAlarmsOutput, bool) bool) error
+	DescribeAlarmHistoryPages(*cloudwatch.DescribeAlarmHistoryInput, func(*cloudwatch.DescribeAlarmHistoryOutput, bool) bool) error
+}
+
+// transition is a change of state of an alarm
+type transition struct {
+	Timestamp time.Time
+	Summary   string
+}
+
+// historyData is the part of the HistoryData JSON of a state update used by
+// the check
+type historyData struct {
+	OldState struct {
+		StateValue string `json:"stateValue"`
+	} `json:"oldState"`
+	NewState struct {
+		StateValue string `json:"stateValue"`
+	} `json:"newState"`
+}
+
+func newTransition(item *cloudwatch.AlarmHistoryItem) transition {
+	t := transition{
+		Timestamp: aws.TimeValue(item.Timestamp),
+		Summary:   aws.StringValue(item.HistorySummary),
+	}
+	var data historyData
+	if err := json.Unmarshal([]byte(aws.StringValue(item.HistoryData)), &data); err == nil && len(data.NewState.StateValue) > 0 {
+		t.Summary = fmt.Sprintf("%s to %s", data.OldState.StateValue, data.NewState.StateValue)
+	}
+	return t
+}
+
+// timeline describes transitions in chronological order
+func timeline(transitions []transition) string {
+	sort.Slice(transitions, func(i, j int) bool {
+		return transitions[i].Timestamp.Before(transitions[j].Timestamp)
+	})
+	steps := make([]string, len(transitions))
+	for i, t := range transitions {
+		steps[i] = fmt.Sprintf("%s at %s", t.Summary, t.Timestamp.UTC().Format(time.RFC3339))
+	}
+	return strings.Join(steps, ", ")
+}
+
+// selected returns whether the alarm name passes the name filters
+func selected(name string) bool {
+	if len(alarmName) > 0 && name != alarmName {
+		return false
+	}
+	if !strings.HasPrefix(name, alarmNamePrefix) {
+		return false
+	}
+	for _, excluded := range excludeAlarms {
+		if name == excluded {
+			return false
+		}
+	}
+	return true
+}
+
+// alarmsSince returns the names of the alarms in ALARM since each time
+func alarmsSince(client CloudWatchClient) (map[string]time.Time, error) {
+	input := &cloudwatch.DescribeAlarmsInput{StateValue: aws.String(cloudwatch.StateValueAlarm)}
+	if len(alarmName) > 0 {
+		input.AlarmNames = aws.StringSlice([]string{alarmName})
+	} else if len(alarmNamePrefix) > 0 {
+		input.AlarmNamePrefix = aws.String(alarmNamePrefix)
+	}
+	since := make(map[string]time.Time)
+	metricAlarms, err := pager.Alarms(client, input)
+	if err != nil {
+		return nil, err
+	}
+	for _, alarm := range metricAlarms {
+		since[aws.StringValue(alarm.AlarmName)] = aws.TimeValue(alarm.StateUpdatedTimestamp)
+	}
+	input.AlarmTypes = aws.StringSlice([]string{cloudwatch.AlarmTypeCompositeAlarm})
+	compositeAlarms, err := pager.CompositeAlarms(client, input)
+	if err != nil {
+		return nil, err
+	}
+	for _, alarm := range compositeAlarms {
+		since[aws.StringValue(alarm.AlarmName)] = aws.TimeValue(alarm.StateUpdatedTimestamp)
+	}
+	return since, nil
+}
+
+func checkHistory(factory *awsclient.Factory, res *result.Result) {
+	cloudWatchClient, err := factory.CloudWatch()
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+
+	now := time.Now()
+	windowDuration := time.Duration(window) * time.Second
+	input := &cloudwatch.DescribeAlarmHistoryInput{
+		StartDate:       aws.Time(now.Add(-windowDuration)),
+		EndDate:         aws.Time(now),
+		HistoryItemType: aws.String(cloudwatch.HistoryItemTypeStateUpdate),
+		AlarmTypes:      aws.StringSlice([]string{cloudwatch.AlarmTypeMetricAlarm, cloudwatch.AlarmTypeCompositeAlarm}),
+	}
+	if len(alarmName) > 0 {
+		input.AlarmName = aws.String(alarmName)
+	}
+	items, err := pager.AlarmHistory(cloudWatchClient, input)
+	if err != nil {
+		res.Unknown("", "failed to get cloudwatch alarm history: %v", err)
+		return
+	}
+	history := make(map[string][]transition)
+	var names []string
+	for _, item := range items {
+		name := aws.StringValue(item.AlarmName)
+		if !selected(name) {
+			continue
+		}
+		if _, ok := history[name]; !ok {
+			names = append(names, name)
+		}
+		history[name] = append(history[name], newTransition(item))
+	}
+	sort.Strings(names)
+
+	if maxTransitions > 0 {
+		status, _ := result.ParseStatus(flappingStatus)
+		for _, name := range names {
+			if transitions := history[name]; len(transitions) > maxTransitions {
+				res.Add(status, name, "flapping, %d transitions in the last %s: %s", len(transitions), windowDuration, timeline(transitions))
+			}
+		}
+	}
+
+	if maxAlarmDuration > 0 {
+		since, err := alarmsSince(cloudWatchClient)
+		if err != nil {
+			res.Unknown("", "failed to get cloudwatch alarm details: %v", err)
+			return
+		}
+		stuck := []string{}
+		for name := range since {
+			stuck = append(stuck, name)
+		}
+		sort.Strings(stuck)
+		status, _ := result.ParseStatus(stuckStatus)
+		for _, name := range stuck {
+			if !selected(name) || now.Sub(since[name]) <= time.Duration(maxAlarmDuration)*time.Second {
+				continue
+			}
+			message := fmt.Sprintf("in ALARM since %s (%s)", since[name].UTC().Format(time.RFC3339), now.Sub(since[name]).Truncate(time.Second))
+			if transitions := history[name]; len(transitions) > 0 {
+				message += ": " + timeline(transitions)
+			}
+			res.Add(status, name, "%s", message)
+		}
+	}
+
+	res.SetOKMessage("No alarm flapping or stuck in ALARM")
+}
+
+func main() {
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
+}
+
+func validate() error {
+	if window <= 0 {
+		return errors.New("--window must be greater than 0")
+	}
+	if maxTransitions <= 0 && maxAlarmDuration <= 0 {
+		return errors.New("one of --max-transitions or --max-alarm-duration is required")
+	}
+	if _, err := result.ParseStatus(flappingStatus); err != nil {
+		return fmt.Errorf("--flapping-status: %v", err)
+	}
+	if _, err := result.ParseStatus(stuckStatus); err != nil {
+		return fmt.Errorf("--stuck-status: %v", err)
+	}
+	return nil
+}
+
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkHistory)
+	pager.Report(res)
+}
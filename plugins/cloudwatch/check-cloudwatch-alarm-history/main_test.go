@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/sensu/sensu-aws/awstest"
+)
+
+func TestMain(m *testing.M) {
+	awstest.Main(m, main)
+}
+
+func TestPlugin(t *testing.T) {
+	server := awstest.NewServer()
+	defer server.Close()
+	start := time.Now().Add(-50 * time.Minute).Truncate(time.Minute).UTC()
+	update := func(name string, minutes int, from string, to string) *cloudwatch.AlarmHistoryItem {
+		return &cloudwatch.AlarmHistoryItem{
+			AlarmName:       aws.String(name),
+			HistoryItemType: aws.String(cloudwatch.HistoryItemTypeStateUpdate),
+			HistorySummary:  aws.String("Alarm updated from " + from + " to " + to),
+			HistoryData:     aws.String(`{"version":"1.0","oldState":{"stateValue":"` + from + `"},"newState":{"stateValue":"` + to + `"}}`),
+			Timestamp:       aws.Time(start.Add(time.Duration(minutes) * time.Minute)),
+		}
+	}
+	// web-cpu flaps three times, db-cpu went into ALARM a day ago
+	server.Respond(awstest.CloudWatch, "DescribeAlarmHistory", &cloudwatch.DescribeAlarmHistoryOutput{
+		AlarmHistoryItems: []*cloudwatch.AlarmHistoryItem{
+			update("web-cpu", 20, "ALARM", "OK"),
+			update("web-cpu", 10, "OK", "ALARM"),
+			update("web-cpu", 30, "OK", "ALARM"),
+			update("api-5xx", 5, "OK", "ALARM"),
+		},
+	})
+	server.Handle(awstest.CloudWatch, "DescribeAlarms", func(req *awstest.Request) (interface{}, error) {
+		output := &cloudwatch.DescribeAlarmsOutput{}
+		if req.Params.Get("AlarmTypes.member.1") != cloudwatch.AlarmTypeCompositeAlarm {
+			output.MetricAlarms = []*cloudwatch.MetricAlarm{
+				{AlarmName: aws.String("db-cpu"), StateValue: aws.String("ALARM"), StateUpdatedTimestamp: aws.Time(time.Now().Add(-24 * time.Hour))},
+				{AlarmName: aws.String("web-cpu"), StateValue: aws.String("ALARM"), StateUpdatedTimestamp: aws.Time(start.Add(30 * time.Minute))},
+			}
+		}
+		return output, nil
+	})
+
+	timeline := "OK to ALARM at " + start.Add(10*time.Minute).Format(time.RFC3339) +
+		", ALARM to OK at " + start.Add(20*time.Minute).Format(time.RFC3339) +
+		", OK to ALARM at " + start.Add(30*time.Minute).Format(time.RFC3339)
+
+	tests := []struct {
+		Name      string
+		Args      []string
+		ExpStatus int
+		ExpOutput string
+	}{
+		{
+			Name:      "flapping",
+			Args:      []string{"--max-transitions", "2"},
+			ExpStatus: 1,
+			ExpOutput: "check-cloudwatch-alarm-history WARNING: web-cpu: flapping, 3 transitions in the last 1h0m0s: " + timeline + "\n",
+		},
+		{
+			Name:      "not flapping",
+			Args:      []string{"--max-transitions", "3"},
+			ExpStatus: 0,
+			ExpOutput: "check-cloudwatch-alarm-history OK: No alarm flapping or stuck in ALARM",
+		},
+		{
+			Name:      "stuck",
+			Args:      []string{"--max-transitions", "0", "--max-alarm-duration", "3600"},
+			ExpStatus: 2,
+			ExpOutput: "check-cloudwatch-alarm-history CRITICAL: db-cpu: in ALARM since ",
+		},
+		{
+			Name:      "excluded",
+			Args:      []string{"--max-transitions", "2", "--max-alarm-duration", "3600", "--exclude-alarms", "db-cpu,web-cpu"},
+			ExpStatus: 0,
+		},
+		{
+			Name:      "stuck with timeline",
+			Args:      []string{"--max-transitions", "0", "--max-alarm-duration", "600", "--alarm-name-prefix", "web-"},
+			ExpStatus: 2,
+			ExpOutput: "check-cloudwatch-alarm-history CRITICAL: web-cpu: in ALARM since " + start.Add(30*time.Minute).Format(time.RFC3339),
+		},
+		{
+			Name:      "nothing to check",
+			Args:      []string{"--max-transitions", "0"},
+			ExpStatus: 3,
+		},
+		{
+			Name:      "invalid status",
+			Args:      []string{"--flapping-status", "bad"},
+			ExpStatus: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			output, status := server.Exec(t, test.Args...)
+			if got, want := status, test.ExpStatus; got != want {
+				t.Errorf("bad exit status: got %d, want %d (output %q)", got, want, output)
+			}
+			if !strings.HasPrefix(output, test.ExpOutput) {
+				t.Errorf("bad output: got %q, want prefix %q", output, test.ExpOutput)
+			}
+		})
+	}
+}
@@ -2,15 +2,20 @@ package main
 
 import (
 	"fmt"
-	"os"
+	"regexp"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/spf13/cobra"
 
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/metricdata"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/regions"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/utils"
 )
 
 /*
@@ -19,6 +24,8 @@ import (
 #
 # DESCRIPTION:
 #   This plugin raise a critical if one of cloud watch alarms are in given state.
+#   Alarms can be selected by name, tags, metric namespace and dimensions,
+#   and the status of each alarm can be read from one of its tags.
 #
 # OUTPUT:
 #   plain-text
@@ -28,11 +35,17 @@ import (
 #
 #
 # USAGE:
-#   ./check-cloudwatch-alarms --exclude_alarms=CPUAlarmLow
-#   ./check-cloudwatch-alarms --aws_region=eu-west-1 --exclude_alarms=CPUAlarmLow
-#   ./check-cloudwatch-alarms --state=ALEARM
+#   ./check-cloudwatch-alarms --exclude-alarms=CPUAlarmLow
+#   ./check-cloudwatch-alarms --aws-region=eu-west-1 --exclude-alarms=CPUAlarmLow
+#   ./check-cloudwatch-alarms --state=ALARM
+#   ./check-cloudwatch-alarms --alarm-name-prefix=prod- --tags="team=web" --severity-tag=severity
+#   ./check-cloudwatch-alarms --namespace=AWS/RDS --dimensions="DBInstanceIdentifier=db" --insufficient-data-status=warning
+#   ./check-cloudwatch-alarms --alarm-name-regex="^(web|api)-.*-5xx$" --composite-alarms=false
 #
 # NOTES:
+#   Composite alarms have no metric, they are skipped when --namespace or
+#   --dimensions is given. With --severity-tag an alarm tagged warning is
+#   reported as a warning, any other alarm as critical.
 #
 # LICENSE:
 #   TODO
@@ -40,35 +53,255 @@ import (
 */
 
 var (
-	excludeAlarms    string
-	state            string
-	cloudWatchClient *cloudwatch.CloudWatch
-	awsRegion        string
+	sessionOptions         aws_session.Options
+	regionOptions          regions.Options
+	pager                  utils.Pager
+	excludeAlarms          string
+	state                  string
+	alarmNamePrefix        string
+	alarmNameRegex         string
+	tags                   string
+	namespace              string
+	dimensions             string
+	compositeAlarms        bool
+	insufficientDataStatus string
+	severityTag            string
+
+	config = plugin.NewConfig("check-cloudwatch-alarms", "The Sensu Go Aws Cloudwatch handler for alarms management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "exclude-alarms",
+			Env:      "EXCLUDE_ALARMS",
+			Argument: "exclude-alarms",
+			Default:  "",
+			Usage:    "Exclude alarms",
+			Value:    &excludeAlarms,
+		},
+		{
+			Path:     "state",
+			Env:      "STATE",
+			Argument: "state",
+			Default:  "ALARM",
+			Usage:    "State of the alarm",
+			Value:    &state,
+		},
+		{
+			Path:     "alarm-name-prefix",
+			Env:      "ALARM_NAME_PREFIX",
+			Argument: "alarm-name-prefix",
+			Default:  "",
+			Usage:    "Only check alarms whose name starts with this prefix",
+			Value:    &alarmNamePrefix,
+		},
+		{
+			Path:     "alarm-name-regex",
+			Env:      "ALARM_NAME_REGEX",
+			Argument: "alarm-name-regex",
+			Default:  "",
+			Usage:    "Only check alarms whose name matches this regular expression",
+			Value:    &alarmNameRegex,
+		},
+		{
+			Path:     "tags",
+			Env:      "TAGS",
+			Argument: "tags",
+			Default:  "",
+			Usage:    "Only check alarms with all of these tags, comma delimited list of Key=Value",
+			Value:    &tags,
+		},
+		{
+			Path:     "namespace",
+			Env:      "NAMESPACE",
+			Argument: "namespace",
+			Default:  "",
+			Usage:    "Only check alarms on a metric of this CloudWatch namespace, e.g. AWS/EC2",
+			Value:    &namespace,
+		},
+		{
+			Path:     "dimensions",
+			Env:      "DIMENSIONS",
+			Argument: "dimensions",
+			Default:  "",
+			Usage:    "Only check alarms on a metric with all of these dimensions, comma delimited list of DimName=Value",
+			Value:    &dimensions,
+		},
+		{
+			Path:     "composite-alarms",
+			Env:      "COMPOSITE_ALARMS",
+			Argument: "composite-alarms",
+			Default:  true,
+			Usage:    "Check composite alarms as well as metric alarms",
+			Value:    &compositeAlarms,
+		},
+		{
+			Path:     "insufficient-data-status",
+			Env:      "INSUFFICIENT_DATA_STATUS",
+			Argument: "insufficient-data-status",
+			Default:  "",
+			Usage:    "Also check alarms in INSUFFICIENT_DATA state with this status: ok, warning, critical or unknown",
+			Value:    &insufficientDataStatus,
+		},
+		{
+			Path:     "severity-tag",
+			Env:      "SEVERITY_TAG",
+			Argument: "severity-tag",
+			Default:  "",
+			Usage:    "Tag holding the severity of each alarm, warning or critical",
+			Value:    &severityTag,
+		},
+	}
 )
 
-func checkAlarms() {
-	selectedAlarms := []string{}
-	excludeAlarmsMap := make(map[string]*string)
+// CloudWatchClient represents the CloudWatch dependencies of the check
+type CloudWatchClient interface {
+	DescribeAlarmsPages(*cloudwatch.DescribeAlarmsInput, func(*cloudwatch.DescribeAlarmsOutput, bool) bool) error
+	ListTagsForResource(*cloudwatch.ListTagsForResourceInput) (*cloudwatch.ListTagsForResourceOutput, error)
+}
 
-	var success bool
+// alarm is a metric or composite alarm
+type alarm struct {
+	name    string
+	arn     string
+	state   string
+	metrics []*cloudwatch.Metric
+}
 
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
-	success, cloudWatchClient = awsclient.GetCloudWatchClient(awsSession)
-	if !success {
-		return
+// describeAlarms returns the metric and composite alarms in state
+func describeAlarms(client CloudWatchClient, state string) ([]alarm, error) {
+	input := &cloudwatch.DescribeAlarmsInput{StateValue: aws.String(state)}
+	if len(alarmNamePrefix) > 0 {
+		input.AlarmNamePrefix = aws.String(alarmNamePrefix)
+	}
+	metricAlarms, err := pager.Alarms(client, input)
+	if err != nil {
+		return nil, err
+	}
+	var alarms []alarm
+	for _, metricAlarm := range metricAlarms {
+		a := alarm{
+			name:  aws.StringValue(metricAlarm.AlarmName),
+			arn:   aws.StringValue(metricAlarm.AlarmArn),
+			state: aws.StringValue(metricAlarm.StateValue),
+		}
+		if metricAlarm.Namespace != nil {
+			a.metrics = append(a.metrics, &cloudwatch.Metric{Namespace: metricAlarm.Namespace, Dimensions: metricAlarm.Dimensions})
+		}
+		// metric math alarms have their metrics in queries
+		for _, query := range metricAlarm.Metrics {
+			if query.MetricStat != nil {
+				a.metrics = append(a.metrics, query.MetricStat.Metric)
+			}
+		}
+		alarms = append(alarms, a)
+	}
+	if !compositeAlarms || len(namespace) > 0 || len(dimensions) > 0 {
+		return alarms, nil
 	}
 
-	describeInput := &cloudwatch.DescribeAlarmsInput{}
-	describeInput.StateValue = aws.String(state)
+	input.AlarmTypes = aws.StringSlice([]string{cloudwatch.AlarmTypeCompositeAlarm})
+	composites, err := pager.CompositeAlarms(client, input)
+	if err != nil {
+		return nil, err
+	}
+	for _, composite := range composites {
+		alarms = append(alarms, alarm{
+			name:  aws.StringValue(composite.AlarmName),
+			arn:   aws.StringValue(composite.AlarmArn),
+			state: aws.StringValue(composite.StateValue),
+		})
+	}
+	return alarms, nil
+}
 
-	describeOutput, err := cloudWatchClient.DescribeAlarms(describeInput)
+// onMetric returns whether one of the metrics of a is in namespace and has
+// every one of dimensions
+func (a alarm) onMetric(namespace string, dimensions []*cloudwatch.Dimension) bool {
+	for _, metric := range a.metrics {
+		if len(namespace) > 0 && aws.StringValue(metric.Namespace) != namespace {
+			continue
+		}
+		if hasDimensions(metric.Dimensions, dimensions) {
+			return true
+		}
+	}
+	return false
+}
 
+func hasDimensions(have []*cloudwatch.Dimension, want []*cloudwatch.Dimension) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if aws.StringValue(h.Name) == aws.StringValue(w.Name) && aws.StringValue(h.Value) == aws.StringValue(w.Value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTags parses a comma delimited list of Key=Value
+func parseTags(spec string) (map[string]string, error) {
+	parsed := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		if len(strings.TrimSpace(pair)) == 0 {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || len(key) == 0 {
+			return nil, fmt.Errorf("invalid tag %q, expected Key=Value", pair)
+		}
+		parsed[key] = strings.TrimSpace(parts[1])
+	}
+	return parsed, nil
+}
+
+func alarmTags(client CloudWatchClient, arn string) (map[string]string, error) {
+	output, err := client.ListTagsForResource(&cloudwatch.ListTagsForResourceInput{ResourceARN: aws.String(arn)})
 	if err != nil {
-		fmt.Println("Failed to get cloudwatch alarm details , Error : ", err)
+		return nil, err
+	}
+	alarmTags := make(map[string]string)
+	for _, tag := range output.Tags {
+		alarmTags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
 	}
+	return alarmTags, nil
+}
+
+func checkAlarms(factory *awsclient.Factory, res *result.Result) {
+	excludeAlarmsMap := make(map[string]*string)
 
-	if describeOutput == nil || describeOutput.MetricAlarms == nil || len(describeOutput.MetricAlarms) == 0 {
-		fmt.Println("OK : No alarm in", state, "state")
+	cloudWatchClient, err := factory.CloudWatch()
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+
+	nameRegex, _ := regexp.Compile(alarmNameRegex)
+	wantTags, _ := parseTags(tags)
+	wantDimensions, _ := metricdata.ParseDimensions(dimensions)
+
+	states := []string{state}
+	if len(insufficientDataStatus) > 0 && state != cloudwatch.StateValueInsufficientData {
+		states = append(states, cloudwatch.StateValueInsufficientData)
+	}
+	var alarms []alarm
+	for _, s := range states {
+		stateAlarms, err := describeAlarms(cloudWatchClient, s)
+		if err != nil {
+			res.Unknown("", "failed to get cloudwatch alarm details: %v", err)
+			return
+		}
+		alarms = append(alarms, stateAlarms...)
+	}
+
+	if len(alarms) == 0 {
+		res.SetOKMessage("No alarm in %s state", strings.Join(states, " or "))
 		return
 	}
 
@@ -79,46 +312,74 @@ func checkAlarms() {
 		}
 	}
 
-	for _, alarm := range describeOutput.MetricAlarms {
-		if excludeAlarmsMap[*alarm.AlarmName] == nil {
-			selectedAlarms = append(selectedAlarms, *alarm.AlarmName)
+	for _, alarm := range alarms {
+		if excludeAlarmsMap[alarm.name] != nil || !nameRegex.MatchString(alarm.name) {
+			continue
+		}
+		if (len(namespace) > 0 || len(wantDimensions) > 0) && !alarm.onMetric(namespace, wantDimensions) {
+			continue
 		}
-	}
 
-	if selectedAlarms != nil && len(selectedAlarms) > 0 {
-		fmt.Println("CRITICAL :", len(selectedAlarms), "are in state", state, " :", selectedAlarms)
-		return
+		status := result.Critical
+		if alarm.state == cloudwatch.StateValueInsufficientData && state != cloudwatch.StateValueInsufficientData {
+			status, _ = result.ParseStatus(insufficientDataStatus)
+		}
+		if len(wantTags) > 0 || len(severityTag) > 0 {
+			have, err := alarmTags(cloudWatchClient, alarm.arn)
+			if err != nil {
+				res.Unknown(alarm.name, "failed to get alarm tags: %v", err)
+				continue
+			}
+			if !matchTags(have, wantTags) {
+				continue
+			}
+			if severity, ok := have[severityTag]; ok && status == result.Critical {
+				if parsed, err := result.ParseStatus(severity); err == nil && parsed == result.Warning {
+					status = result.Warning
+				}
+			}
+		}
+		res.Add(status, alarm.name, "alarm is in state %s", alarm.state)
 	}
 
-	fmt.Println("OK : Everything looks good")
+	res.SetOKMessage("Everything looks good")
 }
 
-func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+func matchTags(have map[string]string, want map[string]string) bool {
+	for key, value := range want {
+		if v, ok := have[key]; !ok || v != value {
+			return false
+		}
 	}
+	return true
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
-	}
-	checkAlarms()
-	return nil
+func main() {
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
 }
 
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-cloudwatch-alarms",
-		Short: "The Sensu Go Aws Cloudwatch handler for alarms management",
-		RunE:  run,
+func validate() error {
+	if _, err := regexp.Compile(alarmNameRegex); err != nil {
+		return fmt.Errorf("--alarm-name-regex: %v", err)
+	}
+	if _, err := parseTags(tags); err != nil {
+		return err
 	}
+	if _, err := metricdata.ParseDimensions(dimensions); err != nil {
+		return err
+	}
+	if len(insufficientDataStatus) > 0 {
+		if _, err := result.ParseStatus(insufficientDataStatus); err != nil {
+			return fmt.Errorf("--insufficient-data-status: %v", err)
+		}
+	}
+	return nil
+}
 
-	cmd.Flags().StringVar(&excludeAlarms, "exclude_alarms", "", "Exclude alarms")
-	cmd.Flags().StringVar(&state, "state", "ALARM", "State of the alarm")
-	cmd.Flags().StringVar(&awsRegion, "aws_region", "us-east-1", "AWS Region (defaults to us-east-1).")
-	return cmd
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkAlarms)
+	pager.Report(res)
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/sensu/sensu-aws/awstest"
+)
+
+func TestMain(m *testing.M) {
+	awstest.Main(m, main)
+}
+
+func TestPlugin(t *testing.T) {
+	server := awstest.NewServer()
+	defer server.Close()
+	metricAlarm := func(name string, state string, namespace string, instance string) *cloudwatch.MetricAlarm {
+		return &cloudwatch.MetricAlarm{
+			AlarmName:  aws.String(name),
+			AlarmArn:   aws.String("arn:" + name),
+			StateValue: aws.String(state),
+			Namespace:  aws.String(namespace),
+			Dimensions: []*cloudwatch.Dimension{{Name: aws.String("InstanceId"), Value: aws.String(instance)}},
+		}
+	}
+	alarms := map[string]*cloudwatch.DescribeAlarmsOutput{
+		cloudwatch.StateValueAlarm: {
+			MetricAlarms: []*cloudwatch.MetricAlarm{
+				metricAlarm("web-cpu", "ALARM", "AWS/EC2", "web"),
+				metricAlarm("db-cpu", "ALARM", "AWS/RDS", "db"),
+				{
+					AlarmName:  aws.String("api-5xx"),
+					AlarmArn:   aws.String("arn:api-5xx"),
+					StateValue: aws.String("ALARM"),
+					Metrics: []*cloudwatch.MetricDataQuery{{
+						Id:         aws.String("m1"),
+						MetricStat: &cloudwatch.MetricStat{Metric: &cloudwatch.Metric{Namespace: aws.String("AWS/ApplicationELB")}},
+					}},
+				},
+			},
+			CompositeAlarms: []*cloudwatch.CompositeAlarm{{
+				AlarmName:  aws.String("site-down"),
+				AlarmArn:   aws.String("arn:site-down"),
+				StateValue: aws.String("ALARM"),
+			}},
+		},
+		cloudwatch.StateValueInsufficientData: {
+			MetricAlarms: []*cloudwatch.MetricAlarm{metricAlarm("web-disk", "INSUFFICIENT_DATA", "AWS/EC2", "web")},
+		},
+	}
+	server.Handle(awstest.CloudWatch, "DescribeAlarms", func(req *awstest.Request) (interface{}, error) {
+		all := alarms[req.Params.Get("StateValue")]
+		output := &cloudwatch.DescribeAlarmsOutput{}
+		prefix := req.Params.Get("AlarmNamePrefix")
+		if all == nil {
+			return output, nil
+		}
+		if req.Params.Get("AlarmTypes.member.1") == cloudwatch.AlarmTypeCompositeAlarm {
+			output.CompositeAlarms = all.CompositeAlarms
+			return output, nil
+		}
+		for _, alarm := range all.MetricAlarms {
+			if strings.HasPrefix(*alarm.AlarmName, prefix) {
+				output.MetricAlarms = append(output.MetricAlarms, alarm)
+			}
+		}
+		return output, nil
+	})
+	tags := map[string][]*cloudwatch.Tag{
+		"arn:web-cpu": {{Key: aws.String("team"), Value: aws.String("web")}, {Key: aws.String("severity"), Value: aws.String("warning")}},
+		"arn:db-cpu":  {{Key: aws.String("team"), Value: aws.String("db")}},
+	}
+	server.Handle(awstest.CloudWatch, "ListTagsForResource", func(req *awstest.Request) (interface{}, error) {
+		return &cloudwatch.ListTagsForResourceOutput{Tags: tags[req.Params.Get("ResourceARN")]}, nil
+	})
+
+	tests := []struct {
+		Name      string
+		Args      []string
+		ExpStatus int
+		ExpOutput string
+	}{
+		{
+			Name:      "every alarm",
+			Args:      []string{"--exclude-alarms", "db-cpu"},
+			ExpStatus: 2,
+			ExpOutput: "check-cloudwatch-alarms CRITICAL: 3 critical\nCRITICAL web-cpu: alarm is in state ALARM\nCRITICAL api-5xx: alarm is in state ALARM\nCRITICAL site-down: alarm is in state ALARM\n",
+		},
+		{
+			Name:      "no composite alarms",
+			Args:      []string{"--composite-alarms=false", "--alarm-name-regex", "^(web|site)-"},
+			ExpStatus: 2,
+			ExpOutput: "check-cloudwatch-alarms CRITICAL: web-cpu: alarm is in state ALARM\n",
+		},
+		{
+			Name:      "prefix",
+			Args:      []string{"--alarm-name-prefix", "db-", "--composite-alarms=false"},
+			ExpStatus: 2,
+			ExpOutput: "check-cloudwatch-alarms CRITICAL: db-cpu: alarm is in state ALARM\n",
+		},
+		{
+			Name:      "metric math namespace",
+			Args:      []string{"--namespace", "AWS/ApplicationELB"},
+			ExpStatus: 2,
+			ExpOutput: "check-cloudwatch-alarms CRITICAL: api-5xx: alarm is in state ALARM\n",
+		},
+		{
+			Name:      "dimensions",
+			Args:      []string{"--namespace", "AWS/EC2", "--dimensions", "InstanceId=db"},
+			ExpStatus: 0,
+			ExpOutput: "check-cloudwatch-alarms OK: Everything looks good",
+		},
+		{
+			Name:      "severity tag",
+			Args:      []string{"--tags", "team=web", "--severity-tag", "severity"},
+			ExpStatus: 1,
+			ExpOutput: "check-cloudwatch-alarms WARNING: web-cpu: alarm is in state ALARM\n",
+		},
+		{
+			Name:      "insufficient data",
+			Args:      []string{"--namespace", "AWS/EC2", "--insufficient-data-status", "warning"},
+			ExpStatus: 2,
+			ExpOutput: "check-cloudwatch-alarms CRITICAL: web-cpu: alarm is in state ALARM\nCRITICAL web-cpu: alarm is in state ALARM\nWARNING web-disk: alarm is in state INSUFFICIENT_DATA\n",
+		},
+		{
+			Name:      "no alarm",
+			Args:      []string{"--state", "OK"},
+			ExpStatus: 0,
+			ExpOutput: "check-cloudwatch-alarms OK: No alarm in OK state",
+		},
+		{
+			Name:      "invalid regex",
+			Args:      []string{"--alarm-name-regex", "("},
+			ExpStatus: 3,
+		},
+		{
+			Name:      "invalid tags",
+			Args:      []string{"--tags", "team"},
+			ExpStatus: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			output, status := server.Exec(t, test.Args...)
+			if got, want := status, test.ExpStatus; got != want {
+				t.Errorf("bad exit status: got %d, want %d (output %q)", got, want, output)
+			}
+			if !strings.HasPrefix(output, test.ExpOutput) {
+				t.Errorf("bad output: got %q, want prefix %q", output, test.ExpOutput)
+			}
+		})
+	}
+}
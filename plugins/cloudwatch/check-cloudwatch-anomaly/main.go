@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/metricdata"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/regions"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/utils"
+)
+
+/*
+#
+# check-cloudwatch-anomaly
+#
+# DESCRIPTION:
+#   This plugin checks the newest value of a CloudWatch metric against the
+#   ANOMALY_DETECTION_BAND of its anomaly detector, with --create-detector
+#   creating the detector when the metric has none, and triggers an alarm when
+#   the value is outside the band.
+#
+# OUTPUT:
+#   plain-text
+#
+# PLATFORMS:
+#   MAC OS
+#
+# USAGE:
+#   ./check-cloudwatch-anomaly --namespace=AWS/EC2 --metric-name=CPUUtilization --dimensions="InstanceId=i-12345678"
+#   ./check-cloudwatch-anomaly --namespace=AWS/ELB --metric-name=RequestCount --dimensions="LoadBalancerName=web" --statistic=sum --period=300 --band-width=3 --direction=below --severity=warning
+#   ./check-cloudwatch-anomaly --namespace=AWS/SQS --metric-name=ApproximateAgeOfOldestMessage --dimensions="QueueName=jobs" --create-detector
+#
+# NOTES:
+#   The band width is the number of standard deviations of the band. A new
+#   anomaly detector needs to be trained before it has a band, until then the
+#   check returns the --no-data-status. Anomaly detectors are billed like
+#   alarms, creating them requires the cloudwatch:PutAnomalyDetector
+#   permission.
+#
+# LICENSE:
+#   TODO
+#
+*/
+
+var (
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	pager          utils.Pager
+	namespace      string
+	metricName     string
+	dimensions     string
+	statistic      string
+	period         int64
+	fetchAge       int64
+	bandWidth      float64
+	direction      string
+	severity       string
+	createDetector bool
+	noDataStatus   string
+
+	config = plugin.NewConfig("check-cloudwatch-anomaly", "The Sensu Go Aws Cloudwatch handler for metric anomaly detection")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "namespace",
+			Env:      "NAMESPACE",
+			Argument: "namespace",
+			Default:  "",
+			Usage:    "CloudWatch namespace of the metric, e.g. AWS/EC2",
+			Value:    &namespace,
+		},
+		{
+			Path:     "metric-name",
+			Env:      "METRIC_NAME",
+			Argument: "metric-name",
+			Default:  "",
+			Usage:    "CloudWatch metric name, e.g. CPUUtilization",
+			Value:    &metricName,
+		},
+		{
+			Path:     "dimensions",
+			Env:      "DIMENSIONS",
+			Argument: "dimensions",
+			Default:  "",
+			Usage:    "Comma delimited list of DimName=Value",
+			Value:    &dimensions,
+		},
+		{
+			Path:     "statistic",
+			Env:      "STATISTIC",
+			Argument: "statistic",
+			Default:  "Average",
+			Usage:    "CloudWatch statistic: average, maximum, minimum, samplecount, sum or a percentile such as p99",
+			Value:    &statistic,
+		},
+		{
+			Path:     "period",
+			Env:      "PERIOD",
+			Argument: "period",
+			Default:  int64(300),
+			Usage:    "CloudWatch metric statistics period in seconds. Must be a multiple of 60",
+			Value:    &period,
+		},
+		{
+			Path:     "fetch-age",
+			Env:      "FETCH_AGE",
+			Argument: "fetch-age",
+			Default:  int64(0),
+			Usage:    "How long ago to fetch metrics from in seconds",
+			Value:    &fetchAge,
+		},
+		{
+			Path:     "band-width",
+			Env:      "BAND_WIDTH",
+			Argument: "band-width",
+			Default:  float64(2),
+			Usage:    "Width of the anomaly detection band in standard deviations",
+			Value:    &bandWidth,
+		},
+		{
+			Path:     "direction",
+			Env:      "DIRECTION",
+			Argument: "direction",
+			Default:  "both",
+			Usage:    "Alert on values above the band, below the band or both",
+			Value:    &direction,
+		},
+		{
+			Path:     "severity",
+			Env:      "SEVERITY",
+			Argument: "severity",
+			Default:  "critical",
+			Usage:    "Status when the value is outside the band: warning or critical",
+			Value:    &severity,
+		},
+		{
+			Path:     "create-detector",
+			Env:      "CREATE_DETECTOR",
+			Argument: "create-detector",
+			Default:  false,
+			Usage:    "Create the anomaly detector of the metric when it has none, detectors are billed",
+			Value:    &createDetector,
+		},
+		{
+			Path:     "no-data-status",
+			Env:      "NO_DATA_STATUS",
+			Argument: "no-data-status",
+			Default:  "unknown",
+			Usage:    "Status when CloudWatch returns no datapoints or no band yet: ok, warning, critical or unknown",
+			Value:    &noDataStatus,
+		},
+	}
+)
+
+// CloudWatchClient represents the CloudWatch dependencies of the check
+type CloudWatchClient interface {
+	utils.AnomalyDetectorDescriber
+	PutAnomalyDetector(*cloudwatch.PutAnomalyDetectorInput) (*cloudwatch.PutAnomalyDetectorOutput, error)
+	GetMetricDataPages(*cloudwatch.GetMetricDataInput, func(*cloudwatch.GetMetricDataOutput, bool) bool) error
+}
+
+// detectorState returns the state of the anomaly detector of query, creating
+// the detector when there is none and createDetector is set
+func detectorState(client CloudWatchClient, query metricdata.Query) (string, error) {
+	detectors, err := pager.AnomalyDetectors(client, &cloudwatch.DescribeAnomalyDetectorsInput{
+		Namespace:  aws.String(query.Namespace),
+		MetricName: aws.String(query.MetricName),
+		Dimensions: query.Dimensions,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe anomaly detectors: %v", err)
+	}
+	for _, detector := range detectors {
+		if aws.StringValue(detector.Stat) == query.Stat && len(detector.Dimensions) == len(query.Dimensions) {
+			return aws.StringValue(detector.StateValue), nil
+		}
+	}
+	if !createDetector {
+		return "", errors.New("no anomaly detector exists, create one or set --create-detector")
+	}
+	_, err = client.PutAnomalyDetector(&cloudwatch.PutAnomalyDetectorInput{
+		Namespace:  aws.String(query.Namespace),
+		MetricName: aws.String(query.MetricName),
+		Dimensions: query.Dimensions,
+		Stat:       aws.String(query.Stat),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create anomaly detector: %v", err)
+	}
+	return cloudwatch.AnomalyDetectorStateValuePendingTraining, nil
+}
+
+// bounds returns the lower and upper bounds of band at timestamp, CloudWatch
+// returns them as two series of the band expression
+func bounds(band *metricdata.Series, timestamp time.Time) (float64, float64, bool) {
+	lower, upper, count := math.Inf(1), math.Inf(-1), 0
+	for _, s := range band.All() {
+		for i, t := range s.Timestamps {
+			if t.Equal(timestamp) {
+				lower = math.Min(lower, s.Values[i])
+				upper = math.Max(upper, s.Values[i])
+				count++
+			}
+		}
+	}
+	return lower, upper, count >= 2
+}
+
+func checkAnomaly(factory *awsclient.Factory, res *result.Result) {
+	cloudWatchClient, err := factory.CloudWatch()
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	query := metricdata.Query{
+		ID:         "m1",
+		Namespace:  namespace,
+		MetricName: metricName,
+		Stat:       statistic,
+	}
+	query.Dimensions, _ = metricdata.ParseDimensions(dimensions)
+
+	description := fmt.Sprintf("%s %s %s", namespace, metricName, statistic)
+	if len(dimensions) > 0 {
+		description = fmt.Sprintf("%s %s (%s) %s", namespace, metricName, dimensions, statistic)
+	}
+	noData, _ := result.ParseStatus(noDataStatus)
+
+	state, err := detectorState(cloudWatchClient, query)
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	if state == cloudwatch.AnomalyDetectorStateValuePendingTraining {
+		res.Add(noData, "", "%s anomaly detector is pending training", description)
+		return
+	}
+
+	// the band is fetched over a few periods so that the newest datapoint
+	// is found even when CloudWatch is late to publish it
+	end := time.Now().Add(-time.Duration(fetchAge) * time.Second)
+	batch := &metricdata.Batch{Start: end.Add(-5 * time.Duration(period) * time.Second), End: end, Period: period}
+	keys := batch.Add(query, metricdata.Query{ID: "band", Expression: fmt.Sprintf("ANOMALY_DETECTION_BAND(m1, %v)", bandWidth)})
+	series, err := batch.Fetch(cloudWatchClient)
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	point, err := series[keys[0]].Latest()
+	if metricdata.IsNoData(err) {
+		res.Add(noData, "", "%s returned no data", description)
+		return
+	}
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	lower, upper, ok := bounds(series[keys[1]], point.Timestamp)
+	if !ok {
+		res.Add(noData, "", "%s has no anomaly detection band at %s", description, point.Timestamp.Format(time.RFC3339))
+		return
+	}
+
+	status, _ := result.ParseStatus(severity)
+	band := fmt.Sprintf("band %v to %v", lower, upper)
+	switch {
+	case point.Value > upper && direction != "below":
+		res.Add(status, "", "%s is %v, above the expected %s", description, point.Value, band)
+	case point.Value < lower && direction != "above":
+		res.Add(status, "", "%s is %v, below the expected %s", description, point.Value, band)
+	default:
+		res.OK("", "%s is %v, within the expected %s", description, point.Value, band)
+	}
+}
+
+func main() {
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
+}
+
+func validate() error {
+	if len(namespace) == 0 {
+		return errors.New("--namespace is required")
+	}
+	if len(metricName) == 0 {
+		return errors.New("--metric-name is required")
+	}
+	if _, err := metricdata.ParseDimensions(dimensions); err != nil {
+		return err
+	}
+	if bandWidth <= 0 {
+		return errors.New("--band-width must be greater than 0")
+	}
+	if direction != "both" && direction != "above" && direction != "below" {
+		return fmt.Errorf("--direction must be both, above or below, not %q", direction)
+	}
+	if status, err := result.ParseStatus(severity); err != nil || (status != result.Warning && status != result.Critical) {
+		return fmt.Errorf("--severity must be warning or critical, not %q", severity)
+	}
+	if _, err := result.ParseStatus(noDataStatus); err != nil {
+		return fmt.Errorf("--no-data-status: %v", err)
+	}
+	var err error
+	statistic, err = metricdata.ParseStat(statistic)
+	return err
+}
+
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkAnomaly)
+	pager.Report(res)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/sensu/sensu-aws/awstest"
+)
+
+func TestMain(m *testing.M) {
+	awstest.Main(m, main)
+}
+
+func TestPlugin(t *testing.T) {
+	server := awstest.NewServer()
+	defer server.Close()
+	// instance web has a trained detector and 42 within the band 10 to 40,
+	// instance api has a detector pending training and db has none
+	server.Handle(awstest.CloudWatch, "DescribeAnomalyDetectors", func(req *awstest.Request) (interface{}, error) {
+		output := &cloudwatch.DescribeAnomalyDetectorsOutput{}
+		states := map[string]string{
+			"web": cloudwatch.AnomalyDetectorStateValueTrained,
+			"api": cloudwatch.AnomalyDetectorStateValuePendingTraining,
+		}
+		if state, ok := states[req.Params.Get("Dimensions.member.1.Value")]; ok {
+			output.AnomalyDetectors = []*cloudwatch.AnomalyDetector{{
+				Stat:       aws.String("Average"),
+				Dimensions: []*cloudwatch.Dimension{{Name: aws.String("InstanceId"), Value: aws.String("web")}},
+				StateValue: aws.String(state),
+			}}
+		}
+		return output, nil
+	})
+	server.Respond(awstest.CloudWatch, "PutAnomalyDetector", &cloudwatch.PutAnomalyDetectorOutput{})
+	server.Handle(awstest.CloudWatch, "GetMetricData", func(req *awstest.Request) (interface{}, error) {
+		now := time.Now().Truncate(time.Minute)
+		point := func(id string, label string, value float64) *cloudwatch.MetricDataResult {
+			return &cloudwatch.MetricDataResult{
+				Id:         aws.String(id),
+				Label:      aws.String(label),
+				StatusCode: aws.String(cloudwatch.StatusCodeComplete),
+				Timestamps: []*time.Time{aws.Time(now)},
+				Values:     []*float64{aws.Float64(value)},
+			}
+		}
+		return &cloudwatch.GetMetricDataOutput{MetricDataResults: []*cloudwatch.MetricDataResult{
+			point("g0_m1", "CPUUtilization", 42),
+			point("g0_band", "CPUUtilization Upper", 40),
+			point("g0_band", "CPUUtilization Lower", 10),
+		}}, nil
+	})
+
+	web := []string{"--namespace", "AWS/EC2", "--metric-name", "CPUUtilization", "--dimensions", "InstanceId=web"}
+
+	tests := []struct {
+		Name      string
+		Args      []string
+		ExpStatus int
+		ExpOutput string
+		ExpPuts   int
+	}{
+		{
+			Name:      "above",
+			Args:      web,
+			ExpStatus: 2,
+			ExpOutput: "check-cloudwatch-anomaly CRITICAL: AWS/EC2 CPUUtilization (InstanceId=web) Average is 42, above the expected band 10 to 40",
+		},
+		{
+			Name:      "above warning",
+			Args:      append(web, "--severity", "warning"),
+			ExpStatus: 1,
+		},
+		{
+			Name:      "below only",
+			Args:      append(web, "--direction", "below"),
+			ExpStatus: 0,
+			ExpOutput: "check-cloudwatch-anomaly OK: AWS/EC2 CPUUtilization (InstanceId=web) Average is 42, within the expected band 10 to 40",
+		},
+		{
+			Name:      "pending training",
+			Args:      []string{"--namespace", "AWS/EC2", "--metric-name", "CPUUtilization", "--dimensions", "InstanceId=api"},
+			ExpStatus: 3,
+			ExpOutput: "check-cloudwatch-anomaly UNKNOWN: AWS/EC2 CPUUtilization (InstanceId=api) Average anomaly detector is pending training",
+		},
+		{
+			Name:      "created",
+			Args:      []string{"--namespace", "AWS/EC2", "--metric-name", "CPUUtilization", "--dimensions", "InstanceId=db", "--no-data-status", "ok", "--create-detector"},
+			ExpStatus: 0,
+			ExpPuts:   1,
+		},
+		{
+			Name:      "not created",
+			Args:      []string{"--namespace", "AWS/EC2", "--metric-name", "CPUUtilization", "--dimensions", "InstanceId=db"},
+			ExpStatus: 3,
+			ExpOutput: "check-cloudwatch-anomaly UNKNOWN: no anomaly detector exists",
+		},
+		{
+			Name:      "invalid band width",
+			Args:      append(web, "--band-width", "0"),
+			ExpStatus: 3,
+		},
+		{
+			Name:      "invalid severity",
+			Args:      append(web, "--severity", "unknown"),
+			ExpStatus: 3,
+		},
+		{
+			Name:      "invalid direction",
+			Args:      append(web, "--direction", "up"),
+			ExpStatus: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			puts := server.Calls(awstest.CloudWatch, "PutAnomalyDetector")
+			output, status := server.Exec(t, test.Args...)
+			if got, want := status, test.ExpStatus; got != want {
+				t.Errorf("bad exit status: got %d, want %d (output %q)", got, want, output)
+			}
+			if !strings.HasPrefix(output, test.ExpOutput) {
+				t.Errorf("bad output: got %q, want prefix %q", output, test.ExpOutput)
+			}
+			if got, want := server.Calls(awstest.CloudWatch, "PutAnomalyDetector")-puts, test.ExpPuts; got != want {
+				t.Errorf("bad detector creations: got %d, want %d", got, want)
+			}
+		})
+	}
+
+	for _, req := range server.Requests() {
+		if req.Operation == "GetMetricData" {
+			if got, want := req.Params.Get("MetricDataQueries.member.2.Expression"), "ANOMALY_DETECTION_BAND(g0_m1, 2)"; got != want {
+				t.Errorf("bad band expression: got %q, want %q", got, want)
+			}
+			break
+		}
+	}
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"os"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
-	"github.com/spf13/cobra"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/metricdata"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/regions"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/threshold"
 )
 
 /*
@@ -32,8 +36,12 @@ import (
 #
 # USAGE:
 #   ./check-cloudwatch-composite-metric --namespace AWS/ELB --dimensions="LoadBalancerName=test-elb" --period=60 --statistics=Maximum --operator=equal --critical=0
+#   ./check-cloudwatch-composite-metric --namespace AWS/ELB --dimensions="LoadBalancerName=test-elb" --metrics="m1::HTTPCode_Backend_5XX:Sum;m2::HTTPCode_ELB_5XX:Sum;m3::RequestCount:Sum" --expression="100*(FILL(m1,0)+FILL(m2,0))/m3" --warning=1 --critical=5
 #
 # NOTES:
+#   --expression replaces the numerator/denominator percentage with any metric
+#   math expression over the --metrics queries, each of which may have its own
+#   namespace and dimensions. Every series the expression returns is checked.
 #
 # LICENSE:
 #   TODO
@@ -41,10 +49,10 @@ import (
 */
 
 var (
+	sessionOptions        aws_session.Options
+	regionOptions         regions.Options
 	excludeAlarms         string
 	state                 string
-	cloudWatchClient      *cloudwatch.CloudWatch
-	awsRegion             string
 	namespace             string
 	numeratorMetric       bool
 	denominatorMetric     bool
@@ -55,51 +63,256 @@ var (
 	critical              float64
 	warning               float64
 	compare               string
+	warningRange          string
+	criticalRange         string
 	numeratorDefault      float64
 	noDenominatorDataOk   bool
 	zeroDenominatorDataOk bool
 	noDataOk              bool
 	numeratorMetricName   string
 	denominatorMetricName string
+	metricQueries         string
+	expression            string
+
+	config = plugin.NewConfig("check-cloudwatch-composite-metric", "The Sensu Go Aws Bucket handler for bucket management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "exclude-alarms",
+			Env:      "EXCLUDE_ALARMS",
+			Argument: "exclude-alarms",
+			Default:  "",
+			Usage:    "Exclude alarms",
+			Value:    &excludeAlarms,
+		},
+		{
+			Path:     "state",
+			Env:      "STATE",
+			Argument: "state",
+			Default:  "ALARM",
+			Usage:    "State of the alarm",
+			Value:    &state,
+		},
+		{
+			Path:     "namespace",
+			Env:      "NAMESPACE",
+			Argument: "namespace",
+			Default:  "AWS/EC2",
+			Usage:    "CloudWatch namespace for metric",
+			Value:    &namespace,
+		},
+		{
+			Path:     "numerator-metric",
+			Env:      "NUMERATOR_METRIC",
+			Argument: "numerator-metric",
+			Default:  true,
+			Usage:    "Numerator metric name present",
+			Value:    &numeratorMetric,
+		},
+		{
+			Path:     "numerator-metric-name",
+			Env:      "NUMERATOR_METRIC_NAME",
+			Argument: "numerator-metric-name",
+			Default:  "",
+			Usage:    "Numerator metric name",
+			Value:    &numeratorMetricName,
+		},
+		{
+			Path:     "denominator-metric",
+			Env:      "DENOMINATOR_METRIC",
+			Argument: "denominator-metric",
+			Default:  true,
+			Usage:    "Denominator metric name present",
+			Value:    &denominatorMetric,
+		},
+		{
+			Path:     "denominator-metric-name",
+			Env:      "DENOMINATOR_METRIC_NAME",
+			Argument: "denominator-metric-name",
+			Default:  "",
+			Usage:    "Denominator metric name",
+			Value:    &denominatorMetricName,
+		},
+		{
+			Path:     "metrics",
+			Env:      "METRICS",
+			Argument: "metrics",
+			Default:  "",
+			Usage:    "Metric queries of --expression separated by ';', each id:namespace:metric-name:statistic[:dimensions], the namespace and dimensions default to --namespace and --dimensions",
+			Value:    &metricQueries,
+		},
+		{
+			Path:     "expression",
+			Env:      "EXPRESSION",
+			Argument: "expression",
+			Default:  "",
+			Usage:    "CloudWatch metric math expression over the --metrics ids, e.g. (m1+m2)/m3, thresholded instead of the numerator/denominator percentage",
+			Value:    &expression,
+		},
+		{
+			Path:     "dimensions",
+			Env:      "DIMENSIONS",
+			Argument: "dimensions",
+			Default:  "",
+			Usage:    "Comma delimited list of DimName=Value",
+			Value:    &dimensions,
+		},
+		{
+			Path:     "period",
+			Env:      "PERIOD",
+			Argument: "period",
+			Default:  int64(60),
+			Usage:    "CloudWatch metric statistics period in seconds. Must be a multiple of 60",
+			Value:    &period,
+		},
+		{
+			Path:     "statistic",
+			Env:      "STATISTIC",
+			Argument: "statistic",
+			Default:  "Average",
+			Usage:    "CloudWatch statistic: average, maximum, minimum, samplecount, sum or a percentile such as p99",
+			Value:    &statistic,
+		},
+		{
+			Path:     "unit",
+			Env:      "UNIT",
+			Argument: "unit",
+			Default:  "",
+			Usage:    "CloudWatch metric unit",
+			Value:    &unit,
+		},
+		{
+			Path:     "critical",
+			Env:      "CRITICAL",
+			Argument: "critical",
+			Default:  float64(0),
+			Usage:    "Trigger a critical when value is over VALUE as a Percent",
+			Value:    &critical,
+		},
+		{
+			Path:     "warning",
+			Env:      "WARNING",
+			Argument: "warning",
+			Default:  float64(0),
+			Usage:    "Trigger a warning when value is over VALUE as a Percent",
+			Value:    &warning,
+		},
+		{
+			Path:     "compare",
+			Env:      "COMPARE",
+			Argument: "compare",
+			Default:  "greater",
+			Usage:    "Comparision operator for threshold: equal, not, greater, greater_equal, less, less_equal",
+			Value:    &compare,
+		},
+		{
+			Path:     "warning-range",
+			Env:      "WARNING_RANGE",
+			Argument: "warning-range",
+			Default:  "",
+			Usage:    "Warning threshold range in percent (e.g. 10:, ~:20, @5:10), overrides warning and compare",
+			Value:    &warningRange,
+		},
+		{
+			Path:     "critical-range",
+			Env:      "CRITICAL_RANGE",
+			Argument: "critical-range",
+			Default:  "",
+			Usage:    "Critical threshold range in percent (e.g. 10:, ~:20, @5:10), overrides critical and compare",
+			Value:    &criticalRange,
+		},
+		{
+			Path:     "numerator-default",
+			Env:      "NUMERATOR_DEFAULT",
+			Argument: "numerator-default",
+			Default:  float64(0),
+			Usage:    "Default for numerator if no data is returned for metric",
+			Value:    &numeratorDefault,
+		},
+		{
+			Path:     "no-denominator-data-ok",
+			Env:      "NO_DENOMINATOR_DATA_OK",
+			Argument: "no-denominator-data-ok",
+			Default:  false,
+			Usage:    "Returns ok if no data is returned from denominator metric",
+			Value:    &noDenominatorDataOk,
+		},
+		{
+			Path:     "zero-denominator-data-ok",
+			Env:      "ZERO_DENOMINATOR_DATA_OK",
+			Argument: "zero-denominator-data-ok",
+			Default:  false,
+			Usage:    "Returns ok if denominator metric is zero",
+			Value:    &zeroDenominatorDataOk,
+		},
+		{
+			Path:     "no-data-ok",
+			Env:      "NO_DATA_OK",
+			Argument: "no-data-ok",
+			Default:  false,
+			Usage:    "Returns ok if no data is returned from either metric",
+			Value:    &noDataOk,
+		},
+	}
 )
 
-func metrics() {
+// CloudWatchClient represents the CloudWatch dependencies of the check
+type CloudWatchClient interface {
+	GetMetricDataPages(*cloudwatch.GetMetricDataInput, func(*cloudwatch.GetMetricDataOutput, bool) bool) error
+}
+
+func metrics(factory *awsclient.Factory, res *result.Result) {
 	var numeratorMetricValue *float64
 	var denomatorMetricValue *float64
 	var err error
-	var success bool
 
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
+	cloudWatchClient, err := factory.CloudWatch()
+	if err != nil {
+		res.Error("", err)
+		return
+	}
 
-	success, cloudWatchClient = awsclient.GetCloudWatchClient(awsSession)
-	if !success {
+	if len(expression) > 0 {
+		checkExpression(res, cloudWatchClient)
 		return
 	}
 
 	if numeratorMetric && len(numeratorMetricName) <= 0 {
-		fmt.Println("Provide a valid numerator metric name")
+		res.Unknown("", "provide a valid numerator metric name")
 		return
 	}
 
 	if denominatorMetric && len(denominatorMetricName) <= 0 {
-		fmt.Println("Provide a valid denomitator metric name")
+		res.Unknown("", "provide a valid denominator metric name")
 		return
 	}
 
+	var group []metricdata.Query
 	if numeratorMetric {
-		numeratorMetricValue, err = geMetrics(numeratorMetricName)
-		if err != nil {
-			fmt.Println("Error while getting metric statistics for Metric :", numeratorMetricName)
-			return
-		}
+		group = append(group, metricQuery("numerator", numeratorMetricName))
 	}
-
 	if denominatorMetric {
-		denomatorMetricValue, err = geMetrics(denominatorMetricName)
+		group = append(group, metricQuery("denominator", denominatorMetricName))
+	}
+	if len(group) > 0 {
+		batch := metricdata.NewBatch(time.Duration(10*period)*time.Second, period)
+		keys := batch.Add(group...)
+		series, err := batch.Fetch(cloudWatchClient)
 		if err != nil {
-			fmt.Println("Error while getting metric statistics for Metric :", denominatorMetricName)
+			res.Unknown("", "error while getting metric data: %v", err)
 			return
 		}
+		for i, query := range group {
+			point, err := series[keys[i]].Latest()
+			if err != nil {
+				continue
+			}
+			if query.ID == "numerator" {
+				numeratorMetricValue = &point.Value
+			} else {
+				denomatorMetricValue = &point.Value
+			}
+		}
 	}
 
 	if numeratorMetricValue == nil {
@@ -114,22 +327,22 @@ func metrics() {
 
 	// no data in numerator or denominator this is to keep backwards compatibility
 	if noData && noDataOk {
-		fmt.Println("OK : Returned no data but that's ok")
+		res.OK("", "returned no data but that's ok")
 		return
 	} else if denomatorMetricValue == nil && noDenominatorDataOk {
-		fmt.Println("OK : ", denominatorMetricName, "returned no data but that's ok")
+		res.OK(denominatorMetricName, "returned no data but that's ok")
 		return
 	} else if noData { // This is legacy case
-		fmt.Println("Unknown : metric data could not be retrieved")
+		res.Unknown("", "metric data could not be retrieved")
 		return
 	}
 
 	// Now both the denominator and numerator have data (or a valid default)
 	if *denomatorMetricValue == 0 && zeroDenominatorDataOk {
-		fmt.Println("OK :", denominatorMetricName, ": denominator value is zero but that's ok")
+		res.OK(denominatorMetricName, "denominator value is zero but that's ok")
 		return
-	} else if *denomatorMetricValue != 0 {
-		fmt.Println("Unknown :", denominatorMetricName, ": denominator value is zero")
+	} else if *denomatorMetricValue == 0 {
+		res.Unknown(denominatorMetricName, "denominator value is zero")
 		return
 	}
 
@@ -137,136 +350,135 @@ func metrics() {
 	value := *numeratorMetricValue / (*denomatorMetricValue) * 100
 	message := fmt.Sprintf("%s-%s/%s-(%s) is value %f", namespace, numeratorMetricName, denominatorMetricName, dimensions, value)
 
-	if compare == "greater" {
-		if value > critical {
-			fmt.Println("CRITICAL : ", message)
-			return
-		}
-		if value > warning {
-			fmt.Println("WARNING : ", message)
-			return
-		}
-		fmt.Println("OK : ", message)
+	thresholds, err := getThresholds()
+	if err != nil {
+		res.Error("", err)
 		return
-	} else if compare == "less" {
-		if value < critical {
-			fmt.Println("CRITICAL : ", message)
-			return
-		}
-		if value < warning {
-			fmt.Println("WARNING : ", message)
-			return
-		}
-		fmt.Println("OK : ", message)
+	}
+	status := thresholds.Status(value)
+	if status != result.OK {
+		res.Add(status, "", "%s (%s threshold %s)", message, strings.ToLower(status.String()), thresholds.Range(status))
+	} else {
+		res.OK("", "%s", message)
+	}
+}
+
+// checkExpression thresholds the newest value of each series returned by the
+// metric math expression
+func checkExpression(res *result.Result, cloudWatchClient CloudWatchClient) {
+	thresholds, err := getThresholds()
+	if err != nil {
+		res.Error("", err)
 		return
-	} else if compare == "equal" {
-		if value == critical {
-			fmt.Println("CRITICAL : ", message)
-			return
-		}
-		if value == warning {
-			fmt.Println("WARNING : ", message)
-			return
-		}
-		fmt.Println("OK : ", message)
+	}
+	group, err := expressionQueries()
+	if err != nil {
+		res.Error("", err)
 		return
-	} else if compare == "not" {
-		if value != critical {
-			fmt.Println("CRITICAL : ", message)
-			return
+	}
+	batch := metricdata.NewBatch(time.Duration(10*period)*time.Second, period)
+	keys := batch.Add(group...)
+	results, err := batch.Fetch(cloudWatchClient)
+	if err != nil {
+		res.Unknown("", "error while getting metric data: %v", err)
+		return
+	}
+
+	for _, series := range results[keys[len(keys)-1]].All() {
+		point, err := series.Latest()
+		if err != nil {
+			if noDataOk {
+				res.OK(series.Label, "returned no data but that's ok")
+			} else {
+				res.Unknown(series.Label, "metric data could not be retrieved")
+			}
+			continue
 		}
-		if value != warning {
-			fmt.Println("WARNING : ", message)
-			return
+		message := fmt.Sprintf("%s is value %f", series.Label, point.Value)
+		if status := thresholds.Status(point.Value); status != result.OK {
+			res.Add(status, "", "%s (%s threshold %s)", message, strings.ToLower(status.String()), thresholds.Range(status))
+		} else {
+			res.OK("", "%s", message)
 		}
-		fmt.Println("OK : ", message)
-		return
 	}
 }
 
-func geMetrics(metricName string) (*float64, error) {
-	input := &cloudwatch.GetMetricStatisticsInput{}
-	input.Namespace = aws.String(namespace)
-	input.MetricName = aws.String(metricName)
-	dimensionInputs := strings.Split(dimensions, ",")
-	for _, dimension := range dimensionInputs {
-		dimensionNameValuePair := strings.Split(dimension, "=")
-		if dimensionNameValuePair != nil && len(dimensionNameValuePair) == 2 {
-			input.Dimensions = append(input.Dimensions, &cloudwatch.Dimension{Name: aws.String(dimensionNameValuePair[0]), Value: aws.String(dimensionNameValuePair[1])})
-		}
+// expressionQueries returns the hidden metric queries of the expression
+// followed by the expression itself
+func expressionQueries() ([]metricdata.Query, error) {
+	group, err := metricdata.ParseQueries(metricQueries)
+	if err != nil {
+		return nil, err
 	}
-	input.EndTime = aws.Time(time.Now())
-	input.StartTime = aws.Time(time.Now().Add(time.Duration(-10*(period/60)) * time.Minute))
-	input.Period = aws.Int64(period)
-	input.Statistics = []*string{aws.String(statistic)}
-	input.Unit = aws.String(unit)
-	var minimumTimeDifference float64
-	var timeDifference float64
-	var averageValue *float64
-	minimumTimeDifference = -1
-	metrics, err := cloudWatchClient.GetMetricStatistics(input)
+	defaultDimensions, err := metricdata.ParseDimensions(dimensions)
 	if err != nil {
 		return nil, err
 	}
-	if metrics != nil && metrics.Datapoints != nil && len(metrics.Datapoints) > 1 {
-		for _, datapoint := range metrics.Datapoints {
-			timeDifference = time.Since(*datapoint.Timestamp).Seconds()
-			if minimumTimeDifference == -1 {
-				minimumTimeDifference = timeDifference
-				averageValue = datapoint.Average
-			} else if timeDifference < minimumTimeDifference {
-				minimumTimeDifference = timeDifference
-				averageValue = datapoint.Average
-			}
+	for i := range group {
+		if group[i].ID == "expression" {
+			return nil, errors.New("the metric query id expression is reserved")
+		}
+		if len(group[i].Namespace) == 0 {
+			group[i].Namespace = namespace
+		}
+		if group[i].Dimensions == nil {
+			group[i].Dimensions = defaultDimensions
 		}
+		group[i].Unit = unit
+		group[i].Hidden = true
 	}
-	return averageValue, nil
+	return append(group, metricdata.Query{ID: "expression", Expression: expression, Label: expression}), nil
 }
 
-func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+func getThresholds() (threshold.Thresholds, error) {
+	thresholds, err := threshold.FromOperator(compare, warning, critical)
+	if err != nil {
+		return thresholds, err
 	}
+	err = thresholds.Override(warningRange, criticalRange)
+	return thresholds, err
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
+// metricQuery returns the query of the statistic of metricName over the
+// dimensions of the check
+func metricQuery(id string, metricName string) metricdata.Query {
+	query := metricdata.Query{
+		ID:         id,
+		Namespace:  namespace,
+		MetricName: metricName,
+		Stat:       statistic,
+		Unit:       unit,
 	}
-	metrics()
-	return nil
+	query.Dimensions, _ = metricdata.ParseDimensions(dimensions)
+	return query
 }
 
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-cloudwatch-composite-metric",
-		Short: "The Sensu Go Aws Bucket handler for bucket management",
-		RunE:  run,
-	}
+func main() {
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
+}
 
-	cmd.Flags().StringVar(&excludeAlarms, "exclude_alarms", "", "Exclude alarms")
-	cmd.Flags().StringVar(&state, "state", "ALARM", "State of the alarm")
-	cmd.Flags().StringVar(&awsRegion, "aws_region", "us-east-2", "AWS Region (defaults to us-east-1).")
-	cmd.Flags().StringVar(&namespace, "namespace", "AWS/EC2", "CloudWatch namespace for metric")
-	cmd.Flags().BoolVar(&numeratorMetric, "numerator_metric", true, "Numerator metric name present")
-	cmd.Flags().StringVar(&numeratorMetricName, "numerator_metric_name", "", "Numerator metric name")
-	cmd.Flags().BoolVar(&denominatorMetric, "denominator_metric", true, "Denominator metric name present")
-	cmd.Flags().StringVar(&denominatorMetricName, "denominator_metric_name", "", "Denominator metric name")
-	cmd.Flags().StringVar(&dimensions, "dimensions", "", "Comma delimited list of DimName=Value")
-	cmd.Flags().Int64Var(&period, "period", 60, "CloudWatch metric statistics period in seconds. Must be a multiple of 60")
-	cmd.Flags().StringVar(&statistic, "statistic", "Average", "CloudWatch statistics method")
-	cmd.Flags().StringVar(&unit, "unit", "", "CloudWatch metric unit")
-	cmd.Flags().Float64Var(&critical, "critical", 0, "Trigger a critical when value is over VALUE as a Percent")
-	cmd.Flags().Float64Var(&warning, "warning", 0, "Trigger a warning when value is over VALUE as a Percent")
-	cmd.Flags().StringVar(&compare, "compare", "greater", "Comparision operator for threshold: equal, not, greater, less")
-	cmd.Flags().Float64Var(&numeratorDefault, "numerator_default", 0, "Default for numerator if no data is returned for metric")
-	cmd.Flags().BoolVar(&noDenominatorDataOk, "no_denominator_data_ok", false, "Returns ok if no data is returned from denominator metric")
-	cmd.Flags().BoolVar(&zeroDenominatorDataOk, "zero_denominator_data_ok", false, "Returns ok if denominator metric is zero")
-	cmd.Flags().BoolVar(&noDataOk, "no_data_ok", false, "Returns ok if no data is returned from either metric")
+func validate() error {
+	if len(expression) > 0 {
+		group, err := expressionQueries()
+		if err != nil {
+			return err
+		}
+		if len(group) == 1 {
+			return errors.New("--metrics is required with --expression")
+		}
+	} else if len(dimensions) == 0 {
+		return errors.New("--dimensions is required")
+	}
+	if _, err := metricdata.ParseDimensions(dimensions); err != nil {
+		return err
+	}
+	var err error
+	statistic, err = metricdata.ParseStat(statistic)
+	return err
+}
 
-	_ = cmd.MarkFlagRequired("dimensions")
-	return cmd
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, metrics)
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/sensu/sensu-aws/awstest"
+)
+
+func TestMain(m *testing.M) {
+	awstest.Main(m, main)
+}
+
+func dataResult(id string, label string, value float64) *cloudwatch.MetricDataResult {
+	return &cloudwatch.MetricDataResult{
+		Id:         aws.String(id),
+		Label:      aws.String(label),
+		StatusCode: aws.String(cloudwatch.StatusCodeComplete),
+		Timestamps: []*time.Time{aws.Time(time.Now())},
+		Values:     []*float64{aws.Float64(value)},
+	}
+}
+
+func TestExpression(t *testing.T) {
+	server := awstest.NewServer()
+	defer server.Close()
+	server.Handle(awstest.CloudWatch, "GetMetricData", func(req *awstest.Request) (interface{}, error) {
+		switch req.Params.Get("MetricDataQueries.member.4.Label") {
+		case "SEARCH":
+			return &cloudwatch.GetMetricDataOutput{MetricDataResults: []*cloudwatch.MetricDataResult{
+				dataResult("g0_expression", "web", 1), dataResult("g0_expression", "api", 8),
+			}}, nil
+		case "none":
+			return &cloudwatch.GetMetricDataOutput{}, nil
+		}
+		return &cloudwatch.GetMetricDataOutput{MetricDataResults: []*cloudwatch.MetricDataResult{
+			dataResult("g0_expression", req.Params.Get("MetricDataQueries.member.4.Label"), 2.5),
+		}}, nil
+	})
+
+	args := []string{
+		"--namespace", "AWS/ELB", "--dimensions", "LoadBalancerName=web",
+		"--metrics", "m1::HTTPCode_Backend_5XX:sum; m2:AWS/ApplicationELB:HTTPCode_ELB_5XX_Count:Sum:LoadBalancer=app/web/1; m3::RequestCount:p99",
+		"--warning", "2", "--critical", "5",
+	}
+
+	tests := []struct {
+		Name      string
+		Args      []string
+		ExpStatus int
+		ExpOutput string
+	}{
+		{
+			Name:      "warning",
+			Args:      append(args, "--expression", "100*(m1+FILL(m2,0))/m3"),
+			ExpStatus: 1,
+			ExpOutput: "check-cloudwatch-composite-metric WARNING: 100*(m1+FILL(m2,0))/m3 is value 2.500000 (warning threshold",
+		},
+		{
+			Name:      "every series",
+			Args:      append(args, "--expression", "SEARCH"),
+			ExpStatus: 2,
+			ExpOutput: "check-cloudwatch-composite-metric CRITICAL: api is value 8.000000 (critical threshold ~:5)\nCRITICAL api is value 8.000000 (critical threshold ~:5)\nOK web is value 1.000000\n",
+		},
+		{
+			Name:      "no data",
+			Args:      append(args, "--expression", "none"),
+			ExpStatus: 3,
+			ExpOutput: "check-cloudwatch-composite-metric UNKNOWN: none: metric data could not be retrieved",
+		},
+		{
+			Name:      "no data ok",
+			Args:      append(args, "--expression", "none", "--no-data-ok"),
+			ExpStatus: 0,
+		},
+		{
+			Name:      "missing metrics",
+			Args:      []string{"--expression", "m1*2"},
+			ExpStatus: 3,
+		},
+		{
+			Name:      "invalid metric id",
+			Args:      []string{"--expression", "M1*2", "--metrics", "M1:AWS/EC2:CPUUtilization:Average"},
+			ExpStatus: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			output, status := server.Exec(t, test.Args...)
+			if got, want := status, test.ExpStatus; got != want {
+				t.Errorf("bad exit status: got %d, want %d (output %q)", got, want, output)
+			}
+			if !strings.HasPrefix(output, test.ExpOutput) {
+				t.Errorf("bad output: got %q, want prefix %q", output, test.ExpOutput)
+			}
+		})
+	}
+
+	var req *awstest.Request
+	for _, r := range server.Requests() {
+		if r.Operation == "GetMetricData" {
+			req = r
+			break
+		}
+	}
+	params := map[string]string{
+		"MetricDataQueries.member.1.Id":                                          "g0_m1",
+		"MetricDataQueries.member.1.ReturnData":                                  "false",
+		"MetricDataQueries.member.1.MetricStat.Metric.Namespace":                 "AWS/ELB",
+		"MetricDataQueries.member.1.MetricStat.Metric.Dimensions.member.1.Value": "web",
+		"MetricDataQueries.member.1.MetricStat.Stat":                             "Sum",
+		"MetricDataQueries.member.2.MetricStat.Metric.Namespace":                 "AWS/ApplicationELB",
+		"MetricDataQueries.member.2.MetricStat.Metric.Dimensions.member.1.Name":  "LoadBalancer",
+		"MetricDataQueries.member.3.MetricStat.Stat":                             "p99",
+		"MetricDataQueries.member.4.Expression":                                  "100*(g0_m1+FILL(g0_m2,0))/g0_m3",
+		"MetricDataQueries.member.4.ReturnData":                                  "true",
+	}
+	for name, want := range params {
+		if got := req.Params.Get(name); got != want {
+			t.Errorf("bad %s: got %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/metricdata"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/regions"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/threshold"
+)
+
+/*
+#
+# check-cloudwatch-metric
+#
+# DESCRIPTION:
+#   This plugin retrieves the newest value of a statistic of any CloudWatch
+#   metric and triggers alarms based on the threshold ranges specified.
+#
+# OUTPUT:
+#   plain-text
+#
+# PLATFORMS:
+#   MAC OS
+#
+# USAGE:
+#   ./check-cloudwatch-metric --namespace=AWS/ELB --metric-name=Latency --dimensions="LoadBalancerName=web" --critical=~:2
+#   ./check-cloudwatch-metric --namespace=AWS/SQS --metric-name=ApproximateAgeOfOldestMessage --dimensions="QueueName=jobs" --statistic=maximum --period=300 --warning=~:600 --critical=~:1800
+#   ./check-cloudwatch-metric --namespace=AWS/ApplicationELB --metric-name=TargetResponseTime --dimensions="LoadBalancer=app/web/0123456789abcdef" --statistic=p99 --critical=~:1.5 --no-data-status=ok
+#
+# NOTES:
+#   Thresholds are Nagios ranges, e.g. ~:2 alerts above 2, 10: below 10 and
+#   @5:10 between 5 and 10.
+#
+# LICENSE:
+#   TODO
+#
+*/
+
+var (
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	namespace      string
+	metricName     string
+	dimensions     string
+	statistic      string
+	period         int64
+	fetchAge       int64
+	unit           string
+	warning        string
+	critical       string
+	noDataStatus   string
+
+	config = plugin.NewConfig("check-cloudwatch-metric", "The Sensu Go Aws Cloudwatch handler for metric thresholds")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "namespace",
+			Env:      "NAMESPACE",
+			Argument: "namespace",
+			Default:  "",
+			Usage:    "CloudWatch namespace of the metric, e.g. AWS/EC2",
+			Value:    &namespace,
+		},
+		{
+			Path:     "metric-name",
+			Env:      "METRIC_NAME",
+			Argument: "metric-name",
+			Default:  "",
+			Usage:    "CloudWatch metric name, e.g. CPUUtilization",
+			Value:    &metricName,
+		},
+		{
+			Path:     "dimensions",
+			Env:      "DIMENSIONS",
+			Argument: "dimensions",
+			Default:  "",
+			Usage:    "Comma delimited list of DimName=Value",
+			Value:    &dimensions,
+		},
+		{
+			Path:     "statistic",
+			Env:      "STATISTIC",
+			Argument: "statistic",
+			Default:  "Average",
+			Usage:    "CloudWatch statistic: average, maximum, minimum, samplecount, sum or a percentile such as p99",
+			Value:    &statistic,
+		},
+		{
+			Path:     "period",
+			Env:      "PERIOD",
+			Argument: "period",
+			Default:  int64(60),
+			Usage:    "CloudWatch metric statistics period in seconds. Must be a multiple of 60",
+			Value:    &period,
+		},
+		{
+			Path:     "fetch-age",
+			Env:      "FETCH_AGE",
+			Argument: "fetch-age",
+			Default:  int64(0),
+			Usage:    "How long ago to fetch metrics from in seconds",
+			Value:    &fetchAge,
+		},
+		{
+			Path:     "unit",
+			Env:      "UNIT",
+			Argument: "unit",
+			Default:  "",
+			Usage:    "CloudWatch metric unit, e.g. Seconds or Percent",
+			Value:    &unit,
+		},
+		{
+			Path:     "warning",
+			Env:      "WARNING",
+			Argument: "warning",
+			Default:  "",
+			Usage:    "Warning threshold range (e.g. ~:80, 10:, @5:10)",
+			Value:    &warning,
+		},
+		{
+			Path:     "critical",
+			Env:      "CRITICAL",
+			Argument: "critical",
+			Default:  "",
+			Usage:    "Critical threshold range (e.g. ~:90, 5:, @5:10)",
+			Value:    &critical,
+		},
+		{
+			Path:     "no-data-status",
+			Env:      "NO_DATA_STATUS",
+			Argument: "no-data-status",
+			Default:  "unknown",
+			Usage:    "Status when CloudWatch returns no datapoints: ok, warning, critical or unknown",
+			Value:    &noDataStatus,
+		},
+	}
+)
+
+// CloudWatchClient represents the CloudWatch dependencies of the check
+type CloudWatchClient interface {
+	GetMetricDataPages(*cloudwatch.GetMetricDataInput, func(*cloudwatch.GetMetricDataOutput, bool) bool) error
+}
+
+func checkMetric(factory *awsclient.Factory, res *result.Result) {
+	cloudWatchClient, err := factory.CloudWatch()
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	thresholds, err := threshold.New(warning, critical)
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	query := metricdata.Query{
+		Namespace:  namespace,
+		MetricName: metricName,
+		Stat:       statistic,
+		Unit:       unit,
+	}
+	query.Dimensions, _ = metricdata.ParseDimensions(dimensions)
+
+	description := fmt.Sprintf("%s %s %s", namespace, metricName, statistic)
+	if len(dimensions) > 0 {
+		description = fmt.Sprintf("%s %s (%s) %s", namespace, metricName, dimensions, statistic)
+	}
+	point, err := metricdata.Latest(cloudWatchClient, query, time.Duration(fetchAge)*time.Second, period)
+	if metricdata.IsNoData(err) {
+		status, _ := result.ParseStatus(noDataStatus)
+		res.Add(status, "", "%s returned no data", description)
+		return
+	}
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+
+	message := fmt.Sprintf("%s is %v", description, point.Value)
+	if len(unit) > 0 {
+		message += " " + unit
+	}
+	if status := thresholds.Status(point.Value); status != result.OK {
+		res.Add(status, "", "%s (%s threshold %s)", message, strings.ToLower(status.String()), thresholds.Range(status))
+	} else {
+		res.OK("", "%s", message)
+	}
+}
+
+func main() {
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
+}
+
+func validate() error {
+	if len(namespace) == 0 {
+		return errors.New("--namespace is required")
+	}
+	if len(metricName) == 0 {
+		return errors.New("--metric-name is required")
+	}
+	if _, err := metricdata.ParseDimensions(dimensions); err != nil {
+		return err
+	}
+	if period <= 0 || period%60 != 0 {
+		return fmt.Errorf("--period must be a positive multiple of 60, got %d", period)
+	}
+	if _, err := threshold.New(warning, critical); err != nil {
+		return err
+	}
+	if _, err := result.ParseStatus(noDataStatus); err != nil {
+		return fmt.Errorf("--no-data-status: %v", err)
+	}
+	var err error
+	statistic, err = metricdata.ParseStat(statistic)
+	return err
+}
+
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkMetric)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/sensu/sensu-aws/awstest"
+)
+
+func TestMain(m *testing.M) {
+	awstest.Main(m, main)
+}
+
+func TestPlugin(t *testing.T) {
+	server := awstest.NewServer()
+	defer server.Close()
+	// the latency of load balancer web is 1.5 then 2.5, queue jobs has no data
+	server.Handle(awstest.CloudWatch, "GetMetricData", func(req *awstest.Request) (interface{}, error) {
+		output := &cloudwatch.GetMetricDataOutput{}
+		if req.Params.Get("MetricDataQueries.member.1.MetricStat.Metric.Dimensions.member.1.Value") == "web" {
+			now := time.Now()
+			output.MetricDataResults = []*cloudwatch.MetricDataResult{{
+				Id:         aws.String(req.Params.Get("MetricDataQueries.member.1.Id")),
+				StatusCode: aws.String(cloudwatch.StatusCodeComplete),
+				Timestamps: []*time.Time{aws.Time(now.Add(-time.Minute)), aws.Time(now.Add(-2 * time.Minute))},
+				Values:     []*float64{aws.Float64(2.5), aws.Float64(1.5)},
+			}}
+		}
+		return output, nil
+	})
+
+	latency := []string{"--namespace", "AWS/ELB", "--metric-name", "Latency", "--dimensions", "LoadBalancerName=web", "--unit", "Seconds"}
+	queue := []string{"--namespace", "AWS/SQS", "--metric-name", "ApproximateAgeOfOldestMessage", "--dimensions", "QueueName=jobs"}
+
+	tests := []struct {
+		Name      string
+		Args      []string
+		ExpStatus int
+		ExpOutput string
+	}{
+		{
+			Name:      "ok",
+			Args:      append(latency, "--warning", "~:3"),
+			ExpStatus: 0,
+			ExpOutput: "check-cloudwatch-metric OK: AWS/ELB Latency (LoadBalancerName=web) Average is 2.5 Seconds",
+		},
+		{
+			Name:      "warning",
+			Args:      append(latency, "--warning", "~:2", "--critical", "~:3"),
+			ExpStatus: 1,
+			ExpOutput: "check-cloudwatch-metric WARNING: AWS/ELB Latency (LoadBalancerName=web) Average is 2.5 Seconds (warning threshold",
+		},
+		{
+			Name:      "critical percentile",
+			Args:      append(latency, "--statistic", "P99", "--critical", "~:2"),
+			ExpStatus: 2,
+			ExpOutput: "check-cloudwatch-metric CRITICAL: AWS/ELB Latency (LoadBalancerName=web) p99 is 2.5 Seconds (critical threshold",
+		},
+		{
+			Name:      "no data",
+			Args:      queue,
+			ExpStatus: 3,
+			ExpOutput: "check-cloudwatch-metric UNKNOWN: AWS/SQS ApproximateAgeOfOldestMessage (QueueName=jobs) Average returned no data",
+		},
+		{
+			Name:      "no data ok",
+			Args:      append(queue, "--no-data-status", "ok"),
+			ExpStatus: 0,
+			ExpOutput: "check-cloudwatch-metric OK: AWS/SQS ApproximateAgeOfOldestMessage (QueueName=jobs) Average returned no data",
+		},
+		{
+			Name:      "no data critical",
+			Args:      append(queue, "--no-data-status", "critical"),
+			ExpStatus: 2,
+		},
+		{
+			Name:      "missing metric name",
+			Args:      []string{"--namespace", "AWS/ELB"},
+			ExpStatus: 3,
+		},
+		{
+			Name:      "invalid dimensions",
+			Args:      []string{"--namespace", "AWS/ELB", "--metric-name", "Latency", "--dimensions", "web"},
+			ExpStatus: 3,
+		},
+		{
+			Name:      "invalid statistic",
+			Args:      append(latency, "--statistic", "median"),
+			ExpStatus: 3,
+		},
+		{
+			Name:      "zero period",
+			Args:      append(latency, "--period", "0"),
+			ExpStatus: 3,
+		},
+		{
+			Name:      "misaligned period",
+			Args:      append(latency, "--period", "90"),
+			ExpStatus: 3,
+		},
+		{
+			Name:      "invalid threshold",
+			Args:      append(latency, "--critical", "a:b"),
+			ExpStatus: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			calls := server.Calls(awstest.CloudWatch, "GetMetricData")
+			output, status := server.Exec(t, test.Args...)
+			if got, want := status, test.ExpStatus; got != want {
+				t.Errorf("bad exit status: got %d, want %d (output %q)", got, want, output)
+			}
+			if !strings.HasPrefix(output, test.ExpOutput) {
+				t.Errorf("bad output: got %q, want prefix %q", output, test.ExpOutput)
+			}
+			if test.ExpStatus == 3 && len(test.ExpOutput) == 0 {
+				if got, want := server.Calls(awstest.CloudWatch, "GetMetricData"), calls; got != want {
+					t.Errorf("invalid options called CloudWatch: got %d calls, want %d", got, want)
+				}
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@ package main
 #
 # USAGE:
 #   ./check-ebs-burst-limit
+#   ./check-ebs-burst-limit --warning-range=20: --critical-range=5:
 #
 # LICENSE:
 #   TODO
@@ -23,153 +24,182 @@ package main
 */
 
 import (
-	"fmt"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/spf13/cobra"
 
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/metricdata"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/regions"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/threshold"
+	"github.com/sensu/sensu-aws/utils"
 )
 
 var (
-	ec2Client         *ec2.EC2
+	sessionOptions    aws_session.Options
+	regionOptions     regions.Options
+	pager             utils.Pager
 	scheme            string
-	awsRegion         string
-	cloudWatchClient  *cloudwatch.CloudWatch
 	criticalThreshold float64
 	warningThreshold  float64
+	warningRange      string
+	criticalRange     string
 	checkSelf         bool
+
+	config = plugin.NewConfig("check-ebs-burst-limit", "The Sensu Go Aws EBS handler for burst limit management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "critical",
+			Env:      "CRITICAL",
+			Argument: "critical",
+			Default:  float64(50),
+			Usage:    "Trigger a critical when ebs burst limit is under VALUE",
+			Value:    &criticalThreshold,
+		},
+		{
+			Path:     "warning",
+			Env:      "WARNING",
+			Argument: "warning",
+			Default:  float64(10),
+			Usage:    "Trigger a warning when ebs burst limit is under VALUE",
+			Value:    &warningThreshold,
+		},
+		{
+			Path:     "warning-range",
+			Env:      "WARNING_RANGE",
+			Argument: "warning-range",
+			Default:  "",
+			Usage:    "Warning threshold range of the burst balance (e.g. 10:, ~:20, @5:10), overrides warning",
+			Value:    &warningRange,
+		},
+		{
+			Path:     "critical-range",
+			Env:      "CRITICAL_RANGE",
+			Argument: "critical-range",
+			Default:  "",
+			Usage:    "Critical threshold range of the burst balance (e.g. 10:, ~:20, @5:10), overrides critical",
+			Value:    &criticalRange,
+		},
+		{
+			Path:     "check-self",
+			Env:      "CHECK_SELF",
+			Argument: "check-self",
+			Default:  false,
+			Usage:    "Only check the instance on which this plugin is being run - this overrides the -r option and uses the region of the current instance",
+			Value:    &checkSelf,
+		},
+	}
 )
 
-func checkLimit() {
-	var success bool
+// EC2Client represents the EC2 dependencies of the check
+type EC2Client interface {
+	DescribeVolumesPages(*ec2.DescribeVolumesInput, func(*ec2.DescribeVolumesOutput, bool) bool) error
+}
+
+// CloudWatchClient represents the CloudWatch dependencies of the check
+type CloudWatchClient interface {
+	GetMetricDataPages(*cloudwatch.GetMetricDataInput, func(*cloudwatch.GetMetricDataOutput, bool) bool) error
+}
+
+func checkLimit(factory *awsclient.Factory, res *result.Result) {
 	volumeInput := &ec2.DescribeVolumesInput{}
 
 	// Set the describe-volumes filter depending on whether -s was specified
 	if checkSelf {
 		//TODO
 	} else {
-		//The --check_self option was not specified, look at all volumes which are attached
+		//The --check-self option was not specified, look at all volumes which are attached
 		filter := &ec2.Filter{}
 		filter.Name = aws.String("attachment.status")
 		filter.Values = []*string{aws.String("attached")}
 		volumeInput.Filters = []*ec2.Filter{filter}
 	}
 
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
-
-	success, ec2Client = awsclient.GetEC2Client(awsSession)
-	if !success {
+	ec2Client, err := factory.EC2()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
-	success, cloudWatchClient = awsclient.GetCloudWatchClient(awsSession)
-	if !success {
+	cloudWatchClient, err := factory.CloudWatch()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
 
-	volumes, err := ec2Client.DescribeVolumes(volumeInput)
+	thresholds, err := getThresholds()
 	if err != nil {
-		fmt.Println(err)
-	}
-
-	isCritical := false
-	shouldWarn := false
-	errors := []string{}
-
-	if volumes != nil {
-		for _, volume := range volumes.Volumes {
-			crit, warn, errorString := getMetric(*volume.VolumeId)
-			if errorString != nil && len(errorString) > 0 {
-				errors = append(errors, errorString[0])
-			}
-			isCritical = isCritical || crit
-			shouldWarn = shouldWarn || warn
-		}
+		res.Error("", err)
+		return
 	}
-
-	if isCritical {
-		fmt.Println("CRITICAL : Volume(s) have exceeded critical threshold:", errors)
-	} else if shouldWarn {
-		fmt.Println("WARNING : Volume(s) have exceeded warning threshold:", errors)
+	volumes, err := pager.Volumes(ec2Client, volumeInput)
+	if err != nil {
+		res.Error("", err)
+		return
 	}
-}
 
-func getMetric(volumeId string) (bool, bool, []string) {
-	errors := []string{}
-	isCRitical := false
-	shouldWarn := false
-	stats := "Average"
-	var input cloudwatch.GetMetricStatisticsInput
-	input.Namespace = aws.String("AWS/EBS")
-	input.MetricName = aws.String("BurstBalance")
-	var dimensionFilter cloudwatch.Dimension
-	dimensionFilter.Name = aws.String("VolumeId")
-	dimensionFilter.Value = aws.String(volumeId)
-	input.Dimensions = []*cloudwatch.Dimension{&dimensionFilter}
-	input.Period = aws.Int64(120)
-	input.EndTime = aws.Time(time.Now())
-	input.StartTime = aws.Time(time.Now().Add(time.Duration(-24*60) * time.Minute))
-	input.Statistics = []*string{aws.String(stats)}
-	metrics, err := cloudWatchClient.GetMetricStatistics(&input)
-	if err != nil {
-		fmt.Println(err)
+	batch := metricdata.NewBatch(24*time.Hour, 120)
+	keys := make(map[string]string)
+	for _, volume := range volumes {
+		keys[*volume.VolumeId] = batch.Add(metricdata.Query{
+			Namespace:  "AWS/EBS",
+			MetricName: "BurstBalance",
+			Dimensions: []*cloudwatch.Dimension{metricdata.Dimension("VolumeId", *volume.VolumeId)},
+			Stat:       "Average",
+		})[0]
 	}
-	if metrics != nil && metrics.Datapoints != nil && len(metrics.Datapoints) >= 1 {
-		var minimumTimeDifference float64
-		var timeDifference float64
-		var averageValue *float64
-		minimumTimeDifference = -1
-		for _, datapoint := range metrics.Datapoints {
-			timeDifference = time.Since(*datapoint.Timestamp).Seconds()
-			if minimumTimeDifference == -1 || timeDifference < minimumTimeDifference {
-				minimumTimeDifference = timeDifference
-				averageValue = datapoint.Average
-			}
+	if batch.Len() > 0 {
+		series, err := batch.Fetch(cloudWatchClient)
+		if err != nil {
+			res.Error("", err)
+			return
 		}
-		if *averageValue < criticalThreshold {
-			errors = append(errors, fmt.Sprintf("%v:%v", volumeId, averageValue))
-			isCRitical = true
-		} else if *averageValue < warningThreshold {
-			errors = append(errors, fmt.Sprintf("%v:%v", volumeId, averageValue))
-			shouldWarn = true
+		for _, volume := range volumes {
+			checkVolume(res, thresholds, *volume.VolumeId, series[keys[*volume.VolumeId]])
 		}
 	}
-	return isCRitical, shouldWarn, errors
+	res.SetOKMessage("%d volume(s) above burst balance thresholds", len(volumes))
 }
 
-func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+func checkVolume(res *result.Result, thresholds threshold.Thresholds, volumeId string, series *metricdata.Series) {
+	point, err := series.Latest()
+	if err != nil {
+		return
+	}
+	burstBalance := point.Value
+	if status := thresholds.Status(burstBalance); status != result.OK {
+		res.Add(status, volumeId, "burst balance %v has exceeded %s threshold %s", burstBalance, strings.ToLower(status.String()), thresholds.Range(status))
 	}
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
-	}
-	checkLimit()
-	return nil
+// getThresholds alerts when the burst balance is under the warning and
+// critical thresholds unless ranges are given
+func getThresholds() (threshold.Thresholds, error) {
+	thresholds := threshold.Under(warningThreshold, criticalThreshold)
+	err := thresholds.Override(warningRange, criticalRange)
+	return thresholds, err
 }
 
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-ebs-burst-limit",
-		Short: "The Sensu Go Aws EBS handler for burst limit management",
-		RunE:  run,
-	}
+func main() {
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
+}
 
-	cmd.Flags().StringVar(&awsRegion, "aws_region", "us-east-2", "AWS Region (defaults to us-east-1).")
-	cmd.Flags().Float64Var(&criticalThreshold, "critical", 50, "Trigger a critical when ebs burst limit is under VALUE")
-	cmd.Flags().Float64Var(&warningThreshold, "warning", 10, "Trigger a warning when ebs burst limit is under VALUE")
-	cmd.Flags().BoolVar(&checkSelf, "check_self", false, "Only check the instance on which this plugin is being run - this overrides the -r option and uses the region of the current instance")
+func validate() error {
+	_, err := getThresholds()
+	return err
+}
 
-	return cmd
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkLimit)
+	pager.Report(res)
 }
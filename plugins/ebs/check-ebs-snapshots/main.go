@@ -14,7 +14,7 @@ package main
 #   MAC OS
 #
 # USAGE:
-#   ./check-ebs-snapshots --check_ignored=false
+#   ./check-ebs-snapshots --check-ignored=false
 #
 # NOTES:
 #   When using check_ignored flag value as true, any volume that has a tag-key of "IGNORE_BACKUP" will
@@ -26,35 +26,60 @@ package main
 */
 
 import (
-	"fmt"
-	"os"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/spf13/cobra"
 
-	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/regions"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/utils"
 )
 
 var (
-	ec2Client         *ec2.EC2
+	sessionOptions    aws_session.Options
+	regionOptions     regions.Options
+	pager             utils.Pager
 	scheme            string
-	awsRegion         string
-	cloudWatchClient  *cloudwatch.CloudWatch
 	criticalThreshold float64
 	checkIgnored      bool
 	period            int64
+
+	config = plugin.NewConfig("check-ebs-snapshots", "The Sensu Go Aws EBS handler for snapshot management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "check-ignored",
+			Env:      "CHECK_IGNORED",
+			Argument: "check-ignored",
+			Default:  true,
+			Usage:    "mark as true to ignore volumes with an IGNORE_BACKUP tag",
+			Value:    &checkIgnored,
+		},
+		{
+			Path:     "period",
+			Env:      "PERIOD",
+			Argument: "period",
+			Default:  int64(7),
+			Usage:    "Length in time to alert on missing snapshots",
+			Value:    &period,
+		},
+	}
 )
 
-func checkSnapshot() {
-	var errors []string
-	var success bool
+// EC2Client represents the EC2 dependencies of the check
+type EC2Client interface {
+	DescribeSnapshotsPages(*ec2.DescribeSnapshotsInput, func(*ec2.DescribeSnapshotsOutput, bool) bool) error
+	DescribeVolumesPages(*ec2.DescribeVolumesInput, func(*ec2.DescribeVolumesOutput, bool) bool) error
+}
+
+func checkSnapshot(factory *awsclient.Factory, res *result.Result) {
 
 	volumeInput := &ec2.DescribeVolumesInput{}
-	tagNames := []string{}
 
 	filter := &ec2.Filter{}
 	filter.Name = aws.String("attachment.status")
@@ -64,78 +89,68 @@ func checkSnapshot() {
 	filter2.Name = aws.String("tag-key")
 	filter2.Values = []*string{aws.String("Name")}
 
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
-
-	success, ec2Client = awsclient.GetEC2Client(awsSession)
-	if !success {
-		return
-	}
-	success, cloudWatchClient = awsclient.GetCloudWatchClient(awsSession)
-	if !success {
+	ec2Client, err := factory.EC2()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
 
-	volumes, err := ec2Client.DescribeVolumes(volumeInput)
+	volumes, err := pager.Volumes(ec2Client, volumeInput)
 	if err != nil {
-		fmt.Println(err)
+		res.Error("", err)
+		return
 	}
 
-	if volumes != nil {
-		for _, volume := range volumes.Volumes {
-			tags := volume.Tags
-			ignoreVolume := false
-			if volume.Tags != nil && len(volume.Tags) > 0 {
-				for _, tag := range tags {
-					if checkIgnored && *tag.Key == "IGNORE_BACKUP" {
-						ignoreVolume = true
-						break
-					} else {
-						tagNames = append(tagNames, *tag.Key)
-					}
-				}
-				if ignoreVolume {
-					continue
-				}
-				latestSnapshot, err := getLatestSnapshot(*volume.VolumeId)
-				if err != nil {
-					fmt.Println("Error : ", err)
-					return
-				}
-				if latestSnapshot != nil {
-					timeDiffrence := aws.Time(time.Now().Add(-time.Duration(period*24*60) * time.Minute)).Sub(*latestSnapshot.StartTime)
-					if timeDiffrence.Seconds() < 0 {
-						errors = append(errors, fmt.Sprintf("%v latest snapshot is %v for Voulme %s \n", tagNames, *latestSnapshot.StartTime, *volume.VolumeId))
-					}
+	for _, volume := range volumes {
+		tags := volume.Tags
+		tagNames := []string{}
+		ignoreVolume := false
+		if volume.Tags != nil && len(volume.Tags) > 0 {
+			for _, tag := range tags {
+				if checkIgnored && *tag.Key == "IGNORE_BACKUP" {
+					ignoreVolume = true
+					break
 				} else {
-					errors = append(errors, fmt.Sprintf("%v has no snapshot for Voulme %s \n", tagNames, *volume.VolumeId))
+					tagNames = append(tagNames, *tag.Key)
 				}
 			}
-		}
-
-		if len(errors) > 0 {
-			fmt.Println("Warning : ", errors)
-		} else {
-			fmt.Println("Ok")
+			if ignoreVolume {
+				continue
+			}
+			latestSnapshot, err := getLatestSnapshot(ec2Client, *volume.VolumeId)
+			if err != nil {
+				res.Error(*volume.VolumeId, err)
+				continue
+			}
+			if latestSnapshot != nil {
+				timeDiffrence := aws.Time(time.Now().Add(-time.Duration(period*24*60) * time.Minute)).Sub(*latestSnapshot.StartTime)
+				if timeDiffrence.Seconds() > 0 {
+					res.Warning(*volume.VolumeId, "%v latest snapshot is %v", tagNames, *latestSnapshot.StartTime)
+				}
+			} else {
+				res.Warning(*volume.VolumeId, "%v has no snapshot", tagNames)
+			}
 		}
 	}
+	res.SetOKMessage("all volumes have a snapshot newer than %d day(s)", period)
 }
 
-func getLatestSnapshot(volumeId string) (*ec2.Snapshot, error) {
+func getLatestSnapshot(ec2Client EC2Client, volumeId string) (*ec2.Snapshot, error) {
 	var latestSnapshot *ec2.Snapshot
 	filter := &ec2.Filter{}
 	filter.Name = aws.String("volume-id")
 	filter.Values = []*string{&volumeId}
 	snapshotInput := &ec2.DescribeSnapshotsInput{}
 	snapshotInput.Filters = []*ec2.Filter{filter}
-	snapshots, err := ec2Client.DescribeSnapshots(snapshotInput)
+	snapshots, err := pager.Snapshots(ec2Client, snapshotInput)
 	if err != nil {
 		return nil, err
 	}
-	if snapshots != nil && snapshots.Snapshots != nil && len(snapshots.Snapshots) >= 1 {
+	if len(snapshots) >= 1 {
 		var minimumTimeDifference float64
 		var timeDifference float64
 		minimumTimeDifference = -1
-		for _, snapshot := range snapshots.Snapshots {
+		for _, snapshot := range snapshots {
 			timeDifference = time.Since(*snapshot.StartTime).Seconds()
 			if minimumTimeDifference == -1 || timeDifference < minimumTimeDifference {
 				minimumTimeDifference = timeDifference
@@ -149,31 +164,13 @@ func getLatestSnapshot(volumeId string) (*ec2.Snapshot, error) {
 }
 
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
-}
-
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
-	}
-	checkSnapshot()
-	return nil
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, nil, run)
 }
 
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-ebs-snapshot",
-		Short: "The Sensu Go Aws EBS handler for snapshot management",
-		RunE:  run,
-	}
-
-	cmd.Flags().StringVar(&awsRegion, "aws_region", "us-east-1", "AWS Region (defaults to us-east-1).")
-	cmd.Flags().BoolVar(&checkIgnored, "check_ignored", true, "mark as true to ignore volumes with an IGNORE_BACKUP tag")
-	cmd.Flags().Int64Var(&period, "period", 7, "Length in time to alert on missing snapshots")
-	return cmd
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkSnapshot)
+	pager.Report(res)
 }
@@ -0,0 +1,257 @@
+package main
+
+/*
+#
+# check-ec2-compliance
+#
+# DESCRIPTION:
+#   This plugin checks EC2 instances matching a given filter against a rule
+#   file of required tags, allowed instance types and families, IMDSv2, EBS
+#   optimization and public IP addresses in private subnets, and reports each
+#   instance violating a rule.
+#
+# OUTPUT:
+#   plain-text
+#
+# PLATFORMS:
+#   MAC OS
+#
+#
+# USAGE:
+#   ./check-ec2-compliance --rules-file=/etc/sensu/ec2-compliance.json
+#   ./check-ec2-compliance --rules="{\"required_tags\" : [{\"name\" : \"Owner\"}], \"require_imdsv2\" : true}" --severity=warning
+# NOTES:
+#   An example rule file:
+#   {
+#     "required_tags": [{"name": "Owner"}, {"name": "Environment", "values": ["production", "staging"]}],
+#     "instance_types": ["m5.large"],
+#     "instance_families": ["t3", "m5"],
+#     "require_imdsv2": true,
+#     "require_ebs_optimized": true,
+#     "private_subnets": ["subnet-0123456789abcdef0"],
+#     "exclude_tags": [{"name": "Compliance", "value": "exempt"}]
+#   }
+#   An instance type is allowed when it is one of instance_types or of
+#   instance_families, instances with one of exclude_tags are not checked.
+#
+# LICENSE:
+#   TODO
+#
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/models"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/regions"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/utils"
+)
+
+var (
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	pager          utils.Pager
+	rulesFile      string
+	rules          string
+	filters        string
+	severity       string
+
+	config = plugin.NewConfig("check-ec2-compliance", "The Sensu Go Aws EC2 handler for instance compliance")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "rules-file",
+			Env:      "RULES_FILE",
+			Argument: "rules-file",
+			Default:  "",
+			Usage:    "JSON file of compliance rules",
+			Value:    &rulesFile,
+		},
+		{
+			Path:     "rules",
+			Env:      "RULES",
+			Argument: "rules",
+			Default:  "",
+			Usage:    "JSON String representation of compliance rules, instead of --rules-file",
+			Value:    &rules,
+		},
+		{
+			Path:     "filters",
+			Env:      "FILTERS",
+			Argument: "filters",
+			Default:  "{\"filters\" : [{\"name\" : \"instance-state-name\", \"values\": [\"running\"]}]}",
+			Usage:    "JSON String representation of Filters",
+			Value:    &filters,
+		},
+		{
+			Path:     "severity",
+			Env:      "SEVERITY",
+			Argument: "severity",
+			Default:  "critical",
+			Usage:    "Status of instances violating a rule: warning or critical",
+			Value:    &severity,
+		},
+	}
+)
+
+// EC2Client represents the EC2 dependencies of the check
+type EC2Client interface {
+	DescribeInstancesPages(*ec2.DescribeInstancesInput, func(*ec2.DescribeInstancesOutput, bool) bool) error
+}
+
+// loadRules returns the rules of --rules or --rules-file
+func loadRules() (models.ComplianceRules, error) {
+	var complianceRules models.ComplianceRules
+	data := []byte(rules)
+	if len(rulesFile) > 0 {
+		var err error
+		data, err = ioutil.ReadFile(rulesFile)
+		if err != nil {
+			return complianceRules, fmt.Errorf("failed to read rules file: %v", err)
+		}
+	}
+	if err := json.Unmarshal(data, &complianceRules); err != nil {
+		return complianceRules, fmt.Errorf("failed to unmarshal rules: %v", err)
+	}
+	return complianceRules, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// violations returns the rules instance violates
+func violations(complianceRules models.ComplianceRules, instance *ec2.Instance) []string {
+	tags := make(map[string]string)
+	for _, tag := range instance.Tags {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+
+	var violated []string
+	for _, required := range complianceRules.RequiredTags {
+		value, ok := tags[required.Name]
+		switch {
+		case !ok:
+			violated = append(violated, fmt.Sprintf("missing tag %s", required.Name))
+		case len(required.Values) > 0 && !contains(required.Values, value):
+			violated = append(violated, fmt.Sprintf("tag %s=%s not one of %s", required.Name, value, strings.Join(required.Values, ",")))
+		}
+	}
+
+	instanceType := aws.StringValue(instance.InstanceType)
+	family := strings.SplitN(instanceType, ".", 2)[0]
+	if len(complianceRules.InstanceTypes) > 0 || len(complianceRules.InstanceFamilies) > 0 {
+		if !contains(complianceRules.InstanceTypes, instanceType) && !contains(complianceRules.InstanceFamilies, family) {
+			violated = append(violated, fmt.Sprintf("instance type %s not allowed", instanceType))
+		}
+	}
+
+	if complianceRules.RequireIMDSv2 {
+		if instance.MetadataOptions == nil || aws.StringValue(instance.MetadataOptions.HttpTokens) != ec2.HttpTokensStateRequired {
+			violated = append(violated, "IMDSv2 not required")
+		}
+	}
+	if complianceRules.RequireEBSOptimized && !aws.BoolValue(instance.EbsOptimized) {
+		violated = append(violated, "not EBS optimized")
+	}
+	if instance.PublicIpAddress != nil && contains(complianceRules.PrivateSubnets, aws.StringValue(instance.SubnetId)) {
+		violated = append(violated, fmt.Sprintf("public IP %s in private subnet %s", aws.StringValue(instance.PublicIpAddress), aws.StringValue(instance.SubnetId)))
+	}
+	return violated
+}
+
+// excluded returns whether instance has one of the exclude tags of the rules
+func excluded(complianceRules models.ComplianceRules, instance *ec2.Instance) bool {
+	for _, tag := range instance.Tags {
+		for _, exclude := range complianceRules.ExcludeTags {
+			if aws.StringValue(tag.Key) == exclude.Name && aws.StringValue(tag.Value) == exclude.Value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func checkCompliance(factory *awsclient.Factory, res *result.Result) {
+	var ec2Filters models.Filters
+	ec2Client, err := factory.EC2()
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	complianceRules, err := loadRules()
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	err = json.Unmarshal([]byte(filters), &ec2Filters)
+	if err != nil {
+		res.Unknown("", "failed to unmarshal filter data: %v", err)
+		return
+	}
+
+	reservations, err := pager.Reservations(ec2Client, &ec2.DescribeInstancesInput{Filters: ec2Filters.Filters})
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	status, _ := result.ParseStatus(severity)
+	checked := 0
+	for _, reservation := range reservations {
+		for _, instance := range reservation.Instances {
+			if excluded(complianceRules, instance) {
+				continue
+			}
+			checked++
+			if violated := violations(complianceRules, instance); len(violated) > 0 {
+				res.Add(status, aws.StringValue(instance.InstanceId), "%s", strings.Join(violated, ", "))
+			}
+		}
+	}
+	res.SetOKMessage("%d instances comply with the rules", checked)
+}
+
+func main() {
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
+}
+
+func validate() error {
+	if len(rulesFile) == 0 && len(rules) == 0 {
+		return errors.New("--rules-file or --rules is required")
+	}
+	if _, err := loadRules(); err != nil {
+		return err
+	}
+	var ec2Filters models.Filters
+	if err := json.Unmarshal([]byte(filters), &ec2Filters); err != nil {
+		return fmt.Errorf("--filters: %v", err)
+	}
+	if status, err := result.ParseStatus(severity); err != nil || (status != result.Warning && status != result.Critical) {
+		return fmt.Errorf("--severity must be warning or critical, not %q", severity)
+	}
+	return nil
+}
+
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkCompliance)
+	pager.Report(res)
+}
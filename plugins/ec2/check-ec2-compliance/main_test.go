@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu/sensu-aws/awstest"
+)
+
+func TestMain(m *testing.M) {
+	awstest.Main(m, main)
+}
+
+func TestPlugin(t *testing.T) {
+	server := awstest.NewServer()
+	defer server.Close()
+
+	instance := func(id string, instanceType string, tags map[string]string) *ec2.Instance {
+		instance := &ec2.Instance{
+			InstanceId:      aws.String(id),
+			InstanceType:    aws.String(instanceType),
+			EbsOptimized:    aws.Bool(true),
+			SubnetId:        aws.String("subnet-private"),
+			MetadataOptions: &ec2.InstanceMetadataOptionsResponse{HttpTokens: aws.String("required")},
+		}
+		for key, value := range tags {
+			instance.Tags = append(instance.Tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+		}
+		return instance
+	}
+	owned := map[string]string{"Owner": "web", "Environment": "production"}
+	compliant := instance("i-ok", "m5.large", owned)
+	untagged := instance("i-untagged", "t3.micro", map[string]string{"Environment": "dev"})
+	legacy := instance("i-legacy", "m4.xlarge", owned)
+	legacy.MetadataOptions.HttpTokens = aws.String("optional")
+	legacy.EbsOptimized = aws.Bool(false)
+	public := instance("i-public", "t3.small", owned)
+	public.PublicIpAddress = aws.String("203.0.113.10")
+	exempt := instance("i-exempt", "c4.large", map[string]string{"Compliance": "exempt"})
+	server.Respond(awstest.EC2, "DescribeInstances", &ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{compliant, untagged, legacy, public, exempt}}},
+	})
+
+	dir, err := ioutil.TempDir("", "check-ec2-compliance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rulesFile := filepath.Join(dir, "rules.json")
+	err = ioutil.WriteFile(rulesFile, []byte(`{
+		"required_tags": [{"name": "Owner"}, {"name": "Environment", "values": ["production", "staging"]}],
+		"instance_types": ["m5.large"],
+		"instance_families": ["t3"],
+		"require_imdsv2": true,
+		"require_ebs_optimized": true,
+		"private_subnets": ["subnet-private"],
+		"exclude_tags": [{"name": "Compliance", "value": "exempt"}]
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		Name      string
+		Args      []string
+		ExpStatus int
+		ExpOutput string
+	}{
+		{
+			Name:      "rules file",
+			Args:      []string{"--rules-file", rulesFile},
+			ExpStatus: 2,
+			ExpOutput: "check-ec2-compliance CRITICAL: 3 critical\n" +
+				"CRITICAL i-untagged: missing tag Owner, tag Environment=dev not one of production,staging\n" +
+				"CRITICAL i-legacy: instance type m4.xlarge not allowed, IMDSv2 not required, not EBS optimized\n" +
+				"CRITICAL i-public: public IP 203.0.113.10 in private subnet subnet-private\n",
+		},
+		{
+			Name:      "inline rules",
+			Args:      []string{"--rules", `{"instance_families": ["m5", "t3", "c4"]}`, "--severity", "warning"},
+			ExpStatus: 1,
+			ExpOutput: "check-ec2-compliance WARNING: i-legacy: instance type m4.xlarge not allowed\n",
+		},
+		{
+			Name:      "compliant",
+			Args:      []string{"--rules", `{"required_tags": [{"name": "Environment"}], "exclude_tags": [{"name": "Compliance", "value": "exempt"}]}`},
+			ExpStatus: 0,
+			ExpOutput: "check-ec2-compliance OK: 4 instances comply with the rules",
+		},
+		{
+			Name:      "missing rules",
+			Args:      []string{},
+			ExpStatus: 3,
+		},
+		{
+			Name:      "invalid rules",
+			Args:      []string{"--rules", `{"required_tags": "Owner"}`},
+			ExpStatus: 3,
+		},
+		{
+			Name:      "missing rules file",
+			Args:      []string{"--rules-file", filepath.Join(dir, "missing.json")},
+			ExpStatus: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			output, status := server.Exec(t, test.Args...)
+			if got, want := status, test.ExpStatus; got != want {
+				t.Errorf("bad exit status: got %d, want %d (output %q)", got, want, output)
+			}
+			if !strings.HasPrefix(output, test.ExpOutput) {
+				t.Errorf("bad output: got %q, want prefix %q", output, test.ExpOutput)
+			}
+		})
+	}
+}
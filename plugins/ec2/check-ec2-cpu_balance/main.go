@@ -15,6 +15,7 @@ package main
 #   ./check-ec2-cpu_balance --critical=3
 #   ./check-ec2-cpu_balance --critical=1 --warning=5
 #   ./check-ec2-cpu_balance --critical=1 --warning=5 --tag=TESTING
+#   ./check-ec2-cpu_balance --warning-range=5: --critical-range=@0:1
 #
 # NOTES:
 #
@@ -23,115 +24,163 @@ package main
 */
 
 import (
-	"fmt"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/sensu/sensu-aws/awsclient"
-	"github.com/spf13/cobra"
+	"github.com/sensu/sensu-aws/events"
+	"github.com/sensu/sensu-aws/metricdata"
+	"github.com/sensu/sensu-aws/regions"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/threshold"
 	"github.com/sensu/sensu-aws/utils"
 )
 
 var (
-	ec2Client         *ec2.EC2
-	cloudWatchClient  *cloudwatch.CloudWatch
+	sessionOptions    aws_session.Options
+	regionOptions     regions.Options
+	eventOptions      events.Options
+	pager             utils.Pager
 	criticalThreshold float64
 	warningThreshold  float64
+	warningRange      string
+	criticalRange     string
 	tagValue          string
-	awsRegion         string
+
+	config = plugin.NewConfig("check-ec2-cpu_balance", "The Sensu Go Aws EC2 handler for cpu management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "critical",
+			Env:      "CRITICAL",
+			Argument: "critical",
+			Default:  1.2,
+			Usage:    "Trigger a critical when value is below the criticalThreshold.",
+			Value:    &criticalThreshold,
+		},
+		{
+			Path:     "warning",
+			Env:      "WARNING",
+			Argument: "warning",
+			Default:  2.3,
+			Usage:    "Trigger a warning when value is below warningThreshold",
+			Value:    &warningThreshold,
+		},
+		{
+			Path:     "warning-range",
+			Env:      "WARNING_RANGE",
+			Argument: "warning-range",
+			Default:  "",
+			Usage:    "Warning threshold range of the cpu balance (e.g. 10:, ~:20, @5:10), overrides warning",
+			Value:    &warningRange,
+		},
+		{
+			Path:     "critical-range",
+			Env:      "CRITICAL_RANGE",
+			Argument: "critical-range",
+			Default:  "",
+			Usage:    "Critical threshold range of the cpu balance (e.g. 10:, ~:20, @5:10), overrides critical",
+			Value:    &criticalRange,
+		},
+		{
+			Path:     "tag",
+			Env:      "TAG",
+			Argument: "tag",
+			Default:  "NAME",
+			Usage:    "Add instance TAG value to warn/critical message.",
+			Value:    &tagValue,
+		},
+	}
 )
 
-func ckeckCpu() {
-	var success bool
+// EC2Client represents the EC2 dependencies of the check
+type EC2Client interface {
+	DescribeInstancesPages(*ec2.DescribeInstancesInput, func(*ec2.DescribeInstancesOutput, bool) bool) error
+}
+
+// CloudWatchClient represents the CloudWatch dependencies of the check
+type CloudWatchClient interface {
+	GetMetricDataPages(*cloudwatch.GetMetricDataInput, func(*cloudwatch.GetMetricDataOutput, bool) bool) error
+}
+
+func ckeckCpu(factory *awsclient.Factory, res *result.Result) {
 	var reservations []*ec2.Reservation
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
-	success, ec2Client = awsclient.GetEC2Client(awsSession)
-	if !success {
+	thresholds, err := getThresholds()
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	ec2Client, err := factory.EC2()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
 	filter := ec2.Filter{Name: aws.String("instance-state-name"), Values: []*string{
 		aws.String("running")}}
 
-	reservations, err := utils.GetReservations(ec2Client, []*ec2.Filter{&filter})
+	reservations, err = pager.Reservations(ec2Client, &ec2.DescribeInstancesInput{Filters: []*ec2.Filter{&filter}})
 	if err != nil {
-		fmt.Println(err.Error())
+		res.Error("", err)
 		return
 	}
 
-	success, cloudWatchClient = awsclient.GetCloudWatchClient(awsSession)
-	if !success {
+	cloudWatchClient, err := factory.CloudWatch()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
 
+	var instances []*ec2.Instance
+	batch := metricdata.NewBatch(10*time.Minute, 60)
+	keys := make(map[string]string)
 	for _, reservation := range reservations {
 		for _, instance := range reservation.Instances {
-			instanceType := instance.InstanceType
-			if strings.HasPrefix(*instanceType, "t2.") {
-				cpuBalance, err := getEc2CpuBalance(*instance)
-				if err != nil {
-					fmt.Println(err.Error())
-					return
-				}
-				tagValue := getMatchingInstanceTag(*instance)
-				if tagValue != nil {
-					if *cpuBalance < criticalThreshold {
-						fmt.Println(*instance.InstanceId, *tagValue, "is below critical threshold", "[cpuBalance <", criticalThreshold, "]")
-					} else if *cpuBalance < warningThreshold {
-						fmt.Println(*instance.InstanceId, *tagValue, "is below warning threshold", "[cpuBalance <", warningThreshold, "]")
-					}
-				}
+			if strings.HasPrefix(*instance.InstanceType, "t2.") {
+				instances = append(instances, instance)
+				keys[*instance.InstanceId] = batch.Add(cpuBalanceQuery(*instance))[0]
 			}
 		}
 	}
-}
-
-func getEc2CpuBalance(instance ec2.Instance) (*float64, error) {
-	stats := "Average"
-	var period int64
-	period = 60
-	var input cloudwatch.GetMetricStatisticsInput
-	input.Namespace = aws.String("AWS/EC2")
-	input.MetricName = aws.String("CPUCreditBalance")
-	var dimensionFilter cloudwatch.Dimension
-	dimensionFilter.Name = aws.String("InstanceId")
-	dimensionFilter.Value = instance.InstanceId
-	input.Dimensions = []*cloudwatch.Dimension{&dimensionFilter}
-	input.EndTime = aws.Time(time.Now())
-	input.StartTime = aws.Time(time.Now().Add(time.Duration(-10*(period/60)) * time.Minute))
-	input.Period = aws.Int64(period)
-	input.Statistics = []*string{aws.String(stats)}
-	metrics, err := cloudWatchClient.GetMetricStatistics(&input)
+	if len(instances) == 0 {
+		return
+	}
+	series, err := batch.Fetch(cloudWatchClient)
 	if err != nil {
-		return nil, err
+		res.Error("", err)
+		return
 	}
-	if metrics != nil {
-		for _, datapoint := range metrics.Datapoints {
-			return datapoint.Average, nil
-		}
 
-		var minimumTimeDifference float64
-		var timeDifference float64
-		var averageValue *float64
-		minimumTimeDifference = -1
-		for _, datapoint := range metrics.Datapoints {
-			timeDifference = time.Since(*datapoint.Timestamp).Seconds()
-			if minimumTimeDifference == -1 {
-				minimumTimeDifference = timeDifference
-				averageValue = datapoint.Average
-			} else if timeDifference < minimumTimeDifference {
-				minimumTimeDifference = timeDifference
-				averageValue = datapoint.Average
+	for _, instance := range instances {
+		point, err := series[keys[*instance.InstanceId]].Latest()
+		if err != nil {
+			continue
+		}
+		cpuBalance := point.Value
+		tagValue := getMatchingInstanceTag(*instance)
+		if tagValue != nil {
+			if status := thresholds.Status(cpuBalance); status != result.OK {
+				res.Add(status, *instance.InstanceId, "%s is below %s threshold [cpuBalance %v, expected %s]", *tagValue, strings.ToLower(status.String()), cpuBalance, thresholds.Range(status))
+			} else {
+				res.OK(*instance.InstanceId, "%s cpuBalance %v", *tagValue, cpuBalance)
 			}
 		}
-		return averageValue, nil
 	}
-	return nil, nil
+}
+
+func cpuBalanceQuery(instance ec2.Instance) metricdata.Query {
+	return metricdata.Query{
+		Namespace:  "AWS/EC2",
+		MetricName: "CPUCreditBalance",
+		Dimensions: []*cloudwatch.Dimension{metricdata.Dimension("InstanceId", *instance.InstanceId)},
+		Stat:       "Average",
+	}
 }
 
 func getMatchingInstanceTag(instance ec2.Instance) *string {
@@ -143,34 +192,31 @@ func getMatchingInstanceTag(instance ec2.Instance) *string {
 	return nil
 }
 
+// getThresholds alerts when the cpu balance is below the warning and
+// critical thresholds unless ranges are given
+func getThresholds() (threshold.Thresholds, error) {
+	thresholds := threshold.Under(warningThreshold, criticalThreshold)
+	err := thresholds.Override(warningRange, criticalRange)
+	return thresholds, err
+}
+
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, eventOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
-	}
-	ckeckCpu()
-	return nil
+func validate() error {
+	_, err := getThresholds()
+	return err
 }
 
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-ec2-cpu_balance",
-		Short: "The Sensu Go Aws EC2 handler for cpu management",
-		RunE:  run,
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, ckeckCpu)
+	pager.Report(res)
+	if err := eventOptions.Emit(config.Name, res); err != nil {
+		res.Error("", err)
 	}
-
-	cmd.Flags().StringVar(&awsRegion, "aws_region", "us-east-1", "AWS region")
-	cmd.Flags().Float64Var(&criticalThreshold, "critical", 1.2, "Trigger a critical when value is below the criticalThreshold.")
-	cmd.Flags().Float64Var(&warningThreshold, "warning", 2.3, "Trigger a warning when value is below warningThreshold")
-	cmd.Flags().StringVar(&tagValue, "tag", "NAME", "Add instance TAG value to warn/critical message.")
-
-	return cmd
 }
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu/sensu-aws/awstest"
+)
+
+func TestMain(m *testing.M) {
+	awstest.Main(m, main)
+}
+
+func TestPlugin(t *testing.T) {
+	server := awstest.NewServer()
+	defer server.Close()
+
+	instance := func(id, name string) *ec2.Instance {
+		return &ec2.Instance{
+			InstanceId:   aws.String(id),
+			InstanceType: aws.String("t2.micro"),
+			Tags:         []*ec2.Tag{{Key: aws.String("NAME"), Value: aws.String(name)}},
+		}
+	}
+	server.Respond(awstest.EC2, "DescribeInstances", &ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{instance("i-1", "web"), instance("i-2", "worker")}}},
+	})
+	balances := map[string]float64{"i-1": 10, "i-2": 1}
+	server.Handle(awstest.CloudWatch, "GetMetricData", func(req *awstest.Request) (interface{}, error) {
+		output := &cloudwatch.GetMetricDataOutput{}
+		for i := 1; len(req.Params.Get(fmt.Sprintf("MetricDataQueries.member.%d.Id", i))) > 0; i++ {
+			prefix := fmt.Sprintf("MetricDataQueries.member.%d.", i)
+			balance := balances[req.Params.Get(prefix+"MetricStat.Metric.Dimensions.member.1.Value")]
+			output.MetricDataResults = append(output.MetricDataResults, &cloudwatch.MetricDataResult{
+				Id:         aws.String(req.Params.Get(prefix + "Id")),
+				StatusCode: aws.String(cloudwatch.StatusCodeComplete),
+				Timestamps: []*time.Time{aws.Time(time.Now())},
+				Values:     []*float64{aws.Float64(balance)},
+			})
+		}
+		return output, nil
+	})
+
+	output, status := server.Exec(t, "--aws-region", "eu-west-1")
+	if got, want := status, 2; got != want {
+		t.Errorf("bad exit status: got %d, want %d", got, want)
+	}
+	if !strings.Contains(output, "i-2: worker is below critical threshold") {
+		t.Errorf("bad output: got %q", output)
+	}
+	if got, want := server.Calls(awstest.CloudWatch, "GetMetricData"), 1; got != want {
+		t.Errorf("bad GetMetricData call count: got %d, want %d", got, want)
+	}
+	requests := server.Requests()
+	if got, want := requests[0].Params.Get("Filter.1.Value.1"), "running"; got != want {
+		t.Errorf("bad instance state filter: got %q, want %q", got, want)
+	}
+	if got, want := requests[0].Region, "eu-west-1"; got != want {
+		t.Errorf("bad region: got %q, want %q", got, want)
+	}
+}
+
+func TestPluginThrottled(t *testing.T) {
+	throttling := &awstest.Error{StatusCode: 400, Code: "Throttling", Message: "Rate exceeded"}
+	balance := &cloudwatch.GetMetricDataOutput{
+		MetricDataResults: []*cloudwatch.MetricDataResult{{
+			Id:         aws.String("g0_m0"),
+			StatusCode: aws.String(cloudwatch.StatusCodeComplete),
+			Timestamps: []*time.Time{aws.Time(time.Now())},
+			Values:     []*float64{aws.Float64(10)},
+		}},
+	}
+
+	tests := []struct {
+		Name      string
+		Responses []interface{}
+		ExpStatus int
+		ExpOutput string
+		ExpCalls  int
+	}{
+		{
+			Name:      "retried",
+			Responses: []interface{}{throttling, balance},
+			ExpStatus: 0,
+			ExpOutput: "i-1: web cpuBalance 10",
+			ExpCalls:  2,
+		},
+		{
+			Name:      "throttled after the last retry",
+			Responses: []interface{}{throttling},
+			ExpStatus: 3,
+			ExpOutput: "Throttling: throttled by monitoring GetMetricData after 2 retries: Rate exceeded",
+			ExpCalls:  3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			server := awstest.NewServer()
+			defer server.Close()
+			server.Respond(awstest.EC2, "DescribeInstances", &ec2.DescribeInstancesOutput{
+				Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{{
+					InstanceId:   aws.String("i-1"),
+					InstanceType: aws.String("t2.micro"),
+					Tags:         []*ec2.Tag{{Key: aws.String("NAME"), Value: aws.String("web")}},
+				}}}},
+			})
+			server.Sequence(awstest.CloudWatch, "GetMetricData", test.Responses...)
+
+			output, status := server.Exec(t, "--max-retries", "2", "--retry-min-delay", "1", "--rate-limit", "100")
+			if got, want := status, test.ExpStatus; got != want {
+				t.Errorf("bad exit status: got %d, want %d (output %q)", got, want, output)
+			}
+			if !strings.Contains(output, test.ExpOutput) {
+				t.Errorf("bad output: got %q, want %q", output, test.ExpOutput)
+			}
+			if got, want := server.Calls(awstest.CloudWatch, "GetMetricData"), test.ExpCalls; got != want {
+				t.Errorf("bad GetMetricData call count: got %d, want %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+/*
+#
+# check-ec2-events
+#
+# DESCRIPTION:
+#   This plugin checks the scheduled events of EC2 instances, such as
+#   reboots, retirements and maintenance, raising a warning or a critical
+#   when an event starts within the given number of days.
+#
+# OUTPUT:
+#   plain-text
+#
+# PLATFORMS:
+#   MAC OS
+#
+#
+# USAGE:
+#   ./check-ec2-events
+#   ./check-ec2-events --warning=14 --critical=3 --event-codes=instance-retirement,instance-stop
+#   ./check-ec2-events --tags="Environment=production" --tag=Name
+# NOTES:
+#   Completed and canceled events are ignored. Events already started are
+#   critical, events without an announced start are a warning.
+#
+# LICENSE:
+#   TODO
+#
+*/
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/regions"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/utils"
+)
+
+var (
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	pager          utils.Pager
+	warningDays    int
+	criticalDays   int
+	eventCodes     []string
+	tags           string
+	tagValue       string
+
+	config = plugin.NewConfig("check-ec2-events", "The Sensu Go Aws EC2 handler for scheduled events")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "warning",
+			Env:      "WARNING",
+			Argument: "warning",
+			Default:  7,
+			Usage:    "Trigger a warning when an event starts within this many days",
+			Value:    &warningDays,
+		},
+		{
+			Path:     "critical",
+			Env:      "CRITICAL",
+			Argument: "critical",
+			Default:  2,
+			Usage:    "Trigger a critical when an event starts within this many days",
+			Value:    &criticalDays,
+		},
+		{
+			Path:     "event-codes",
+			Env:      "EVENT_CODES",
+			Argument: "event-codes",
+			Default:  []string{},
+			Usage:    "Comma delimited list of event codes to check, e.g. instance-retirement,system-reboot, all when empty",
+			Value:    &eventCodes,
+		},
+		{
+			Path:     "tags",
+			Env:      "TAGS",
+			Argument: "tags",
+			Default:  "",
+			Usage:    "Only check instances with all of these tags, comma delimited list of Key=Value",
+			Value:    &tags,
+		},
+		{
+			Path:     "tag",
+			Env:      "TAG",
+			Argument: "tag",
+			Default:  "Name",
+			Usage:    "Add instance TAG value to warn/critical message.",
+			Value:    &tagValue,
+		},
+	}
+)
+
+// EC2Client represents the EC2 dependencies of the check
+type EC2Client interface {
+	DescribeInstancesPages(*ec2.DescribeInstancesInput, func(*ec2.DescribeInstancesOutput, bool) bool) error
+	DescribeInstanceStatusPages(*ec2.DescribeInstanceStatusInput, func(*ec2.DescribeInstanceStatusOutput, bool) bool) error
+}
+
+// tagFilters returns the tag:Key filters of a comma delimited list of
+// Key=Value
+func tagFilters(spec string) ([]*ec2.Filter, error) {
+	var filters []*ec2.Filter
+	for _, pair := range strings.Split(spec, ",") {
+		if len(strings.TrimSpace(pair)) == 0 {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || len(key) == 0 {
+			return nil, fmt.Errorf("invalid tag %q, expected Key=Value", pair)
+		}
+		filters = append(filters, &ec2.Filter{Name: aws.String("tag:" + key), Values: aws.StringSlice([]string{strings.TrimSpace(parts[1])})})
+	}
+	return filters, nil
+}
+
+// instanceLabels returns the --tag value of every instance with the --tags
+func instanceLabels(client EC2Client) (map[string]string, error) {
+	filters, _ := tagFilters(tags)
+	reservations, err := pager.Reservations(client, &ec2.DescribeInstancesInput{Filters: filters})
+	if err != nil {
+		return nil, err
+	}
+	labels := make(map[string]string)
+	for _, reservation := range reservations {
+		for _, instance := range reservation.Instances {
+			label := ""
+			for _, tag := range instance.Tags {
+				if aws.StringValue(tag.Key) == tagValue {
+					label = aws.StringValue(tag.Value)
+				}
+			}
+			labels[aws.StringValue(instance.InstanceId)] = label
+		}
+	}
+	return labels, nil
+}
+
+// done returns whether event is completed or canceled, which EC2 tells by a
+// prefix of the description
+func done(event *ec2.InstanceStatusEvent) bool {
+	description := aws.StringValue(event.Description)
+	return strings.HasPrefix(description, "[Completed]") || strings.HasPrefix(description, "[Canceled]")
+}
+
+func checkEvents(factory *awsclient.Factory, res *result.Result) {
+	ec2Client, err := factory.EC2()
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+
+	// the event.code filter selects the instances with a matching event, the
+	// other events of those instances are skipped below
+	input := &ec2.DescribeInstanceStatusInput{IncludeAllInstances: aws.Bool(true)}
+	codes := make(map[string]bool)
+	if len(eventCodes) > 0 {
+		input.Filters = []*ec2.Filter{{Name: aws.String("event.code"), Values: aws.StringSlice(eventCodes)}}
+		for _, code := range eventCodes {
+			codes[code] = true
+		}
+	}
+	statuses, err := pager.InstanceStatuses(ec2Client, input)
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	labels, err := instanceLabels(ec2Client)
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+
+	now := time.Now()
+	scheduled := 0
+	for _, status := range statuses {
+		id := aws.StringValue(status.InstanceId)
+		label, ok := labels[id]
+		if !ok {
+			continue
+		}
+		resource := id
+		if len(label) > 0 {
+			resource = fmt.Sprintf("%s (%s)", id, label)
+		}
+		for _, event := range status.Events {
+			if done(event) || (len(codes) > 0 && !codes[aws.StringValue(event.Code)]) {
+				continue
+			}
+			scheduled++
+			if event.NotBefore == nil {
+				res.Warning(resource, "%s scheduled, start not announced yet", aws.StringValue(event.Code))
+				continue
+			}
+			start := aws.TimeValue(event.NotBefore)
+			days := start.Sub(now).Hours() / 24
+			message := fmt.Sprintf("%s scheduled for %s", aws.StringValue(event.Code), start.UTC().Format(time.RFC3339))
+			if days < 0 {
+				message = fmt.Sprintf("%s started %s", aws.StringValue(event.Code), start.UTC().Format(time.RFC3339))
+			} else {
+				message += fmt.Sprintf(", in %.1f days", days)
+			}
+			if description := aws.StringValue(event.Description); len(description) > 0 {
+				message += ": " + description
+			}
+			switch {
+			case days <= float64(criticalDays):
+				res.Critical(resource, "%s", message)
+			case days <= float64(warningDays):
+				res.Warning(resource, "%s", message)
+			}
+		}
+	}
+	res.SetOKMessage("%d scheduled events, none within %d days", scheduled, warningDays)
+}
+
+func main() {
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
+}
+
+func validate() error {
+	if criticalDays > warningDays {
+		return errors.New("--critical must not be greater than --warning")
+	}
+	_, err := tagFilters(tags)
+	return err
+}
+
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkEvents)
+	pager.Report(res)
+}
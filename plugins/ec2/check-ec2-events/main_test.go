@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu/sensu-aws/awstest"
+)
+
+func TestMain(m *testing.M) {
+	awstest.Main(m, main)
+}
+
+func TestPlugin(t *testing.T) {
+	server := awstest.NewServer()
+	defer server.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	event := func(code string, in time.Duration, description string) *ec2.InstanceStatusEvent {
+		return &ec2.InstanceStatusEvent{
+			Code:        aws.String(code),
+			NotBefore:   aws.Time(now.Add(in)),
+			Description: aws.String(description),
+		}
+	}
+	server.Respond(awstest.EC2, "DescribeInstanceStatus", &ec2.DescribeInstanceStatusOutput{
+		InstanceStatuses: []*ec2.InstanceStatus{
+			{InstanceId: aws.String("i-1"), Events: []*ec2.InstanceStatusEvent{event("instance-retirement", 36*time.Hour, "The instance is running on degraded hardware")}},
+			{InstanceId: aws.String("i-2"), Events: []*ec2.InstanceStatusEvent{event("system-reboot", 5*24*time.Hour, "")}},
+			{InstanceId: aws.String("i-3"), Events: []*ec2.InstanceStatusEvent{
+				event("system-maintenance", 12*time.Hour, "[Completed] Scheduled maintenance"),
+				event("instance-reboot", 20*24*time.Hour, ""),
+				{Code: aws.String("instance-stop")},
+			}},
+		},
+	})
+	// instances matching --tags, i-2 is not tagged for production
+	server.Handle(awstest.EC2, "DescribeInstances", func(req *awstest.Request) (interface{}, error) {
+		instance := func(id string, name string) *ec2.Instance {
+			return &ec2.Instance{InstanceId: aws.String(id), Tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String(name)}}}
+		}
+		instances := []*ec2.Instance{instance("i-1", "web"), instance("i-3", "db")}
+		if req.Params.Get("Filter.1.Name") != "tag:Environment" {
+			instances = append(instances, instance("i-2", "worker"))
+		}
+		return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{Instances: instances}}}, nil
+	})
+
+	tests := []struct {
+		Name      string
+		Args      []string
+		ExpStatus int
+		ExpOutput string
+	}{
+		{
+			Name:      "scheduled",
+			Args:      []string{},
+			ExpStatus: 2,
+			ExpOutput: "check-ec2-events CRITICAL: i-1 (web): instance-retirement scheduled for " + now.Add(36*time.Hour).Format(time.RFC3339) + ", in 1.5 days: The instance is running on degraded hardware\n" +
+				"CRITICAL i-1 (web): instance-retirement scheduled for " + now.Add(36*time.Hour).Format(time.RFC3339) + ", in 1.5 days: The instance is running on degraded hardware\n" +
+				"WARNING i-2 (worker): system-reboot scheduled for " + now.Add(5*24*time.Hour).Format(time.RFC3339) + ", in 5.0 days\n" +
+				"WARNING i-3 (db): instance-stop scheduled, start not announced yet\n",
+		},
+		{
+			Name:      "tags",
+			Args:      []string{"--tags", "Environment=production", "--critical", "1"},
+			ExpStatus: 1,
+			ExpOutput: "check-ec2-events WARNING: 2 warning\n" +
+				"WARNING i-1 (web): instance-retirement scheduled for ",
+		},
+		{
+			Name:      "far away",
+			Args:      []string{"--warning", "1", "--critical", "0", "--event-codes", "instance-retirement,system-reboot,instance-reboot"},
+			ExpStatus: 0,
+			ExpOutput: "check-ec2-events OK: 3 scheduled events, none within 1 days",
+		},
+		{
+			Name:      "event codes",
+			Args:      []string{"--event-codes", "instance-reboot"},
+			ExpStatus: 0,
+			ExpOutput: "check-ec2-events OK: 1 scheduled events, none within 7 days",
+		},
+		{
+			Name:      "start not announced",
+			Args:      []string{"--event-codes", "instance-stop"},
+			ExpStatus: 1,
+			ExpOutput: "check-ec2-events WARNING: i-3 (db): instance-stop scheduled, start not announced yet\n",
+		},
+		{
+			Name:      "invalid thresholds",
+			Args:      []string{"--warning", "1", "--critical", "2"},
+			ExpStatus: 3,
+		},
+		{
+			Name:      "invalid tags",
+			Args:      []string{"--tags", "Environment"},
+			ExpStatus: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			output, status := server.Exec(t, test.Args...)
+			if got, want := status, test.ExpStatus; got != want {
+				t.Errorf("bad exit status: got %d, want %d (output %q)", got, want, output)
+			}
+			if !strings.HasPrefix(output, test.ExpOutput) {
+				t.Errorf("bad output: got %q, want prefix %q", output, test.ExpOutput)
+			}
+		})
+	}
+}
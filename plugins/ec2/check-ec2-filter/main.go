@@ -18,8 +18,15 @@ package main
 #
 # USAGE:
 #   ./check-ec2-filter --filters="{\"filters\" : [{\"name\" : \"instance-state-name\", \"values\": [\"running\"]}]}"
-#   ./check-ec2-filter --exclude_tags="{\"TAG_NAME\" : \"TAG_VALUE\"}" --compare=not
+#   ./check-ec2-filter --exclude-tags="{\"TAG_NAME\" : \"TAG_VALUE\"}" --compare=not
+#   ./check-ec2-filter --exclude-tags="{\"Environment\" : [\"dev*\", \"/^test-[0-9]+$/\"]}" --min-running-secs=600
+#   ./check-ec2-filter --filters="{\"filters\" : [{\"name\" : \"instance-state-name\", \"values\": [\"stopped\"]}]}" --min-stopped-secs=604800 --compare=greater --warning=0 --critical=5
 # NOTES:
+#   Each excluded tag has a value or a list of values, * and ? in a value are
+#   wildcards and a value between slashes is a regular expression.
+#   --min-running-secs and --max-running-secs select running instances by the
+#   time since their LaunchTime, --min-stopped-secs selects stopped instances
+#   by the time in their state transition reason.
 #
 # LICENSE:
 #   Justin McCarty
@@ -32,146 +39,311 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"os"
+	"regexp"
+	"strings"
 	"time"
 
-	"github.com/spf13/cobra"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/regions"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
 	"github.com/sensu/sensu-aws/models"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/threshold"
 	"github.com/sensu/sensu-aws/utils"
 )
 
 var (
-	ec2Client               *ec2.EC2
+	sessionOptions          aws_session.Options
+	regionOptions           regions.Options
+	pager                   utils.Pager
 	criticalThreshold       int
 	warningThreshold        int
 	excludeTags             string
 	compareValue            string
+	warningRange            string
+	criticalRange           string
 	detailedMessageRequired bool
 	minRunningSecs          float64
+	maxRunningSecs          float64
+	minStoppedSecs          float64
 	filters                 string
-	awsRegion               string
+
+	config = plugin.NewConfig("check-ec2-filter", "The Sensu Go Aws EC2 handler for instance filter management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "critical",
+			Env:      "CRITICAL",
+			Argument: "critical",
+			Default:  1,
+			Usage:    "Critical threshold for filter",
+			Value:    &criticalThreshold,
+		},
+		{
+			Path:     "warning",
+			Env:      "WARNING",
+			Argument: "warning",
+			Default:  2,
+			Usage:    "Warning threshold for filter',	",
+			Value:    &warningThreshold,
+		},
+		{
+			Path:     "exclude-tags",
+			Env:      "EXCLUDE_TAGS",
+			Argument: "exclude-tags",
+			Default:  "{}",
+			Usage:    "JSON String Representation of tag values, each a value or a list of values with * and ? wildcards or /regex/",
+			Value:    &excludeTags,
+		},
+		{
+			Path:     "compare",
+			Env:      "COMPARE",
+			Argument: "compare",
+			Default:  "equal",
+			Usage:    "Comparision operator for threshold: equal, not, greater, greater_equal, less, less_equal",
+			Value:    &compareValue,
+		},
+		{
+			Path:     "warning-range",
+			Env:      "WARNING_RANGE",
+			Argument: "warning-range",
+			Default:  "",
+			Usage:    "Warning threshold range (e.g. 10:, ~:20, @5:10), overrides warning and compare",
+			Value:    &warningRange,
+		},
+		{
+			Path:     "critical-range",
+			Env:      "CRITICAL_RANGE",
+			Argument: "critical-range",
+			Default:  "",
+			Usage:    "Critical threshold range (e.g. 10:, ~:20, @5:10), overrides critical and compare",
+			Value:    &criticalRange,
+		},
+		{
+			Path:     "detailed-message",
+			Env:      "DETAILED_MESSAGE",
+			Argument: "detailed-message",
+			Default:  false,
+			Usage:    "Detailed description is required or not",
+			Value:    &detailedMessageRequired,
+		},
+		{
+			Path:     "min-running-secs",
+			Env:      "MIN_RUNNING_SECS",
+			Argument: "min-running-secs",
+			Default:  float64(0),
+			Usage:    "Only count running instances launched at least this many seconds ago",
+			Value:    &minRunningSecs,
+		},
+		{
+			Path:     "max-running-secs",
+			Env:      "MAX_RUNNING_SECS",
+			Argument: "max-running-secs",
+			Default:  float64(0),
+			Usage:    "Only count running instances launched at most this many seconds ago, 0 to disable",
+			Value:    &maxRunningSecs,
+		},
+		{
+			Path:     "min-stopped-secs",
+			Env:      "MIN_STOPPED_SECS",
+			Argument: "min-stopped-secs",
+			Default:  float64(0),
+			Usage:    "Only count stopped instances stopped at least this many seconds ago, 0 to disable",
+			Value:    &minStoppedSecs,
+		},
+		{
+			Path:     "filters",
+			Env:      "FILTERS",
+			Argument: "filters",
+			Default:  "{\"filters\" : [{\"name\" : \"instance-state-name\", \"values\": [\"running\"]}]}",
+			Usage:    "JSON String representation of Filters",
+			Value:    &filters,
+		},
+	}
 )
 
-func checkFilter() {
-	var excludedTags map[string]*string
+// EC2Client represents the EC2 dependencies of the check
+type EC2Client interface {
+	DescribeInstancesPages(*ec2.DescribeInstancesInput, func(*ec2.DescribeInstancesOutput, bool) bool) error
+}
+
+// tagPatterns holds the excluded values of each tag
+type tagPatterns map[string][]*regexp.Regexp
+
+// parseExcludeTags parses a JSON object of tag values, each a value or a list
+// of values
+func parseExcludeTags(spec string) (tagPatterns, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(spec), &raw); err != nil {
+		return nil, err
+	}
+	patterns := make(tagPatterns)
+	for key, value := range raw {
+		var values []interface{}
+		switch v := value.(type) {
+		case []interface{}:
+			values = v
+		default:
+			values = []interface{}{v}
+		}
+		for _, v := range values {
+			text, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("value of tag %q must be a string or a list of strings", key)
+			}
+			pattern, err := tagPattern(text)
+			if err != nil {
+				return nil, fmt.Errorf("value of tag %q: %v", key, err)
+			}
+			patterns[key] = append(patterns[key], pattern)
+		}
+	}
+	return patterns, nil
+}
+
+// tagPattern compiles a tag value, a value between slashes is a regular
+// expression and * and ? are wildcards in any other value
+func tagPattern(value string) (*regexp.Regexp, error) {
+	if len(value) >= 2 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/") {
+		return regexp.Compile(value[1 : len(value)-1])
+	}
+	quoted := regexp.QuoteMeta(value)
+	quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+	quoted = strings.ReplaceAll(quoted, `\?`, ".")
+	return regexp.Compile("^" + quoted + "$")
+}
+
+// excludes returns whether one of tags has an excluded value
+func (p tagPatterns) excludes(tags []*ec2.Tag) bool {
+	for _, tag := range tags {
+		for _, pattern := range p[aws.StringValue(tag.Key)] {
+			if pattern.MatchString(aws.StringValue(tag.Value)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stoppedAt matches the time EC2 appends to the state transition reason, e.g.
+// "User initiated (2016-05-09 19:27:48 GMT)"
+var stoppedAt = regexp.MustCompile(`\((\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}) GMT\)`)
+
+// stoppedSince returns when instance was stopped, if its state transition
+// reason tells
+func stoppedSince(instance *ec2.Instance) (time.Time, bool) {
+	match := stoppedAt.FindStringSubmatch(aws.StringValue(instance.StateTransitionReason))
+	if match == nil {
+		return time.Time{}, false
+	}
+	since, err := time.Parse("2006-01-02 15:04:05", match[1])
+	return since, err == nil
+}
+
+// timeSelected returns whether instance passes the running and stopped time
+// filters at now
+func timeSelected(instance *ec2.Instance, now time.Time) bool {
+	state := ""
+	if instance.State != nil {
+		state = aws.StringValue(instance.State.Name)
+	}
+	if state == ec2.InstanceStateNameRunning {
+		running := now.Sub(aws.TimeValue(instance.LaunchTime)).Seconds()
+		if running < minRunningSecs || (maxRunningSecs > 0 && running > maxRunningSecs) {
+			return false
+		}
+	}
+	if minStoppedSecs > 0 {
+		since, ok := stoppedSince(instance)
+		if state != ec2.InstanceStateNameStopped || !ok || now.Sub(since).Seconds() < minStoppedSecs {
+			return false
+		}
+	}
+	return true
+}
+
+func checkFilter(factory *awsclient.Factory, res *result.Result) {
 	var ec2Fileters models.Filters
 	var awsInstances []models.AwsInstance
-	var success bool
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
-	success, ec2Client = awsclient.GetEC2Client(awsSession)
-	if !success {
+	ec2Client, err := factory.EC2()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
-	err := json.Unmarshal([]byte(excludeTags), &excludedTags)
+	excludedTags, err := parseExcludeTags(excludeTags)
 	if err != nil {
-		fmt.Println("Failed to unmarshal exclude tags details , ", err)
+		res.Unknown("", "failed to unmarshal exclude tags details: %v", err)
+		return
 	}
 
 	err = json.Unmarshal([]byte(filters), &ec2Fileters)
 	if err != nil {
-		fmt.Println("Failed to unmarshal filter data , ", err)
+		res.Unknown("", "failed to unmarshal filter data: %v", err)
+		return
 	}
 
-	reservations, err := utils.GetReservations(ec2Client, ec2Fileters.Filters)
+	reservations, err := pager.Reservations(ec2Client, &ec2.DescribeInstancesInput{Filters: ec2Fileters.Filters})
 	if err != nil {
-		fmt.Println(err.Error())
+		res.Error("", err)
 		return
 	}
+	now := time.Now()
 	for _, reservation := range reservations {
 		for _, instance := range reservation.Instances {
-			tags := instance.Tags
-			excludeIntance := false
-			for _, tag := range tags {
-				if excludedTags[*tag.Key] != nil && *excludedTags[*tag.Key] == *tag.Value {
-					excludeIntance = true
-				}
-			}
-			if !excludeIntance {
-				timeDifference := time.Since(time.Now().Add(time.Duration(-10) * time.Minute)).Seconds()
-				if !(timeDifference < minRunningSecs) {
-					awsInstance := models.AwsInstance{Id: *instance.InstanceId, LaunchTime: *instance.LaunchTime, Tags: instance.Tags}
-					awsInstances = append(awsInstances, awsInstance)
-				}
+			if !excludedTags.excludes(instance.Tags) && timeSelected(instance, now) {
+				awsInstance := models.AwsInstance{Id: *instance.InstanceId, LaunchTime: aws.TimeValue(instance.LaunchTime), Tags: instance.Tags}
+				awsInstances = append(awsInstances, awsInstance)
 			}
 		}
 	}
 
 	selectedInstancesCount := len(awsInstances)
 	var buffer bytes.Buffer
-	buffer.WriteString(fmt.Sprintf("Current Count : %d  ", selectedInstancesCount))
+	buffer.WriteString(fmt.Sprintf("Current Count : %d", selectedInstancesCount))
 	if detailedMessageRequired && selectedInstancesCount > 0 {
 		for _, awsInstance := range awsInstances {
 			buffer.WriteString(fmt.Sprintf(", %s", awsInstance.Id))
 		}
 	}
 
-	if compareValue == "equal" {
-		if selectedInstancesCount == criticalThreshold {
-			fmt.Println("Critical threshold for filter , ", buffer.String())
-		}
-		if selectedInstancesCount == warningThreshold {
-			fmt.Println("Warning threshold for filter , ", buffer.String())
-		}
-	} else if compareValue == "not" {
-		if selectedInstancesCount != criticalThreshold {
-			fmt.Println("Critical threshold for filter , ", buffer.String())
-		}
-		if selectedInstancesCount != warningThreshold {
-			fmt.Println("Warning threshold for filter , ", buffer.String())
-		}
-	} else if compareValue == "greater" {
-		if selectedInstancesCount > criticalThreshold {
-			fmt.Println("Critical threshold for filter , ", buffer.String())
-		}
-		if selectedInstancesCount > warningThreshold {
-			fmt.Println("Warning threshold for filter , ", buffer.String())
-		}
-	} else if compareValue == "less" {
-		if selectedInstancesCount < criticalThreshold {
-			fmt.Println("Critical threshold for filter , ", buffer.String())
-		}
-		if selectedInstancesCount < warningThreshold {
-			fmt.Println("Warning threshold for filter , ", buffer.String())
-		}
+	thresholds, err := threshold.FromOperator(compareValue, float64(warningThreshold), float64(criticalThreshold))
+	if err == nil {
+		err = thresholds.Override(warningRange, criticalRange)
+	}
+	if err != nil {
+		res.Error("", err)
+		return
 	}
+	status := thresholds.Status(float64(selectedInstancesCount))
+	if status != result.OK {
+		res.Add(status, "", "%s threshold %s for filter, %s", strings.ToLower(status.String()), thresholds.Range(status), buffer.String())
+	}
+	res.SetOKMessage("%s", buffer.String())
 }
 
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
+func validate() error {
+	if _, err := parseExcludeTags(excludeTags); err != nil {
+		return fmt.Errorf("--exclude-tags: %v", err)
+	}
+	if maxRunningSecs > 0 && maxRunningSecs < minRunningSecs {
+		return fmt.Errorf("--max-running-secs %v is less than --min-running-secs %v", maxRunningSecs, minRunningSecs)
 	}
-	checkFilter()
 	return nil
 }
 
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-ec2-filter",
-		Short: "The Sensu Go Aws EC2 handler for instance filter management",
-		RunE:  run,
-	}
-
-	cmd.Flags().StringVar(&awsRegion, "aws_region", "us-east-1", "AWS Region")
-	cmd.Flags().IntVar(&criticalThreshold, "critical", 1, "Critical threshold for filter")
-	cmd.Flags().IntVar(&warningThreshold, "warning", 2, "Warning threshold for filter',	")
-	cmd.Flags().StringVar(&excludeTags, "exclude_tags", "{}", "JSON String Representation of tag values")
-	cmd.Flags().StringVar(&compareValue, "compare", "equal", "Comparision operator for threshold: equal, not, greater, less")
-	cmd.Flags().BoolVar(&detailedMessageRequired, "detailed_message", false, "Detailed description is required or not")
-	cmd.Flags().Float64Var(&minRunningSecs, "min_running_secs", 0, "Minimum running seconds")
-	cmd.Flags().StringVar(&filters, "filters", "{\"filters\" : [{\"name\" : \"instance-state-name\", \"values\": [\"running\"]}]}", "JSON String representation of Filters")
-
-	return cmd
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkFilter)
+	pager.Report(res)
 }
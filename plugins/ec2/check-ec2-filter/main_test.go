@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu/sensu-aws/awstest"
+)
+
+func TestMain(m *testing.M) {
+	awstest.Main(m, main)
+}
+
+func TestPlugin(t *testing.T) {
+	server := awstest.NewServer()
+	defer server.Close()
+
+	now := time.Now().UTC()
+	running := func(id string, launched time.Duration, environment string) *ec2.Instance {
+		return &ec2.Instance{
+			InstanceId: aws.String(id),
+			LaunchTime: aws.Time(now.Add(-launched)),
+			State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			Tags:       []*ec2.Tag{{Key: aws.String("Environment"), Value: aws.String(environment)}},
+		}
+	}
+	stopped := func(id string, stopped time.Duration) *ec2.Instance {
+		return &ec2.Instance{
+			InstanceId:            aws.String(id),
+			LaunchTime:            aws.Time(now.Add(-30 * 24 * time.Hour)),
+			State:                 &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameStopped)},
+			StateTransitionReason: aws.String("User initiated (" + now.Add(-stopped).Format("2006-01-02 15:04:05") + " GMT)"),
+		}
+	}
+	server.Respond(awstest.EC2, "DescribeInstances", &ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{
+			running("i-new", 5*time.Minute, "production"),
+			running("i-old", 48*time.Hour, "production"),
+			running("i-dev", 24*time.Hour, "dev-3"),
+			running("i-test", 24*time.Hour, "test-12"),
+			stopped("i-stopped", 10*24*time.Hour),
+			stopped("i-paused", time.Hour),
+		}}},
+	})
+
+	ok := []string{"--compare", "greater", "--warning", "100", "--critical", "100", "--detailed-message"}
+
+	tests := []struct {
+		Name      string
+		Args      []string
+		ExpStatus int
+		ExpOutput string
+	}{
+		{
+			Name:      "every instance",
+			Args:      ok,
+			ExpOutput: "check-ec2-filter OK: Current Count : 6, i-new, i-old, i-dev, i-test, i-stopped, i-paused\n",
+		},
+		{
+			Name:      "min running",
+			Args:      append(ok, "--min-running-secs", "600"),
+			ExpOutput: "check-ec2-filter OK: Current Count : 5, i-old, i-dev, i-test, i-stopped, i-paused\n",
+		},
+		{
+			Name:      "max running",
+			Args:      append(ok, "--max-running-secs", "3600", "--exclude-tags", `{"Environment": ["dev*", "/^test-[0-9]+$/"]}`),
+			ExpOutput: "check-ec2-filter OK: Current Count : 3, i-new, i-stopped, i-paused\n",
+		},
+		{
+			Name:      "exclude tags",
+			Args:      append(ok, "--exclude-tags", `{"Environment": "prod?ction"}`),
+			ExpOutput: "check-ec2-filter OK: Current Count : 4, i-dev, i-test, i-stopped, i-paused\n",
+		},
+		{
+			Name:      "stopped for a week",
+			Args:      []string{"--min-stopped-secs", "604800", "--compare", "greater", "--warning", "0", "--critical", "5"},
+			ExpStatus: 1,
+			ExpOutput: "check-ec2-filter WARNING: warning threshold ~:0 for filter, Current Count : 1\n",
+		},
+		{
+			Name:      "invalid exclude tags",
+			Args:      []string{"--exclude-tags", `{"Environment": 1}`},
+			ExpStatus: 3,
+		},
+		{
+			Name:      "invalid exclude tags regex",
+			Args:      []string{"--exclude-tags", `{"Environment": "/(/"}`},
+			ExpStatus: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			output, status := server.Exec(t, test.Args...)
+			if got, want := status, test.ExpStatus; got != want {
+				t.Errorf("bad exit status: got %d, want %d (output %q)", got, want, output)
+			}
+			if !strings.HasPrefix(output, test.ExpOutput) {
+				t.Errorf("bad output: got %q, want prefix %q", output, test.ExpOutput)
+			}
+		})
+	}
+}
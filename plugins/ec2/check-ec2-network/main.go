@@ -13,7 +13,8 @@ package main
 #   MAC OS
 #
 # USAGE:
-#   ./check-ec2-network --instance_id=i-0f1626fsbfvbafa2 --direction=NetworkOut
+#   ./check-ec2-network --instance-id=i-0f1626fsbfvbafa2 --direction=NetworkOut
+#   ./check-ec2-network --instance-id=i-0f1626fsbfvbafa2 --warning-range=1000:1500000 --critical-range=~:2000000
 #
 # NOTES:
 #
@@ -23,142 +24,180 @@ package main
 */
 
 import (
-	"fmt"
-	"os"
+	"errors"
 	"time"
 
-	"github.com/spf13/cobra"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/metricdata"
+	"github.com/sensu/sensu-aws/regions"
 
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
-	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/threshold"
 )
 
 var (
-	ec2Client         *ec2.EC2
-	cloudWatchClient  *cloudwatch.CloudWatch
+	sessionOptions    aws_session.Options
+	regionOptions     regions.Options
 	criticalThreshold float64
 	warningThreshold  float64
+	warningRange      string
+	criticalRange     string
 	instanceId        string
 	endTime           string
 	period            int64
 	direction         string
-	awsRegion         string
+
+	config = plugin.NewConfig("check-ec2-network", "The Sensu Go Aws EC2 handler for network management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "critical",
+			Env:      "CRITICAL",
+			Argument: "critical",
+			Default:  float64(1000000),
+			Usage:    "Trigger a critical if network traffice is over specified Bytes",
+			Value:    &criticalThreshold,
+		},
+		{
+			Path:     "warning",
+			Env:      "WARNING",
+			Argument: "warning",
+			Default:  float64(1500000),
+			Usage:    "Trigger a warning if network traffice is over specified Bytes",
+			Value:    &warningThreshold,
+		},
+		{
+			Path:     "warning-range",
+			Env:      "WARNING_RANGE",
+			Argument: "warning-range",
+			Default:  "",
+			Usage:    "Warning threshold range of the network traffic in Bytes (e.g. 10:, ~:20, @5:10), overrides warning",
+			Value:    &warningRange,
+		},
+		{
+			Path:     "critical-range",
+			Env:      "CRITICAL_RANGE",
+			Argument: "critical-range",
+			Default:  "",
+			Usage:    "Critical threshold range of the network traffic in Bytes (e.g. 10:, ~:20, @5:10), overrides critical",
+			Value:    &criticalRange,
+		},
+		{
+			Path:     "instance-id",
+			Env:      "INSTANCE_ID",
+			Argument: "instance-id",
+			Default:  "",
+			Usage:    "EC2 Instance ID to check.",
+			Value:    &instanceId,
+		},
+		{
+			Path:     "end-time",
+			Env:      "END_TIME",
+			Argument: "end-time",
+			Default:  time.Now().Format(time.RFC3339),
+			Usage:    "CloudWatch metric statistics end time, e.g. 2014-11-12T11:45:26.371Z",
+			Value:    &endTime,
+		},
+		{
+			Path:     "period",
+			Env:      "PERIOD",
+			Argument: "period",
+			Default:  int64(60),
+			Usage:    "CloudWatch metric statistics period in seconds",
+			Value:    &period,
+		},
+		{
+			Path:     "direction",
+			Env:      "DIRECTION",
+			Argument: "direction",
+			Default:  "NetworkIn",
+			Usage:    "Select NetworkIn or NetworkOut",
+			Value:    &direction,
+		},
+	}
 )
 
-func checkNetwork() {
-	var success bool
+// CloudWatchClient represents the CloudWatch dependencies of the check
+type CloudWatchClient interface {
+	GetMetricDataPages(*cloudwatch.GetMetricDataInput, func(*cloudwatch.GetMetricDataOutput, bool) bool) error
+}
+
+func checkNetwork(factory *awsclient.Factory, res *result.Result) {
 
 	if !(direction == "NetworkIn" || direction == "NetworkOut") {
-		fmt.Println("Invalid direction")
+		res.Unknown("", "invalid direction %q", direction)
 		return
 	}
 
 	endTimeDate, err := time.Parse(time.RFC3339, endTime)
 
 	if err != nil {
-		fmt.Println("Invalid end time entered , ", err)
+		res.Unknown("", "invalid end time entered: %v", err)
+		return
+	}
+	thresholds, err := getThresholds()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
 
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
-	success, ec2Client = awsclient.GetEC2Client(awsSession)
-	if !success {
+	cloudWatchClient, err := factory.CloudWatch()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
-	success, cloudWatchClient = awsclient.GetCloudWatchClient(awsSession)
-	if !success {
+	batch := &metricdata.Batch{Start: endTimeDate.Add(-5 * time.Minute), End: endTimeDate, Period: period}
+	key := batch.Add(metricdata.Query{
+		Namespace:  "AWS/EC2",
+		MetricName: direction,
+		Dimensions: []*cloudwatch.Dimension{metricdata.Dimension("InstanceId", instanceId)},
+		Stat:       "Average",
+		Unit:       "Bytes",
+	})[0]
+	series, err := batch.Fetch(cloudWatchClient)
+	if err != nil {
+		res.Error(instanceId, err)
 		return
 	}
-	networkValue, err := getEc2NetworkMetric(endTimeDate)
+	point, err := series[key].Latest()
 	if err != nil {
-		fmt.Println(err.Error())
+		res.Unknown(instanceId, "%v", err)
 		return
 	}
-	if networkValue != nil {
-		if *networkValue > criticalThreshold {
-			fmt.Println("CRITICAL:", direction, "at", *networkValue, "bytes")
-		} else if *networkValue > warningThreshold {
-			fmt.Println("WARNINg:", direction, " at ", *networkValue, "bytes")
-		} else {
-			fmt.Println("OK:", direction, "at", *networkValue, "bytes")
-		}
+	networkValue := point.Value
+	if status := thresholds.Status(networkValue); status != result.OK {
+		res.Add(status, instanceId, "%s at %v bytes, expected %s", direction, networkValue, thresholds.Range(status))
+	} else {
+		res.OK(instanceId, "%s at %v bytes", direction, networkValue)
 	}
-
 }
 
-func getEc2NetworkMetric(endTimeDate time.Time) (*float64, error) {
-	stats := "Average"
-	var input cloudwatch.GetMetricStatisticsInput
-	input.Namespace = aws.String("AWS/EC2")
-	input.MetricName = aws.String(direction)
-	var dimensionFilter cloudwatch.Dimension
-	dimensionFilter.Name = aws.String("InstanceId")
-	dimensionFilter.Value = aws.String(instanceId)
-	input.Dimensions = []*cloudwatch.Dimension{&dimensionFilter}
-	input.EndTime = aws.Time(endTimeDate)
-	input.StartTime = aws.Time(endTimeDate.Add(time.Duration(-5) * time.Minute))
-	input.Period = aws.Int64(period)
-	input.Statistics = []*string{aws.String(stats)}
-	input.Unit = aws.String("Bytes")
-	metrics, err := cloudWatchClient.GetMetricStatistics(&input)
-	if err != nil {
-		return nil, err
-	}
-	if metrics != nil && metrics.Datapoints != nil && len(metrics.Datapoints) > 1 {
-		var minimumTimeDifference float64
-		var timeDifference float64
-		var averageValue *float64
-		minimumTimeDifference = -1
-		for _, datapoint := range metrics.Datapoints {
-			timeDifference = time.Since(*datapoint.Timestamp).Seconds()
-			if minimumTimeDifference == -1 {
-				minimumTimeDifference = timeDifference
-				averageValue = datapoint.Average
-			} else if timeDifference < minimumTimeDifference {
-				minimumTimeDifference = timeDifference
-				averageValue = datapoint.Average
-			}
-		}
-		return averageValue, nil
-	}
-	return nil, nil
+// getThresholds alerts when the network traffic is over the warning and
+// critical thresholds unless ranges are given
+func getThresholds() (threshold.Thresholds, error) {
+	thresholds := threshold.Over(warningThreshold, criticalThreshold)
+	err := thresholds.Override(warningRange, criticalRange)
+	return thresholds, err
 }
 
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
+func validate() error {
+	if len(instanceId) == 0 {
+		return errors.New("--instance-id is required")
 	}
-	checkNetwork()
-	return nil
+	_, err := getThresholds()
+	return err
 }
 
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-ec2-netwrok",
-		Short: "The Sensu Go Aws EC2 handler for netwrok management",
-		RunE:  run,
-	}
-
-	cmd.Flags().StringVar(&awsRegion, "aws_region", "us-east-1", "AWS Region")
-	cmd.Flags().Float64Var(&criticalThreshold, "critical", 1000000, "Trigger a critical if network traffice is over specified Bytes")
-	cmd.Flags().Float64Var(&warningThreshold, "warning", 1500000, "Trigger a warning if network traffice is over specified Bytes")
-	cmd.Flags().StringVar(&instanceId, "instance_id", "", "EC2 Instance ID to check.")
-	cmd.Flags().StringVar(&endTime, "end_time", time.Now().Format(time.RFC3339), "CloudWatch metric statistics end time, e.g. 2014-11-12T11:45:26.371Z")
-	cmd.Flags().Int64Var(&period, "period", 60, "CloudWatch metric statistics period in seconds")
-	cmd.Flags().StringVar(&direction, "direction", "NetworkIn", "Select NetworkIn or NetworkOut")
-
-	_ = cmd.MarkFlagRequired("instance_id")
-	return cmd
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkNetwork)
 }
@@ -0,0 +1,319 @@
+package main
+
+/*
+#
+# check-ec2-reserved-instances
+#
+# DESCRIPTION:
+#   This plugin matches running EC2 instances to the active reserved
+#   instances of the region, and reports unused reservations, reservations
+#   expiring within the given number of days and instance types running on
+#   demand that could be covered by a reservation. With --savings-plans it
+#   also reports underused and expiring savings plans.
+#
+# OUTPUT:
+#   plain-text
+#
+# PLATFORMS:
+#   MAC OS
+#
+#
+# USAGE:
+#   ./check-ec2-reserved-instances
+#   ./check-ec2-reserved-instances --expiry-warning=60 --expiry-critical=14 --unused-status=critical
+#   ./check-ec2-reserved-instances --on-demand-status=warning --on-demand-min=3
+#   ./check-ec2-reserved-instances --savings-plans --savings-plans-min-utilization=95
+# NOTES:
+#   Zonal reservations are matched first, then regional reservations of the
+#   same instance type, then regional Linux/UNIX reservations with default
+#   tenancy to the other sizes of their family by normalized footprint. The
+#   platform of an instance, e.g. Red Hat Enterprise Linux, is read from its
+#   image, instances whose image is gone count as Linux/UNIX or Windows.
+#   Spot and scheduled instances are left out.
+#   Savings plans are reported in their region, plans without a region in
+#   us-east-1. Their utilization comes from Cost Explorer, which charges
+#   every request and has no data for the current day.
+#
+# LICENSE:
+#   TODO
+#
+*/
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/regions"
+	"github.com/sensu/sensu-aws/reserved"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/utils"
+)
+
+var (
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	pager          utils.Pager
+	expiryWarning  int
+	expiryCritical int
+	unusedStatus   string
+	onDemandStatus string
+	onDemandMin    int
+	savingsPlans   bool
+	planDays       int
+	planMinUsed    float64
+
+	config = plugin.NewConfig("check-ec2-reserved-instances", "The Sensu Go Aws EC2 handler for reserved instance utilization")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "expiry-warning",
+			Env:      "EXPIRY_WARNING",
+			Argument: "expiry-warning",
+			Default:  30,
+			Usage:    "Trigger a warning when reserved instances expire within this many days",
+			Value:    &expiryWarning,
+		},
+		{
+			Path:     "expiry-critical",
+			Env:      "EXPIRY_CRITICAL",
+			Argument: "expiry-critical",
+			Default:  7,
+			Usage:    "Trigger a critical when reserved instances expire within this many days",
+			Value:    &expiryCritical,
+		},
+		{
+			Path:     "unused-status",
+			Env:      "UNUSED_STATUS",
+			Argument: "unused-status",
+			Default:  "warning",
+			Usage:    "Status of reservations with unused instances: ok, warning, critical or unknown",
+			Value:    &unusedStatus,
+		},
+		{
+			Path:     "on-demand-status",
+			Env:      "ON_DEMAND_STATUS",
+			Argument: "on-demand-status",
+			Default:  "ok",
+			Usage:    "Status of instance types running on demand: ok, warning, critical or unknown",
+			Value:    &onDemandStatus,
+		},
+		{
+			Path:     "on-demand-min",
+			Env:      "ON_DEMAND_MIN",
+			Argument: "on-demand-min",
+			Default:  1,
+			Usage:    "Only report instance types with at least this many instances running on demand",
+			Value:    &onDemandMin,
+		},
+		{
+			Path:     "savings-plans",
+			Env:      "SAVINGS_PLANS",
+			Argument: "savings-plans",
+			Default:  false,
+			Usage:    "Also check the savings plans of the region, Cost Explorer charges every request",
+			Value:    &savingsPlans,
+		},
+		{
+			Path:     "savings-plans-days",
+			Env:      "SAVINGS_PLANS_DAYS",
+			Argument: "savings-plans-days",
+			Default:  7,
+			Usage:    "Number of days before today savings plan utilization is measured over",
+			Value:    &planDays,
+		},
+		{
+			Path:     "savings-plans-min-utilization",
+			Env:      "SAVINGS_PLANS_MIN_UTILIZATION",
+			Argument: "savings-plans-min-utilization",
+			Default:  float64(90),
+			Usage:    "Report savings plans utilized below this percentage with --unused-status",
+			Value:    &planMinUsed,
+		},
+	}
+)
+
+// EC2Client represents the EC2 dependencies of the check
+type EC2Client interface {
+	DescribeImages(*ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error)
+	DescribeInstancesPages(*ec2.DescribeInstancesInput, func(*ec2.DescribeInstancesOutput, bool) bool) error
+	DescribeReservedInstances(*ec2.DescribeReservedInstancesInput) (*ec2.DescribeReservedInstancesOutput, error)
+}
+
+func checkReservedInstances(factory *awsclient.Factory, res *result.Result) {
+	ec2Client, err := factory.EC2()
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	reservations, err := reserved.Active(ec2Client)
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	instances, err := runningInstances(ec2Client)
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	platforms, err := reserved.Platforms(ec2Client, instances)
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	usages, onDemand := reserved.Match(reservations, instances, platforms)
+
+	now := time.Now()
+	var count int64
+	var used float64
+	status, _ := result.ParseStatus(unusedStatus)
+	for _, usage := range usages {
+		id := aws.StringValue(usage.Reservation.ReservedInstancesId)
+		description := fmt.Sprintf("%s %s", aws.StringValue(usage.Reservation.InstanceType), usage.Scope())
+		count += usage.Count()
+		used += usage.Used
+		if usage.Unused() > 0 {
+			res.Add(status, id, "%s of %d reserved %s unused", instanceCount(usage.Unused()), usage.Count(), description)
+		}
+		expiry(res, id, now, aws.TimeValue(usage.Reservation.End), fmt.Sprintf("%d reserved %s expire", usage.Count(), description))
+	}
+
+	byType := make(map[string][]string)
+	for _, instance := range onDemand {
+		instanceType := aws.StringValue(instance.InstanceType)
+		byType[instanceType] = append(byType[instanceType], aws.StringValue(instance.InstanceId))
+	}
+	types := []string{}
+	for instanceType := range byType {
+		types = append(types, instanceType)
+	}
+	sort.Strings(types)
+	status, _ = result.ParseStatus(onDemandStatus)
+	for _, instanceType := range types {
+		if ids := byType[instanceType]; len(ids) >= onDemandMin {
+			res.Add(status, instanceType, "%d instances running on demand could be reserved: %s", len(ids), strings.Join(ids, ", "))
+		}
+	}
+
+	utilization := 0.0
+	if count > 0 {
+		utilization = 100 * used / float64(count)
+	}
+	message := fmt.Sprintf("%s of %d reserved instances used (%.1f%%), %d instances on demand", instanceCount(used), count, utilization, len(onDemand))
+	if savingsPlans {
+		plans, err := checkSavingsPlans(factory, res, now)
+		if err != nil {
+			res.Error("", err)
+			return
+		}
+		message += fmt.Sprintf(", %d savings plans", plans)
+	}
+	res.SetOKMessage("%s", message)
+}
+
+// checkSavingsPlans reports the underused and expiring savings plans of the
+// region of factory and returns the number of plans checked
+func checkSavingsPlans(factory *awsclient.Factory, res *result.Result, now time.Time) (int, error) {
+	plansClient, err := factory.SavingsPlans()
+	if err != nil {
+		return 0, err
+	}
+	costClient, err := factory.CostExplorer()
+	if err != nil {
+		return 0, err
+	}
+	usages, err := reserved.SavingsPlans(&pager, plansClient, costClient, regions.Of(factory), reserved.UtilizationPeriod(now, planDays))
+	if err != nil {
+		return 0, err
+	}
+	status, _ := result.ParseStatus(unusedStatus)
+	for _, usage := range usages {
+		id := aws.StringValue(usage.Plan.SavingsPlanId)
+		description := fmt.Sprintf("%s savings plan", aws.StringValue(usage.Plan.SavingsPlanType))
+		if percentage, ok := usage.Percentage(); ok && percentage < planMinUsed {
+			res.Add(status, id, "%s %.1f%% utilized over the last %d days, %.2f %s of commitment unused",
+				description, percentage, planDays, usage.Unused(), aws.StringValue(usage.Plan.Currency))
+		}
+		end, err := usage.End()
+		if err != nil {
+			res.Unknown(id, "invalid end %q", aws.StringValue(usage.Plan.End))
+			continue
+		}
+		expiry(res, id, now, end, description+" expires")
+	}
+	return len(usages), nil
+}
+
+// expiry reports a reservation or plan ending at end within the expiry
+// thresholds, message says what expires
+func expiry(res *result.Result, resource string, now time.Time, end time.Time, message string) {
+	days := end.Sub(now).Hours() / 24
+	message = fmt.Sprintf("%s on %s, in %.1f days", message, end.UTC().Format(time.RFC3339), days)
+	switch {
+	case days <= float64(expiryCritical):
+		res.Critical(resource, "%s", message)
+	case days <= float64(expiryWarning):
+		res.Warning(resource, "%s", message)
+	}
+}
+
+// instanceCount formats a number of reserved instances, a fraction when
+// instances of other sizes use part of a reservation
+func instanceCount(n float64) string {
+	return strconv.FormatFloat(math.Round(n*100)/100, 'f', -1, 64)
+}
+
+// runningInstances returns the running instances of the region of client
+func runningInstances(client EC2Client) ([]*ec2.Instance, error) {
+	filter := ec2.Filter{Name: aws.String("instance-state-name"), Values: []*string{aws.String("running")}}
+	reservations, err := pager.Reservations(client, &ec2.DescribeInstancesInput{Filters: []*ec2.Filter{&filter}})
+	if err != nil {
+		return nil, err
+	}
+	var instances []*ec2.Instance
+	for _, reservation := range reservations {
+		instances = append(instances, reservation.Instances...)
+	}
+	return instances, nil
+}
+
+func main() {
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
+}
+
+func validate() error {
+	if expiryCritical > expiryWarning {
+		return errors.New("--expiry-critical must not be greater than --expiry-warning")
+	}
+	if _, err := result.ParseStatus(unusedStatus); err != nil {
+		return fmt.Errorf("--unused-status: %v", err)
+	}
+	if _, err := result.ParseStatus(onDemandStatus); err != nil {
+		return fmt.Errorf("--on-demand-status: %v", err)
+	}
+	if planDays < 1 {
+		return errors.New("--savings-plans-days must be at least 1")
+	}
+	if planMinUsed < 0 || planMinUsed > 100 {
+		return errors.New("--savings-plans-min-utilization must be between 0 and 100")
+	}
+	return nil
+}
+
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkReservedInstances)
+	pager.Report(res)
+}
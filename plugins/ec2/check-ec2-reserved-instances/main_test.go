@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/savingsplans"
+	"github.com/sensu/sensu-aws/awstest"
+)
+
+func TestMain(m *testing.M) {
+	awstest.Main(m, main)
+}
+
+func TestPlugin(t *testing.T) {
+	server := awstest.NewServer()
+	defer server.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	reservation := func(id string, instanceType string, count int64, expires time.Duration) *ec2.ReservedInstances {
+		return &ec2.ReservedInstances{
+			ReservedInstancesId: aws.String(id),
+			InstanceType:        aws.String(instanceType),
+			InstanceCount:       aws.Int64(count),
+			ProductDescription:  aws.String("Linux/UNIX"),
+			Scope:               aws.String(ec2.ScopeRegion),
+			End:                 aws.Time(now.Add(expires)),
+		}
+	}
+	server.Respond(awstest.EC2, "DescribeReservedInstances", &ec2.DescribeReservedInstancesOutput{
+		ReservedInstances: []*ec2.ReservedInstances{
+			reservation("r-web", "m5.large", 2, 200*24*time.Hour),
+			reservation("r-db", "r5.xlarge", 1, 12*24*time.Hour),
+			reservation("r-c5", "c5.large", 2, 300*24*time.Hour),
+		},
+	})
+	instance := func(id string, instanceType string) *ec2.Instance {
+		return &ec2.Instance{
+			InstanceId:   aws.String(id),
+			InstanceType: aws.String(instanceType),
+			Placement:    &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+		}
+	}
+	rhel := instance("i-6", "c5.large")
+	rhel.ImageId = aws.String("ami-rhel")
+	server.Respond(awstest.EC2, "DescribeInstances", &ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{Instances: []*ec2.Instance{
+			instance("i-1", "m5.large"), instance("i-2", "r5.xlarge"), instance("i-3", "t3.micro"), instance("i-4", "t3.micro"),
+			instance("i-5", "c5.xlarge"), rhel,
+		}}},
+	})
+	server.Respond(awstest.EC2, "DescribeImages", &ec2.DescribeImagesOutput{
+		Images: []*ec2.Image{{ImageId: aws.String("ami-rhel"), PlatformDetails: aws.String("Red Hat Enterprise Linux")}},
+	})
+	plan := func(id string, region string, expires time.Duration) *savingsplans.SavingsPlan {
+		return &savingsplans.SavingsPlan{
+			SavingsPlanId:   aws.String(id),
+			SavingsPlanArn:  aws.String("arn:aws:savingsplans::123456789012:savingsplan/" + id),
+			SavingsPlanType: aws.String(savingsplans.SavingsPlanTypeCompute),
+			Currency:        aws.String("USD"),
+			Region:          aws.String(region),
+			End:             aws.String(now.Add(expires).Format(time.RFC3339)),
+		}
+	}
+	server.Respond(awstest.SavingsPlans, "DescribeSavingsPlans", &savingsplans.DescribeSavingsPlansOutput{
+		SavingsPlans: []*savingsplans.SavingsPlan{plan("sp-1", "us-east-1", 5*24*time.Hour), plan("sp-2", "eu-west-1", 5*24*time.Hour)},
+	})
+	server.Respond(awstest.CostExplorer, "GetSavingsPlansUtilizationDetails", &costexplorer.GetSavingsPlansUtilizationDetailsOutput{
+		SavingsPlansUtilizationDetails: []*costexplorer.SavingsPlansUtilizationDetail{{
+			SavingsPlanArn: aws.String("arn:aws:savingsplans::123456789012:savingsplan/sp-1"),
+			Utilization:    &costexplorer.SavingsPlansUtilization{UtilizationPercentage: aws.String("65"), UnusedCommitment: aws.String("21.5")},
+		}},
+	})
+
+	expiry := "1 reserved r5.xlarge regional expire on " + now.Add(12*24*time.Hour).Format(time.RFC3339) + ", in 12.0 days"
+	planExpiry := "Compute savings plan expires on " + now.Add(5*24*time.Hour).Format(time.RFC3339) + ", in 5.0 days"
+
+	tests := []struct {
+		Name      string
+		Args      []string
+		ExpStatus int
+		ExpOutput string
+	}{
+		{
+			Name:      "unused and expiring",
+			Args:      []string{},
+			ExpStatus: 1,
+			ExpOutput: "check-ec2-reserved-instances WARNING: 2 warning\n" +
+				"WARNING r-db: " + expiry + "\n" +
+				"WARNING r-web: 1 of 2 reserved m5.large regional unused\n" +
+				"OK c5.large: 1 instances running on demand could be reserved: i-6\n" +
+				"OK t3.micro: 2 instances running on demand could be reserved: i-3, i-4\n",
+		},
+		{
+			Name:      "critical expiry",
+			Args:      []string{"--expiry-critical", "14", "--unused-status", "ok", "--on-demand-min", "3"},
+			ExpStatus: 2,
+			ExpOutput: "check-ec2-reserved-instances CRITICAL: r-db: " + expiry + "\n",
+		},
+		{
+			Name:      "utilization",
+			Args:      []string{"--expiry-warning", "7", "--unused-status", "ok", "--on-demand-min", "3"},
+			ExpStatus: 0,
+			ExpOutput: "check-ec2-reserved-instances OK: 4 of 5 reserved instances used (80.0%), 3 instances on demand\n",
+		},
+		{
+			Name:      "savings plans",
+			Args:      []string{"--savings-plans", "--expiry-warning", "7", "--on-demand-min", "3"},
+			ExpStatus: 2,
+			ExpOutput: "check-ec2-reserved-instances CRITICAL: sp-1: " + planExpiry + "\n" +
+				"CRITICAL sp-1: " + planExpiry + "\n" +
+				"WARNING r-web: 1 of 2 reserved m5.large regional unused\n" +
+				"WARNING sp-1: Compute savings plan 65.0% utilized over the last 7 days, 21.50 USD of commitment unused\n",
+		},
+		{
+			Name:      "invalid expiry",
+			Args:      []string{"--expiry-warning", "7", "--expiry-critical", "14"},
+			ExpStatus: 3,
+		},
+		{
+			Name:      "invalid savings plan utilization",
+			Args:      []string{"--savings-plans", "--savings-plans-min-utilization", "120"},
+			ExpStatus: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			output, status := server.Exec(t, test.Args...)
+			if got, want := status, test.ExpStatus; got != want {
+				t.Errorf("bad exit status: got %d, want %d (output %q)", got, want, output)
+			}
+			if !strings.HasPrefix(output, test.ExpOutput) {
+				t.Errorf("bad output: got %q, want prefix %q", output, test.ExpOutput)
+			}
+		})
+	}
+
+	if got, want := server.Calls(awstest.CostExplorer, "GetSavingsPlansUtilizationDetails"), 1; got != want {
+		t.Errorf("bad Cost Explorer call count: got %d, want %d", got, want)
+	}
+}
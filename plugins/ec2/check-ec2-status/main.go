@@ -0,0 +1,222 @@
+package main
+
+/*
+#
+# check-ec2-status
+#
+# DESCRIPTION:
+#   This plugin checks the system and instance status checks of EC2 instances
+#   matching a given filter, and the status of the EBS volumes attached to
+#   them, raising a critical for each impaired instance with the failing
+#   checks and since when they fail.
+#
+# OUTPUT:
+#   plain-text
+#
+# PLATFORMS:
+#   MAC OS
+#
+#
+# USAGE:
+#   ./check-ec2-status
+#   ./check-ec2-status --filters="{\"filters\" : [{\"name\" : \"availability-zone\", \"values\": [\"eu-west-1a\"]}]}"
+#   ./check-ec2-status --aws-region=eu-west-1 --ebs-status=false
+# NOTES:
+#   Filters are those of DescribeInstanceStatus, which does not support tag
+#   filters. Volumes in warning status are reported as a warning.
+#
+# LICENSE:
+#   TODO
+#
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/models"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/regions"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/utils"
+)
+
+var (
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	pager          utils.Pager
+	filters        string
+	ebsStatus      bool
+
+	config = plugin.NewConfig("check-ec2-status", "The Sensu Go Aws EC2 handler for instance status checks")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "filters",
+			Env:      "FILTERS",
+			Argument: "filters",
+			Default:  "{\"filters\" : [{\"name\" : \"instance-state-name\", \"values\": [\"running\"]}]}",
+			Usage:    "JSON String representation of Filters",
+			Value:    &filters,
+		},
+		{
+			Path:     "ebs-status",
+			Env:      "EBS_STATUS",
+			Argument: "ebs-status",
+			Default:  true,
+			Usage:    "Check the status of the EBS volumes attached to the instances",
+			Value:    &ebsStatus,
+		},
+	}
+)
+
+// EC2Client represents the EC2 dependencies of the check
+type EC2Client interface {
+	DescribeInstanceStatusPages(*ec2.DescribeInstanceStatusInput, func(*ec2.DescribeInstanceStatusOutput, bool) bool) error
+	DescribeVolumeStatusPages(*ec2.DescribeVolumeStatusInput, func(*ec2.DescribeVolumeStatusOutput, bool) bool) error
+}
+
+// failures describes the failing checks of a status summary, e.g. "system
+// reachability failed since 2020-09-08T10:00:00Z"
+func failures(kind string, summary *ec2.InstanceStatusSummary) []string {
+	if summary == nil || aws.StringValue(summary.Status) != ec2.SummaryStatusImpaired {
+		return nil
+	}
+	var failed []string
+	for _, detail := range summary.Details {
+		if aws.StringValue(detail.Status) != ec2.StatusTypeFailed {
+			continue
+		}
+		failure := fmt.Sprintf("%s %s failed", kind, aws.StringValue(detail.Name))
+		if detail.ImpairedSince != nil {
+			failure += " since " + detail.ImpairedSince.UTC().Format(time.RFC3339)
+		}
+		failed = append(failed, failure)
+	}
+	if len(failed) == 0 {
+		failed = append(failed, fmt.Sprintf("%s status impaired", kind))
+	}
+	return failed
+}
+
+// volumeStatusWarning is a volume status missing from the SDK enum
+const volumeStatusWarning = "warning"
+
+// volumeFailures returns the status and the failing checks of each instance
+// with a volume in impaired or warning status
+func volumeFailures(client EC2Client) (map[string]result.Status, map[string][]string, error) {
+	volumes, err := pager.VolumeStatuses(client, &ec2.DescribeVolumeStatusInput{
+		Filters: []*ec2.Filter{{
+			Name:   aws.String("volume-status.status"),
+			Values: aws.StringSlice([]string{ec2.VolumeStatusInfoStatusImpaired, volumeStatusWarning}),
+		}},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	statuses := make(map[string]result.Status)
+	failed := make(map[string][]string)
+	for _, volume := range volumes {
+		if volume.VolumeStatus == nil {
+			continue
+		}
+		status := result.Warning
+		if aws.StringValue(volume.VolumeStatus.Status) == ec2.VolumeStatusInfoStatusImpaired {
+			status = result.Critical
+		}
+		var checks []string
+		for _, detail := range volume.VolumeStatus.Details {
+			if value := aws.StringValue(detail.Status); value != "passed" && value != "normal" {
+				checks = append(checks, fmt.Sprintf("%s %s", aws.StringValue(detail.Name), value))
+			}
+		}
+		failure := fmt.Sprintf("EBS volume %s %s", aws.StringValue(volume.VolumeId), aws.StringValue(volume.VolumeStatus.Status))
+		if len(checks) > 0 {
+			failure = fmt.Sprintf("EBS volume %s %s", aws.StringValue(volume.VolumeId), strings.Join(checks, ", "))
+		}
+		for _, event := range volume.Events {
+			if event.NotBefore != nil {
+				failure += " since " + event.NotBefore.UTC().Format(time.RFC3339)
+				break
+			}
+		}
+		for _, attachment := range volume.AttachmentStatuses {
+			instance := aws.StringValue(attachment.InstanceId)
+			if status.Worse(statuses[instance]) {
+				statuses[instance] = status
+			}
+			failed[instance] = append(failed[instance], failure)
+		}
+	}
+	return statuses, failed, nil
+}
+
+func checkStatus(factory *awsclient.Factory, res *result.Result) {
+	var ec2Filters models.Filters
+	ec2Client, err := factory.EC2()
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	err = json.Unmarshal([]byte(filters), &ec2Filters)
+	if err != nil {
+		res.Unknown("", "failed to unmarshal filter data: %v", err)
+		return
+	}
+
+	instances, err := pager.InstanceStatuses(ec2Client, &ec2.DescribeInstanceStatusInput{Filters: ec2Filters.Filters})
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	volumeStatuses := make(map[string]result.Status)
+	volumeFailed := make(map[string][]string)
+	if ebsStatus && len(instances) > 0 {
+		volumeStatuses, volumeFailed, err = volumeFailures(ec2Client)
+		if err != nil {
+			res.Error("", err)
+			return
+		}
+	}
+
+	for _, instance := range instances {
+		id := aws.StringValue(instance.InstanceId)
+		failed := append(failures("system", instance.SystemStatus), failures("instance", instance.InstanceStatus)...)
+		status := volumeStatuses[id]
+		if len(failed) > 0 {
+			status = result.Critical
+		}
+		failed = append(failed, volumeFailed[id]...)
+		if len(failed) > 0 {
+			res.Add(status, id, "%s", strings.Join(failed, ", "))
+		}
+	}
+	res.SetOKMessage("None of %d instances is impaired", len(instances))
+}
+
+func main() {
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
+}
+
+func validate() error {
+	var ec2Filters models.Filters
+	if err := json.Unmarshal([]byte(filters), &ec2Filters); err != nil {
+		return fmt.Errorf("--filters: %v", err)
+	}
+	return nil
+}
+
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkStatus)
+	pager.Report(res)
+}
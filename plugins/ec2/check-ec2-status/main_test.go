@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu/sensu-aws/awstest"
+)
+
+func TestMain(m *testing.M) {
+	awstest.Main(m, main)
+}
+
+func TestPlugin(t *testing.T) {
+	server := awstest.NewServer()
+	defer server.Close()
+
+	since := time.Date(2020, 9, 8, 10, 0, 0, 0, time.UTC)
+	summary := func(status string, failed bool) *ec2.InstanceStatusSummary {
+		detail := &ec2.InstanceStatusDetails{Name: aws.String("reachability"), Status: aws.String("passed")}
+		if failed {
+			detail.Status = aws.String("failed")
+			detail.ImpairedSince = aws.Time(since)
+		}
+		return &ec2.InstanceStatusSummary{Status: aws.String(status), Details: []*ec2.InstanceStatusDetails{detail}}
+	}
+	server.Respond(awstest.EC2, "DescribeInstanceStatus", &ec2.DescribeInstanceStatusOutput{
+		InstanceStatuses: []*ec2.InstanceStatus{
+			{InstanceId: aws.String("i-ok"), SystemStatus: summary("ok", false), InstanceStatus: summary("ok", false)},
+			{InstanceId: aws.String("i-system"), SystemStatus: summary("impaired", true), InstanceStatus: summary("ok", false)},
+			{InstanceId: aws.String("i-instance"), SystemStatus: summary("ok", false), InstanceStatus: summary("impaired", true)},
+			{InstanceId: aws.String("i-ebs"), SystemStatus: summary("ok", false), InstanceStatus: summary("ok", false)},
+		},
+	})
+	server.Respond(awstest.EC2, "DescribeVolumeStatus", &ec2.DescribeVolumeStatusOutput{
+		VolumeStatuses: []*ec2.VolumeStatusItem{{
+			VolumeId: aws.String("vol-1"),
+			VolumeStatus: &ec2.VolumeStatusInfo{
+				Status: aws.String("warning"),
+				Details: []*ec2.VolumeStatusDetails{
+					{Name: aws.String("io-enabled"), Status: aws.String("passed")},
+					{Name: aws.String("io-performance"), Status: aws.String("degraded")},
+				},
+			},
+			AttachmentStatuses: []*ec2.VolumeStatusAttachmentStatus{{InstanceId: aws.String("i-ebs")}},
+		}},
+	})
+
+	tests := []struct {
+		Name      string
+		Args      []string
+		ExpStatus int
+		ExpOutput string
+	}{
+		{
+			Name:      "impaired",
+			Args:      []string{},
+			ExpStatus: 2,
+			ExpOutput: "check-ec2-status CRITICAL: 2 critical, 1 warning\n" +
+				"CRITICAL i-system: system reachability failed since 2020-09-08T10:00:00Z\n" +
+				"CRITICAL i-instance: instance reachability failed since 2020-09-08T10:00:00Z\n" +
+				"WARNING i-ebs: EBS volume vol-1 io-performance degraded\n",
+		},
+		{
+			Name:      "without ebs",
+			Args:      []string{"--ebs-status=false"},
+			ExpStatus: 2,
+			ExpOutput: "check-ec2-status CRITICAL: 2 critical\n",
+		},
+		{
+			Name:      "invalid filters",
+			Args:      []string{"--filters", "{"},
+			ExpStatus: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			output, status := server.Exec(t, test.Args...)
+			if got, want := status, test.ExpStatus; got != want {
+				t.Errorf("bad exit status: got %d, want %d (output %q)", got, want, output)
+			}
+			if !strings.HasPrefix(output, test.ExpOutput) {
+				t.Errorf("bad output: got %q, want prefix %q", output, test.ExpOutput)
+			}
+		})
+	}
+}
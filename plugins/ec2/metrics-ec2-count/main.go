@@ -14,10 +14,10 @@ package main
 #
 # USAGE:
 #   # get metrics on the status of all instances in the region
-#   ./metrics-ec2-count.go --metric_type=status
+#   ./metrics-ec2-count.go --metric-type=status
 #
 #   # get metrics on all instance types in the region
-#   ./metrics-ec2-count.go --metric_type=instance
+#   ./metrics-ec2-count.go --metric-type=instance
 #
 # NOTES:
 #
@@ -26,43 +26,68 @@ package main
 */
 
 import (
-	"fmt"
-	"os"
+	"time"
 
-	"github.com/spf13/cobra"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/regions"
 
-	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/metric"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/result"
 	"github.com/sensu/sensu-aws/utils"
 )
 
 var (
-	ec2Client        *ec2.EC2
-	cloudWatchClient *cloudwatch.CloudWatch
-	metricType       string
-	scheme           string
-	awsRegion        string
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	pager          utils.Pager
+	metricType     string
+	scheme         string
+	metricFormat   string
+	output         *metric.Writer
+
+	config = plugin.NewConfig("metrics-ec2-count", "The Sensu Go Aws EC2 handler for number of instance management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "metric-type",
+			Env:      "METRIC_TYPE",
+			Argument: "metric-type",
+			Default:  "instance",
+			Usage:    "Count by type: status, instance",
+			Value:    &metricType,
+		},
+		{
+			Path:     "scheme",
+			Env:      "SCHEME",
+			Argument: "scheme",
+			Default:  "sensu.aws.ec2",
+			Usage:    "Metric naming scheme, text to prepend to metric",
+			Value:    &scheme,
+		},
+	}
 )
 
-func metrics() {
-	var success bool
+// EC2Client represents the EC2 dependencies of the check
+type EC2Client interface {
+	DescribeInstancesPages(*ec2.DescribeInstancesInput, func(*ec2.DescribeInstancesOutput, bool) bool) error
+}
+
+func metrics(factory *awsclient.Factory, res *result.Result) {
 	metricCount := make(map[string]int)
 
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
-	success, ec2Client = awsclient.GetEC2Client(awsSession)
-	if !success {
-		return
-	}
-	success, cloudWatchClient = awsclient.GetCloudWatchClient(awsSession)
-	if !success {
+	ec2Client, err := factory.EC2()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
-	reservations, err := utils.GetReservations(ec2Client, nil)
+	reservations, err := pager.Reservations(ec2Client, &ec2.DescribeInstancesInput{})
 	if err != nil {
-		fmt.Println(err.Error())
-		os.Exit(0)
+		res.Error("", err)
+		return
 	}
 
 	if reservations == nil || len(reservations) <= 0 {
@@ -80,41 +105,34 @@ func metrics() {
 		}
 	}
 
-	if len(metricCount) > 0 {
-		fmt.Println("Number of", scheme, "instances by", metricType)
-		for metric, count := range metricCount {
-			fmt.Println(metric, "-", count)
-		}
+	tagName := "instance_type"
+	if metricType == "status" {
+		tagName = "state"
+	}
+	now := time.Now()
+	for name, count := range metricCount {
+		output.Add("instance_count", float64(count), now, append(regionOptions.MetricTags(factory), metric.Tag{Name: tagName, Value: name})...)
 	}
 }
 
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
+	options = append(options, metric.FormatOption(&metricFormat))
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Metrics(config, options, nil, run)
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
+func run(res *result.Result) {
+	var err error
+	output, err = metric.NewWriter(metricFormat, scheme)
+	if err != nil {
+		res.Error("", err)
+		return
 	}
-	metrics()
-	return nil
-}
-
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "metrics-ec2-count",
-		Short: "The Sensu Go Aws EC2 handler for number of instance management",
-		RunE:  run,
+	regionOptions.Run(res, sessionOptions, metrics)
+	pager.Report(res)
+	if err := output.Flush(); err != nil {
+		res.Error("", err)
 	}
-
-	cmd.Flags().StringVar(&awsRegion, "aws_region", "us-east-1", "AWS Region")
-	cmd.Flags().StringVar(&metricType, "metric_type", "instance", "Count by type: status, instance")
-	cmd.Flags().StringVar(&scheme, "scheme", "sensu.aws.ec2", "Metric naming scheme, text to prepend to metric")
-
-	return cmd
 }
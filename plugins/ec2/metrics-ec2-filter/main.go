@@ -27,88 +27,123 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
-	"os"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/metric"
 	"github.com/sensu/sensu-aws/models"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/regions"
+	"github.com/sensu/sensu-aws/result"
 	"github.com/sensu/sensu-aws/utils"
-	"github.com/spf13/cobra"
 )
 
 var (
-	ec2Client  *ec2.EC2
-	filters    string
-	metricType string
-	scheme     string
-	filterName string
-	awsRegion  string
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	pager          utils.Pager
+	filters        string
+	metricType     string
+	scheme         string
+	metricFormat   string
+	output         *metric.Writer
+	filterName     string
+
+	config = plugin.NewConfig("metrics-ec2-filter", "The Sensu Go Aws EC2 handler for instance filter management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "metric-type",
+			Env:      "METRIC_TYPE",
+			Argument: "metric-type",
+			Default:  "instance",
+			Usage:    "Count by type: status, instance",
+			Value:    &metricType,
+		},
+		{
+			Path:     "scheme",
+			Env:      "SCHEME",
+			Argument: "scheme",
+			Default:  "sensu.aws.ec2",
+			Usage:    "Metric naming scheme, text to prepend to metric",
+			Value:    &scheme,
+		},
+		{
+			Path:     "filters",
+			Env:      "FILTERS",
+			Argument: "filters",
+			Default:  "{}",
+			Usage:    "JSON String representation of Filters, e.g. {\"filters\" : [{\"name\" : \"instance-state-name\", \"values\": [\"running\"]}]}",
+			Value:    &filters,
+		},
+		{
+			Path:     "filter-name",
+			Env:      "FILTER_NAME",
+			Argument: "filter-name",
+			Default:  "",
+			Usage:    "Filter naming scheme, text to prepend to metric",
+			Value:    &filterName,
+		},
+	}
 )
 
-func metrics() {
-	var success bool
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
+// EC2Client represents the EC2 dependencies of the check
+type EC2Client interface {
+	DescribeInstancesPages(*ec2.DescribeInstancesInput, func(*ec2.DescribeInstancesOutput, bool) bool) error
+}
 
-	success, ec2Client = awsclient.GetEC2Client(awsSession)
-	if !success {
+func metrics(factory *awsclient.Factory, res *result.Result) {
+	ec2Client, err := factory.EC2()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
 	var ec2Fileters models.Filters
-	err := json.Unmarshal([]byte(filters), &ec2Fileters)
+	err = json.Unmarshal([]byte(filters), &ec2Fileters)
 	if err != nil {
-		fmt.Println("Failed to unmarshal filter data , ", err)
+		res.Unknown("", "failed to unmarshal filter data: %v", err)
 		return
 	}
 
-	reservations, err := utils.GetReservations(ec2Client, ec2Fileters.Filters)
+	reservations, err := pager.Reservations(ec2Client, &ec2.DescribeInstancesInput{Filters: ec2Fileters.Filters})
 	if err != nil {
-		fmt.Println(err.Error())
+		res.Error("", err)
 		return
 	}
 
-	fmt.Print("EC2 Instances of ", scheme)
-	if len(strings.TrimSpace(filterName)) > 1 {
-		fmt.Println("with filter :", filterName)
-	}
+	count := 0
 	for _, reservation := range reservations {
-		for _, instance := range reservation.Instances {
-			fmt.Println(*instance.InstanceId)
-		}
+		count += len(reservation.Instances)
+	}
+	tags := regionOptions.MetricTags(factory)
+	if len(strings.TrimSpace(filterName)) > 0 {
+		tags = append(tags, metric.Tag{Name: "filter", Value: filterName})
 	}
+	output.Add("instance_count", float64(count), time.Now(), tags...)
 }
 
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
+	options = append(options, metric.FormatOption(&metricFormat))
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Metrics(config, options, nil, run)
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
+func run(res *result.Result) {
+	var err error
+	output, err = metric.NewWriter(metricFormat, scheme)
+	if err != nil {
+		res.Error("", err)
+		return
 	}
-	metrics()
-	return nil
-}
-
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "metrics-ec2-filter",
-		Short: "The Sensu Go Aws EC2 handler for instance filter management",
-		RunE:  run,
+	regionOptions.Run(res, sessionOptions, metrics)
+	pager.Report(res)
+	if err := output.Flush(); err != nil {
+		res.Error("", err)
 	}
-
-	cmd.Flags().StringVar(&awsRegion, "aws_region", "us-east-1", "Aws Region")
-	cmd.Flags().StringVar(&metricType, "metric_type", "instance", "Count by type: status, instance")
-	cmd.Flags().StringVar(&scheme, "scheme", "sensu.aws.ec2", "Metric naming scheme, text to prepend to metric")
-	cmd.Flags().StringVar(&filters, "filters", "{}", "JSON String representation of Filters, e.g. {\"filters\" : [{\"name\" : \"instance-state-name\", \"values\": [\"running\"]}]}")
-	cmd.Flags().StringVar(&filterName, "filter_name", "", "Filter naming scheme, text to prepend to metric")
-
-	return cmd
 }
@@ -0,0 +1,220 @@
+package main
+
+/*
+# metrics-ec2-reserved-instances
+#
+# DESCRIPTION:
+#   This plugin retrieves the utilization of the active EC2 reserved
+#   instances of the region and the number of instances running on demand,
+#   and with --savings-plans the utilization of the savings plans of the
+#   region.
+#
+# OUTPUT:
+#   plain-text
+#
+# PLATFORMS:
+#   MAC OS
+#
+# USAGE:
+#   ./metrics-ec2-reserved-instances
+#   ./metrics-ec2-reserved-instances --aws-region=eu-west-1 --metric-format=prometheus_text
+#   ./metrics-ec2-reserved-instances --savings-plans --savings-plans-days=1
+#
+# NOTES:
+#   Each reservation has reserved_instance_count, reserved_instance_used and
+#   reserved_instance_utilization metrics tagged with its ID, instance type
+#   and scope, on_demand_instance_count is tagged with the instance type and
+#   reserved_instance_utilization_total covers every reservation.
+#   savings_plan_utilization and savings_plan_unused_commitment are tagged
+#   with the savings plan ID and type, plans without Cost Explorer data yet
+#   are left out.
+#
+# LICENSE:
+#  TODO
+*/
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/metric"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/regions"
+	"github.com/sensu/sensu-aws/reserved"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/utils"
+)
+
+var (
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	pager          utils.Pager
+	scheme         string
+	metricFormat   string
+	output         *metric.Writer
+	savingsPlans   bool
+	planDays       int
+
+	config = plugin.NewConfig("metrics-ec2-reserved-instances", "The Sensu Go Aws EC2 handler for reserved instance utilization metrics")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "scheme",
+			Env:      "SCHEME",
+			Argument: "scheme",
+			Default:  "sensu.aws.ec2",
+			Usage:    "Metric naming scheme, text to prepend to metric",
+			Value:    &scheme,
+		},
+		{
+			Path:     "savings-plans",
+			Env:      "SAVINGS_PLANS",
+			Argument: "savings-plans",
+			Default:  false,
+			Usage:    "Also output the utilization of the savings plans of the region, Cost Explorer charges every request",
+			Value:    &savingsPlans,
+		},
+		{
+			Path:     "savings-plans-days",
+			Env:      "SAVINGS_PLANS_DAYS",
+			Argument: "savings-plans-days",
+			Default:  7,
+			Usage:    "Number of days before today savings plan utilization is measured over",
+			Value:    &planDays,
+		},
+	}
+)
+
+// EC2Client represents the EC2 dependencies of the check
+type EC2Client interface {
+	DescribeImages(*ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error)
+	DescribeInstancesPages(*ec2.DescribeInstancesInput, func(*ec2.DescribeInstancesOutput, bool) bool) error
+	DescribeReservedInstances(*ec2.DescribeReservedInstancesInput) (*ec2.DescribeReservedInstancesOutput, error)
+}
+
+func metrics(factory *awsclient.Factory, res *result.Result) {
+	ec2Client, err := factory.EC2()
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	reservations, err := reserved.Active(ec2Client)
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	filter := ec2.Filter{Name: aws.String("instance-state-name"), Values: []*string{aws.String("running")}}
+	instanceReservations, err := pager.Reservations(ec2Client, &ec2.DescribeInstancesInput{Filters: []*ec2.Filter{&filter}})
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	var instances []*ec2.Instance
+	for _, reservation := range instanceReservations {
+		instances = append(instances, reservation.Instances...)
+	}
+	platforms, err := reserved.Platforms(ec2Client, instances)
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	usages, onDemand := reserved.Match(reservations, instances, platforms)
+
+	now := time.Now()
+	tags := regionOptions.MetricTags(factory)
+	var count int64
+	var used float64
+	for _, usage := range usages {
+		count += usage.Count()
+		used += usage.Used
+		usageTags := append(tags[:len(tags):len(tags)],
+			metric.Tag{Name: "reserved_instances_id", Value: aws.StringValue(usage.Reservation.ReservedInstancesId)},
+			metric.Tag{Name: "instance_type", Value: aws.StringValue(usage.Reservation.InstanceType)},
+			metric.Tag{Name: "scope", Value: usage.Scope()},
+		)
+		output.Add("reserved_instance_count", float64(usage.Count()), now, usageTags...)
+		output.Add("reserved_instance_used", usage.Used, now, usageTags...)
+		output.Add("reserved_instance_utilization", usage.Utilization(), now, usageTags...)
+	}
+	if count > 0 {
+		output.Add("reserved_instance_utilization_total", 100*used/float64(count), now, tags...)
+	}
+
+	onDemandCount := make(map[string]int)
+	for _, instance := range onDemand {
+		onDemandCount[aws.StringValue(instance.InstanceType)]++
+	}
+	for instanceType, n := range onDemandCount {
+		output.Add("on_demand_instance_count", float64(n), now, append(tags[:len(tags):len(tags)], metric.Tag{Name: "instance_type", Value: instanceType})...)
+	}
+
+	if savingsPlans {
+		if err := savingsPlanMetrics(factory, now, tags); err != nil {
+			res.Error("", err)
+		}
+	}
+}
+
+// savingsPlanMetrics outputs the utilization of the savings plans of the
+// region of factory
+func savingsPlanMetrics(factory *awsclient.Factory, now time.Time, tags []metric.Tag) error {
+	plansClient, err := factory.SavingsPlans()
+	if err != nil {
+		return err
+	}
+	costClient, err := factory.CostExplorer()
+	if err != nil {
+		return err
+	}
+	usages, err := reserved.SavingsPlans(&pager, plansClient, costClient, regions.Of(factory), reserved.UtilizationPeriod(now, planDays))
+	if err != nil {
+		return err
+	}
+	for _, usage := range usages {
+		percentage, ok := usage.Percentage()
+		if !ok {
+			continue
+		}
+		planTags := append(tags[:len(tags):len(tags)],
+			metric.Tag{Name: "savings_plan_id", Value: aws.StringValue(usage.Plan.SavingsPlanId)},
+			metric.Tag{Name: "savings_plan_type", Value: aws.StringValue(usage.Plan.SavingsPlanType)},
+		)
+		output.Add("savings_plan_utilization", percentage, now, planTags...)
+		output.Add("savings_plan_unused_commitment", usage.Unused(), now, planTags...)
+	}
+	return nil
+}
+
+func main() {
+	options = append(options, metric.FormatOption(&metricFormat))
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Metrics(config, options, validate, run)
+}
+
+func validate() error {
+	if planDays < 1 {
+		return errors.New("--savings-plans-days must be at least 1")
+	}
+	return nil
+}
+
+func run(res *result.Result) {
+	var err error
+	output, err = metric.NewWriter(metricFormat, scheme)
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	regionOptions.Run(res, sessionOptions, metrics)
+	pager.Report(res)
+	if err := output.Flush(); err != nil {
+		res.Error("", err)
+	}
+}
@@ -4,15 +4,19 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net"
-	"os"
 	"strings"
 	"time"
 
-	"github.com/spf13/cobra"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/regions"
 
 	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/threshold"
+	"github.com/sensu/sensu-aws/utils"
 )
 
 /*
@@ -32,6 +36,7 @@ import (
 #
 # USAGE:
 #  ./check-elb-certs -aws_region=${your_region} -warning=${days_to_warn} -critical=${days_to_critical}
+#  ./check-elb-certs --warning-range=30: --critical-range=7:
 #
 # NOTES:
 #
@@ -41,98 +46,152 @@ import (
 */
 
 var (
-	awsRegion string
-	warning   int
-	critical  int
-	verbose   bool
-	elbClient *elb.ELB
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	pager          utils.Pager
+	warning        int
+	critical       int
+	warningRange   string
+	criticalRange  string
+	verbose        bool
+
+	config = plugin.NewConfig("check-elb-certs", "The Sensu Go Aws Load Balancer handler for certificate expiry management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "warning",
+			Env:      "WARNING",
+			Argument: "warning",
+			Default:  30,
+			Usage:    "Warn on minimum number of days to SSL/TLS certificate expiration",
+			Value:    &warning,
+		},
+		{
+			Path:     "critical",
+			Env:      "CRITICAL",
+			Argument: "critical",
+			Default:  5,
+			Usage:    "Minimum number of days to SSL/TLS certificate expiration",
+			Value:    &critical,
+		},
+		{
+			Path:     "warning-range",
+			Env:      "WARNING_RANGE",
+			Argument: "warning-range",
+			Default:  "",
+			Usage:    "Warning threshold range of the days to expiration (e.g. 30:, @0:30), overrides warning",
+			Value:    &warningRange,
+		},
+		{
+			Path:     "critical-range",
+			Env:      "CRITICAL_RANGE",
+			Argument: "critical-range",
+			Default:  "",
+			Usage:    "Critical threshold range of the days to expiration (e.g. 5:, @0:5), overrides critical",
+			Value:    &criticalRange,
+		},
+		{
+			Path:     "verbose",
+			Env:      "VERBOSE",
+			Argument: "verbose",
+			Default:  false,
+			Usage:    "Provide SSL/TLS certificate expiration details even when OK",
+			Value:    &verbose,
+		},
+	}
 )
 
-func checkExpiry() {
-	awsSession := aws_session.CreateAwsSession()
+// ELBClient represents the ELB dependencies of the check
+type ELBClient interface {
+	DescribeLoadBalancersPages(*elb.DescribeLoadBalancersInput, func(*elb.DescribeLoadBalancersOutput, bool) bool) error
+}
 
-	success, elbClient := awsclient.GetElbClient(awsSession)
-	if !success {
+func checkExpiry(factory *awsclient.Factory, res *result.Result) {
+	thresholds, err := expiryThresholds()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
-
-	describeLoadBalancerInput := &elb.DescribeLoadBalancersInput{}
-	describeLoadBalancerOutput, err := elbClient.DescribeLoadBalancers(describeLoadBalancerInput)
+	elbClient, err := factory.ELB()
 	if err != nil {
-		fmt.Println("Error :", err)
+		res.Error("", err)
 		return
 	}
-	if !(describeLoadBalancerOutput != nil && describeLoadBalancerOutput.LoadBalancerDescriptions != nil && len(describeLoadBalancerOutput.LoadBalancerDescriptions) > 0) {
+
+	describeLoadBalancerInput := &elb.DescribeLoadBalancersInput{}
+	loadBalancers, err := pager.LoadBalancers(elbClient, describeLoadBalancerInput)
+	if err != nil {
+		res.Error("", err)
 		return
 	}
-	for _, loadBalancer := range describeLoadBalancerOutput.LoadBalancerDescriptions {
+	for _, loadBalancer := range loadBalancers {
 		for _, listener := range loadBalancer.ListenerDescriptions {
 			elbListener := listener.Listener
 			if strings.ToUpper(*elbListener.Protocol) == "HTTPS" {
-				dnsName := *loadBalancer.DNSName
-				fmt.Println(dnsName)
-				port := *elbListener.LoadBalancerPort
-				ips, err := net.LookupIP(dnsName)
-				if err != nil {
-					fmt.Println("Error :", err)
-					return
-				}
-				dialer := net.Dialer{}
-				connection, err := tls.DialWithDialer(&dialer, "tcp", fmt.Sprintf("[%s]:%d", ips[0], port), &tls.Config{ServerName: dnsName})
-				if err != nil {
-					fmt.Println("Error :", err)
-					return
-				}
-				for _, chain := range connection.ConnectionState().VerifiedChains {
-					for _, cert := range chain {
-						if cert.IsCA {
-							continue
-						}
-						expiryDate := cert.NotAfter
-						if critical > 0 && float64(critical) > (time.Since(expiryDate).Minutes()/float64(60))/float64(24.0) {
-							fmt.Println(fmt.Sprintf("CRITICAL:Load Balancer Name:'%s' , Expiry Date:%s", *loadBalancer.LoadBalancerName, expiryDate.Format(time.RFC3339)))
-							break
-						}
-						if warning > 0 && float64(warning) > (time.Since(expiryDate).Minutes()/float64(60))/float64(24.0) {
-							fmt.Println(fmt.Sprintf("WARNING:Load Balancer Name:'%s' , Expiry Date:%s", *loadBalancer.LoadBalancerName, expiryDate.Format(time.RFC3339)))
-							break
-						}
-					}
-				}
+				checkListenerCertificate(res, thresholds, *loadBalancer.LoadBalancerName, *loadBalancer.DNSName, *elbListener.LoadBalancerPort)
 			}
 		}
 	}
+	res.SetOKMessage("no certificate expires within %d day(s)", warning)
 }
 
-func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+func checkListenerCertificate(res *result.Result, thresholds threshold.Thresholds, loadBalancerName string, dnsName string, port int64) {
+	ips, err := net.LookupIP(dnsName)
+	if err != nil {
+		res.Error(loadBalancerName, err)
+		return
 	}
-}
-
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
+	dialer := net.Dialer{}
+	connection, err := tls.DialWithDialer(&dialer, "tcp", fmt.Sprintf("[%s]:%d", ips[0], port), &tls.Config{ServerName: dnsName})
+	if err != nil {
+		res.Error(loadBalancerName, err)
+		return
+	}
+	defer connection.Close()
+	for _, chain := range connection.ConnectionState().VerifiedChains {
+		for _, cert := range chain {
+			if cert.IsCA {
+				continue
+			}
+			expiryDate := cert.NotAfter
+			daysLeft := time.Until(expiryDate).Hours() / 24.0
+			if status := thresholds.Status(daysLeft); status != result.OK {
+				res.Add(status, loadBalancerName, "certificate for port %d expires %s", port, expiryDate.Format(time.RFC3339))
+			} else if verbose {
+				res.OK(loadBalancerName, "certificate for port %d expires %s", port, expiryDate.Format(time.RFC3339))
+			}
+			return
+		}
 	}
-	checkExpiry()
-	return nil
 }
 
-func configureRootCommand() *cobra.Command {
+func main() {
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
+}
 
-	cmd := &cobra.Command{
-		Use:   "check-elb-certs",
-		Short: "The Sensu Go Aws Load Balancer handler for certificate expiry management",
-		RunE:  run,
-	}
+func validate() error {
+	_, err := expiryThresholds()
+	return err
+}
 
-	cmd.Flags().StringVar(&awsRegion, "aws_region", "us-west-1", "AWS Region (defaults to us-east-1).")
-	cmd.Flags().IntVar(&warning, "warning", 30, "Warn on minimum number of days to SSL/TLS certificate expiration")
-	cmd.Flags().IntVar(&critical, "critical", 5, "Minimum number of days to SSL/TLS certificate expiration")
-	cmd.Flags().BoolVar(&verbose, "verbose", false, "Provide SSL/TLS certificate expiration details even when OK")
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkExpiry)
+	pager.Report(res)
+}
 
-	return cmd
+// expiryThresholds alerts when fewer days than warning or critical are left,
+// non positive values disable the threshold unless ranges are given
+func expiryThresholds() (threshold.Thresholds, error) {
+	thresholds := threshold.Under(float64(warning), float64(critical))
+	if warning <= 0 {
+		thresholds.Warning = nil
+	}
+	if critical <= 0 {
+		thresholds.Critical = nil
+	}
+	err := thresholds.Override(warningRange, criticalRange)
+	return thresholds, err
 }
@@ -16,7 +16,7 @@ package main
 #
 #
 # USAGE:
-#  ./check-elb-health-fog -aws_region=${you_region} --instances=${your_instance_ids} --elb_name=${your_elb_name} --verbose=true
+#  ./check-elb-health-fog -aws_region=${you_region} --instances=${your_instance_ids} --elb-name=${your_elb_name} --verbose=true
 #
 # NOTES:
 #
@@ -26,46 +26,84 @@ package main
 */
 
 import (
-	"fmt"
-	"os"
+	"errors"
 	"strings"
 
-	"github.com/spf13/cobra"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/regions"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/result"
 )
 
 var (
-	awsRegion string
-	elbName   string
-	instances string
-	verbose   bool
-	elbClient *elb.ELB
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	elbName        string
+	instances      string
+	verbose        bool
+
+	config = plugin.NewConfig("check-elb-health-fog", "The Sensu Go Aws Load Balancer handler for health management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "elb-name",
+			Env:      "ELB_NAME",
+			Argument: "elb-name",
+			Default:  "",
+			Usage:    "The Elastic Load Balancer name of which you want to check the health",
+			Value:    &elbName,
+		},
+		{
+			Path:     "instances",
+			Env:      "INSTANCES",
+			Argument: "instances",
+			Default:  "",
+			Usage:    "Comma separated list of specific instances IDs inside the ELB of which you want to check the health",
+			Value:    &instances,
+		},
+		{
+			Path:     "verbose",
+			Env:      "VERBOSE",
+			Argument: "verbose",
+			Default:  false,
+			Usage:    "Enable a little bit more verbose reports about instance health",
+			Value:    &verbose,
+		},
+	}
 )
 
-func checkHealth() {
-	var success bool
-	awsSession := aws_session.CreateAwsSession()
-	success, elbClient = awsclient.GetElbClient(awsSession)
-	if !success {
+// ELBClient represents the ELB dependencies of the check
+type ELBClient interface {
+	DescribeInstanceHealth(*elb.DescribeInstanceHealthInput) (*elb.DescribeInstanceHealthOutput, error)
+}
+
+func checkHealth(factory *awsclient.Factory, res *result.Result) {
+	elbClient, err := factory.ELB()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
 
-	instanceStates, err := getInstanceHealth()
+	instanceStates, err := getInstanceHealth(elbClient)
 	if err != nil {
-		fmt.Println("An issue occured while communicating with the AWS EC2 API,", err)
+		res.Unknown(elbName, "an issue occured while communicating with the AWS ELB API: %v", err)
 		return
 	}
-	checkUnhealthyInstances(instanceStates)
+	checkUnhealthyInstances(res, regions.Of(factory), instanceStates)
 }
 
-func getInstanceHealth() ([]*elb.InstanceState, error) {
+func getInstanceHealth(elbClient ELBClient) ([]*elb.InstanceState, error) {
 	instanceIdentifiers := strings.Split(instances, ",")
 	input := &elb.DescribeInstanceHealthInput{}
 	for _, instanceID := range instanceIdentifiers {
-		input.Instances = append(input.Instances, &elb.Instance{InstanceId: &instanceID})
+		if len(instanceID) > 0 {
+			input.Instances = append(input.Instances, &elb.Instance{InstanceId: aws.String(instanceID)})
+		}
 	}
 	input.LoadBalancerName = &elbName
 	output, err := elbClient.DescribeInstanceHealth(input)
@@ -78,7 +116,7 @@ func getInstanceHealth() ([]*elb.InstanceState, error) {
 	return output.InstanceStates, nil
 }
 
-func checkUnhealthyInstances(instanceStates []*elb.InstanceState) {
+func checkUnhealthyInstances(res *result.Result, region string, instanceStates []*elb.InstanceState) {
 	unhealthyInstances := make(map[string]string)
 	for _, instanceState := range instanceStates {
 		if *instanceState.State != "InService" {
@@ -86,49 +124,33 @@ func checkUnhealthyInstances(instanceStates []*elb.InstanceState) {
 		}
 	}
 
-	if unhealthyInstances == nil || len(unhealthyInstances) <= 0 {
-		fmt.Println("OK : All instances on ELB ", awsRegion, "::", elbName, "healthy!")
+	if len(unhealthyInstances) <= 0 {
+		res.SetOKMessage("All instances on ELB %s::%s healthy!", region, elbName)
 		return
 	}
 
 	if verbose {
-		fmt.Println("CRITICAL : Unhealthy instances detected:")
 		for id, state := range unhealthyInstances {
-			fmt.Println(id, "::", state)
+			res.Critical(id, "%s", state)
 		}
 	} else {
-		fmt.Println("CRITICAL : Detected ", len(unhealthyInstances), "unhealthy instances")
+		res.Critical(elbName, "Detected %d unhealthy instances", len(unhealthyInstances))
 	}
 }
 
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
+func validate() error {
+	if len(elbName) == 0 {
+		return errors.New("--elb-name is required")
 	}
-	checkHealth()
 	return nil
 }
 
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-elb-health-fog",
-		Short: "The Sensu Go Aws Load Balancer handler for health management",
-		RunE:  run,
-	}
-
-	cmd.Flags().StringVar(&awsRegion, "aws_region", "eu-west-1", "AWS Region (such as eu-west-1). If you do not specify a region, it will be detected by the server the script is run on")
-	cmd.Flags().StringVar(&elbName, "elb_name", "", "The Elastic Load Balancer name of which you want to check the health")
-	cmd.Flags().StringVar(&instances, "instances", "", "Comma separated list of specific instances IDs inside the ELB of which you want to check the health")
-	cmd.Flags().BoolVar(&verbose, "verbose", false, "Enable a little bit more verbose reports about instance health")
-	_ = cmd.MarkFlagRequired("elb_name")
-	return cmd
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkHealth)
 }
@@ -13,9 +13,9 @@ package main
 #   MAC OS
 #
 # USAGE:
-#   ./check-elb-health-sdk --aws_region=region
-#   ./check-elb-health-sdk --aws_region=region --elb_name=my-elb
-#   ./check-elb-health-sdk --aws_region=region --elb_name=my-elb --instances=instance1,instance2
+#   ./check-elb-health-sdk --aws-region=region
+#   ./check-elb-health-sdk --aws-region=region --elb-name=my-elb
+#   ./check-elb-health-sdk --aws-region=region --elb-name=my-elb --instances=instance1,instance2
 #
 # LICENSE
 #  TODO
@@ -24,64 +24,124 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"strings"
 
-	"github.com/spf13/cobra"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/events"
+	"github.com/sensu/sensu-aws/regions"
 
 	"github.com/aws/aws-sdk-go/aws"
 
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/utils"
 )
 
 var (
-	awsRegion   string
-	elbName     string
-	instances   string
-	verbose     bool
-	instanceTag string
-	warnOnly    bool
-	elbClient   *elb.ELB
-	ec2Client   *ec2.EC2
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	eventOptions   events.Options
+	pager          utils.Pager
+	elbName        string
+	instances      string
+	verbose        bool
+	instanceTag    string
+	warnOnly       bool
+
+	config = plugin.NewConfig("check-elb-health-sdk", "The Sensu Go Aws Load Balancer handler for health management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "elb-name",
+			Env:      "ELB_NAME",
+			Argument: "elb-name",
+			Default:  "",
+			Usage:    "The Elastic Load Balancer name of which you want to check the health",
+			Value:    &elbName,
+		},
+		{
+			Path:     "instances",
+			Env:      "INSTANCES",
+			Argument: "instances",
+			Default:  "",
+			Usage:    "Comma separated list of specific instances IDs inside the ELB of which you want to check the health",
+			Value:    &instances,
+		},
+		{
+			Path:     "verbose",
+			Env:      "VERBOSE",
+			Argument: "verbose",
+			Default:  false,
+			Usage:    "Enable a little bit more verbose reports about instance health",
+			Value:    &verbose,
+		},
+		{
+			Path:     "instance-tag",
+			Env:      "INSTANCE_TAG",
+			Argument: "instance-tag",
+			Default:  "Name",
+			Usage:    "Specify instance tag to be included in the check output. E.g. 'Name' tag",
+			Value:    &instanceTag,
+		},
+		{
+			Path:     "warn-only",
+			Env:      "WARN_ONLY",
+			Argument: "warn-only",
+			Default:  false,
+			Usage:    "Warn instead of critical when unhealthy instances are found",
+			Value:    &warnOnly,
+		},
+	}
 )
 
-func checkHealth() {
-	var success bool
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
-	success, ec2Client = awsclient.GetEC2Client(awsSession)
-	if !success {
+// EC2Client represents the EC2 dependencies of the check
+type EC2Client interface {
+	DescribeTagsPages(*ec2.DescribeTagsInput, func(*ec2.DescribeTagsOutput, bool) bool) error
+}
+
+// ELBClient represents the ELB dependencies of the check
+type ELBClient interface {
+	DescribeInstanceHealth(*elb.DescribeInstanceHealthInput) (*elb.DescribeInstanceHealthOutput, error)
+	DescribeLoadBalancersPages(*elb.DescribeLoadBalancersInput, func(*elb.DescribeLoadBalancersOutput, bool) bool) error
+}
+
+func checkHealth(factory *awsclient.Factory, res *result.Result) {
+	ec2Client, err := factory.EC2()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
-	success, elbClient = awsclient.GetElbClient(awsSession)
-	if !success {
+	elbClient, err := factory.ELB()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
-	elbs, err := getLoadBalancers()
-	if err != nil || elbs == nil {
+	elbs, err := getLoadBalancers(elbClient)
+	if err != nil {
+		res.Unknown("", "an issue occured while communicating with the AWS ELB API: %v", err)
+		return
+	}
+	if len(elbs) == 0 {
+		res.SetOKMessage("No Load Balancer found in region %s", regions.Of(factory))
 		return
 	}
-	checkInstanceHealth(elbs)
+	checkInstanceHealth(res, elbClient, ec2Client, elbs)
 }
 
-func getLoadBalancers() ([]string, error) {
+func getLoadBalancers(elbClient ELBClient) ([]string, error) {
 	input := &elb.DescribeLoadBalancersInput{}
-	output, err := elbClient.DescribeLoadBalancers(input)
+	loadBalancers, err := pager.LoadBalancers(elbClient, input)
 	if err != nil {
-		fmt.Println("An issue occured while communicating with the AWS EC2 API,", err)
 		return nil, err
 	}
-
-	if !(output != nil && output.LoadBalancerDescriptions != nil && len(output.LoadBalancerDescriptions) > 0) {
-		fmt.Println("No Load Balancer found in region -", awsRegion)
-		return nil, nil
-	}
 	elbs := []string{}
 	inlcudeElb := false
 	allElbs := []string{}
-	for _, loadbalancer := range output.LoadBalancerDescriptions {
+	for _, loadbalancer := range loadBalancers {
 		if len(elbName) > 0 && *loadbalancer.LoadBalancerName == elbName {
 			elbs = append(elbs, elbName)
 			inlcudeElb = true
@@ -95,23 +155,23 @@ func getLoadBalancers() ([]string, error) {
 	return elbs, nil
 }
 
-func checkInstanceHealth(elbs []string) {
-	critical := false
-	elbStateMapping := make(map[string]map[string]string)
+func checkInstanceHealth(res *result.Result, elbClient ELBClient, ec2Client EC2Client, elbs []string) {
+	status := result.Critical
+	if warnOnly {
+		status = result.Warning
+	}
 	for _, loadBalancer := range elbs {
 		unhealthyInstances := make(map[string]string)
-		instanceIdentifiers := strings.Split(instances, ",")
 		healtStatusInput := &elb.DescribeInstanceHealthInput{}
-		for _, instanceID := range instanceIdentifiers {
-			healtStatusInput.Instances = append(healtStatusInput.Instances, &elb.Instance{InstanceId: &instanceID})
+		for _, instanceID := range strings.Split(instances, ",") {
+			if len(instanceID) > 0 {
+				healtStatusInput.Instances = append(healtStatusInput.Instances, &elb.Instance{InstanceId: aws.String(instanceID)})
+			}
 		}
-		healtStatusInput.LoadBalancerName = &loadBalancer
+		healtStatusInput.LoadBalancerName = aws.String(loadBalancer)
 		healtStatusOutput, err := elbClient.DescribeInstanceHealth(healtStatusInput)
 		if err != nil {
-			fmt.Println("An issue occured while communicating with the AWS EC2 API,", err)
-			return
-		}
-		if !(healtStatusOutput != nil && healtStatusOutput.InstanceStates != nil && len(healtStatusOutput.InstanceStates) > 0) {
+			res.Unknown(loadBalancer, "an issue occured while communicating with the AWS ELB API: %v", err)
 			continue
 		}
 		for _, instanceState := range healtStatusOutput.InstanceStates {
@@ -123,82 +183,47 @@ func checkInstanceHealth(elbs []string) {
 				filter.Name = aws.String("resource-id")
 				filter.Values = []*string{instanceState.InstanceId}
 				tagInput.Filters = []*ec2.Filter{filter}
-				tagOutput, err := ec2Client.DescribeTags(tagInput)
+				tags, err := pager.Tags(ec2Client, tagInput)
 				if err != nil {
-					fmt.Println("An issue occured while communicating with the AWS EC2 API,", err)
-					return
+					res.Unknown(*instanceState.InstanceId, "an issue occured while communicating with the AWS EC2 API: %v", err)
+					continue
 				}
 
-				if tagOutput != nil && tagOutput.Tags != nil && len(tagOutput.Tags) > 0 {
-					for _, tag := range tagOutput.Tags {
-						if *tag.Key == instanceTag {
-							unhealthyInstances[*instanceState.InstanceId] = fmt.Sprintf("%s::%s", *tag.Value, *instanceState.State)
-							break
-						}
+				for _, tag := range tags {
+					if *tag.Key == instanceTag {
+						unhealthyInstances[*instanceState.InstanceId] = fmt.Sprintf("%s::%s", *tag.Value, *instanceState.State)
+						break
 					}
 				}
 			}
 		}
-		if unhealthyInstances != nil && len(unhealthyInstances) > 0 {
-			critical = true
-			elbStateMapping[loadBalancer] = unhealthyInstances
+		if len(unhealthyInstances) == 0 {
+			res.OK(loadBalancer, "%d instance(s) InService", len(healtStatusOutput.InstanceStates))
 			continue
 		}
-	}
-	if !critical && verbose {
-		fmt.Println("OK : All instances on all ELBs are healthy!")
-		return
-	}
-	if verbose {
-		if warnOnly {
-			fmt.Println("WARNING : Unhealthy instances detected: For Elbs")
-		} else {
-			fmt.Println("CRITICAL : Unhealthy instances detected: For Elbs")
-		}
-		for loadBlancer, instanceStates := range elbStateMapping {
-			fmt.Println("ELB : ", loadBlancer)
-			for instaceID, instanceState := range instanceStates {
-				fmt.Println(instaceID, "::", instanceState)
+		if verbose {
+			for instaceID, instanceState := range unhealthyInstances {
+				res.Add(status, loadBalancer, "%s::%s", instaceID, instanceState)
 			}
-		}
-	} else {
-		if warnOnly {
-			fmt.Println("WARNING : Detected ", len(elbStateMapping), "unhealthy elbs")
 		} else {
-			fmt.Println("CRITICAL : Detected ", len(elbStateMapping), "unhealthy elbs")
+			res.Add(status, loadBalancer, "%d unhealthy instance(s)", len(unhealthyInstances))
 		}
 	}
+	res.SetOKMessage("All instances on all ELBs are healthy!")
 }
 
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
-}
-
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
-	}
-	checkHealth()
-	return nil
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, eventOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, nil, run)
 }
 
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-elb-health-sdk",
-		Short: "The Sensu Go Aws Load Balancer handler for health management",
-		RunE:  run,
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkHealth)
+	pager.Report(res)
+	if err := eventOptions.Emit(config.Name, res); err != nil {
+		res.Error("", err)
 	}
-
-	cmd.Flags().StringVar(&awsRegion, "aws_region", "eu-west-1", "AWS Region (such as eu-west-1). If you do not specify a region, it will be detected by the server the script is run on")
-	cmd.Flags().StringVar(&elbName, "elb_name", "", "The Elastic Load Balancer name of which you want to check the health")
-	cmd.Flags().StringVar(&instances, "instances", "", "Comma separated list of specific instances IDs inside the ELB of which you want to check the health")
-	cmd.Flags().BoolVar(&verbose, "verbose", false, "Enable a little bit more verbose reports about instance health")
-	cmd.Flags().StringVar(&instanceTag, "instance_tag", "Name", "Specify instance tag to be included in the check output. E.g. 'Name' tag")
-	cmd.Flags().BoolVar(&warnOnly, "warn_only", false, "Warn instead of critical when unhealthy instances are found")
-	return cmd
 }
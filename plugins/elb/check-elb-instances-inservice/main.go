@@ -16,9 +16,9 @@ package main
 #
 # USAGE:
 #   all LoadBalancers
-#   ./check-elb-instance-inservice --aws_region=${your_region}
+#   ./check-elb-instance-inservice --aws-region=${your_region}
 #   one loadBalancer
-#   ./check-elb-instance-inservice --aws_region=${your_region} --elb_name=${LoadBalancerName}
+#   ./check-elb-instance-inservice --aws-region=${your_region} --elb-name=${LoadBalancerName}
 #
 # NOTES:
 #   Based heavily on Peter Hoppe check-autoscaling-instances-inservices
@@ -29,67 +29,80 @@ package main
 */
 
 import (
-	"fmt"
-	"os"
-
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/spf13/cobra"
 
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/regions"
 
-	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/utils"
 )
 
 var (
-	awsRegion string
-	elbName   string
-	elbClient *elb.ELB
-	ec2Client *ec2.EC2
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	pager          utils.Pager
+	elbName        string
+
+	config = plugin.NewConfig("check-elb-instances-inservice", "The Sensu Go Aws Load Balancer handler for instance state management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "elb-name",
+			Env:      "ELB_NAME",
+			Argument: "elb-name",
+			Default:  "",
+			Usage:    "The Elastic Load Balancer name of which you want to check the health",
+			Value:    &elbName,
+		},
+	}
 )
 
-func checkStatus() {
-	var success bool
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
-	success, elbClient = awsclient.GetElbClient(awsSession)
-	if !success {
+// ELBClient represents the ELB dependencies of the check
+type ELBClient interface {
+	DescribeInstanceHealth(*elb.DescribeInstanceHealthInput) (*elb.DescribeInstanceHealthOutput, error)
+	DescribeLoadBalancersPages(*elb.DescribeLoadBalancersInput, func(*elb.DescribeLoadBalancersOutput, bool) bool) error
+}
+
+func checkStatus(factory *awsclient.Factory, res *result.Result) {
+	elbClient, err := factory.ELB()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
-	success, ec2Client = awsclient.GetEC2Client(awsSession)
-	if !success {
+	loadBalancers, err := getLoadBalancers(elbClient)
+	if err != nil {
+		res.Unknown("", "an issue occured while communicating with the AWS ELB API: %v", err)
 		return
 	}
-	loadBalancers, err := getLoadBalancers()
-	if err != nil || loadBalancers == nil {
+	if len(loadBalancers) == 0 {
+		res.SetOKMessage("No Load Balancer found in region %s", regions.Of(factory))
 		return
 	}
-	checkInstanceHealth(loadBalancers)
+	checkInstanceHealth(res, elbClient, loadBalancers)
 }
 
-func getLoadBalancers() ([]*elb.LoadBalancerDescription, error) {
+func getLoadBalancers(elbClient ELBClient) ([]*elb.LoadBalancerDescription, error) {
 	input := &elb.DescribeLoadBalancersInput{}
 	if len(elbName) > 0 {
 		input.LoadBalancerNames = []*string{&elbName}
 	}
-	output, err := elbClient.DescribeLoadBalancers(input)
-	if err != nil {
-		fmt.Println("An issue occured while communicating with the AWS EC2 API,", err)
-		return nil, err
-	}
-
-	if !(output != nil && output.LoadBalancerDescriptions != nil && len(output.LoadBalancerDescriptions) > 0) {
-		fmt.Println("No Load Balancer found in region -", awsRegion)
-		return nil, nil
-	}
-	return output.LoadBalancerDescriptions, nil
+	return pager.LoadBalancers(elbClient, input)
 }
 
-func checkInstanceHealth(loadBalancers []*elb.LoadBalancerDescription) {
+func checkInstanceHealth(res *result.Result, elbClient ELBClient, loadBalancers []*elb.LoadBalancerDescription) {
 	for _, loadBalancer := range loadBalancers {
 		unhealthyInstances := make(map[string]string)
-		instanceStates, err := getHealthStatus(*loadBalancer.LoadBalancerName)
-		if err != nil || instanceStates == nil {
+		instanceStates, err := getHealthStatus(elbClient, *loadBalancer.LoadBalancerName)
+		if err != nil {
+			res.Unknown(*loadBalancer.LoadBalancerName, "an issue occured while communicating with the AWS ELB API: %v", err)
+			continue
+		}
+		if len(instanceStates) == 0 {
 			continue
 		}
 		for _, instanceState := range instanceStates {
@@ -98,61 +111,37 @@ func checkInstanceHealth(loadBalancers []*elb.LoadBalancerDescription) {
 			}
 		}
 		if len(unhealthyInstances) == 0 {
-			fmt.Println("OK : All instances of Load Balancer - ", *loadBalancer.LoadBalancerName, "are in healthy state")
+			res.OK(*loadBalancer.LoadBalancerName, "All instances are in healthy state")
 			continue
 		}
 		if len(unhealthyInstances) == len(instanceStates) {
-			fmt.Println("CRITICAL : All instances of Load Balancer - ", *loadBalancer.LoadBalancerName, "are in unhealthy state")
+			res.Critical(*loadBalancer.LoadBalancerName, "All instances are in unhealthy state")
 			continue
 		}
-		fmt.Println("WARNING : Unhealthy Instances for Load Balanacer - ", *loadBalancer.LoadBalancerName, "are")
 		for id, state := range unhealthyInstances {
-			fmt.Println("Instace - ", id, ":: State - ", state)
+			res.Warning(*loadBalancer.LoadBalancerName, "Instance %s :: State %s", id, state)
 		}
-
 	}
 }
 
-func getHealthStatus(elbName string) ([]*elb.InstanceState, error) {
+func getHealthStatus(elbClient ELBClient, elbName string) ([]*elb.InstanceState, error) {
 	healtStatusInput := &elb.DescribeInstanceHealthInput{}
 	healtStatusInput.LoadBalancerName = aws.String(elbName)
 	healtStatusOutput, err := elbClient.DescribeInstanceHealth(healtStatusInput)
 	if err != nil {
-		fmt.Println("An issue occured while communicating with the AWS EC2 API,", err)
 		return nil, err
 	}
-	if !(healtStatusOutput != nil && healtStatusOutput.InstanceStates != nil && len(healtStatusOutput.InstanceStates) > 0) {
-		return nil, nil
-	}
 	return healtStatusOutput.InstanceStates, nil
 }
 
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, nil, run)
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
-	}
-	checkStatus()
-	return nil
-}
-
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-elb-instances-inservice",
-		Short: "The Sensu Go Aws Load Balancer handler for instance state management",
-		RunE:  run,
-	}
-
-	cmd.Flags().StringVar(&awsRegion, "aws_region", "eu-west-1", "AWS Region (such as eu-west-1). If you do not specify a region, it will be detected by the server the script is run on")
-	cmd.Flags().StringVar(&elbName, "elb_name", "", "The Elastic Load Balancer name of which you want to check the health")
-
-	return cmd
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkStatus)
+	pager.Report(res)
 }
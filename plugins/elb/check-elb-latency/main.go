@@ -1,21 +1,21 @@
 package main
 
 import (
-	"fmt"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/sensu/sensu-aws/awsclient"
-	"github.com/spf13/cobra"
+	"github.com/sensu/sensu-aws/regions"
 
-	"github.com/aws/aws-sdk-go/aws"
-
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
-	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/metricdata"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/threshold"
+	"github.com/sensu/sensu-aws/utils"
 )
 
 /*
@@ -35,10 +35,13 @@ import (
 #
 # USAGE:
 #   Warning if any load balancer's latency is over 1 second, critical if over 3 seconds.
-#   ./check-elb-latency --warning_over=1 --critical_over=3
+#   ./check-elb-latency --warning-over=1 --critical-over=3
 #
 #   Critical if "app" load balancer's latency is over 5 seconds, maximum of last one hour
-#   ./check-elb-latency --elb_names=app --critical_over=5 --statistics=maximum --period=3600
+#   ./check-elb-latency --elb-names=app --critical-over=5 --statistics=maximum --period=3600
+#
+#   Critical if any load balancer's latency is over 2 seconds, warning if over 1 second
+#   ./check-elb-latency --warning-range=~:1 --critical-range=~:2
 #
 # NOTES:
 #
@@ -49,165 +52,201 @@ import (
 */
 
 var (
-	awsRegion        string
-	elbNames         string
-	period           int64
-	statistics       string
-	criticalOver     float64
-	warningOver      float64
-	elbClient        *elb.ELB
-	ec2Client        *ec2.EC2
-	cloudWatchClient *cloudwatch.CloudWatch
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	pager          utils.Pager
+	elbNames       string
+	period         int64
+	statistics     string
+	criticalOver   float64
+	warningOver    float64
+	warningRange   string
+	criticalRange  string
+
+	config = plugin.NewConfig("check-elb-latency", "The Sensu Go Aws Load Balancer handler for latency management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "elb-names",
+			Env:      "ELB_NAMES",
+			Argument: "elb-names",
+			Default:  "",
+			Usage:    "Load balancer names to check. Separated by ,. If not specified, check all load balancers",
+			Value:    &elbNames,
+		},
+		{
+			Path:     "period",
+			Env:      "PERIOD",
+			Argument: "period",
+			Default:  int64(60),
+			Usage:    "CloudWatch metric statistics period",
+			Value:    &period,
+		},
+		{
+			Path:     "statistics",
+			Env:      "STATISTICS",
+			Argument: "statistics",
+			Default:  "average",
+			Usage:    "CloudWatch statistic: average, maximum, minimum, samplecount, sum or a percentile such as p99",
+			Value:    &statistics,
+		},
+		{
+			Path:     "critical-over",
+			Env:      "CRITICAL_OVER",
+			Argument: "critical-over",
+			Default:  float64(60),
+			Usage:    "Trigger a critical severity if latancy is over specified seconds",
+			Value:    &criticalOver,
+		},
+		{
+			Path:     "warning-over",
+			Env:      "WARNING_OVER",
+			Argument: "warning-over",
+			Default:  float64(60),
+			Usage:    "Trigger a warning severity if latancy is over specified seconds",
+			Value:    &warningOver,
+		},
+		{
+			Path:     "warning-range",
+			Env:      "WARNING_RANGE",
+			Argument: "warning-range",
+			Default:  "",
+			Usage:    "Warning threshold range of the latency (e.g. 10:, ~:20, @5:10), overrides warning-over",
+			Value:    &warningRange,
+		},
+		{
+			Path:     "critical-range",
+			Env:      "CRITICAL_RANGE",
+			Argument: "critical-range",
+			Default:  "",
+			Usage:    "Critical threshold range of the latency (e.g. 10:, ~:20, @5:10), overrides critical-over",
+			Value:    &criticalRange,
+		},
+	}
 )
 
-func checkInstanceLatency() {
-	var awsSession *session.Session
-	var success bool
-	noOfHealthyElbs := 0
+// ELBClient represents the ELB dependencies of the check
+type ELBClient interface {
+	DescribeLoadBalancersPages(*elb.DescribeLoadBalancersInput, func(*elb.DescribeLoadBalancersOutput, bool) bool) error
+}
+
+// CloudWatchClient represents the CloudWatch dependencies of the check
+type CloudWatchClient interface {
+	GetMetricDataPages(*cloudwatch.GetMetricDataInput, func(*cloudwatch.GetMetricDataOutput, bool) bool) error
+}
+
+func checkInstanceLatency(factory *awsclient.Factory, res *result.Result) {
+	thresholds, err := getThresholds()
+	if err != nil {
+		res.Error("", err)
+		return
+	}
 	//aws session
-	awsSession = aws_session.CreateAwsSessionWithRegion(awsRegion)
-	success, elbClient = awsclient.GetElbClient(awsSession)
-	if !success {
+	elbClient, err := factory.ELB()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
-	success, elbs := getLoadBalancers()
-	if !success {
+	elbs, err := getLoadBalancers(elbClient)
+	if err != nil {
+		res.Unknown("", "an issue occured while communicating with the AWS ELB API: %v", err)
 		return
 	}
-	success, cloudWatchClient = awsclient.GetCloudWatchClient(awsSession)
-	if !success {
+	if len(elbs) == 0 {
+		res.SetOKMessage("No Load Balancer found in region %s", regions.Of(factory))
+		return
+	}
+	cloudWatchClient, err := factory.CloudWatch()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
+	batch := metricdata.NewBatch(time.Duration(period/60)*time.Minute, period)
+	keys := make(map[string]string)
 	for _, elb := range elbs {
-		value, startTime, endTime, err := getMetrics(elb)
-		if err != nil {
-			fmt.Println("Error while getting metrics for Load Balancer - ", elb, ", Error is ", err)
-			return
-		}
-		if value != nil {
-			checkLatency(*value, elb, *startTime, *endTime)
-			continue
-		}
-		noOfHealthyElbs++
+		keys[elb] = batch.Add(metricdata.Query{
+			Namespace:  "AWS/ELB",
+			MetricName: "Latency",
+			Dimensions: []*cloudwatch.Dimension{metricdata.Dimension("LoadBalancerName", elb)},
+			Stat:       statistics,
+			Unit:       "Seconds",
+		})[0]
+	}
+	series, err := batch.Fetch(cloudWatchClient)
+	if err != nil {
+		res.Unknown("", "error while getting metrics: %v", err)
+		return
 	}
-	if noOfHealthyElbs > 0 && noOfHealthyElbs == len(elbs) {
-		fmt.Println("OK : ALL load balancers are running with expected latency value")
+	startTime := batch.Start.Format(time.RFC3339)
+	endTime := batch.End.Format(time.RFC3339)
+	for _, elb := range elbs {
+		if point, err := series[keys[elb]].Latest(); err == nil {
+			checkLatency(res, thresholds, point.Value, elb, startTime, endTime)
+		}
 	}
+	res.SetOKMessage("ALL load balancers are running with expected latency value")
 }
 
-func getLoadBalancers() (bool, []string) {
+func getLoadBalancers(elbClient ELBClient) ([]string, error) {
 	selectedElbs := []string{}
 	input := &elb.DescribeLoadBalancersInput{}
-	elbs := strings.Split(elbNames, ",")
-
-	elbMap := make(map[string]*string)
 
-	for _, elbName := range elbs {
-		elbMap[elbName] = &elbName
+	elbMap := make(map[string]bool)
+	for _, elbName := range strings.Split(elbNames, ",") {
+		if len(elbName) > 0 {
+			elbMap[elbName] = true
+		}
 	}
 
-	noOfElbs := len(elbMap)
-
-	output, err := elbClient.DescribeLoadBalancers(input)
+	loadBalancers, err := pager.LoadBalancers(elbClient, input)
 	if err != nil {
-		fmt.Println("An issue occured while communicating with the AWS EC2 API,", err)
-		return false, nil
+		return nil, err
 	}
 
-	if !(output != nil && output.LoadBalancerDescriptions != nil && len(output.LoadBalancerDescriptions) > 0) {
-		fmt.Println("No Load Balancer found in region -", awsRegion)
-		return false, nil
-	}
-
-	for _, loadBalancer := range output.LoadBalancerDescriptions {
-		if noOfElbs > 0 && elbMap[*loadBalancer.LoadBalancerName] != nil {
+	for _, loadBalancer := range loadBalancers {
+		if len(elbMap) == 0 || elbMap[*loadBalancer.LoadBalancerName] {
 			selectedElbs = append(selectedElbs, *loadBalancer.LoadBalancerName)
 		}
 	}
-	return true, selectedElbs
+	return selectedElbs, nil
 }
 
-func getMetrics(elb string) (*float64, *string, *string, error) {
-	statistic := strings.Title(statistics)
-	metricInput := &cloudwatch.GetMetricStatisticsInput{}
-	metricInput.Namespace = aws.String("AWS/ELB")
-	metricInput.MetricName = aws.String("Latency")
-	dimension := &cloudwatch.Dimension{}
-	dimension.Name = aws.String("LoadBalancerName")
-	dimension.Value = &elb
-	metricInput.Dimensions = []*cloudwatch.Dimension{dimension}
-	metricInput.EndTime = aws.Time(time.Now())
-	metricInput.StartTime = aws.Time((*metricInput.EndTime).Add(time.Duration(-period/60) * time.Minute))
-	metricInput.Statistics = []*string{&statistic}
-	metricInput.Period = aws.Int64(period)
-	metricInput.Unit = aws.String("Seconds")
-	metrics, err := cloudWatchClient.GetMetricStatistics(metricInput)
-	if err != nil {
-		return nil, nil, nil, err
-	}
-	if metrics != nil && metrics.Datapoints != nil && len(metrics.Datapoints) > 1 {
-		var minimumTimeDifference float64
-		var timeDifference float64
-		var averageValue *float64
-		minimumTimeDifference = -1
-		for _, datapoint := range metrics.Datapoints {
-			timeDifference = time.Since(*datapoint.Timestamp).Seconds()
-			if minimumTimeDifference == -1 {
-				minimumTimeDifference = timeDifference
-				averageValue = datapoint.Average
-			} else if timeDifference < minimumTimeDifference {
-				minimumTimeDifference = timeDifference
-				averageValue = datapoint.Average
-			}
-		}
-		startTime := metricInput.StartTime.Format(time.RFC3339)
-		endTime := metricInput.EndTime.Format(time.RFC3339)
-		return averageValue, &startTime, &endTime, nil
+// check latency threshold
+func checkLatency(res *result.Result, thresholds threshold.Thresholds, value float64, elb string, startTime string, endTime string) {
+	if status := thresholds.Status(value); status != result.OK {
+		res.Add(status, elb, "Latency between %s and %s is %v (expected %s)", startTime, endTime, value, thresholds.Range(status))
 	}
-	return nil, nil, nil, nil
 }
 
-//check latency threshold
-func checkLatency(value float64, elb string, startTime string, endTime string) {
-	if value >= criticalOver {
-		fmt.Println("CRTICAL : Latency Value for Load Balancer - ", elb, " between ", startTime, " and ", endTime, " is ", value, "(expected lower than ", criticalOver, ")")
-		return
-	}
-	if value >= warningOver {
-		fmt.Println("WARNING : Latency Value for Load Balancer - ", elb, " between ", startTime, " and ", endTime, " is ", value, "(expected lower than ", criticalOver, ")")
-		return
+// getThresholds alerts when the latency reaches warning-over or critical-over
+// unless ranges are given
+func getThresholds() (threshold.Thresholds, error) {
+	thresholds, err := threshold.FromOperator("greater_equal", warningOver, criticalOver)
+	if err != nil {
+		return thresholds, err
 	}
+	err = thresholds.Override(warningRange, criticalRange)
+	return thresholds, err
 }
 
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
+func validate() error {
+	var err error
+	statistics, err = metricdata.ParseStat(statistics)
+	if err != nil {
+		return err
 	}
-	checkInstanceLatency()
-	return nil
+	_, err = getThresholds()
+	return err
 }
 
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-elb-latency",
-		Short: "The Sensu Go Aws Load Balancer handler for latency management",
-		RunE:  run,
-	}
-
-	cmd.Flags().StringVar(&awsRegion, "aws_region", "eu-east-1", "AWS Region (defaults to us-east-1).")
-	cmd.Flags().StringVar(&elbNames, "elb_names", "", "Load balancer names to check. Separated by ,. If not specified, check all load balancers")
-	cmd.Flags().Int64Var(&period, "period", 60, "CloudWatch metric statistics period")
-	cmd.Flags().StringVar(&statistics, "statistics", "average", "CloudWatch statistics method")
-	cmd.Flags().Float64Var(&criticalOver, "critical_over", 60, "Trigger a critical severity if latancy is over specified seconds")
-	cmd.Flags().Float64Var(&warningOver, "warning_over", 60, "Trigger a warning severity if latancy is over specified seconds")
-	return cmd
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkInstanceLatency)
+	pager.Report(res)
 }
@@ -1,19 +1,18 @@
 package main
 
 import (
-	"fmt"
-	"os"
-
-	"github.com/spf13/cobra"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/regions"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
 
 	"github.com/aws/aws-sdk-go/service/elb"
 
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
-
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/threshold"
 )
 
 /*
@@ -31,11 +30,14 @@ import (
 #   MAC OS
 #
 # USAGE:
-#   Warning if the load balancer has 3 or fewer healthy nodes and critical if 2 or fewer
-#   ./check-elb-nodes --warning=3 --critical=2 --load_balancer=#{your-load-balancer}
+#   Warning if the load balancer has fewer than 3 healthy nodes and critical if fewer than 2
+#   ./check-elb-nodes --warning=3 --critical=2 --load-balancer=#{your-load-balancer}
+#
+#   Warning if the load balancer has less than 50% healthy nodes and critical if less than 25%
+#   ./check-elb-nodes --warning-percentage=50 --critical-percentage=25 --load-balancer=#{your-load-balancer}
 #
-#   Warning if the load balancer has 50% or less healthy nodes and critical if 25% or less
-#   ./check-elb-nodes --warning_percentage=50 --critical_percentage=25 --load_balancer=#{your-load-balancer}
+#   Warning if the load balancer has fewer than 3 healthy nodes and critical if less than 25%
+#   ./check-elb-nodes --warning-range=3: --critical-range=25%: --load-balancer=#{your-load-balancer}
 #
 # NOTES:
 #
@@ -45,119 +47,178 @@ import (
 */
 
 var (
-	awsregion          string
+	sessionOptions     aws_session.Options
+	regionOptions      regions.Options
 	elbName            string
 	warning            int
 	critical           int
 	warningPercentage  float64
 	criticalPercentage float64
-	elbClient          *elb.ELB
+	warningRange       string
+	criticalRange      string
+
+	config = plugin.NewConfig("check-elb-nodes", "The Sensu Go Aws Load Balancer handler for node management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "load-balancer",
+			Env:      "LOAD_BALANCER",
+			Argument: "load-balancer",
+			Default:  "",
+			Usage:    "The name of the ELB",
+			Value:    &elbName,
+		},
+		{
+			Path:     "warning",
+			Env:      "WARNING",
+			Argument: "warning",
+			Default:  -1,
+			Usage:    "Minimum number of nodes InService on the ELB to be considered a warning",
+			Value:    &warning,
+		},
+		{
+			Path:     "critical",
+			Env:      "CRITICAL",
+			Argument: "critical",
+			Default:  -1,
+			Usage:    "Minimum number of nodes InService on the ELB to be considered critical",
+			Value:    &critical,
+		},
+		{
+			Path:     "warning-percentage",
+			Env:      "WARNING_PERCENTAGE",
+			Argument: "warning-percentage",
+			Default:  float64(-1),
+			Usage:    "Warn when the percentage of InService nodes is below this number",
+			Value:    &warningPercentage,
+		},
+		{
+			Path:     "critical-percentage",
+			Env:      "CRITICAL_PERCENTAGE",
+			Argument: "critical-percentage",
+			Default:  float64(-1),
+			Usage:    "CRITICAL when the percentage of InService nodes is below this number",
+			Value:    &criticalPercentage,
+		},
+		{
+			Path:     "warning-range",
+			Env:      "WARNING_RANGE",
+			Argument: "warning-range",
+			Default:  "",
+			Usage:    "Warning threshold range of the InService nodes, a count or a percentage (e.g. 3:, 50%:)",
+			Value:    &warningRange,
+		},
+		{
+			Path:     "critical-range",
+			Env:      "CRITICAL_RANGE",
+			Argument: "critical-range",
+			Default:  "",
+			Usage:    "Critical threshold range of the InService nodes, a count or a percentage (e.g. 2:, 25%:)",
+			Value:    &criticalRange,
+		},
+	}
 )
 
-func checkNodes() {
-	var awsSession *session.Session
-	var success bool
+// ELBClient represents the ELB dependencies of the check
+type ELBClient interface {
+	DescribeInstanceHealth(*elb.DescribeInstanceHealthInput) (*elb.DescribeInstanceHealthOutput, error)
+}
+
+func checkNodes(factory *awsclient.Factory, res *result.Result) {
 	if len(elbName) <= 0 {
-		fmt.Println("Please enter a load balance name")
+		res.Unknown("", "please enter a load balancer name")
+		return
+	}
+	rangeThresholds, err := threshold.New(warningRange, criticalRange)
+	if err != nil {
+		res.Error("", err)
 		return
 	}
-	if (critical == -1 || warning == -1) && (criticalPercentage == -1 || warningPercentage == -1) {
-		fmt.Println("please enter (critical and warning non zero positive value) and/or (critical percentage and warning percentage non zero positive value)")
+	hasRanges := rangeThresholds.Warning != nil || rangeThresholds.Critical != nil
+	if !hasRanges && (critical == -1 || warning == -1) && (criticalPercentage == -1 || warningPercentage == -1) {
+		res.Unknown("", "please enter (critical and warning non zero positive value) and/or (critical percentage and warning percentage non zero positive value)")
 		return
 	}
-	awsSession = aws_session.CreateAwsSessionWithRegion(awsregion)
-	success, elbClient = awsclient.GetElbClient(awsSession)
-	if !success {
+	elbClient, err := factory.ELB()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
-	instanceStates, err := getInstanceHealth()
-	if err != nil || instanceStates == nil {
+	instanceStates, err := getInstanceHealth(elbClient)
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "LoadBalancerNotFound" {
+			res.Critical(elbName, "%s", awsErr.Message())
+			return
+		}
+		res.Unknown(elbName, "an issue occured while communicating with the AWS ELB API: %v", err)
 		return
 	}
-	checkInstanceHealth(instanceStates)
+	checkInstanceHealth(res, rangeThresholds, instanceStates)
 }
 
-func getInstanceHealth() ([]*elb.InstanceState, error) {
+func getInstanceHealth(elbClient ELBClient) ([]*elb.InstanceState, error) {
 	input := &elb.DescribeInstanceHealthInput{}
 	input.LoadBalancerName = &elbName
 	output, err := elbClient.DescribeInstanceHealth(input)
-	if err != nil && err.(awserr.Error).Code() == "LoadBalancerNotFound" {
-		fmt.Println(err.(awserr.Error).Message())
+	if err != nil {
 		return nil, err
-	} else if err != nil {
-		fmt.Println("An issue occured while communicating with the AWS EC2 API,", err)
-		return nil, err
-	}
-	if !(output != nil && output.InstanceStates != nil && len(output.InstanceStates) > 0) {
-		return nil, nil
 	}
 	return output.InstanceStates, nil
 }
 
-func checkInstanceHealth(instanceStates []*elb.InstanceState) {
-	var pecentage float64
+func checkInstanceHealth(res *result.Result, rangeThresholds threshold.Thresholds, instanceStates []*elb.InstanceState) {
 	instancesStatesCountMap := make(map[string]int)
 	for _, instanceState := range instanceStates {
 		instancesStatesCountMap[*instanceState.State] = instancesStatesCountMap[*instanceState.State] + 1
 	}
 	if len(instancesStatesCountMap) <= 0 {
-		fmt.Println("Load Balance - ", elbName, " does not have any node")
+		res.Critical(elbName, "Load Balancer does not have any node")
 		return
 	}
-	for state, count := range instancesStatesCountMap {
-		message := fmt.Sprintf("%d number of instances are in state %s", count, state)
-		fmt.Println(message)
+	inService := instancesStatesCountMap["InService"]
+	total := float64(len(instanceStates))
+	countThresholds := nodeThresholds(float64(warning), float64(critical), false)
+	if status := countThresholds.StatusOf(float64(inService), total); status != result.OK {
+		res.Add(status, elbName, "%d number of instances are in state InService (expected %s)", inService, countThresholds.Range(status))
 	}
-	if critical > 0 && instancesStatesCountMap["InService"] < critical {
-		message := fmt.Sprintf("CRITICAL : %d number of instances are in state %s ", instancesStatesCountMap["InService"], "InService")
-		fmt.Println(message)
-	} else if warning > 0 && instancesStatesCountMap["InService"] < warning {
-		message := fmt.Sprintf("WARNING : %d number of instances are in state %s ", instancesStatesCountMap["InService"], "InService")
-		fmt.Println(message)
+	percentThresholds := nodeThresholds(warningPercentage, criticalPercentage, true)
+	if status := percentThresholds.StatusOf(float64(inService), total); status != result.OK {
+		res.Add(status, elbName, "%v percentage are in state InService (expected %s)", float64(inService)/total*100, percentThresholds.Range(status))
 	}
-	if criticalPercentage > 0 || warningPercentage > 0 {
-		pecentage = float64(instancesStatesCountMap["InService"]) / float64(len(instanceStates))
-		pecentage = pecentage * 100
+	if status := rangeThresholds.StatusOf(float64(inService), total); status != result.OK {
+		res.Add(status, elbName, "%d number of instances are in state InService (expected %s)", inService, rangeThresholds.Range(status))
 	}
-	if criticalPercentage > 0 && pecentage < criticalPercentage {
-		message := fmt.Sprintf("CRITICAL : %v percentage are in state %s ", pecentage, "InService")
-		fmt.Println(message)
-	} else if warningPercentage > 0 && pecentage < warningPercentage {
-		message := fmt.Sprintf("WARNING : %v percentage are in state %s ", pecentage, "InService")
-		fmt.Println(message)
+	for state, count := range instancesStatesCountMap {
+		res.OK(elbName, "%d number of instances are in state %s", count, state)
 	}
 }
 
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
-	}
-	checkNodes()
-	return nil
+func validate() error {
+	_, err := threshold.New(warningRange, criticalRange)
+	return err
 }
 
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-elb-nodes",
-		Short: "The Sensu Go Aws Load Balancer handler for node management",
-		RunE:  run,
-	}
-
-	cmd.Flags().StringVar(&awsregion, "aws_region", "us-east-1", "AWS Region (defaults to us-east-1).")
-	cmd.Flags().StringVar(&elbName, "load_balancer", "", "The name of the ELB")
-	cmd.Flags().IntVar(&warning, "warning", -1, "Minimum number of nodes InService on the ELB to be considered a warning")
-	cmd.Flags().IntVar(&critical, "critical", -1, "Minimum number of nodes InService on the ELB to be considered critical")
-	cmd.Flags().Float64Var(&warningPercentage, "warning_percentage", -1, "Warn when the percentage of InService nodes is at or below this number")
-	cmd.Flags().Float64Var(&criticalPercentage, "critical_percentage", -1, "CRITICAL when the percentage of InService nodes is at or below this number")
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkNodes)
+}
 
-	return cmd
+// nodeThresholds alerts when the InService nodes are below the given values,
+// non positive values disable the threshold
+func nodeThresholds(warning float64, critical float64, percent bool) threshold.Thresholds {
+	thresholds := threshold.Under(warning, critical)
+	thresholds.Warning.Percent = percent
+	thresholds.Critical.Percent = percent
+	if warning <= 0 {
+		thresholds.Warning = nil
+	}
+	if critical <= 0 {
+		thresholds.Critical = nil
+	}
+	return thresholds
 }
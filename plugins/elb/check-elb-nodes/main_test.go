@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/sensu/sensu-aws/awstest"
+)
+
+func TestMain(m *testing.M) {
+	awstest.Main(m, main)
+}
+
+func TestPlugin(t *testing.T) {
+	server := awstest.NewServer()
+	defer server.Close()
+
+	// 2 of 4 nodes, 50%, are InService
+	state := func(id string, state string) *elb.InstanceState {
+		return &elb.InstanceState{InstanceId: aws.String(id), State: aws.String(state)}
+	}
+	server.Respond(awstest.ELB, "DescribeInstanceHealth", &elb.DescribeInstanceHealthOutput{
+		InstanceStates: []*elb.InstanceState{
+			state("i-1", "InService"),
+			state("i-2", "InService"),
+			state("i-3", "OutOfService"),
+			state("i-4", "OutOfService"),
+		},
+	})
+
+	tests := []struct {
+		Name      string
+		Args      []string
+		ExpStatus int
+		ExpOutput string
+	}{
+		{
+			Name:      "count at threshold",
+			Args:      []string{"--warning", "2", "--critical", "1"},
+			ExpStatus: 0,
+		},
+		{
+			Name:      "count below warning",
+			Args:      []string{"--warning", "3", "--critical", "2"},
+			ExpStatus: 1,
+			ExpOutput: "check-elb-nodes WARNING: web: 2 number of instances are in state InService (expected 3:)",
+		},
+		{
+			Name:      "count below critical",
+			Args:      []string{"--warning", "4", "--critical", "3"},
+			ExpStatus: 2,
+			ExpOutput: "check-elb-nodes CRITICAL: web: 2 number of instances are in state InService (expected 3:)",
+		},
+		{
+			Name:      "percentage at threshold",
+			Args:      []string{"--warning-percentage", "50", "--critical-percentage", "25"},
+			ExpStatus: 0,
+		},
+		{
+			Name:      "percentage below warning",
+			Args:      []string{"--warning-percentage", "60", "--critical-percentage", "25"},
+			ExpStatus: 1,
+			ExpOutput: "check-elb-nodes WARNING: web: 50 percentage are in state InService (expected 60%:)",
+		},
+		{
+			Name:      "count range at threshold",
+			Args:      []string{"--warning-range", "2:", "--critical-range", "25%:"},
+			ExpStatus: 0,
+		},
+		{
+			Name:      "percentage range below critical",
+			Args:      []string{"--warning-range", "2:", "--critical-range", "75%:"},
+			ExpStatus: 2,
+			ExpOutput: "check-elb-nodes CRITICAL: web: 2 number of instances are in state InService (expected 75%:)",
+		},
+		{
+			Name:      "invalid range",
+			Args:      []string{"--warning-range", "5:1"},
+			ExpStatus: 3,
+		},
+		{
+			Name:      "no thresholds",
+			Args:      []string{},
+			ExpStatus: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			output, status := server.Exec(t, append([]string{"--load-balancer", "web"}, test.Args...)...)
+			if got, want := status, test.ExpStatus; got != want {
+				t.Errorf("bad exit status: got %d, want %d (output %q)", got, want, output)
+			}
+			if !strings.HasPrefix(output, test.ExpOutput) {
+				t.Errorf("bad output: got %q, want prefix %q", output, test.ExpOutput)
+			}
+		})
+	}
+}
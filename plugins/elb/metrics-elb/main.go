@@ -1,20 +1,20 @@
 package main
 
 import (
-	"fmt"
-	"os"
 	"time"
 
-	"github.com/spf13/cobra"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/regions"
 
-	"github.com/aws/aws-sdk-go/aws"
-
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
-	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/metric"
+	"github.com/sensu/sensu-aws/metricdata"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/utils"
 )
 
 /*
@@ -53,25 +53,87 @@ const (
 )
 
 var (
-	awsRegion    string
-	elbName      string
-	period       int64
-	criticalOver float64
-	warningOver  float64
-	fetchAge     int64
-	//scheme           string
-	elbClient        *elb.ELB
-	ec2Client        *ec2.EC2
-	cloudWatchClient *cloudwatch.CloudWatch
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	pager          utils.Pager
+	elbName        string
+	period         int64
+	criticalOver   float64
+	warningOver    float64
+	fetchAge       int64
+	scheme         string
+	metricFormat   string
+	output         *metric.Writer
+
+	config = plugin.NewConfig("metrics-elb", "The Sensu Go Aws Load Balancer handler for metrics management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "elb-name",
+			Env:      "ELB_NAME",
+			Argument: "elb-name",
+			Default:  "",
+			Usage:    "Name of the Elastic Load Balancer",
+			Value:    &elbName,
+		},
+		{
+			Path:     "scheme",
+			Env:      "SCHEME",
+			Argument: "scheme",
+			Default:  "sensu.aws.elb",
+			Usage:    "Metric naming scheme, text to prepend to metric",
+			Value:    &scheme,
+		},
+		{
+			Path:     "period",
+			Env:      "PERIOD",
+			Argument: "period",
+			Default:  int64(60),
+			Usage:    "CloudWatch metric statistics period",
+			Value:    &period,
+		},
+		{
+			Path:     "fetch-age",
+			Env:      "FETCH_AGE",
+			Argument: "fetch-age",
+			Default:  int64(60),
+			Usage:    "How long ago to fetch metrics for in seconds",
+			Value:    &fetchAge,
+		},
+		{
+			Path:     "critical-over",
+			Env:      "CRITICAL_OVER",
+			Argument: "critical-over",
+			Default:  float64(60),
+			Usage:    "Trigger a critical severity if latancy is over specified seconds",
+			Value:    &criticalOver,
+		},
+		{
+			Path:     "warning-over",
+			Env:      "WARNING_OVER",
+			Argument: "warning-over",
+			Default:  float64(60),
+			Usage:    "Trigger a warning severity if latancy is over specified seconds",
+			Value:    &warningOver,
+		},
+	}
 )
 
-func metrics() {
-	var awsSession *session.Session
-	var success bool
+// ELBClient represents the ELB dependencies of the check
+type ELBClient interface {
+	DescribeLoadBalancersPages(*elb.DescribeLoadBalancersInput, func(*elb.DescribeLoadBalancersOutput, bool) bool) error
+}
+
+// CloudWatchClient represents the CloudWatch dependencies of the check
+type CloudWatchClient interface {
+	GetMetricDataPages(*cloudwatch.GetMetricDataInput, func(*cloudwatch.GetMetricDataOutput, bool) bool) error
+}
+
+func metrics(factory *awsclient.Factory, res *result.Result) {
 	var elb *string
-	awsSession = aws_session.CreateAwsSessionWithRegion(awsRegion)
-	success, elbClient = awsclient.GetElbClient(awsSession)
-	if !success {
+	elbClient, err := factory.ELB()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
 	if len(elbName) > 0 {
@@ -79,93 +141,65 @@ func metrics() {
 	} else {
 		elb = nil
 	}
-	success, elbs := getLoadBalancers(elb)
-	if !success {
+	elbs, err := getLoadBalancers(elbClient, elb)
+	if err != nil {
+		res.Unknown("", "an issue occured while communicating with the AWS ELB API: %v", err)
 		return
 	}
-	success, cloudWatchClient = awsclient.GetCloudWatchClient(awsSession)
-	if !success {
+	cloudWatchClient, err := factory.CloudWatch()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
+	tags := regionOptions.MetricTags(factory)
 	metrics := getMetrics()
+	batch := metricdata.NewFetchBatch(time.Duration(fetchAge)*time.Second, period)
+	keys := make(map[string][]string)
+	for _, loadBalancer := range elbs {
+		for _, metricName := range metrics {
+			keys[loadBalancer] = append(keys[loadBalancer], batch.Add(metricQuery(loadBalancer, metricName))...)
+		}
+	}
+	if batch.Len() == 0 {
+		return
+	}
+	series, err := batch.Fetch(cloudWatchClient)
+	if err != nil {
+		res.Unknown("", "error while getting metric data: %v", err)
+		return
+	}
 	for _, loadBalancer := range elbs {
-		for _, metric := range metrics {
-			printStatistic(loadBalancer, metric, getMetricStatisticMapping(metric))
+		for i, metricName := range metrics {
+			if point, err := series[keys[loadBalancer][i]].Latest(); err == nil {
+				output.Add(metricName, point.Value, point.Timestamp, append(tags, metric.Tag{Name: "LoadBalancerName", Value: loadBalancer})...)
+			}
 		}
 	}
 }
 
-func getLoadBalancers(elbName *string) (bool, []string) {
+func getLoadBalancers(elbClient ELBClient, elbName *string) ([]string, error) {
 	selectedElbs := []string{}
 	input := &elb.DescribeLoadBalancersInput{}
 	if elbName != nil {
 		input.LoadBalancerNames = []*string{elbName}
 	}
-	output, err := elbClient.DescribeLoadBalancers(input)
+	loadBalancers, err := pager.LoadBalancers(elbClient, input)
 	if err != nil {
-		fmt.Println("An issue occured while communicating with the AWS EC2 API,", err)
-		return false, nil
-	}
-
-	if !(output != nil && output.LoadBalancerDescriptions != nil && len(output.LoadBalancerDescriptions) > 0) {
-		fmt.Println("No Load Balancer found in region -", awsRegion)
-		return false, nil
+		return nil, err
 	}
 
-	for _, loadBalancer := range output.LoadBalancerDescriptions {
+	for _, loadBalancer := range loadBalancers {
 		selectedElbs = append(selectedElbs, *loadBalancer.LoadBalancerName)
 	}
-	return true, selectedElbs
+	return selectedElbs, nil
 }
 
-func printStatistic(elb string, metricName string, statistic string) {
-	metricInput := &cloudwatch.GetMetricStatisticsInput{}
-	metricInput.Namespace = aws.String("AWS/ELB")
-	metricInput.MetricName = aws.String(metricName)
-	dimension := &cloudwatch.Dimension{}
-	dimension.Name = aws.String("LoadBalancerName")
-	dimension.Value = &elb
-	metricInput.Dimensions = []*cloudwatch.Dimension{dimension}
-	metricInput.EndTime = aws.Time(time.Now().Add(time.Duration(-fetchAge/60) * time.Minute))
-	metricInput.StartTime = aws.Time((*metricInput.EndTime).Add(time.Duration(-period/60) * time.Minute))
-	metricInput.Statistics = []*string{&statistic}
-	metricInput.Period = aws.Int64(period)
-	metrics, err := cloudWatchClient.GetMetricStatistics(metricInput)
-	if err != nil {
-		fmt.Println("Error while getting", statistic, "value for metric", metricName)
-		return
-	}
-	if metrics != nil && metrics.Datapoints != nil && len(metrics.Datapoints) > 1 {
-		var minimumTimeDifference float64
-		var timeDifference float64
-		var value *float64
-		var timestamp time.Time
-		minimumTimeDifference = -1
-		for _, datapoint := range metrics.Datapoints {
-			timeDifference = time.Since(*datapoint.Timestamp).Seconds()
-			if minimumTimeDifference == -1 {
-				minimumTimeDifference = timeDifference
-				if statistic == "Average" {
-					value = datapoint.Average
-				} else if statistic == SumString {
-					value = datapoint.Sum
-				} else if statistic == MaxString {
-					value = datapoint.Maximum
-				}
-				timestamp = *datapoint.Timestamp
-			} else if timeDifference < minimumTimeDifference {
-				minimumTimeDifference = timeDifference
-				if statistic == "Average" {
-					value = datapoint.Average
-				} else if statistic == SumString {
-					value = datapoint.Sum
-				} else if statistic == MaxString {
-					value = datapoint.Maximum
-				}
-				timestamp = *datapoint.Timestamp
-			}
-		}
-		fmt.Println("Load Balancer :", elb, ", Statistic :", statistic, ", Metric :", metricName, ", Latest Value :", value, ", Timestamp : ", timestamp.Format(time.RFC3339))
+func metricQuery(elb string, metricName string) metricdata.Query {
+	return metricdata.Query{
+		Namespace:  "AWS/ELB",
+		MetricName: metricName,
+		Dimensions: []*cloudwatch.Dimension{metricdata.Dimension("LoadBalancerName", elb)},
+		Stat:       getMetricStatisticMapping(metricName),
 	}
 }
 
@@ -196,36 +230,23 @@ func getMetrics() []string {
 }
 
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
+	options = append(options, metric.FormatOption(&metricFormat))
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Metrics(config, options, nil, run)
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
+func run(res *result.Result) {
+	var err error
+	output, err = metric.NewWriter(metricFormat, scheme)
+	if err != nil {
+		res.Error("", err)
+		return
 	}
-	metrics()
-	return nil
-}
-
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-elb-metrics",
-		Short: "The Sensu Go Aws Load Balancer handler for metrics management",
-		RunE:  run,
+	regionOptions.Run(res, sessionOptions, metrics)
+	pager.Report(res)
+	if err := output.Flush(); err != nil {
+		res.Error("", err)
 	}
-
-	cmd.Flags().StringVar(&awsRegion, "aws_region", "eu-east-1", "AWS Region (defaults to us-east-1).")
-	cmd.Flags().StringVar(&elbName, "elb_name", "", "Name of the Elastic Load Balancer")
-	//flag.StringVar(&scheme, "scheme", "elb", "Metric naming scheme, text to prepend to metric")
-	cmd.Flags().Int64Var(&period, "period", 60, "CloudWatch metric statistics period")
-	cmd.Flags().Int64Var(&fetchAge, "fetch_age", 60, "How long ago to fetch metrics for in seconds")
-	cmd.Flags().Float64Var(&criticalOver, "critical_over", 60, "Trigger a critical severity if latancy is over specified seconds")
-	cmd.Flags().Float64Var(&warningOver, "warning_over", 60, "Trigger a warning severity if latancy is over specified seconds")
-
-	return cmd
 }
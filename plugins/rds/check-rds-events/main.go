@@ -23,10 +23,13 @@ package main
 #
 # USAGE:
 #  Check's a specific RDS instance in a specific region for critical events
-#  ./check-rds-events --aws_region=${your_region}  --db_instance_id=${your_rds_instance_id_name}
+#  ./check-rds-events --aws-region=${your_region}  --db-instance-id=${your_rds_instance_id_name}
 #
 #  Checks all RDS instances in a specific region
-#  ./check-rds-events.rb --aws_region=${your_region}
+#  ./check-rds-events.rb --aws-region=${your_region}
+#
+#  Checks all RDS instances in every region enabled for the account
+#  ./check-rds-events --all-regions
 #
 #
 # NOTES:
@@ -38,82 +41,110 @@ package main
 */
 
 import (
-	"fmt"
-	"os"
 	"regexp"
 	"time"
 
-	"github.com/spf13/cobra"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/regions"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/utils"
 )
 
 var (
-	awsRegion    string
-	dbInstanceId string
-	ec2Client    *ec2.EC2
-	rdsClient    *rds.RDS
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	pager          utils.Pager
+	dbInstanceId   string
+
+	config = plugin.NewConfig("check-rds-events", "The Sensu Go Aws RDS handler for rds events management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:      "db-instance-id",
+			Env:       "DB_INSTANCE_ID",
+			Argument:  "db-instance-id",
+			Shorthand: "d",
+			Default:   "",
+			Usage:     "DB instance identifier",
+			Value:     &dbInstanceId,
+		},
+	}
 )
 
-func checkRdsEvents() {
-	var success bool
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
-	success, ec2Client = awsclient.GetEC2Client(awsSession)
-	if !success {
+// EC2Client represents the EC2 dependencies of the check
+type EC2Client interface {
+	DescribeRegions(*ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error)
+}
+
+// RDSClient represents the RDS dependencies of the check
+type RDSClient interface {
+	DescribeDBInstancesPages(*rds.DescribeDBInstancesInput, func(*rds.DescribeDBInstancesOutput, bool) bool) error
+	DescribeEventsPages(*rds.DescribeEventsInput, func(*rds.DescribeEventsOutput, bool) bool) error
+}
+
+func checkRdsEvents(factory *awsclient.Factory, res *result.Result) {
+	ec2Client, err := factory.EC2()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
-	resultRegions, err := ec2Client.DescribeRegions(nil)
+	describedRegions, err := ec2Client.DescribeRegions(nil)
 	if err != nil {
-		fmt.Println("Error", err)
+		res.Error("", err)
 		return
 	}
 	validRegion := false
-	if resultRegions != nil && resultRegions.Regions != nil && len(resultRegions.Regions) > 0 {
-		for _, region := range resultRegions.Regions {
-			if *region.RegionName == awsRegion {
-				validRegion = true
-				break
-			}
+	for _, region := range describedRegions.Regions {
+		if *region.RegionName == regions.Of(factory) {
+			validRegion = true
+			break
 		}
 	}
 	if !validRegion {
-		fmt.Println("CRITICAL : Invalid region specified!")
+		res.Critical("", "Invalid region specified!")
 		return
 	}
-	success, rdsClient = awsclient.GetRDSClient(awsSession)
-	if !success {
+	rdsClient, err := factory.RDS()
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	clusters, err := getClusters(rdsClient)
+	if err != nil {
+		res.Unknown("", "an error occurred processing AWS RDS API DescribeDBInstances: %v", err)
 		return
 	}
-	clusters, err := getClusters()
-	if err != nil || (!(clusters != nil && len(clusters) > 0)) {
+	if len(clusters) == 0 {
+		if len(dbInstanceId) > 0 {
+			res.Unknown(dbInstanceId, "instance not found")
+		}
 		return
 	}
-	checkEvents(clusters)
+	checkEvents(res, rdsClient, clusters)
+	res.SetOKMessage("No critical events for %d DB instance(s)", len(clusters))
 }
 
-func checkEvents(clusters []string) {
-	criticalClusters := []string{}
+func checkEvents(res *result.Result, rdsClient RDSClient, clusters []string) {
 	for _, cluster := range clusters {
 		eventInput := &rds.DescribeEventsInput{}
 		eventInput.SourceType = aws.String("db-instance")
-		eventInput.SourceIdentifier = &cluster
+		eventInput.SourceIdentifier = aws.String(cluster)
 		eventInput.StartTime = aws.Time(time.Now().Add(time.Duration(-24*60) * time.Minute))
-		eventOutput, err := rdsClient.DescribeEvents(eventInput)
+		events, err := pager.Events(rdsClient, eventInput)
 
 		if err != nil {
-			fmt.Println("Error occurred while getting rds event details for db instance -", cluster, ",Error -", err)
-			return
-		}
-
-		if eventOutput == nil || eventOutput.Events == nil || len(eventOutput.Events) == 0 {
+			res.Unknown(cluster, "error occurred while getting rds event details: %v", err)
 			continue
 		}
 
-		for _, event := range eventOutput.Events {
+		for _, event := range events {
 			// we will need to filter out non-disruptive/basic operation events.
 			//ie. the regular backup operations
 			match, _ := regexp.MatchString("Backing up DB instance", *event.Message)
@@ -143,89 +174,39 @@ func checkEvents(clusters []string) {
 			// you can add more filters to skip more events.
 
 			// draft the messages
-			criticalClusters = append(criticalClusters, fmt.Sprintf("%s : %s \n", cluster, *event.Message))
+			res.Critical(cluster, "%s", *event.Message)
 		}
 	}
-	if len(criticalClusters) > 0 {
-		fmt.Println("CRITICAL : Clusters w/ critical events :", criticalClusters)
-	}
 }
 
-func getClusters() ([]string, error) {
+func getClusters(rdsClient RDSClient) ([]string, error) {
 	clusters := []string{}
 	dbInstanceInput := &rds.DescribeDBInstancesInput{}
-	filter := &rds.Filter{}
 	if len(dbInstanceId) > 0 {
+		filter := &rds.Filter{}
 		filter.Name = aws.String("db-instance-id")
 		filter.Values = []*string{aws.String(dbInstanceId)}
 		dbInstanceInput.Filters = []*rds.Filter{filter}
 	}
-	dbClusterOutput, err := rdsClient.DescribeDBInstances(dbInstanceInput)
+	dbInstances, err := pager.DBInstances(rdsClient, dbInstanceInput)
 	if err != nil {
-		fmt.Println("An error occurred processing AWS RDS API DescribeDBInstances", err)
 		return nil, err
 	}
 
-	if dbClusterOutput != nil && dbClusterOutput.DBInstances != nil && len(dbClusterOutput.DBInstances) > 0 {
-		clusters = append(clusters, dbInstanceId)
-	} else {
-		fmt.Println("UNKNOWN :", dbInstanceId, "instance not found")
-		return nil, nil
-	}
-
-	if len(dbInstanceId) == 0 {
-		filter = &rds.Filter{}
-		dbInstanceInput.Filters = []*rds.Filter{filter}
-		dbClusterOutput, err = rdsClient.DescribeDBInstances(dbInstanceInput)
-	}
-
-	if err != nil {
-		fmt.Println("An error occurred processing AWS RDS API DescribeDBInstances", err)
-		return nil, err
-	}
-
-	if dbClusterOutput != nil && dbClusterOutput.DBInstances != nil && len(dbClusterOutput.DBInstances) > 0 {
-		for _, dbInstance := range dbClusterOutput.DBInstances {
-			clusters = append(clusters, *dbInstance.DBInstanceIdentifier)
-		}
+	for _, dbInstance := range dbInstances {
+		clusters = append(clusters, *dbInstance.DBInstanceIdentifier)
 	}
 	return clusters, nil
 }
 
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
-}
-
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
-	}
-	checkRdsEvents()
-	return nil
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, nil, run)
 }
 
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-rds-events",
-		Short: "The Sensu Go Aws RDS handler for rds events management",
-		RunE:  run,
-	}
-
-	cmd.Flags().StringVarP(&awsRegion,
-		"aws_region",
-		"r",
-		"us-east-1",
-		"AWS Region")
-
-	cmd.Flags().StringVarP(&dbInstanceId,
-		"db_instance_id",
-		"d",
-		"",
-		"DB instance identifier")
-	return cmd
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkRdsEvents)
+	pager.Report(res)
 }
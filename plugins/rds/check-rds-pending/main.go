@@ -16,7 +16,7 @@ package main
 #
 #
 # USAGE:
-#  ./check-rds-pending --aws_region=${you_region}
+#  ./check-rds-pending --aws-region=${you_region}
 #
 # NOTES:
 #
@@ -26,113 +26,96 @@ package main
 */
 
 import (
-	"fmt"
-	"os"
-
-	"github.com/spf13/cobra"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/regions"
 
 	"github.com/aws/aws-sdk-go/aws"
 
 	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/utils"
 )
 
 var (
-	awsRegion string
-	rdsClient *rds.RDS
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	pager          utils.Pager
+
+	config = plugin.NewConfig("check-rds-pending", "The Sensu Go Aws RDS handler for rds maintenance management")
+
+	options = []*sensu.PluginConfigOption{}
 )
 
-func checkRdsPending() {
-	var success bool
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
-	success, rdsClient = awsclient.GetRDSClient(awsSession)
-	if !success {
+// RDSClient represents the RDS dependencies of the check
+type RDSClient interface {
+	DescribeDBInstancesPages(*rds.DescribeDBInstancesInput, func(*rds.DescribeDBInstancesOutput, bool) bool) error
+	DescribePendingMaintenanceActions(*rds.DescribePendingMaintenanceActionsInput) (*rds.DescribePendingMaintenanceActionsOutput, error)
+}
+
+func checkRdsPending(factory *awsclient.Factory, res *result.Result) {
+	rdsClient, err := factory.RDS()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
-	clusters, err := getClusters()
-	if err != nil || clusters == nil {
+	clusters, err := getClusters(rdsClient)
+	if err != nil {
+		res.Unknown("", "an error occurred processing AWS RDS API DescribeDBInstances: %v", err)
 		return
 	}
-	checkPendingMaintenance(clusters)
+	if len(clusters) == 0 {
+		res.SetOKMessage("No DB instances found")
+		return
+	}
+	checkPendingMaintenance(res, rdsClient, clusters)
+	res.SetOKMessage("No pending maintenance for %d DB instance(s)", len(clusters))
 }
 
-func getClusters() ([]*string, error) {
+func getClusters(rdsClient RDSClient) ([]*string, error) {
 	clusters := []*string{}
 	dbInstanceInput := &rds.DescribeDBInstancesInput{}
 	//fetch all clusters identifiers
-	dbClusterOutput, err := rdsClient.DescribeDBInstances(dbInstanceInput)
-
+	dbInstances, err := pager.DBInstances(rdsClient, dbInstanceInput)
 	if err != nil {
-		fmt.Println("An error occurred processing AWS RDS API DescribeDBInstances", err)
 		return nil, err
 	}
 
-	if dbClusterOutput != nil && dbClusterOutput.DBInstances != nil && len(dbClusterOutput.DBInstances) > 0 {
-		for _, dbInstance := range dbClusterOutput.DBInstances {
-			clusters = append(clusters, dbInstance.DBInstanceIdentifier)
-		}
-	}
-
-	if !(clusters != nil && len(clusters) > 0) {
-		fmt.Println("OK")
-		return nil, nil
+	for _, dbInstance := range dbInstances {
+		clusters = append(clusters, dbInstance.DBInstanceIdentifier)
 	}
-
 	return clusters, nil
 }
 
-func checkPendingMaintenance(clusters []*string) {
+func checkPendingMaintenance(res *result.Result, rdsClient RDSClient, clusters []*string) {
 	pendingMaintanceInput := &rds.DescribePendingMaintenanceActionsInput{}
 	filter := &rds.Filter{}
 	filter.Name = aws.String("db-instance-id")
 	filter.Values = clusters
 	pendingMaintanceInput.Filters = []*rds.Filter{filter}
-	pendingMaintanceOutput, err := rdsClient.DescribePendingMaintenanceActions(pendingMaintanceInput)
+	pendingMaintenanceActions, err := pager.PendingMaintenanceActions(rdsClient, pendingMaintanceInput)
 	if err != nil {
-		fmt.Println("Error :", err)
-		return
-	}
-
-	if !(pendingMaintanceOutput != nil && pendingMaintanceOutput.PendingMaintenanceActions != nil && len(pendingMaintanceOutput.PendingMaintenanceActions) > 0) {
+		res.Error("", err)
 		return
 	}
 
-	fmt.Println("CRITICAL : Clusters w/ pending maintenance required:")
-	for _, pendingMaintance := range pendingMaintanceOutput.PendingMaintenanceActions {
-		fmt.Println(pendingMaintance.PendingMaintenanceActionDetails)
+	for _, pendingMaintance := range pendingMaintenanceActions {
+		for _, action := range pendingMaintance.PendingMaintenanceActionDetails {
+			res.Critical(aws.StringValue(pendingMaintance.ResourceIdentifier), "pending maintenance %s: %s", aws.StringValue(action.Action), aws.StringValue(action.Description))
+		}
 	}
 }
 
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, nil, run)
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
-	}
-	checkRdsPending()
-	return nil
-}
-
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-rds-pending",
-		Short: "The Sensu Go Aws RDS handler for rds maintenance management",
-		RunE:  run,
-	}
-
-	cmd.Flags().StringVarP(&awsRegion,
-		"aws_region",
-		"r",
-		"us-east-1",
-		"AWS Region")
-
-	return cmd
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkRdsPending)
+	pager.Report(res)
 }
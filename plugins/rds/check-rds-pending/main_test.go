@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockRDSClient struct {
+	mock.Mock
+}
+
+func (m *mockRDSClient) DescribeDBInstancesPages(input *rds.DescribeDBInstancesInput, fn func(*rds.DescribeDBInstancesOutput, bool) bool) error {
+	args := m.Called(input)
+	out, _ := args.Get(0).(*rds.DescribeDBInstancesOutput)
+	if out != nil {
+		fn(out, true)
+	}
+	return args.Error(1)
+}
+
+func (m *mockRDSClient) DescribePendingMaintenanceActions(input *rds.DescribePendingMaintenanceActionsInput) (*rds.DescribePendingMaintenanceActionsOutput, error) {
+	args := m.Called(input)
+	out, _ := args.Get(0).(*rds.DescribePendingMaintenanceActionsOutput)
+	return out, args.Error(1)
+}
+
+func pendingAction(id string) *rds.ResourcePendingMaintenanceActions {
+	return &rds.ResourcePendingMaintenanceActions{
+		ResourceIdentifier: aws.String(id),
+		PendingMaintenanceActionDetails: []*rds.PendingMaintenanceAction{
+			{Action: aws.String("system-update"), Description: aws.String("patch")},
+		},
+	}
+}
+
+func TestCheckPendingMaintenance(t *testing.T) {
+	tests := []struct {
+		Name      string
+		Outputs   []*rds.DescribePendingMaintenanceActionsOutput
+		Error     error
+		ExpStatus result.Status
+	}{
+		{
+			Name:      "no pending maintenance",
+			Outputs:   []*rds.DescribePendingMaintenanceActionsOutput{{}},
+			ExpStatus: result.OK,
+		},
+		{
+			Name: "pending maintenance",
+			Outputs: []*rds.DescribePendingMaintenanceActionsOutput{
+				{
+					PendingMaintenanceActions: []*rds.ResourcePendingMaintenanceActions{pendingAction("db-a")},
+				},
+			},
+			ExpStatus: result.Critical,
+		},
+		{
+			Name: "pending maintenance on a later page",
+			Outputs: []*rds.DescribePendingMaintenanceActionsOutput{
+				{Marker: aws.String("next")},
+				{
+					PendingMaintenanceActions: []*rds.ResourcePendingMaintenanceActions{pendingAction("db-a")},
+				},
+			},
+			ExpStatus: result.Critical,
+		},
+		{
+			Name:      "api error",
+			Outputs:   []*rds.DescribePendingMaintenanceActionsOutput{nil},
+			Error:     errors.New("Throttling"),
+			ExpStatus: result.Unknown,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			client := new(mockRDSClient)
+			for _, output := range test.Outputs {
+				client.On("DescribePendingMaintenanceActions", mock.Anything).Return(output, test.Error).Once()
+			}
+			res := result.New("check-rds-pending")
+			checkPendingMaintenance(res, client, aws.StringSlice([]string{"db-a"}))
+			if got, want := res.Status(), test.ExpStatus; got != want {
+				t.Errorf("bad status: got %v, want %v", got, want)
+			}
+			client.AssertNumberOfCalls(t, "DescribePendingMaintenanceActions", len(test.Outputs))
+		})
+	}
+}
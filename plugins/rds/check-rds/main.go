@@ -16,33 +16,36 @@ package main
 #
 # USAGE:
 #   Critical if DB instance "sensu-admin-db" is not on ap-northeast-1a
-#   ./check-rds --db_instance_id=sensu-admin-db --available_zone_severity=critical --available_zone=ap-northeast-1a
+#   ./check-rds --db-instance-id=sensu-admin-db --available-zone-severity=critical --available-zone=ap-northeast-1a
 #
 #   Warning if CPUUtilization is over 80%, critical if over 90%
-#   ./check-rds --db_instance_id=sensu-admin-db --cpu_warning_over=80 --cpu_critical_over=90
+#   ./check-rds --db-instance-id=sensu-admin-db --cpu-warning-over=80 --cpu-critical-over=90
 #
 #   Critical if CPUUtilization is over 90%, maximum of last one hour
-#   ./check-rds --db_instance_id=sensu-admin-db --cpu_critical_over=90 --statistics=maximum --period=3600
+#   ./check-rds --db-instance-id=sensu-admin-db --cpu-critical-over=90 --statistics=maximum --period=3600
 #
 #   Warning if DatabaseConnections are over 100, critical over 120
-#   ./check-rds --db_instance_id=sensu-admin-db --connections_critical_over=120 --connections_warning_over=100 --statistics=maximum --period=3600
+#   ./check-rds --db-instance-id=sensu-admin-db --connections-critical-over=120 --connections-warning-over=100 --statistics=maximum --period=3600
+#
+#   Warning if CPUUtilization is not between 5% and 80%, critical if 90% or more
+#   ./check-rds --db-instance-id=sensu-admin-db --cpu-warning-range=5:80 --cpu-critical-over=90
 #
 #   Warning if IOPS are over 100, critical over 200
-#   ./check-rds --db_instance_id=sensu-admin-db --iops_critical_over=200 --iops_warning_over=100 --period=300
+#   ./check-rds --db-instance-id=sensu-admin-db --iops-critical-over=200 --iops-warning-over=100 --period=300
 #
 #   Warning if memory usage is over 80%, maximum of last 2 hour
 #   specifying "minimum" is intended actually since memory usage is calculated from CloudWatch "FreeableMemory" metric.
-#   ./check-rds --db_instance_id=sensu-admin-db --memory_warning_over=80 --statistics=minimum --period=7200
+#   ./check-rds --db-instance-id=sensu-admin-db --memory-warning-over=80 --statistics=minimum --period=7200
 #
 #   Disk usage, same as memory
-#   ./check-rds --db_instance_id=sensu-admin-db --disk_warning_over=80 --period=7200
+#   ./check-rds --db-instance-id=sensu-admin-db --disk-warning-over=80 --period=7200
 #
 #   You can check multiple metrics simultaneously. Highest severity will be reported
-#   ./check-rds --db_instance_id=sensu-admin-db --cpu_warning_over=80 --cpu_critical_over=90 --memory_warning_over=60 --memory_critical_over=80
+#   ./check-rds --db-instance-id=sensu-admin-db --cpu-warning-over=80 --cpu-critical-over=90 --memory-warning-over=60 --memory-critical-over=80
 #
 #   You can ignore accept nil values returned for a time periods from Cloudwatch as being an OK.  Amazon falls behind in their
 #   metrics from time to time and this prevents false positives
-#   ./check-rds --db_instance_id=sensu-admin-db --cpu_critical_over=90 -accept_nil=true
+#   ./check-rds --db-instance-id=sensu-admin-db --cpu-critical-over=90 -accept_nil=true
 #
 # NOTES:
 #
@@ -52,32 +55,36 @@ package main
 */
 
 import (
-	"fmt"
 	"math"
-	"os"
-	"strings"
 	"time"
 
 	"github.com/sensu/sensu-aws/awsclient"
-	"github.com/spf13/cobra"
+	"github.com/sensu/sensu-aws/events"
+	"github.com/sensu/sensu-aws/metricdata"
+	"github.com/sensu/sensu-aws/regions"
 
 	"github.com/aws/aws-sdk-go/aws"
 
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/threshold"
+	"github.com/sensu/sensu-aws/utils"
 )
 
 var (
-	awsRegion                string
-	rdsClient                *rds.RDS
+	sessionOptions           aws_session.Options
+	regionOptions            regions.Options
+	eventOptions             events.Options
+	pager                    utils.Pager
 	scheme                   string
 	dbInstanceId             string
 	fetchAge                 int
 	period                   int64
 	statistic                string
-	cloudWatchClient         *cloudwatch.CloudWatch
-	roleArn                  string
 	dbClusterId              string
 	accpetNil                bool
 	availabilityZoneSeverity string
@@ -91,119 +98,357 @@ var (
 	connectionWarning        float64
 	iopsCritical             float64
 	iopsWarning              float64
-	metricSeverities         map[string]map[string]float64
+	cpuWarningRange          string
+	cpuCriticalRange         string
+	memoryWarningRange       string
+	memoryCriticalRange      string
+	diskWarningRange         string
+	diskCriticalRange        string
+	connectionWarningRange   string
+	connectionCriticalRange  string
+	iopsWarningRange         string
+	iopsCriticalRange        string
+	metricThresholds         map[string]threshold.Thresholds
 	availabilityZone         string
-	instanceClassMapping     map[string]string
-	allocatedStorageMapping  map[string]int64
-	dbInstanceZoneMapping    map[string]string
+
+	config = plugin.NewConfig("check-rds", "The Sensu Go Aws RDS handler for rds management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:     "scheme",
+			Env:      "SCHEME",
+			Argument: "scheme",
+			Default:  "",
+			Usage:    "Metric naming scheme, text to prepend to metric",
+			Value:    &scheme,
+		},
+		{
+			Path:     "db-instance-id",
+			Env:      "DB_INSTANCE_ID",
+			Argument: "db-instance-id",
+			Default:  "",
+			Usage:    "DB instance identifier",
+			Value:    &dbInstanceId,
+		},
+		{
+			Path:     "fetch-age",
+			Env:      "FETCH_AGE",
+			Argument: "fetch-age",
+			Default:  0,
+			Usage:    "How long ago to fetch metrics from in seconds",
+			Value:    &fetchAge,
+		},
+		{
+			Path:     "period",
+			Env:      "PERIOD",
+			Argument: "period",
+			Default:  int64(180),
+			Usage:    "CloudWatch metric statistics period",
+			Value:    &period,
+		},
+		{
+			Path:     "statistic",
+			Env:      "STATISTIC",
+			Argument: "statistic",
+			Default:  "average",
+			Usage:    "CloudWatch statistic: average, maximum, minimum, samplecount, sum or a percentile such as p99",
+			Value:    &statistic,
+		},
+		{
+			Path:     "db-cluster-id",
+			Env:      "DB_CLUSTER_ID",
+			Argument: "db-cluster-id",
+			Default:  "",
+			Usage:    "DB cluster identifier",
+			Value:    &dbClusterId,
+		},
+		{
+			Path:     "accept-nil",
+			Env:      "ACCEPT_NIL",
+			Argument: "accept-nil",
+			Default:  false,
+			Usage:    "Continue if CloudWatch provides no metrics for the time period",
+			Value:    &accpetNil,
+		},
+		{
+			Path:     "available-zone-severity",
+			Env:      "AVAILABLE_ZONE_SEVERITY",
+			Argument: "available-zone-severity",
+			Default:  "critical",
+			Usage:    "Trigger a #{severity} if availability zone is different than given argument",
+			Value:    &availabilityZoneSeverity,
+		},
+		{
+			Path:     "available-zone",
+			Env:      "AVAILABLE_ZONE",
+			Argument: "available-zone",
+			Default:  "us-west-1a",
+			Usage:    "available zone",
+			Value:    &availabilityZone,
+		},
+		{
+			Path:     "cpu-critical-over",
+			Env:      "CPU_CRITICAL_OVER",
+			Argument: "cpu-critical-over",
+			Default:  float64(80),
+			Usage:    "Trigger a critical if cpu usage is over a percentage",
+			Value:    &cpuCritical,
+		},
+		{
+			Path:     "cpu-warning-over",
+			Env:      "CPU_WARNING_OVER",
+			Argument: "cpu-warning-over",
+			Default:  float64(40),
+			Usage:    "Trigger a warning if cpu usage is over a percentage",
+			Value:    &cpuWarning,
+		},
+		{
+			Path:     "memory-critical-over",
+			Env:      "MEMORY_CRITICAL_OVER",
+			Argument: "memory-critical-over",
+			Default:  float64(80),
+			Usage:    "Trigger a critical if memory usage is over a Bytes",
+			Value:    &memoryCritical,
+		},
+		{
+			Path:     "memory-warning-over",
+			Env:      "MEMORY_WARNING_OVER",
+			Argument: "memory-warning-over",
+			Default:  float64(40),
+			Usage:    "Trigger a warning if memory usage is over a Bytes",
+			Value:    &memoryWarning,
+		},
+		{
+			Path:     "disk-critical-over",
+			Env:      "DISK_CRITICAL_OVER",
+			Argument: "disk-critical-over",
+			Default:  float64(80),
+			Usage:    "Trigger a critical if disk usage is over a Bytes",
+			Value:    &diskCritical,
+		},
+		{
+			Path:     "disk-warning-over",
+			Env:      "DISK_WARNING_OVER",
+			Argument: "disk-warning-over",
+			Default:  float64(40),
+			Usage:    "Trigger a warning if disk usage is over a Bytes",
+			Value:    &diskWarning,
+		},
+		{
+			Path:     "connections-critical-over",
+			Env:      "CONNECTIONS_CRITICAL_OVER",
+			Argument: "connections-critical-over",
+			Default:  float64(80),
+			Usage:    "Trigger a critical if connection number is over a number",
+			Value:    &conectionCritical,
+		},
+		{
+			Path:     "connections-warning-over",
+			Env:      "CONNECTIONS_WARNING_OVER",
+			Argument: "connections-warning-over",
+			Default:  float64(40),
+			Usage:    "Trigger a warning if connection number is over a number",
+			Value:    &connectionWarning,
+		},
+		{
+			Path:     "iops-critical-over",
+			Env:      "IOPS_CRITICAL_OVER",
+			Argument: "iops-critical-over",
+			Default:  float64(80),
+			Usage:    "Trigger a critical if iops number is over a Count/Second",
+			Value:    &iopsCritical,
+		},
+		{
+			Path:     "iops-warning-over",
+			Env:      "IOPS_WARNING_OVER",
+			Argument: "iops-warning-over",
+			Default:  float64(40),
+			Usage:    "Trigger a warning if connection number is over a Count/Second",
+			Value:    &iopsWarning,
+		},
+		{
+			Path:     "cpu-warning-range",
+			Env:      "CPU_WARNING_RANGE",
+			Argument: "cpu-warning-range",
+			Default:  "",
+			Usage:    "Warning threshold range of the cpu usage percentage (e.g. 10:, ~:20, @5:10), overrides cpu-warning-over",
+			Value:    &cpuWarningRange,
+		},
+		{
+			Path:     "cpu-critical-range",
+			Env:      "CPU_CRITICAL_RANGE",
+			Argument: "cpu-critical-range",
+			Default:  "",
+			Usage:    "Critical threshold range of the cpu usage percentage (e.g. 10:, ~:20, @5:10), overrides cpu-critical-over",
+			Value:    &cpuCriticalRange,
+		},
+		{
+			Path:     "memory-warning-range",
+			Env:      "MEMORY_WARNING_RANGE",
+			Argument: "memory-warning-range",
+			Default:  "",
+			Usage:    "Warning threshold range of the memory usage percentage (e.g. 10:, ~:20, @5:10), overrides memory-warning-over",
+			Value:    &memoryWarningRange,
+		},
+		{
+			Path:     "memory-critical-range",
+			Env:      "MEMORY_CRITICAL_RANGE",
+			Argument: "memory-critical-range",
+			Default:  "",
+			Usage:    "Critical threshold range of the memory usage percentage (e.g. 10:, ~:20, @5:10), overrides memory-critical-over",
+			Value:    &memoryCriticalRange,
+		},
+		{
+			Path:     "disk-warning-range",
+			Env:      "DISK_WARNING_RANGE",
+			Argument: "disk-warning-range",
+			Default:  "",
+			Usage:    "Warning threshold range of the disk usage percentage (e.g. 10:, ~:20, @5:10), overrides disk-warning-over",
+			Value:    &diskWarningRange,
+		},
+		{
+			Path:     "disk-critical-range",
+			Env:      "DISK_CRITICAL_RANGE",
+			Argument: "disk-critical-range",
+			Default:  "",
+			Usage:    "Critical threshold range of the disk usage percentage (e.g. 10:, ~:20, @5:10), overrides disk-critical-over",
+			Value:    &diskCriticalRange,
+		},
+		{
+			Path:     "connections-warning-range",
+			Env:      "CONNECTIONS_WARNING_RANGE",
+			Argument: "connections-warning-range",
+			Default:  "",
+			Usage:    "Warning threshold range of the connection number (e.g. 10:, ~:20, @5:10), overrides connections-warning-over",
+			Value:    &connectionWarningRange,
+		},
+		{
+			Path:     "connections-critical-range",
+			Env:      "CONNECTIONS_CRITICAL_RANGE",
+			Argument: "connections-critical-range",
+			Default:  "",
+			Usage:    "Critical threshold range of the connection number (e.g. 10:, ~:20, @5:10), overrides connections-critical-over",
+			Value:    &connectionCriticalRange,
+		},
+		{
+			Path:     "iops-warning-range",
+			Env:      "IOPS_WARNING_RANGE",
+			Argument: "iops-warning-range",
+			Default:  "",
+			Usage:    "Warning threshold range of the iops number (e.g. 10:, ~:20, @5:10), overrides iops-warning-over",
+			Value:    &iopsWarningRange,
+		},
+		{
+			Path:     "iops-critical-range",
+			Env:      "IOPS_CRITICAL_RANGE",
+			Argument: "iops-critical-range",
+			Default:  "",
+			Usage:    "Critical threshold range of the iops number (e.g. 10:, ~:20, @5:10), overrides iops-critical-over",
+			Value:    &iopsCriticalRange,
+		},
+	}
 )
 
-func checkRds() {
-	var success bool
+// CloudWatchClient represents the CloudWatch dependencies of the check
+type CloudWatchClient interface {
+	GetMetricDataPages(*cloudwatch.GetMetricDataInput, func(*cloudwatch.GetMetricDataOutput, bool) bool) error
+}
+
+// RDSClient represents the RDS dependencies of the check
+type RDSClient interface {
+	DescribeDBClustersPages(*rds.DescribeDBClustersInput, func(*rds.DescribeDBClustersOutput, bool) bool) error
+	DescribeDBInstancesPages(*rds.DescribeDBInstancesInput, func(*rds.DescribeDBInstancesOutput, bool) bool) error
+}
+
+// dbInstanceDetails maps the identifiers of the checked DB instances to
+// their attributes
+type dbInstanceDetails struct {
+	zones            map[string]string
+	classes          map[string]string
+	allocatedStorage map[string]int64
+}
+
+func (d dbInstanceDetails) add(id string, dbInstance *rds.DBInstance) {
+	d.zones[id] = *dbInstance.AvailabilityZone
+	d.classes[id] = *dbInstance.DBInstanceClass
+	d.allocatedStorage[id] = *dbInstance.AllocatedStorage
+}
+
+func checkRds(factory *awsclient.Factory, res *result.Result) {
 	metrics := getMetrics()
-	metricSeverities = getMetricSeverities()
-	values := make(map[string]*float64)
-	severities := make(map[string]bool)
-	severities["critical"] = false
-	severities["warning"] = false
-	instanceClassMapping = make(map[string]string)
-	allocatedStorageMapping = make(map[string]int64)
-	dbInstanceZoneMapping = make(map[string]string)
+	details := dbInstanceDetails{
+		zones:            make(map[string]string),
+		classes:          make(map[string]string),
+		allocatedStorage: make(map[string]int64),
+	}
 	if len(dbClusterId) <= 0 && len(dbInstanceId) <= 0 {
-		fmt.Println("Please provide db_cluster_id or db_instance_id")
+		res.Unknown("", "please provide db_cluster_id or db_instance_id")
 		return
 	}
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
-	if len(roleArn) <= 0 {
-		success, rdsClient = awsclient.GetRDSClient(awsSession)
-	} else {
-		success, rdsClient = awsclient.GetRDSClientWithRoleArn(awsSession, roleArn)
-	}
-	if !success {
+	rdsClient, err := factory.RDS()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
-	if len(roleArn) <= 0 {
-		success, cloudWatchClient = awsclient.GetCloudWatchClient(awsSession)
-	} else {
-		success, cloudWatchClient = awsclient.GetCloudWatchClientWithRoleArn(awsSession, roleArn)
+	cloudWatchClient, err := factory.CloudWatch()
+	if err != nil {
+		res.Error("", err)
+		return
 	}
-	if !success {
+	err = getClusterDetails(res, rdsClient, details)
+	if err != nil {
+		res.Unknown(dbClusterId, "an error occurred processing AWS RDS API: %v", err)
 		return
 	}
-	err := getClusterDetails()
+	err = getDbInstanceDetails(res, rdsClient, details)
 	if err != nil {
+		res.Unknown(dbInstanceId, "an error occurred processing AWS RDS API: %v", err)
+		return
+	}
+	batch := metricdata.NewFetchBatch(time.Duration(fetchAge)*time.Second, period)
+	keys := make(map[string]map[string]string)
+	for instance := range details.zones {
+		keys[instance] = make(map[string]string)
+		for metric, unit := range metrics {
+			keys[instance][metric] = batch.Add(metricdata.Query{
+				Namespace:  "AWS/RDS",
+				MetricName: metric,
+				Dimensions: []*cloudwatch.Dimension{metricdata.Dimension("DBInstanceIdentifier", instance)},
+				Stat:       statistic,
+				Unit:       unit,
+			})[0]
+		}
+	}
+	if batch.Len() == 0 {
 		return
 	}
-	err = getDbInstanceDetails()
+	series, err := batch.Fetch(cloudWatchClient)
 	if err != nil {
+		res.Unknown("", "error while getting metric data: %v", err)
 		return
 	}
-	for instance, zone := range dbInstanceZoneMapping {
+	for instance, zone := range details.zones {
 		//if zone != availabilityZone {
 		// if availabilityZoneSeverity == "citical" {
 		// 	fmt.Print("CRITICAL :")
 		// } else {
 		// 	fmt.Print("WARNING :")
 		// }
-		fmt.Println("Availabilty Zone for DB Instance", instance, "is", zone)
+		res.OK(instance, "Availabilty Zone is %s", zone)
 		//}
-		for metric, unit := range metrics {
-			value, err := getCloudWatchMetrics(metric, instance, unit)
-			if err != nil {
-				fmt.Println("Error :", err)
-				return
+		values := make(map[string]*float64)
+		for metric := range metrics {
+			if point, err := series[keys[instance][metric]].Latest(); err == nil {
+				values[metric] = &point.Value
 			}
-			values[metric] = value
 		}
 
-		checkCPU(values["CPUUtilization"], instance)
-		checkMemory(values["FreeableMemory"], instance, instanceClassMapping[instance])
-		checkDiskSpace(values["FreeStorageSpace"], instance, allocatedStorageMapping[instance])
-		checkConnections(values["DatabaseConnections"], instance)
-		checkIops(values["ReadIOPS"], values["WriteIOPS"], instance)
-	}
-}
-
-func getCloudWatchMetrics(metricname string, rdsName string, unit string) (*float64, error) {
-	var input cloudwatch.GetMetricStatisticsInput
-	input.Namespace = aws.String("AWS/RDS")
-	input.MetricName = aws.String(metricname)
-	var dimensionFilter cloudwatch.Dimension
-	dimensionFilter.Name = aws.String("DBInstanceIdentifier")
-	dimensionFilter.Value = aws.String(rdsName)
-	input.Dimensions = []*cloudwatch.Dimension{&dimensionFilter}
-	input.EndTime = aws.Time(time.Now())
-	input.StartTime = aws.Time((input.EndTime).Add(time.Duration(-fetchAge/60) * time.Minute))
-	input.Period = aws.Int64(period)
-	input.Statistics = []*string{aws.String(strings.Title(statistic))}
-	input.Unit = aws.String(unit)
-	metrics, err := cloudWatchClient.GetMetricStatistics(&input)
-	if err != nil {
-		return nil, err
-	}
-	if metrics != nil && metrics.Datapoints != nil && len(metrics.Datapoints) > 1 {
-		var minimumTimeDifference float64
-		var timeDifference float64
-		var value *float64
-		minimumTimeDifference = -1
-		for _, datapoint := range metrics.Datapoints {
-			timeDifference = time.Since(*datapoint.Timestamp).Seconds()
-			if minimumTimeDifference == -1 || timeDifference < minimumTimeDifference {
-				minimumTimeDifference = timeDifference
-				if strings.Title(statistic) == "Average" {
-					value = datapoint.Average
-				} else if strings.Title(statistic) == "Sum" {
-					value = datapoint.Sum
-				} else if strings.Title(statistic) == "Maximum" {
-					value = datapoint.Maximum
-				} else if strings.Title(statistic) == "Minimum" {
-					value = datapoint.Minimum
-				}
-			}
-		}
-		return value, nil
+		checkCPU(res, values["CPUUtilization"], instance)
+		checkMemory(res, values["FreeableMemory"], instance, details.classes[instance])
+		checkDiskSpace(res, values["FreeStorageSpace"], instance, details.allocatedStorage[instance])
+		checkConnections(res, values["DatabaseConnections"], instance)
+		checkIops(res, values["ReadIOPS"], values["WriteIOPS"], instance)
 	}
-	return nil, nil
 }
 
 func getMetrics() map[string]string {
@@ -217,94 +462,61 @@ func getMetrics() map[string]string {
 	return metrics
 }
 
-func getMetricSeverities() map[string]map[string]float64 {
-	metricSeverities := make(map[string]map[string]float64)
-	metricSeverities["CPUUtilization"] = make(map[string]float64)
-	metricSeverities["CPUUtilization"]["critical"] = cpuCritical
-	metricSeverities["CPUUtilization"]["warning"] = cpuWarning
-	metricSeverities["FreeableMemory"] = make(map[string]float64)
-	metricSeverities["FreeableMemory"]["critical"] = memoryCritical
-	metricSeverities["FreeableMemory"]["warning"] = memoryWarning
-	metricSeverities["FreeStorageSpace"] = make(map[string]float64)
-	metricSeverities["FreeStorageSpace"]["critical"] = diskCritical
-	metricSeverities["FreeStorageSpace"]["warning"] = diskWarning
-	metricSeverities["DatabaseConnections"] = make(map[string]float64)
-	metricSeverities["DatabaseConnections"]["critical"] = conectionCritical
-	metricSeverities["DatabaseConnections"]["warning"] = connectionWarning
-	metricSeverities["ReadIOPS"] = make(map[string]float64)
-	metricSeverities["ReadIOPS"]["critical"] = iopsCritical
-	metricSeverities["ReadIOPS"]["warning"] = iopsWarning
-	metricSeverities["WriteIOPS"] = make(map[string]float64)
-	metricSeverities["WriteIOPS"]["critical"] = iopsCritical
-	metricSeverities["WriteIOPS"]["warning"] = iopsWarning
-	return metricSeverities
-}
-
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-rds",
-		Short: "The Sensu Go Aws RDS handler for rds management",
-		RunE:  run,
+// getMetricThresholds alerts when a metric reaches its warning-over or
+// critical-over value unless ranges are given, the iops thresholds apply to
+// the sum of the read and write iops
+func getMetricThresholds() (map[string]threshold.Thresholds, error) {
+	metricLimits := []struct {
+		metric                      string
+		warning, critical           float64
+		warningRange, criticalRange string
+	}{
+		{"CPUUtilization", cpuWarning, cpuCritical, cpuWarningRange, cpuCriticalRange},
+		{"FreeableMemory", memoryWarning, memoryCritical, memoryWarningRange, memoryCriticalRange},
+		{"FreeStorageSpace", diskWarning, diskCritical, diskWarningRange, diskCriticalRange},
+		{"DatabaseConnections", connectionWarning, conectionCritical, connectionWarningRange, connectionCriticalRange},
+		{"ReadIOPS", iopsWarning, iopsCritical, iopsWarningRange, iopsCriticalRange},
+	}
+	metricThresholds := make(map[string]threshold.Thresholds)
+	for _, l := range metricLimits {
+		thresholds, err := threshold.FromOperator("greater_equal", l.warning, l.critical)
+		if err == nil {
+			err = thresholds.Override(l.warningRange, l.criticalRange)
+		}
+		if err != nil {
+			return nil, err
+		}
+		metricThresholds[l.metric] = thresholds
 	}
-
-	cmd.Flags().StringVar(&awsRegion, "aws_region", "us-west-1", "AWS Region (defaults to us-east-1).")
-	cmd.Flags().StringVar(&scheme, "scheme", "", "Metric naming scheme, text to prepend to metric")
-	cmd.Flags().StringVar(&dbInstanceId, "db_instance_id", "", "DB instance identifier")
-	cmd.Flags().IntVar(&fetchAge, "fetch_age", 0, "How long ago to fetch metrics from in seconds")
-	cmd.Flags().Int64Var(&period, "period", 180, "CloudWatch metric statistics period")
-	cmd.Flags().StringVar(&statistic, "statistic", "average", "CloudWatch statistics method")
-	cmd.Flags().StringVar(&dbClusterId, "db_cluster_id", "", "DB cluster identifier")
-	cmd.Flags().BoolVar(&accpetNil, "accept_nil", false, "Continue if CloudWatch provides no metrics for the time period")
-	cmd.Flags().StringVar(&availabilityZoneSeverity, "available_zone_severity", "critical", "Trigger a #{severity} if availability zone is different than given argument")
-	cmd.Flags().StringVar(&availabilityZone, "available_zone", "us-west-1a", "available zone")
-	cmd.Flags().Float64Var(&cpuCritical, "cpu_critical_over", 80, "Trigger a critical if cpu usage is over a percentage")
-	cmd.Flags().Float64Var(&cpuWarning, "cpu_warning_over", 40, "Trigger a warning if cpu usage is over a percentage")
-	cmd.Flags().Float64Var(&memoryCritical, "memory_critical_over", 80, "Trigger a critical if memory usage is over a Bytes")
-	cmd.Flags().Float64Var(&memoryWarning, "memory_warning_over", 40, "Trigger a warning if memory usage is over a Bytes")
-	cmd.Flags().Float64Var(&diskCritical, "disk_critical_over", 80, "Trigger a critical if disk usage is over a Bytes")
-	cmd.Flags().Float64Var(&diskWarning, "disk_warning_over", 40, "Trigger a warning if disk usage is over a Bytes")
-	cmd.Flags().Float64Var(&conectionCritical, "connections_critical_over", 80, "Trigger a critical if connection number is over a number")
-	cmd.Flags().Float64Var(&connectionWarning, "connections_warning_over", 40, "Trigger a warning if connection number is over a number")
-	cmd.Flags().Float64Var(&iopsCritical, "iops_critical_over", 80, "Trigger a critical if iops number is over a Count/Second")
-	cmd.Flags().Float64Var(&iopsWarning, "iops_warning_over", 40, "Trigger a warning if connection number is over a Count/Second")
-	cmd.Flags().StringVar(&roleArn, "role_arn", "", "AWS role arn of the role of the third party account to switch to")
-	return cmd
+	return metricThresholds, nil
 }
 
-func checkCPU(value *float64, instance string) {
-	if checkNilValue(value, instance, "cpu") {
+func checkCPU(res *result.Result, value *float64, instance string) {
+	if checkNilValue(res, value, instance, "cpu") {
 		return
 	}
 
-	if *value >= metricSeverities["CPUUtilization"]["critical"] {
-		fmt.Println("CRITICAL : For DB Instance :", instance, "latest cpu usage value :", *value, "expected lower than", metricSeverities["CPUUtilization"]["critical"])
-	}
-
-	if *value >= metricSeverities["CPUUtilization"]["warning"] {
-		fmt.Println("WARNING : For DB Instance :", instance, "latest cpu usage value :", *value, "expected lower than", metricSeverities["CPUUtilization"]["warning"])
-	}
+	checkThresholds(res, "CPUUtilization", *value, instance, "cpu usage")
 }
 
-func checkMemory(value *float64, instance string, instanceClass string) {
-	if checkNilValue(value, instance, "memory") {
+func checkMemory(res *result.Result, value *float64, instance string, instanceClass string) {
+	if checkNilValue(res, value, instance, "memory") {
 		return
 	}
 
 	memoryTotalBytes := getMemoryTotalBytes(instanceClass)
+	if memoryTotalBytes == 0 {
+		res.Unknown(instance, "unknown memory size for instance class %s", instanceClass)
+		return
+	}
 	memoryUsageBytes := memoryTotalBytes - *value
 	memoryUsagePercentage := (memoryUsageBytes / memoryTotalBytes) * 100
 
-	if memoryUsagePercentage >= metricSeverities["FreeableMemory"]["critical"] {
-		fmt.Println("CRITICAL : For DB Instance :", instance, "latest memory usage value :", memoryUsagePercentage, "expected lower than", metricSeverities["FreeableMemory"]["critical"])
-	}
-
-	if memoryUsagePercentage >= metricSeverities["FreeableMemory"]["warning"] {
-		fmt.Println("WARNING : For DB Instance :", instance, "latest memory usage value :", memoryUsagePercentage, "expected lower than", metricSeverities["FreeableMemory"]["warning"])
-	}
+	checkThresholds(res, "FreeableMemory", memoryUsagePercentage, instance, "memory usage")
 }
 
-func checkDiskSpace(value *float64, instance string, allocatedStorage int64) {
-	if checkNilValue(value, instance, "disk") {
+func checkDiskSpace(res *result.Result, value *float64, instance string, allocatedStorage int64) {
+	if checkNilValue(res, value, instance, "disk") {
 		return
 	}
 
@@ -312,59 +524,48 @@ func checkDiskSpace(value *float64, instance string, allocatedStorage int64) {
 	diskUsageBytes := diskTotalBytes - *value
 	diskUsagePercentage := (diskUsageBytes / diskTotalBytes) * 100
 
-	if diskUsagePercentage >= metricSeverities["FreeStorageSpace"]["critical"] {
-		fmt.Println("CRITICAL : For DB Instance :", instance, "latest disk usage value :", diskUsagePercentage, "expected lower than", metricSeverities["FreeStorageSpace"]["critical"])
-	}
-
-	if diskUsagePercentage >= metricSeverities["FreeStorageSpace"]["warning"] {
-		fmt.Println("WARNING : For DB Instance :", instance, "latest disk usage value :", diskUsagePercentage, "expected lower than", metricSeverities["FreeStorageSpace"]["warning"])
-	}
+	checkThresholds(res, "FreeStorageSpace", diskUsagePercentage, instance, "disk usage")
 }
 
-func checkConnections(value *float64, instance string) {
-	if checkNilValue(value, instance, "database connections") {
+func checkConnections(res *result.Result, value *float64, instance string) {
+	if checkNilValue(res, value, instance, "database connections") {
 		return
 	}
 
-	if *value >= metricSeverities["DatabaseConnections"]["critical"] {
-		fmt.Println("CRITICAL : For DB Instance :", instance, "latest cpu usage value :", *value, "expected lower than", metricSeverities["DatabaseConnections"]["critical"])
-	}
-
-	if *value >= metricSeverities["DatabaseConnections"]["warning"] {
-		fmt.Println("WARNING : For DB Instance :", instance, "latest cpu usage value :", *value, "expected lower than", metricSeverities["DatabaseConnections"]["warning"])
-	}
+	checkThresholds(res, "DatabaseConnections", *value, instance, "database connections")
 }
 
-func checkIops(value *float64, value2 *float64, instance string) {
-	isReadIopsNull := checkNilValue(value, instance, "iops")
+func checkIops(res *result.Result, value *float64, value2 *float64, instance string) {
+	isReadIopsNull := checkNilValue(res, value, instance, "iops")
 
 	if isReadIopsNull {
 		return
 	}
 
-	isWriteIopsNull := checkNilValue(value2, instance, "iops")
+	isWriteIopsNull := checkNilValue(res, value2, instance, "iops")
 
 	if isWriteIopsNull {
 		return
 	}
 
 	iopsValue := *value + *value2
-	if iopsValue >= metricSeverities["CPUUtilization"]["critical"] {
-		fmt.Println("CRITICAL : For DB Instance :", instance, "latest iops usage value :", iopsValue, "expected lower than", metricSeverities["CPUUtilization"]["critical"])
-	}
+	checkThresholds(res, "ReadIOPS", iopsValue, instance, "iops usage")
+}
 
-	if iopsValue >= metricSeverities["CPUUtilization"]["warning"] {
-		fmt.Println("WARNING : For DB Instance :", instance, "latest iops usage value :", iopsValue, "expected lower than", metricSeverities["CPUUtilization"]["warning"])
+func checkThresholds(res *result.Result, metric string, value float64, instance string, description string) {
+	thresholds := metricThresholds[metric]
+	if status := thresholds.Status(value); status != result.OK {
+		res.Add(status, instance, "latest %s value %v, expected %s", description, value, thresholds.Range(status))
 	}
 }
 
-func checkNilValue(value *float64, instance string, metric string) bool {
+func checkNilValue(res *result.Result, value *float64, instance string, metric string) bool {
 	if value == nil && accpetNil {
-		fmt.Println("DB INSTACE :", instance, ":", metric, "usage : Cloudwatch returned no results for time period. Accept nil passed so OK")
+		res.OK(instance, "%s usage : Cloudwatch returned no results for time period. Accept nil passed so OK", metric)
 		return true
 	}
 	if value == nil && !accpetNil {
-		fmt.Println("UNKNOWN : DB INSTACE :", instance, ":", metric, "usage : Requested time period did not return values from Cloudwatch. Try increasing your time period.")
+		res.Unknown(instance, "%s usage : Requested time period did not return values from Cloudwatch. Try increasing your time period.", metric)
 		return true
 	}
 	return false
@@ -426,7 +627,7 @@ func getMemoryTotalBytes(instaceClass string) float64 {
 	return memoryByteMap[instaceClass] * math.Pow(1024, 3)
 }
 
-func getClusterDetails() error {
+func getClusterDetails(res *result.Result, rdsClient RDSClient, details dbInstanceDetails) error {
 	if len(dbClusterId) > 0 {
 		dbclustersInput := &rds.DescribeDBClustersInput{}
 
@@ -435,19 +636,18 @@ func getClusterDetails() error {
 		filter.Values = []*string{&dbClusterId}
 		dbclustersInput.Filters = []*rds.Filter{filter}
 
-		dbClusterOutput, err := rdsClient.DescribeDBClusters(dbclustersInput)
+		dbClusters, err := pager.DBClusters(rdsClient, dbclustersInput)
 
 		if err != nil {
-			fmt.Println("An error occurred processing AWS RDS API DescribeDBClusters", err)
 			return err
 		}
 
-		if dbClusterOutput == nil || dbClusterOutput.DBClusters == nil || len(dbClusterOutput.DBClusters) <= 0 {
-			fmt.Println("UNKNOWN : DB Cluster not found!")
+		if len(dbClusters) <= 0 {
+			res.Unknown(dbClusterId, "DB Cluster not found!")
 		}
 
-		if dbClusterOutput != nil && dbClusterOutput.DBClusters != nil && len(dbClusterOutput.DBClusters) > 0 {
-			for _, dbclustersMember := range dbClusterOutput.DBClusters[0].DBClusterMembers {
+		if len(dbClusters) > 0 {
+			for _, dbclustersMember := range dbClusters[0].DBClusterMembers {
 				if *dbclustersMember.IsClusterWriter {
 					dbInstanceInput := &rds.DescribeDBInstancesInput{}
 					filter := &rds.Filter{}
@@ -455,19 +655,16 @@ func getClusterDetails() error {
 					filter.Values = []*string{aws.String(*dbclustersMember.DBInstanceIdentifier)}
 					dbInstanceInput.Filters = []*rds.Filter{filter}
 
-					dbClusterOutput, err := rdsClient.DescribeDBInstances(dbInstanceInput)
+					dbInstances, err := pager.DBInstances(rdsClient, dbInstanceInput)
 
 					if err != nil {
-						fmt.Println("An error occurred processing AWS RDS API DescribeDBInstances", err)
 						return err
 					}
 
-					if dbClusterOutput == nil || dbClusterOutput.DBInstances == nil || len(dbClusterOutput.DBInstances) <= 0 {
-						fmt.Println("UNKNOWN :", dbInstanceId, "instance not found")
+					if len(dbInstances) <= 0 {
+						res.Unknown(*dbclustersMember.DBInstanceIdentifier, "instance not found")
 					} else {
-						dbInstanceZoneMapping[*dbclustersMember.DBInstanceIdentifier] = *dbClusterOutput.DBInstances[0].AvailabilityZone
-						instanceClassMapping[*dbclustersMember.DBInstanceIdentifier] = *dbClusterOutput.DBInstances[0].DBInstanceClass
-						allocatedStorageMapping[*dbclustersMember.DBInstanceIdentifier] = *dbClusterOutput.DBInstances[0].AllocatedStorage
+						details.add(*dbclustersMember.DBInstanceIdentifier, dbInstances[0])
 					}
 				}
 			}
@@ -476,7 +673,7 @@ func getClusterDetails() error {
 	return nil
 }
 
-func getDbInstanceDetails() error {
+func getDbInstanceDetails(res *result.Result, rdsClient RDSClient, details dbInstanceDetails) error {
 	if len(dbInstanceId) > 0 {
 		dbInstanceInput := &rds.DescribeDBInstancesInput{}
 		filter := &rds.Filter{}
@@ -484,37 +681,49 @@ func getDbInstanceDetails() error {
 		filter.Values = []*string{aws.String(dbInstanceId)}
 		dbInstanceInput.Filters = []*rds.Filter{filter}
 
-		dbClusterOutput, err := rdsClient.DescribeDBInstances(dbInstanceInput)
+		dbInstances, err := pager.DBInstances(rdsClient, dbInstanceInput)
 
 		if err != nil {
-			fmt.Println("An error occurred processing AWS RDS API DescribeDBInstances", err)
 			return err
 		}
 
-		if dbClusterOutput == nil || dbClusterOutput.DBInstances == nil || len(dbClusterOutput.DBInstances) <= 0 {
-			fmt.Println("UNKNOWN :", dbInstanceId, "instance not found")
+		if len(dbInstances) <= 0 {
+			res.Unknown(dbInstanceId, "instance not found")
 		} else {
-			dbInstanceZoneMapping[dbInstanceId] = *dbClusterOutput.DBInstances[0].AvailabilityZone
-			instanceClassMapping[dbInstanceId] = *dbClusterOutput.DBInstances[0].DBInstanceClass
-			allocatedStorageMapping[dbInstanceId] = *dbClusterOutput.DBInstances[0].AllocatedStorage
+			details.add(dbInstanceId, dbInstances[0])
 		}
 	}
 	return nil
 }
 
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, eventOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
+}
+
+func validate() error {
+	var err error
+	statistic, err = metricdata.ParseStat(statistic)
+	if err != nil {
+		return err
 	}
+	_, err = getMetricThresholds()
+	return err
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
+func run(res *result.Result) {
+	var err error
+	metricThresholds, err = getMetricThresholds()
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	regionOptions.Run(res, sessionOptions, checkRds)
+	pager.Report(res)
+	if err := eventOptions.Emit(config.Name, res); err != nil {
+		res.Error("", err)
 	}
-	checkRds()
-	return nil
 }
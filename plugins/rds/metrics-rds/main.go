@@ -15,11 +15,11 @@ package main
 #
 #
 # USAGE:
-#   ./rds-metrics --aws_region=eu-west-1
-#   ./rds-metrics --aws_region=eu-west-1 --db_instance_id=sr2x8pbti0eon1
+#   ./rds-metrics --aws-region=eu-west-1
+#   ./rds-metrics --aws-region=eu-west-1 --db-instance-id=sr2x8pbti0eon1
 #
 # NOTES:
-#   Returns all RDS statistics for all RDS instances in this account unless you specify --db_instance_id
+#   Returns all RDS statistics for all RDS instances in this account unless you specify --db-instance-id
 #
 # LICENSE:
 #   TODO
@@ -27,108 +27,138 @@ package main
 */
 
 import (
-	"fmt"
-	"os"
 	"time"
 
-	"github.com/spf13/cobra"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/regions"
 
 	"github.com/aws/aws-sdk-go/aws"
 
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/metric"
+	"github.com/sensu/sensu-aws/metricdata"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/utils"
 )
 
 var (
-	awsRegion    string
-	rdsClient    *rds.RDS
-	scheme       string
-	dbInstanceId string
-	fetchAge     int
-	period       int64
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	pager          utils.Pager
+	scheme         string
+	metricFormat   string
+	output         *metric.Writer
+	dbInstanceId   string
+	fetchAge       int
+	period         int64
 	//statistics       string
-	cloudWatchClient *cloudwatch.CloudWatch
+
+	config = plugin.NewConfig("metrics-rds", "The Sensu Go Aws RDS handler for metric management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:      "scheme",
+			Env:       "SCHEME",
+			Argument:  "scheme",
+			Shorthand: "s",
+			Default:   "",
+			Usage:     "Metric naming scheme, text to prepend to metric",
+			Value:     &scheme,
+		},
+		{
+			Path:     "db-instance-id",
+			Env:      "DB_INSTANCE_ID",
+			Argument: "db-instance-id",
+			Default:  "",
+			Usage:    "DB instance identifier",
+			Value:    &dbInstanceId,
+		},
+		{
+			Path:     "fetch-age",
+			Env:      "FETCH_AGE",
+			Argument: "fetch-age",
+			Default:  0,
+			Usage:    "How long ago to fetch metrics from in seconds",
+			Value:    &fetchAge,
+		},
+		{
+			Path:     "period",
+			Env:      "PERIOD",
+			Argument: "period",
+			Default:  int64(60),
+			Usage:    "CloudWatch metric statistics period",
+			Value:    &period,
+		},
+	}
 )
 
-func metrics() {
-	var success bool
+// CloudWatchClient represents the CloudWatch dependencies of the check
+type CloudWatchClient interface {
+	GetMetricDataPages(*cloudwatch.GetMetricDataInput, func(*cloudwatch.GetMetricDataOutput, bool) bool) error
+}
+
+// RDSClient represents the RDS dependencies of the check
+type RDSClient interface {
+	DescribeDBInstancesPages(*rds.DescribeDBInstancesInput, func(*rds.DescribeDBInstancesOutput, bool) bool) error
+}
+
+func metrics(factory *awsclient.Factory, res *result.Result) {
 	clusters := []*string{}
 	statisticsTypeMap := getStatisticTypes()
 	if len(dbInstanceId) > 0 {
 		clusters = []*string{&dbInstanceId}
 	}
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
-	success, rdsClient = awsclient.GetRDSClient(awsSession)
-	if !success {
+	rdsClient, err := factory.RDS()
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	dbInstances, err := getDBInstances(rdsClient, clusters)
+	if err != nil {
+		res.Unknown("", "an error occurred processing AWS RDS API DescribeDBInstances: %v", err)
 		return
 	}
-	dbInstances, err := getDBInstances(clusters)
-	if err != nil || dbInstances == nil {
+	if len(dbInstances) == 0 {
+		res.Unknown(dbInstanceId, "DB Instance not found!")
 		return
 	}
+	cloudWatchClient, err := factory.CloudWatch()
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	tags := regionOptions.MetricTags(factory)
+	batch := metricdata.NewFetchBatch(time.Duration(fetchAge)*time.Second, period)
+	keys := make(map[string]map[string]string)
 	for _, dbInstance := range dbInstances {
-		fullScheme := *dbInstance.DBInstanceIdentifier
-		if len(scheme) > 0 {
-			fullScheme = fmt.Sprintf("%s.%s", scheme, fullScheme)
-		}
-
-		success, cloudWatchClient = awsclient.GetCloudWatchClient(awsSession)
-		if !success {
-			return
-		}
+		id := *dbInstance.DBInstanceIdentifier
+		keys[id] = make(map[string]string)
 		for metricName, statistic := range statisticsTypeMap {
-			value, timestamp, err := getCloudWatchMetrics(metricName, statistic, fullScheme)
-			if err != nil {
-				fmt.Println("Error : ", err)
-				return
-			}
-			if value == nil || timestamp == nil {
-				continue
-			}
-			fmt.Println(fullScheme, ".", metricName, "  -  value :", value, ", timestamp:", timestamp)
+			keys[id][metricName] = batch.Add(metricdata.Query{
+				Namespace:  "AWS/RDS",
+				MetricName: metricName,
+				Dimensions: []*cloudwatch.Dimension{metricdata.Dimension("DBInstanceIdentifier", id)},
+				Stat:       statistic,
+			})[0]
 		}
 	}
-}
-
-func getCloudWatchMetrics(metricname string, statistic string, rdsName string) (*float64, *time.Time, error) {
-	var input cloudwatch.GetMetricStatisticsInput
-	input.Namespace = aws.String("AWS/RDS")
-	input.MetricName = aws.String(metricname)
-	var dimensionFilter cloudwatch.Dimension
-	dimensionFilter.Name = aws.String("DBInstanceIdentifier")
-	dimensionFilter.Value = aws.String(rdsName)
-	input.Dimensions = []*cloudwatch.Dimension{&dimensionFilter}
-	input.EndTime = aws.Time(time.Now().Add(time.Duration(-fetchAge/60) * time.Minute))
-	input.StartTime = aws.Time((*input.EndTime).Add(time.Duration(-period/60) * time.Minute))
-	input.Period = aws.Int64(period)
-	input.Statistics = []*string{aws.String(statistic)}
-	metrics, err := cloudWatchClient.GetMetricStatistics(&input)
+	series, err := batch.Fetch(cloudWatchClient)
 	if err != nil {
-		return nil, nil, err
+		res.Unknown("", "error while getting metric data: %v", err)
+		return
 	}
-	if metrics != nil && metrics.Datapoints != nil && len(metrics.Datapoints) > 1 {
-		var minimumTimeDifference float64
-		var timeDifference float64
-		var averageValue *float64
-		var timestamp *time.Time
-		minimumTimeDifference = -1
-		for _, datapoint := range metrics.Datapoints {
-			timeDifference = time.Since(*datapoint.Timestamp).Seconds()
-			if minimumTimeDifference == -1 {
-				minimumTimeDifference = timeDifference
-				averageValue = datapoint.Average
-				timestamp = datapoint.Timestamp
-			} else if timeDifference < minimumTimeDifference {
-				minimumTimeDifference = timeDifference
-				averageValue = datapoint.Average
-				timestamp = datapoint.Timestamp
+	for _, dbInstance := range dbInstances {
+		id := *dbInstance.DBInstanceIdentifier
+		for metricName := range statisticsTypeMap {
+			if point, err := series[keys[id][metricName]].Latest(); err == nil {
+				output.Add(metricName, point.Value, point.Timestamp, append(tags, metric.Tag{Name: "DBInstanceIdentifier", Value: id})...)
 			}
 		}
-		return averageValue, timestamp, nil
 	}
-	return nil, nil, nil
 }
 
 func getStatisticTypes() map[string]string {
@@ -150,7 +180,7 @@ func getStatisticTypes() map[string]string {
 	return statisticsTypeMap
 }
 
-func getDBInstances(clusters []*string) ([]*rds.DBInstance, error) {
+func getDBInstances(rdsClient RDSClient, clusters []*string) ([]*rds.DBInstance, error) {
 	dbInstanceInput := &rds.DescribeDBInstancesInput{}
 	if len(clusters) > 0 {
 		filter := &rds.Filter{}
@@ -158,60 +188,27 @@ func getDBInstances(clusters []*string) ([]*rds.DBInstance, error) {
 		filter.Values = clusters
 		dbInstanceInput.Filters = []*rds.Filter{filter}
 	}
-	dbClusterOutput, err := rdsClient.DescribeDBInstances(dbInstanceInput)
-
-	if err != nil {
-		fmt.Println("An error occurred processing AWS RDS API DescribeDBInstances", err)
-		return nil, err
-	}
-
-	if !(dbClusterOutput != nil && dbClusterOutput.DBInstances != nil && len(dbClusterOutput.DBInstances) > 0) {
-		fmt.Println("UNKNOWN : DB Instance not found!")
-		return nil, nil
-	}
-	return dbClusterOutput.DBInstances, nil
+	return pager.DBInstances(rdsClient, dbInstanceInput)
 }
 
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
+	options = append(options, metric.FormatOption(&metricFormat))
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Metrics(config, options, nil, run)
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
+func run(res *result.Result) {
+	var err error
+	output, err = metric.NewWriter(metricFormat, scheme)
+	if err != nil {
+		res.Error("", err)
+		return
 	}
-	metrics()
-	return nil
-}
-
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "metrics-rds",
-		Short: "The Sensu Go Aws RDS handler for metric management",
-		RunE:  run,
+	regionOptions.Run(res, sessionOptions, metrics)
+	pager.Report(res)
+	if err := output.Flush(); err != nil {
+		res.Error("", err)
 	}
-
-	cmd.Flags().StringVarP(&awsRegion,
-		"aws_region",
-		"r",
-		"us-east-1",
-		"AWS Region")
-
-	cmd.Flags().StringVarP(&scheme,
-		"scheme",
-		"s",
-		"",
-		"Metric naming scheme, text to prepend to metric")
-
-	cmd.Flags().StringVar(&awsRegion, "aws_region", "us-east-1", "AWS Region (defaults to us-east-1).")
-	cmd.Flags().StringVar(&scheme, "scheme", "", "Metric naming scheme, text to prepend to metric")
-	cmd.Flags().StringVar(&dbInstanceId, "db_instance_id", "", "DB instance identifier")
-	cmd.Flags().IntVar(&fetchAge, "fetch_age", 0, "How long ago to fetch metrics from in seconds")
-	cmd.Flags().Int64Var(&period, "period", 60, "CloudWatch metric statistics period")
-	return cmd
 }
@@ -17,7 +17,7 @@ package main
 #
 #
 # USAGE:
-#   ./check-s3-bucket-visibility.go --exclude_buckets_regx=sensu --bucket_names=ssensu-ec2,sensu-ec3 --exclude_cuckets=sensu-ec3
+#   ./check-s3-bucket-visibility.go --exclude-buckets-regx=sensu --bucket-names=ssensu-ec2,sensu-ec3 --exclude-cuckets=sensu-ec3
 #
 # NOTES:
 #
@@ -27,111 +27,124 @@ package main
 */
 
 import (
-	"fmt"
-	"os"
+	"errors"
 	"regexp"
 	"strings"
 
-	"github.com/spf13/cobra"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/regions"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/result"
 )
 
 var (
-	s3Client           *s3.S3
+	sessionOptions     aws_session.Options
+	regionOptions      regions.Options
 	filters            string
-	awsRegion          string
 	bucketNames        string
 	allBuckets         bool
 	excludeBuckets     string
 	excludeBucketsRegx string
 	criticalOnMissing  bool
+
+	config = plugin.NewConfig("check-s3-bucket-visibility", "The Sensu Go Aws Bucket handler for bucket visibility management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:      "bucket-names",
+			Env:       "BUCKET_NAMES",
+			Argument:  "bucket-names",
+			Shorthand: "b",
+			Default:   "",
+			Usage:     "A comma seperated list of S3 buckets to check",
+			Value:     &bucketNames,
+		},
+		{
+			Path:      "all-buckets",
+			Env:       "ALL_BUCKETS",
+			Argument:  "all-buckets",
+			Shorthand: "a",
+			Default:   false,
+			Usage:     "If all buckets are true it will look at any buckets that we have access to in the region",
+			Value:     &allBuckets,
+		},
+		{
+			Path:      "exclude-buckets",
+			Env:       "EXCLUDE_BUCKETS",
+			Argument:  "exclude-buckets",
+			Shorthand: "x",
+			Default:   "",
+			Usage:     "A comma seperated list of buckets to ignore that are expected to have loose permissions",
+			Value:     &excludeBuckets,
+		},
+		{
+			Path:     "exclude-buckets-regx",
+			Env:      "EXCLUDE_BUCKETS_REGX",
+			Argument: "exclude-buckets-regx",
+			Default:  "",
+			Usage:    "A regex to filter out bucket names",
+			Value:    &excludeBucketsRegx,
+		},
+		{
+			Path:      "critical-on-missing",
+			Env:       "CRITICAL_ON_MISSING",
+			Argument:  "critical-on-missing",
+			Shorthand: "m",
+			Default:   false,
+			Usage:     "The check will fail with CRITICAL rather than WARN when a bucket is not found",
+			Value:     &criticalOnMissing,
+		},
+	}
 )
 
+// S3Client represents the S3 dependencies of the check
+type S3Client interface {
+	GetBucketPolicy(*s3.GetBucketPolicyInput) (*s3.GetBucketPolicyOutput, error)
+	GetBucketWebsite(*s3.GetBucketWebsiteInput) (*s3.GetBucketWebsiteOutput, error)
+}
+
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
+func validate() error {
+	if len(bucketNames) == 0 {
+		return errors.New("--bucket-names is required")
 	}
-	checkBucketVisibility()
 	return nil
 }
 
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-s3-bucket-visibility",
-		Short: "The Sensu Go Aws Bucket handler for bucket visibility management",
-		RunE:  run,
-	}
-
-	cmd.Flags().StringVarP(&awsRegion,
-		"aws_region",
-		"r",
-		"us-east-1",
-		"AWS Region")
-
-	cmd.Flags().StringVarP(&bucketNames,
-		"bucket_names",
-		"b",
-		"",
-		"A comma seperated list of S3 buckets to check")
-
-	cmd.Flags().BoolVarP(&allBuckets,
-		"all_buckets",
-		"a",
-		false,
-		"If all buckets are true it will look at any buckets that we have access to in the region")
-
-	cmd.Flags().StringVarP(&excludeBuckets,
-		"exclude_buckets",
-		"x",
-		"",
-		"A comma seperated list of buckets to ignore that are expected to have loose permissions")
-
-	cmd.Flags().StringVarP(&excludeBucketsRegx,
-		"exclude_buckets_regx",
-		"r",
-		"",
-		"A regex to filter out bucket names")
-
-	cmd.Flags().BoolVarP(&criticalOnMissing,
-		"critical_on_missing",
-		"m",
-		false,
-		"The check will fail with CRITICAL rather than WARN when a bucket is not found")
-
-	_ = cmd.MarkFlagRequired("bucket_names")
-	return cmd
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkBucketVisibility)
 }
 
-func checkBucketVisibility() {
+func checkBucketVisibility(factory *awsclient.Factory, res *result.Result) {
 	var bucketsTobeExcluded []string
 	var excludeBucket bool
-	var success bool
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
-	success, s3Client = awsclient.GetS3Client(awsSession)
-	if !success {
+	s3Client, err := factory.S3()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
 	if len(strings.TrimSpace(excludeBuckets)) > 0 {
 		bucketsTobeExcluded = strings.Split(excludeBuckets, ",")
 	}
-	excludeBucketsMap := make(map[string]*string)
+	excludeBucketsMap := make(map[string]bool)
 	for _, bucket := range bucketsTobeExcluded {
-		excludeBucketsMap[bucket] = &bucket
+		excludeBucketsMap[bucket] = true
+	}
+	missingStatus := result.Warning
+	if criticalOnMissing {
+		missingStatus = result.Critical
 	}
 	buckets := strings.Split(bucketNames, ",")
 	for _, bucket := range buckets {
@@ -139,46 +152,36 @@ func checkBucketVisibility() {
 		if len(strings.TrimSpace(excludeBucketsRegx)) > 0 {
 			excludeBucket, _ = regexp.MatchString(excludeBucketsRegx, bucket)
 		}
-		if excludeBucket || excludeBucketsMap[bucket] != nil {
-			excludeBucket = true
-		} else {
-			excludeBucket = false
+		if excludeBucket || excludeBucketsMap[bucket] {
+			continue
 		}
-		if !excludeBucket {
-			input := &s3.GetBucketWebsiteInput{Bucket: aws.String(bucket)}
-			_, err := s3Client.GetBucketWebsite(input)
-			if err != nil {
-				if err.(awserr.Error).Code() == "NoSuchBucket" {
-					if criticalOnMissing {
-						fmt.Println(fmt.Sprintf("CRITICAL:'%s' bucket does not exist", bucket))
-
-					} else {
-						fmt.Println(fmt.Sprintf("WARNING:'%s' bucket does not exist", bucket))
-					}
-					continue
-				}
-				if err.(awserr.Error).Code() == "NoSuchWebsiteConfiguration" {
-					fmt.Println(fmt.Sprintf("OK:'%s' bucket does not have a website configuration", bucket))
-				}
+		input := &s3.GetBucketWebsiteInput{Bucket: aws.String(bucket)}
+		_, err := s3Client.GetBucketWebsite(input)
+		if err != nil {
+			if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "NoSuchBucket" {
+				res.Add(missingStatus, bucket, "bucket does not exist")
+				continue
+			} else if ok && awsErr.Code() == "NoSuchWebsiteConfiguration" {
+				res.OK(bucket, "bucket does not have a website configuration")
 			} else {
-				fmt.Println(fmt.Sprintf("CRITICAL:'%s' bucket website configuration found", bucket))
+				res.Error(bucket, err)
+				continue
 			}
-			policyInput := &s3.GetBucketPolicyInput{Bucket: aws.String(bucket)}
-			policyResponse, err := s3Client.GetBucketPolicy(policyInput)
-			if err != nil {
-				if err.(awserr.Error).Code() == "NoSuchBucket" {
-					if criticalOnMissing {
-						fmt.Println(fmt.Sprintf("CRITICAL:'%s' bucket does not exist", bucket))
-					} else {
-						fmt.Println(fmt.Sprintf("WARNING:'%s' bucket does not exist", bucket))
-					}
-				}
-				if err.(awserr.Error).Code() == "NoSuchBucketPolicy" {
-					fmt.Println(fmt.Sprintf("OK:'%s' bucket policy does not exist", bucket))
-				}
-			} else if policyResponse != nil {
-				fmt.Println(fmt.Sprintf("CRITICAL:'%s' bucket policy too permissive", bucket))
+		} else {
+			res.Critical(bucket, "bucket website configuration found")
+		}
+		policyInput := &s3.GetBucketPolicyInput{Bucket: aws.String(bucket)}
+		policyResponse, err := s3Client.GetBucketPolicy(policyInput)
+		if err != nil {
+			if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "NoSuchBucket" {
+				res.Add(missingStatus, bucket, "bucket does not exist")
+			} else if ok && awsErr.Code() == "NoSuchBucketPolicy" {
+				res.OK(bucket, "bucket policy does not exist")
+			} else {
+				res.Error(bucket, err)
 			}
+		} else if policyResponse != nil {
+			res.Critical(bucket, "bucket policy too permissive")
 		}
 	}
 }
@@ -15,7 +15,7 @@ package main
 #
 #
 # USAGE:
-#   ./check-s3-bucket --bucket_name=mybucket
+#   ./check-s3-bucket --bucket-name=mybucket
 #
 # NOTES:
 #
@@ -25,77 +25,82 @@ package main
 */
 
 import (
-	"fmt"
-	"os"
+	"errors"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/spf13/cobra"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/events"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/regions"
+	"github.com/sensu/sensu-aws/result"
 )
 
 var (
-	s3Client   *s3.S3
-	awsRegion  string
-	bucketName string
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	eventOptions   events.Options
+	bucketName     string
+
+	config = plugin.NewConfig("check-s3-bucket", "The Sensu Go Aws Bucket handler for bucket management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:      "bucket-name",
+			Env:       "BUCKET_NAME",
+			Argument:  "bucket-name",
+			Shorthand: "b",
+			Default:   "",
+			Usage:     "An S3 bucket to check",
+			Value:     &bucketName,
+		},
+	}
 )
 
+// S3Client represents the S3 dependencies of the check
+type S3Client interface {
+	HeadBucket(*s3.HeadBucketInput) (*s3.HeadBucketOutput, error)
+}
+
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, eventOptions.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
+func validate() error {
+	if len(bucketName) == 0 {
+		return errors.New("--bucket-name is required")
 	}
-	checkS3Bucket()
 	return nil
 }
 
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-s3-bucket",
-		Short: "The Sensu Go Aws Bucket handler for bucket management",
-		RunE:  run,
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkS3Bucket)
+	if err := eventOptions.Emit(config.Name, res); err != nil {
+		res.Error("", err)
 	}
-
-	cmd.Flags().StringVarP(&awsRegion,
-		"aws_region",
-		"r",
-		"us-east-1",
-		"AWS Region")
-
-	cmd.Flags().StringVarP(&bucketName,
-		"bucket_name",
-		"b",
-		"",
-		"An S3 bucket to check")
-
-	_ = cmd.MarkFlagRequired("bucket_name")
-	return cmd
 }
 
-func checkS3Bucket() {
-	var success bool
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
-	success, s3Client = awsclient.GetS3Client(awsSession)
-	if !success {
+func checkS3Bucket(factory *awsclient.Factory, res *result.Result) {
+	s3Client, err := factory.S3()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
 	input := &s3.HeadBucketInput{Bucket: aws.String(bucketName)}
-	_, err := s3Client.HeadBucket(input)
-	if err != nil && err.(awserr.Error).Code() == "NotFound" {
-		fmt.Println("CRITICAL:", bucketName, "bucket not found")
+	_, err = s3Client.HeadBucket(input)
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "NotFound" {
+		res.Critical(bucketName, "bucket not found")
+	} else if ok {
+		res.Critical(bucketName, "%s", awsErr.Message())
 	} else if err != nil {
-		fmt.Println("CRITICAL:", bucketName, "-", err.(awserr.Error).Message())
+		res.Error(bucketName, err)
 	} else {
-		fmt.Println("OK:", bucketName, "bucket found")
+		res.OK(bucketName, "bucket found")
 	}
 }
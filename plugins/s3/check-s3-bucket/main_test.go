@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sensu/sensu-aws/awstest"
+)
+
+func TestMain(m *testing.M) {
+	awstest.Main(m, main)
+}
+
+func TestPlugin(t *testing.T) {
+	server := awstest.NewServer()
+	defer server.Close()
+	server.Handle(awstest.S3, "HeadBucket", func(req *awstest.Request) (interface{}, error) {
+		if req.Bucket != "logs" {
+			return nil, &awstest.Error{StatusCode: http.StatusNotFound, Code: "NotFound"}
+		}
+		return nil, nil
+	})
+
+	tests := []struct {
+		Name      string
+		Args      []string
+		ExpStatus int
+		ExpOutput string
+	}{
+		{
+			Name:      "bucket found",
+			Args:      []string{"--bucket-name", "logs"},
+			ExpStatus: 0,
+			ExpOutput: "check-s3-bucket OK: logs: bucket found",
+		},
+		{
+			Name:      "bucket not found",
+			Args:      []string{"--bucket-name", "backups"},
+			ExpStatus: 2,
+			ExpOutput: "check-s3-bucket CRITICAL: backups: bucket not found",
+		},
+		{
+			Name:      "deprecated flag",
+			Args:      []string{"--bucket_name=logs"},
+			ExpStatus: 0,
+			ExpOutput: "check-s3-bucket OK: logs: bucket found",
+		},
+		{
+			Name:      "missing bucket name",
+			ExpStatus: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			output, status := server.Exec(t, test.Args...)
+			if got, want := status, test.ExpStatus; got != want {
+				t.Errorf("bad exit status: got %d, want %d (output %q)", got, want, output)
+			}
+			if !strings.HasPrefix(output, test.ExpOutput) {
+				t.Errorf("bad output: got %q, want prefix %q", output, test.ExpOutput)
+			}
+		})
+	}
+}
@@ -15,7 +15,8 @@ package main
 #
 #
 # USAGE:
-#   ./check-s3-object.go --bucket_name=sreejita-testing --key_prefix=s3
+#   ./check-s3-object.go --bucket-name=sreejita-testing --key-prefix=s3
+#   ./check-s3-object.go --bucket-name=sreejita-testing --key-prefix=s3 --warning-age-range=3600:90000 --critical-age-range=~:126000
 #
 # NOTES:
 #
@@ -25,24 +26,29 @@ package main
 */
 
 import (
-	"fmt"
-	"os"
+	"errors"
 	"strings"
 	"time"
 
-	"github.com/spf13/cobra"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/regions"
 	"github.com/sensu/sensu-aws/utils"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/result"
+	"github.com/sensu/sensu-aws/threshold"
 )
 
 var (
-	s3Client                *s3.S3
+	sessionOptions          aws_session.Options
+	regionOptions           regions.Options
+	pager                   utils.Pager
 	filters                 string
 	useIamRole              bool
 	bucketName              string
@@ -52,28 +58,168 @@ var (
 	keyPrefix               string
 	warningAge              float64
 	criticalAge             float64
+	warningAgeRange         string
+	criticalAgeRange        string
 	okZeroSize              bool
 	warningSize             int64
 	criticalSize            int64
 	compareSize             string
+	warningSizeRange        string
+	criticalSizeRange       string
 	noCritOnMultipleObjects bool
-	awsRegion               string
+
+	config = plugin.NewConfig("check-s3-object", "The Sensu Go Aws S3 Object handler for object management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:      "use-iam-role",
+			Env:       "USE_IAM_ROLE",
+			Argument:  "use-iam-role",
+			Shorthand: "u",
+			Default:   false,
+			Usage:     "Use IAM role authenticiation. Instance must have IAM role assigned for this to work",
+			Value:     &useIamRole,
+		},
+		{
+			Path:      "bucket-name",
+			Env:       "BUCKET_NAME",
+			Argument:  "bucket-name",
+			Shorthand: "b",
+			Default:   "",
+			Usage:     "The name of the S3 bucket where object lives",
+			Value:     &bucketName,
+		},
+		{
+			Path:      "key-name",
+			Env:       "KEY_NAME",
+			Argument:  "key-name",
+			Shorthand: "k",
+			Default:   "",
+			Usage:     "The name of key in the bucket",
+			Value:     &keyName,
+		},
+		{
+			Path:      "key-prefix",
+			Env:       "KEY_PREFIX",
+			Argument:  "key-prefix",
+			Shorthand: "p",
+			Default:   "",
+			Usage:     "Prefix key to search on the bucket",
+			Value:     &keyPrefix,
+		},
+		{
+			Path:      "warning-age",
+			Env:       "WARNING_AGE",
+			Argument:  "warning-age",
+			Shorthand: "w",
+			Default:   float64(90000),
+			Usage:     "Warn if mtime greater than provided age in seconds",
+			Value:     &warningAge,
+		},
+		{
+			Path:      "critical-age",
+			Env:       "CRITICAL_AGE",
+			Argument:  "critical-age",
+			Shorthand: "c",
+			Default:   float64(126000),
+			Usage:     "Critical if mtime greater than provided age in seconds",
+			Value:     &criticalAge,
+		},
+		{
+			Path:     "warning-age-range",
+			Env:      "WARNING_AGE_RANGE",
+			Argument: "warning-age-range",
+			Default:  "",
+			Usage:    "Warning threshold range for age in seconds (e.g. 10:, ~:20, @5:10), overrides warning-age",
+			Value:    &warningAgeRange,
+		},
+		{
+			Path:     "critical-age-range",
+			Env:      "CRITICAL_AGE_RANGE",
+			Argument: "critical-age-range",
+			Default:  "",
+			Usage:    "Critical threshold range for age in seconds (e.g. 10:, ~:20, @5:10), overrides critical-age",
+			Value:    &criticalAgeRange,
+		},
+		{
+			Path:      "ok-zero-size",
+			Env:       "OK_ZERO_SIZE",
+			Argument:  "ok-zero-size",
+			Shorthand: "z",
+			Default:   true,
+			Usage:     "OK if file has zero size'",
+			Value:     &okZeroSize,
+		},
+		{
+			Path:     "warning-size",
+			Env:      "WARNING_SIZE",
+			Argument: "warning-size",
+			Default:  int64(0),
+			Usage:    "Warning threshold for size",
+			Value:    &warningSize,
+		},
+		{
+			Path:     "critical-size",
+			Env:      "CRITICAL_SIZE",
+			Argument: "critical-size",
+			Default:  int64(0),
+			Usage:    "Critical threshold for size",
+			Value:    &criticalSize,
+		},
+		{
+			Path:     "operator-size",
+			Env:      "OPERATOR_SIZE",
+			Argument: "operator-size",
+			Default:  "equal",
+			Usage:    "Comparision operator for threshold: equal, not, greater, greater_equal, less, less_equal",
+			Value:    &compareSize,
+		},
+		{
+			Path:     "warning-size-range",
+			Env:      "WARNING_SIZE_RANGE",
+			Argument: "warning-size-range",
+			Default:  "",
+			Usage:    "Warning threshold range for size (e.g. 10:, ~:20, @5:10), overrides warning-size and operator-size",
+			Value:    &warningSizeRange,
+		},
+		{
+			Path:     "critical-size-range",
+			Env:      "CRITICAL_SIZE_RANGE",
+			Argument: "critical-size-range",
+			Default:  "",
+			Usage:    "Critical threshold range for size (e.g. 10:, ~:20, @5:10), overrides critical-size and operator-size",
+			Value:    &criticalSizeRange,
+		},
+		{
+			Path:     "no-crit-on-multiple-objects",
+			Env:      "NO_CRIT_ON_MULTIPLE_OBJECTS",
+			Argument: "no-crit-on-multiple-objects",
+			Default:  true,
+			Usage:    "If this flag is set, sort all matching objects by last_modified date and check against the newest. By default, this check will return a CRITICAL result if multiple matching objects are found.",
+			Value:    &noCritOnMultipleObjects,
+		},
+	}
 )
 
-func checkObject() {
+// S3Client represents the S3 dependencies of the check
+type S3Client interface {
+	HeadObject(*s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
+	ListObjectsPages(*s3.ListObjectsInput, func(*s3.ListObjectsOutput, bool) bool) error
+}
+
+func checkObject(factory *awsclient.Factory, res *result.Result) {
 	var age time.Duration
 	var size int64
 	var keyFullName string
-	var success bool
 
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
-	success, s3Client = awsclient.GetS3Client(awsSession)
-	if !success {
+	s3Client, err := factory.S3()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
 
 	if (len(strings.TrimSpace(keyName)) == 0 && len(strings.TrimSpace(keyPrefix)) == 0) || (len(strings.TrimSpace(keyName)) > 0 && len(strings.TrimSpace(keyPrefix)) > 0) {
-		fmt.Println("Need one option between \"key_name\" and \"key_prefix\"")
+		res.Unknown("", "Need one option between \"key_name\" and \"key_prefix\"")
 		return
 	}
 
@@ -81,192 +227,125 @@ func checkObject() {
 		input := &s3.HeadObjectInput{Bucket: aws.String(bucketName), Key: aws.String(keyName)}
 		output, err := s3Client.HeadObject(input)
 		if err != nil {
-			printErroMessage(err, keyName)
+			checkError(res, err, keyName)
 			return
 		}
 		if output != nil {
 			age = time.Since(*output.LastModified)
 			size = *output.ContentLength
 			keyFullName = keyName
-			printMesaage(age, keyFullName, size)
+			checkObjectDetails(res, age, keyFullName, size)
 		}
 	} else if len(strings.TrimSpace(keyPrefix)) > 0 {
 		input := &s3.ListObjectsInput{Bucket: aws.String(bucketName), Prefix: aws.String(keyPrefix)}
-		output, err := s3Client.ListObjects(input)
+		objects, err := pager.Objects(s3Client, input)
 		if err != nil {
-			printErroMessage(err, keyFullName)
+			checkError(res, err, keyPrefix)
 			return
 		}
-		if output == nil || output.Contents == nil || len(output.Contents) < 1 {
-			fmt.Println(fmt.Sprintf("CRITICAL : Object with prefix \"%s\" not found in bucket '%s'", keyPrefix, bucketName))
+		if len(objects) < 1 {
+			res.Critical(keyPrefix, "Object with prefix not found in bucket '%s'", bucketName)
 			return
 		}
 
-		if output != nil || output.Contents != nil || len(output.Contents) > 1 {
+		if len(objects) > 1 {
 			if !noCritOnMultipleObjects {
-				fmt.Println(fmt.Sprintf("CRITICAL : Your prefix \"%s\" return too much files, you need to be more specific", keyPrefix))
+				res.Critical(keyPrefix, "prefix returns too many files, you need to be more specific")
 				return
 			}
-			utils.SortContents(output.Contents)
+			utils.SortContents(objects)
 		}
 
-		keyFullName = *output.Contents[0].Key
-		age = time.Since(*output.Contents[0].LastModified)
-		size = *output.Contents[0].Size
-		printMesaage(age, keyFullName, size)
+		keyFullName = *objects[0].Key
+		age = time.Since(*objects[0].LastModified)
+		size = *objects[0].Size
+		checkObjectDetails(res, age, keyFullName, size)
 	}
 }
 
-func checkAge(age time.Duration, keyName string) {
-	if age.Seconds() > criticalAge {
-		fmt.Println(fmt.Sprintf("CRITICAL : S3 Object '%s' size : '%d' octets (Bucket - '%s')", keyName, age, bucketName))
+func checkAge(res *result.Result, age time.Duration, keyName string) {
+	thresholds, err := ageThresholds()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
-	if age.Seconds() > warningAge {
-		fmt.Println(fmt.Sprintf("WARNING : S3 Object '%s' size : '%d' octets (Bucket - '%s')", keyName, age, bucketName))
-		return
+	status := thresholds.Status(age.Seconds())
+	if status != result.OK {
+		res.Add(status, keyName, "S3 Object age : '%.0f' seconds, threshold %s (Bucket - '%s')", age.Seconds(), thresholds.Range(status), bucketName)
+	} else {
+		res.OK(keyName, "S3 Object exists in bucket '%s'", bucketName)
 	}
-	fmt.Println(fmt.Sprintf("OK : S3 Object '%s' exists in bucket '%s'", keyName, bucketName))
 }
 
-func checkSize(size int64, keyName string) {
-	if compareSize == "not" {
-		if size != criticalSize {
-			fmt.Println(fmt.Sprintf("CRITICAL : S3 Object '%s' size : '%d' octets (Bucket - '%s')", keyName, size, bucketName))
-			return
-		}
-		if size != warningSize {
-			fmt.Println(fmt.Sprintf("WARNING : S3 Object '%s' size : '%d' octets (Bucket - '%s')", keyName, size, bucketName))
-			return
-		}
+func checkSize(res *result.Result, size int64, keyName string) {
+	thresholds, err := sizeThresholds()
+	if err != nil {
+		res.Error("", err)
+		return
 	}
-
-	if compareSize == "greater" {
-		if size > criticalSize {
-			fmt.Println(fmt.Sprintf("CRITICAL : S3 Object '%s' size : '%d' octets (Bucket - '%s')", keyName, size, bucketName))
-			return
-		}
-		if size > warningSize {
-			fmt.Println(fmt.Sprintf("WARNING : S3 Object '%s' size : '%d' octets (Bucket - '%s')", keyName, size, bucketName))
-			return
-		}
-
-		fmt.Println(fmt.Sprintf("OK : S3 Object '%s' exists in bucket '%s'", keyName, bucketName))
+	status := thresholds.Status(float64(size))
+	if status != result.OK {
+		res.Add(status, keyName, "S3 Object size : '%d' octets, threshold %s (Bucket - '%s')", size, thresholds.Range(status), bucketName)
 	}
+}
 
-	if compareSize == "less" {
-		if size < criticalSize {
-			fmt.Println(fmt.Sprintf("CRITICAL : S3 Object '%s' size : '%d' octets (Bucket - '%s')", keyName, size, bucketName))
-			return
-		}
-		if size < warningSize {
-			fmt.Println(fmt.Sprintf("WARNING : S3 Object '%s' size : '%d' octets (Bucket - '%s')", keyName, size, bucketName))
-			return
-		}
-		fmt.Println(fmt.Sprintf("OK : S3 Object '%s' exists in bucket '%s'", keyName, bucketName))
+// ageThresholds alerts when the object is older than warning-age or
+// critical-age unless ranges are given
+func ageThresholds() (threshold.Thresholds, error) {
+	thresholds := threshold.Over(warningAge, criticalAge)
+	err := thresholds.Override(warningAgeRange, criticalAgeRange)
+	return thresholds, err
+}
+
+// sizeThresholds compares the object size with operator-size unless ranges
+// are given
+func sizeThresholds() (threshold.Thresholds, error) {
+	thresholds, err := threshold.FromOperator(compareSize, float64(warningSize), float64(criticalSize))
+	if err != nil {
+		return thresholds, err
 	}
+	err = thresholds.Override(warningSizeRange, criticalSizeRange)
+	return thresholds, err
 }
 
-func printErroMessage(err error, keyFullName string) {
-	if err.(awserr.Error).Code() == "NotFound" {
-		fmt.Println(fmt.Sprintf("CRITICAL : S3 Object '%s' not found in bucket - '%s'", keyFullName, bucketName))
+func checkError(res *result.Result, err error, keyFullName string) {
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "NotFound" {
+		res.Critical(keyFullName, "S3 Object not found in bucket - '%s'", bucketName)
 	} else {
-		fmt.Println(err.(awserr.Error).Message())
+		res.Error(keyFullName, err)
 	}
 }
 
-func printMesaage(age time.Duration, keyFullName string, size int64) {
-	checkAge(age, keyFullName)
+func checkObjectDetails(res *result.Result, age time.Duration, keyFullName string, size int64) {
+	checkAge(res, age, keyFullName)
 	if size != 0 {
-		checkSize(size, keyFullName)
+		if warningSize != 0 || criticalSize != 0 || len(warningSizeRange) > 0 || len(criticalSizeRange) > 0 {
+			checkSize(res, size, keyFullName)
+		}
 	} else if !okZeroSize {
-		fmt.Println(fmt.Sprintf("CRITICAL : S3 Object '%s' is empty (Bucket - '%s')", keyFullName, bucketName))
+		res.Critical(keyFullName, "S3 Object is empty (Bucket - '%s')", bucketName)
 	}
 }
 
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-s3-object",
-		Short: "The Sensu Go Aws S3 Object handler for object management",
-		RunE:  run,
-	}
-	cmd.Flags().StringVarP(&awsRegion,
-		"aws_region",
-		"r",
-		"us-east-1",
-		"AWS Region (defaults to us-east-1).")
-	cmd.Flags().BoolVarP(&useIamRole,
-		"use_iam_role",
-		"u",
-		false,
-		"Use IAM role authenticiation. Instance must have IAM role assigned for this to work")
-	cmd.Flags().StringVarP(&bucketName,
-		"bucket_name",
-		"b",
-		"",
-		"The name of the S3 bucket where object lives")
-	cmd.Flags().StringVarP(&keyName,
-		"key_name",
-		"k",
-		"",
-		"The name of key in the bucket")
-	cmd.Flags().StringVarP(&keyPrefix,
-		"key_prefix",
-		"p",
-		"",
-		"Prefix key to search on the bucket")
-	cmd.Flags().Float64VarP(&warningAge,
-		"warning_age",
-		"w",
-		90000,
-		"Warn if mtime greater than provided age in seconds")
-	cmd.Flags().Float64VarP(&criticalAge,
-		"critical_age",
-		"c",
-		126000,
-		"Critical if mtime greater than provided age in seconds")
-	cmd.Flags().BoolVarP(&okZeroSize,
-		"ok_zero_size",
-		"z",
-		true,
-		"OK if file has zero size'")
-	cmd.Flags().Int64VarP(&warningSize,
-		"warning_size",
-		"",
-		0,
-		"Warning threshold for size")
-	cmd.Flags().Int64VarP(&criticalSize,
-		"critical_size",
-		"",
-		0,
-		"Critical threshold for size")
-	cmd.Flags().StringVarP(&compareSize,
-		"operator-size",
-		"",
-		"equal",
-		"Comparision operator for threshold: equal, not, greater, less")
-	cmd.Flags().BoolVarP(&noCritOnMultipleObjects,
-		"no_crit_on_multiple_objects",
-		"",
-		true,
-		"If this flag is set, sort all matching objects by last_modified date and check against the newest. By default, this check will return a CRITICAL result if multiple matching objects are found.")
-	_ = cmd.MarkFlagRequired("bucket_name")
-	return cmd
+func main() {
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	options = append(options, pager.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
 }
 
-func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+func validate() error {
+	if len(bucketName) == 0 {
+		return errors.New("--bucket-name is required")
+	}
+	if _, err := ageThresholds(); err != nil {
+		return err
 	}
+	_, err := sizeThresholds()
+	return err
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
-	}
-	checkObject()
-	return nil
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkObject)
+	pager.Report(res)
 }
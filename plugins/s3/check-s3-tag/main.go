@@ -14,7 +14,7 @@ package main
 #   MAC OS
 #
 # USAGE:
-#   ./check-s3-tag --tag_keys=sensu
+#   ./check-s3-tag --tag-keys=sensu
 #
 # LICENSE:
 #   TODO
@@ -22,114 +22,104 @@ package main
 */
 
 import (
-	"flag"
-	"fmt"
-	"os"
+	"errors"
 	"strings"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/spf13/cobra"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/regions"
+	"github.com/sensu/sensu-aws/result"
 )
 
 var (
-	s3Client  *s3.S3
-	tagKeys   string
-	awsRegion string
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	tagKeys        string
+
+	config = plugin.NewConfig("check-s3-tag", "The Sensu Go Aws Bucket handler for tag management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:      "tag-keys",
+			Env:       "TAG_KEYS",
+			Argument:  "tag-keys",
+			Shorthand: "t",
+			Default:   "",
+			Usage:     "Comma seperated Tag Keys",
+			Value:     &tagKeys,
+		},
+	}
 )
 
-func checkTag() {
-	var success bool
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
-	success, s3Client = awsclient.GetS3Client(awsSession)
-	if !success {
+// S3Client represents the S3 dependencies of the check
+type S3Client interface {
+	GetBucketTagging(*s3.GetBucketTaggingInput) (*s3.GetBucketTaggingOutput, error)
+	ListBuckets(*s3.ListBucketsInput) (*s3.ListBucketsOutput, error)
+}
+
+func checkTag(factory *awsclient.Factory, res *result.Result) {
+	s3Client, err := factory.S3()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
 
 	tags := strings.Split(tagKeys, ",")
-	tagMap := make(map[string]*string)
-	missingTagsMap := make(map[string][]string)
-
-	for _, tag := range tags {
-		tagMap[tag] = &tag
-	}
 
 	input := &s3.ListBucketsInput{}
 	output, err := s3Client.ListBuckets(input)
 	if err != nil {
-		fmt.Println(err)
+		res.Error("", err)
+		return
+	}
+	if output == nil {
 		return
 	}
-	if output != nil && output.Buckets != nil && len(output.Buckets) > 1 {
-		for _, bucket := range output.Buckets {
-			bucketInput := &s3.GetBucketTaggingInput{Bucket: bucket.Name}
-			bucketOutput, err := s3Client.GetBucketTagging(bucketInput)
-			if err != nil {
+	for _, bucket := range output.Buckets {
+		bucketTagMap := make(map[string]bool)
+		bucketInput := &s3.GetBucketTaggingInput{Bucket: bucket.Name}
+		bucketOutput, err := s3Client.GetBucketTagging(bucketInput)
+		if err != nil {
+			if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != "NoSuchTagSet" {
+				res.Error(*bucket.Name, err)
 				continue
 			}
-			if bucketOutput != nil && bucketOutput.TagSet != nil && len(bucketOutput.TagSet) > 0 {
-				bucketTagMap := make(map[string]*string)
-				for _, bucketTag := range bucketOutput.TagSet {
-					bucketTagMap[*bucketTag.Key] = bucketTag.Key
-				}
-				for tag, tagValue := range tagMap {
-					if bucketTagMap[tag] != nil {
-						continue
-					} else {
-						missingTagsMap[*bucket.Name] = append(missingTagsMap[*bucket.Name], *tagValue)
-					}
-				}
+		} else if bucketOutput != nil {
+			for _, bucketTag := range bucketOutput.TagSet {
+				bucketTagMap[*bucketTag.Key] = true
 			}
 		}
-	}
-
-	if len(missingTagsMap) > 0 {
-		for bucketName, tags := range missingTagsMap {
-			fmt.Println("CRITICAL : Missing tags for bucket", bucketName, ":", tags)
+		var missingTags []string
+		for _, tag := range tags {
+			if !bucketTagMap[tag] {
+				missingTags = append(missingTags, tag)
+			}
+		}
+		if len(missingTags) > 0 {
+			res.Critical(*bucket.Name, "Missing tags : %v", missingTags)
+		} else {
+			res.OK(*bucket.Name, "all tags present")
 		}
 	}
 }
 
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	plugin.Check(config, options, validate, run)
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
+func validate() error {
+	if len(tagKeys) == 0 {
+		return errors.New("--tag-keys is required")
 	}
-	checkTag()
 	return nil
 }
 
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "check-s3-tag",
-		Short: "The Sensu Go Aws Bucket handler for tag management",
-		RunE:  run,
-	}
-
-	flag.StringVar(&awsRegion, "aws_region", "us-east-1", "AWS Region (defaults to us-east-1).")
-	flag.StringVar(&tagKeys, "tag_keys", "", "Comma seperated Tag Keys")
-
-	cmd.Flags().StringVarP(&awsRegion,
-		"aws_region",
-		"r",
-		"us-east-1",
-		"AWS Region")
-
-	cmd.Flags().StringVarP(&tagKeys,
-		"tag_keys",
-		"t",
-		"",
-		"Comma seperated Tag Keys")
-
-	_ = cmd.MarkFlagRequired("tag_keys")
-	return cmd
+func run(res *result.Result) {
+	regionOptions.Run(res, sessionOptions, checkTag)
 }
@@ -23,131 +23,118 @@ package main
 */
 
 import (
-	"fmt"
-	"os"
-	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/spf13/cobra"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
 	"github.com/sensu/sensu-aws/aws_session"
 	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/metric"
+	"github.com/sensu/sensu-aws/metricdata"
+	"github.com/sensu/sensu-aws/plugin"
+	"github.com/sensu/sensu-aws/regions"
+	"github.com/sensu/sensu-aws/result"
 )
 
 var (
-	s3Client         *s3.S3
-	scheme           string
-	awsRegion        string
-	cloudWatchClient *cloudwatch.CloudWatch
+	sessionOptions aws_session.Options
+	regionOptions  regions.Options
+	scheme         string
+	metricFormat   string
+	output         *metric.Writer
+
+	config = plugin.NewConfig("metrics-s3", "The Sensu Go Aws Bucket handler for metrics management")
+
+	options = []*sensu.PluginConfigOption{
+		{
+			Path:      "scheme",
+			Env:       "SCHEME",
+			Argument:  "scheme",
+			Shorthand: "s",
+			Default:   "sensu.aws.s3.buckets",
+			Usage:     "Metric naming scheme, text to prepend to metric",
+			Value:     &scheme,
+		},
+	}
 )
 
-func metrics() {
-	var success bool
-	awsSession := aws_session.CreateAwsSessionWithRegion(awsRegion)
-	success, s3Client = awsclient.GetS3Client(awsSession)
-	if !success {
+// CloudWatchClient represents the CloudWatch dependencies of the check
+type CloudWatchClient interface {
+	GetMetricDataPages(*cloudwatch.GetMetricDataInput, func(*cloudwatch.GetMetricDataOutput, bool) bool) error
+}
+
+// S3Client represents the S3 dependencies of the check
+type S3Client interface {
+	ListBuckets(*s3.ListBucketsInput) (*s3.ListBucketsOutput, error)
+}
+
+func metrics(factory *awsclient.Factory, res *result.Result) {
+	s3Client, err := factory.S3()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
-	success, cloudWatchClient = awsclient.GetCloudWatchClient(awsSession)
-	if !success {
+	cloudWatchClient, err := factory.CloudWatch()
+	if err != nil {
+		res.Error("", err)
 		return
 	}
 
 	input := &s3.ListBucketsInput{}
-	output, err := s3Client.ListBuckets(input)
+	buckets, err := s3Client.ListBuckets(input)
 	if err != nil {
-		fmt.Println(err)
+		res.Error("", err)
 		return
 	}
-
-	if output != nil && output.Buckets != nil && len(output.Buckets) > 1 {
-		for _, bucket := range output.Buckets {
-			bucketName := strings.Replace(*bucket.Name, ".", "_", -1)
-			getMetricStatistics(bucketName)
-		}
+	if buckets == nil || len(buckets.Buckets) == 0 {
+		return
 	}
 
-}
-
-func getMetricStatistics(bucketName string) {
-	stats := "Average"
-	var period int64
-	period = 24 * 60 * 60
-	var input cloudwatch.GetMetricStatisticsInput
-	input.Namespace = aws.String("AWS/S3")
-	input.MetricName = aws.String("BucketSizeBytes")
-	var dimensionFilter cloudwatch.Dimension
-	dimensionFilter.Name = aws.String("BucketName")
-	dimensionFilter.Value = aws.String(bucketName)
-	var dimensionFilter2 cloudwatch.Dimension
-	dimensionFilter2.Name = aws.String("StorageType")
-	dimensionFilter2.Value = aws.String("StandardStorage")
-	input.Dimensions = []*cloudwatch.Dimension{&dimensionFilter, &dimensionFilter2}
-	input.EndTime = aws.Time(time.Now())
-	input.StartTime = aws.Time(time.Now().Add(time.Duration(-24*60) * time.Minute))
-	input.Period = aws.Int64(period)
-	input.Statistics = []*string{aws.String(stats)}
-	input.Unit = aws.String("Bytes")
-	metrics, err := cloudWatchClient.GetMetricStatistics(&input)
+	batch := metricdata.NewBatch(24*time.Hour, 24*60*60)
+	keys := make(map[string]string)
+	for _, bucket := range buckets.Buckets {
+		keys[*bucket.Name] = batch.Add(metricdata.Query{
+			Namespace:  "AWS/S3",
+			MetricName: "BucketSizeBytes",
+			Dimensions: []*cloudwatch.Dimension{
+				metricdata.Dimension("BucketName", *bucket.Name),
+				metricdata.Dimension("StorageType", "StandardStorage"),
+			},
+			Stat: "Average",
+			Unit: "Bytes",
+		})[0]
+	}
+	series, err := batch.Fetch(cloudWatchClient)
 	if err != nil {
-		fmt.Println("CRITICAL :", scheme, ".", bucketName, ".", "Error : ", err)
+		res.Error("", err)
+		return
 	}
-	if metrics != nil {
-		var minimumTimeDifference float64
-		var timeDifference float64
-		var averageValue *float64
-		minimumTimeDifference = -1
-		for _, datapoint := range metrics.Datapoints {
-			timeDifference = time.Since(*datapoint.Timestamp).Seconds()
-			if minimumTimeDifference == -1 {
-				minimumTimeDifference = timeDifference
-				averageValue = datapoint.Average
-			} else if timeDifference < minimumTimeDifference {
-				minimumTimeDifference = timeDifference
-				averageValue = datapoint.Average
-			}
-		}
-		if averageValue != nil {
-			fmt.Println(fmt.Sprintf("%s.%s.number_of_objects:%v", scheme, bucketName, *averageValue))
+
+	tags := regionOptions.MetricTags(factory)
+	for _, bucket := range buckets.Buckets {
+		if point, err := series[keys[*bucket.Name]].Latest(); err == nil {
+			output.Add("BucketSizeBytes", point.Value, point.Timestamp, append(tags, metric.Tag{Name: "BucketName", Value: *bucket.Name})...)
 		}
 	}
 }
 
 func main() {
-	rootCmd := configureRootCommand()
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
-	}
+	options = append(options, metric.FormatOption(&metricFormat))
+	options = append(options, sessionOptions.PluginConfigOptions()...)
+	options = append(options, regionOptions.PluginConfigOptions()...)
+	plugin.Metrics(config, options, nil, run)
 }
 
-func run(cmd *cobra.Command, args []string) error {
-	if len(args) != 0 {
-		_ = cmd.Help()
-		return fmt.Errorf("invalid argument(s) received")
+func run(res *result.Result) {
+	var err error
+	output, err = metric.NewWriter(metricFormat, scheme)
+	if err != nil {
+		res.Error("", err)
+		return
 	}
-	metrics()
-	return nil
-}
-
-func configureRootCommand() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "metrics-s3",
-		Short: "The Sensu Go Aws Bucket handler for metrics management",
-		RunE:  run,
+	regionOptions.Run(res, sessionOptions, metrics)
+	if err := output.Flush(); err != nil {
+		res.Error("", err)
 	}
-	cmd.Flags().StringVarP(&awsRegion,
-		"aws_region",
-		"r",
-		"us-east-1",
-		"AWS Region")
-
-	cmd.Flags().StringVarP(&scheme,
-		"scheme",
-		"s",
-		"sensu.aws.s3.buckets",
-		"Metric naming scheme, text to prepend to metric")
-	return cmd
 }
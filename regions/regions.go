@@ -0,0 +1,272 @@
+package regions
+
+/*
+runs a check in one or more regions of one or more accounts concurrently and
+merges the findings of every region into a single result
+*/
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-aws/accounts"
+	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/metric"
+	"github.com/sensu/sensu-aws/result"
+)
+
+// DefaultRegion is the plugin region when --aws-region is not set
+const DefaultRegion = "us-east-1"
+
+// DefaultParallelism is the number of regions checked at the same time when
+// Options.Parallelism is not set
+const DefaultParallelism = 4
+
+// Options selects the regions a check runs in, zero values run the check in
+// the plugin region only
+type Options struct {
+	// Region is the plugin region, checked when neither Regions nor
+	// AllRegions is set
+	Region  string
+	Regions []string
+	// AllRegions runs the check in every region enabled for the account
+	AllRegions bool
+	// Parallelism bounds the number of regions checked at the same time
+	Parallelism int
+	// Accounts runs the check in every listed account
+	Accounts accounts.Options
+
+	multipleRegions  bool
+	multipleAccounts bool
+	accounts         map[string]accounts.Account
+}
+
+// target is a region of an account
+type target struct {
+	account accounts.Account
+	region  string
+}
+
+// prefix labels the findings of t with its account and region as needed
+func (o *Options) prefix(t target) string {
+	parts := []string{}
+	if o.multipleAccounts {
+		parts = append(parts, t.account.Label())
+	}
+	if o.multipleRegions {
+		parts = append(parts, t.region)
+	}
+	return strings.Join(parts, "/")
+}
+
+// Describer is implemented by *ec2.EC2
+type Describer interface {
+	DescribeRegions(*ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error)
+}
+
+// CheckFunc checks a single region, clients are created from factory
+type CheckFunc func(factory *awsclient.Factory, res *result.Result)
+
+// PluginConfigOptions returns the plugin region and the region and account
+// selection options
+func (o *Options) PluginConfigOptions() []*sensu.PluginConfigOption {
+	return append(o.Accounts.PluginConfigOptions(),
+		&sensu.PluginConfigOption{Path: "aws-region", Env: "AWS_REGION", Argument: "aws-region", Shorthand: "r", Default: DefaultRegion, Usage: "AWS Region", Value: &o.Region},
+		&sensu.PluginConfigOption{Path: "regions", Env: "AWS_REGIONS", Argument: "regions", Default: []string{}, Usage: "Comma separated list of regions to check, overrides the plugin region", Value: &o.Regions},
+		&sensu.PluginConfigOption{Path: "all-regions", Env: "AWS_ALL_REGIONS", Argument: "all-regions", Default: false, Usage: "Check every region enabled for the account", Value: &o.AllRegions},
+		&sensu.PluginConfigOption{Path: "parallelism", Env: "AWS_REGION_PARALLELISM", Argument: "parallelism", Default: DefaultParallelism, Usage: "Maximum number of regions checked at the same time", Value: &o.Parallelism},
+	)
+}
+
+// Resolve returns the regions to check, client is only used to list the
+// enabled regions when AllRegions is set
+func (o *Options) Resolve(defaultRegion string, client func() (Describer, error)) ([]string, error) {
+	if o.AllRegions {
+		describer, err := client()
+		if err != nil {
+			return nil, err
+		}
+		output, err := describer.DescribeRegions(&ec2.DescribeRegionsInput{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list regions: %v", err)
+		}
+		regions := []string{}
+		for _, region := range output.Regions {
+			regions = append(regions, aws.StringValue(region.RegionName))
+		}
+		sort.Strings(regions)
+		return regions, nil
+	}
+	if len(o.Regions) > 0 {
+		return o.Regions, nil
+	}
+	return []string{defaultRegion}, nil
+}
+
+// Run resolves the accounts and their regions and calls check once per
+// region of every account with a client factory for that region, assuming
+// the role of the account. A single region of a single account writes to res
+// directly, otherwise the account label and region are prefixed to the
+// resource of every finding and the OK summary lists the summary of every
+// region
+func (o *Options) Run(res *result.Result, sessionOptions aws_session.Options, check CheckFunc) {
+	defaultRegion := o.Region
+	if len(defaultRegion) == 0 {
+		defaultRegion = DefaultRegion
+	}
+	newFactory := func(account accounts.Account, region string) (*awsclient.Factory, error) {
+		// regions are checked concurrently, each needs its own copy of the
+		// options
+		opts := sessionOptions
+		opts.Region = region
+		awsSession, err := aws_session.New(opts)
+		if err != nil {
+			return nil, err
+		}
+		factory := awsclient.NewFactory(awsSession)
+		factory.RoleArn = account.RoleArn
+		factory.ExternalID = sessionOptions.ExternalID
+		factory.RoleSessionName = sessionOptions.SessionName()
+		factory.RoleDuration = time.Duration(sessionOptions.SessionDuration) * time.Second
+		return factory, nil
+	}
+
+	accountList, err := o.Accounts.List()
+	if err != nil {
+		res.Error("", err)
+		return
+	}
+	if len(accountList) == 0 {
+		accountList = []accounts.Account{{}}
+	}
+	o.multipleAccounts = len(accountList) > 1
+	o.accounts = make(map[string]accounts.Account)
+	for _, account := range accountList {
+		o.accounts[account.RoleArn] = account
+	}
+
+	var mu sync.Mutex
+	targets := []target{}
+	Each(len(accountList), o.Parallelism, func(i int) {
+		account := accountList[i]
+		regions, err := o.Resolve(defaultRegion, func() (Describer, error) {
+			factory, err := newFactory(account, defaultRegion)
+			if err != nil {
+				return nil, err
+			}
+			return factory.EC2()
+		})
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			res.Error(account.Label(), err)
+			return
+		}
+		if len(regions) > 1 {
+			o.multipleRegions = true
+		}
+		for _, region := range regions {
+			targets = append(targets, target{account: account, region: region})
+		}
+	})
+	sort.SliceStable(targets, func(i, j int) bool {
+		if targets[i].account.Label() != targets[j].account.Label() {
+			return targets[i].account.Label() < targets[j].account.Label()
+		}
+		return targets[i].region < targets[j].region
+	})
+
+	if len(targets) == 1 && !o.multipleAccounts {
+		factory, err := newFactory(targets[0].account, targets[0].region)
+		if err != nil {
+			res.Error("", err)
+			return
+		}
+		check(factory, res)
+		return
+	}
+
+	// findings are merged in target order once every target is checked, so
+	// that the output does not depend on which region answers first
+	targetResults := make([]*result.Result, len(targets))
+	Each(len(targets), o.Parallelism, func(i int) {
+		t := targets[i]
+		targetRes := result.New(o.prefix(t))
+		factory, err := newFactory(t.account, t.region)
+		if err != nil {
+			targetRes.Error("", err)
+		} else {
+			check(factory, targetRes)
+		}
+		targetResults[i] = targetRes
+	})
+	// every finding keeps its resource so that events mode emits one event
+	// per resource, OK targets are only collapsed in the summary
+	summaries := []string{}
+	for i, targetRes := range targetResults {
+		prefix := o.prefix(targets[i])
+		res.MergePrefixed(prefix, targetRes)
+		if targetRes.Status() == result.OK {
+			summaries = append(summaries, fmt.Sprintf("%s: %s", prefix, targetRes.Summary()))
+		}
+	}
+	res.SetOKMessage("%d region(s) OK: %s", len(targets), strings.Join(summaries, "; "))
+}
+
+// MetricTags returns account and region tags for the account and region of
+// factory when Run checks several of them, so that metrics of different
+// regions do not collide
+func (o *Options) MetricTags(factory *awsclient.Factory) []metric.Tag {
+	tags := []metric.Tag{}
+	if o.multipleAccounts {
+		account := o.accounts[factory.RoleArn]
+		tags = append(tags, metric.Tag{Name: "account", Value: account.ID})
+		if len(account.Alias) > 0 {
+			tags = append(tags, metric.Tag{Name: "account_alias", Value: account.Alias})
+		}
+	}
+	if o.multipleRegions {
+		tags = append(tags, metric.Tag{Name: "region", Value: Of(factory)})
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
+
+// Of returns the region clients of factory are created in
+func Of(factory *awsclient.Factory) string {
+	if len(factory.Region) > 0 {
+		return factory.Region
+	}
+	return aws.StringValue(factory.Session.Config.Region)
+}
+
+// Each calls fn for every index below count with at most parallelism calls
+// running at the same time, a parallelism below 1 uses DefaultParallelism
+func Each(count int, parallelism int, fn func(i int)) {
+	if parallelism < 1 {
+		parallelism = DefaultParallelism
+	}
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,247 @@
+package regions
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/sensu/sensu-aws/accounts"
+	"github.com/sensu/sensu-aws/aws_session"
+	"github.com/sensu/sensu-aws/awsclient"
+	"github.com/sensu/sensu-aws/awstest"
+	"github.com/sensu/sensu-aws/result"
+)
+
+type describer struct {
+	regions []string
+	err     error
+}
+
+func (d describer) DescribeRegions(*ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error) {
+	output := &ec2.DescribeRegionsOutput{}
+	for _, region := range d.regions {
+		output.Regions = append(output.Regions, &ec2.Region{RegionName: aws.String(region)})
+	}
+	return output, d.err
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		Name       string
+		Options    Options
+		Describer  describer
+		ExpRegions []string
+		ExpError   bool
+	}{
+		{
+			Name:       "default region",
+			ExpRegions: []string{"us-east-1"},
+		},
+		{
+			Name:       "region list",
+			Options:    Options{Regions: []string{"eu-west-1", "us-west-2"}},
+			ExpRegions: []string{"eu-west-1", "us-west-2"},
+		},
+		{
+			Name:       "all regions",
+			Options:    Options{AllRegions: true, Regions: []string{"eu-west-1"}},
+			Describer:  describer{regions: []string{"us-west-2", "eu-west-1", "ap-south-1"}},
+			ExpRegions: []string{"ap-south-1", "eu-west-1", "us-west-2"},
+		},
+		{
+			Name:      "all regions api error",
+			Options:   Options{AllRegions: true},
+			Describer: describer{err: errors.New("UnauthorizedOperation")},
+			ExpError:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			regions, err := test.Options.Resolve("us-east-1", func() (Describer, error) {
+				return test.Describer, nil
+			})
+			if got, want := err != nil, test.ExpError; got != want {
+				t.Fatalf("conflicting error expectations: got (err != nil) == %v, want %v", got, want)
+			}
+			if got, want := regions, test.ExpRegions; !test.ExpError && !reflect.DeepEqual(got, want) {
+				t.Errorf("bad regions: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestEachParallelism(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning, calls := 0, 0, 0
+	release := make(chan struct{})
+	go func() {
+		for i := 0; i < 6; i++ {
+			release <- struct{}{}
+		}
+	}()
+	Each(6, 2, func(i int) {
+		mu.Lock()
+		running++
+		calls++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		<-release
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+	if calls != 6 {
+		t.Errorf("bad call count: got %d, want 6", calls)
+	}
+	if maxRunning > 2 {
+		t.Errorf("bad parallelism: got %d regions at once, want at most 2", maxRunning)
+	}
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		Name        string
+		Options     Options
+		ExpStatus   result.Status
+		ExpSummary  string
+		ExpFindings []result.Finding
+	}{
+		{
+			Name:       "single region keeps resources",
+			ExpStatus:  result.Critical,
+			ExpSummary: "vol-1: us-east-1",
+			ExpFindings: []result.Finding{
+				{Resource: "vol-1", Status: result.Critical, Message: "us-east-1"},
+				{Resource: "vol-2", Status: result.OK, Message: "attached"},
+			},
+		},
+		{
+			Name:       "several regions",
+			Options:    Options{Regions: []string{"us-east-1", "eu-west-1"}},
+			ExpStatus:  result.Critical,
+			ExpSummary: "us-east-1/vol-1: us-east-1",
+			ExpFindings: []result.Finding{
+				{Resource: "us-east-1/vol-1", Status: result.Critical, Message: "us-east-1"},
+				{Resource: "eu-west-1/vol-2", Status: result.OK, Message: "attached"},
+				{Resource: "us-east-1/vol-2", Status: result.OK, Message: "attached"},
+			},
+		},
+		{
+			Name:       "several regions OK",
+			Options:    Options{Regions: []string{"eu-west-1", "eu-central-1"}},
+			ExpStatus:  result.OK,
+			ExpSummary: "2 region(s) OK: eu-central-1: fine; eu-west-1: fine",
+			ExpFindings: []result.Finding{
+				{Resource: "eu-central-1/vol-2", Status: result.OK, Message: "attached"},
+				{Resource: "eu-west-1/vol-2", Status: result.OK, Message: "attached"},
+			},
+		},
+		{
+			Name: "several accounts",
+			Options: Options{Accounts: accounts.Options{RoleArns: []string{
+				"arn:aws:iam::111111111111:role/sensu",
+				"arn:aws:iam::222222222222:role/sensu",
+			}}},
+			ExpStatus:  result.Critical,
+			ExpSummary: "111111111111/vol-1: us-east-1",
+			ExpFindings: []result.Finding{
+				{Resource: "111111111111/vol-1", Status: result.Critical, Message: "us-east-1"},
+				{Resource: "111111111111/vol-2", Status: result.OK, Message: "attached"},
+				{Resource: "222222222222/vol-2", Status: result.OK, Message: "attached"},
+			},
+		},
+		{
+			Name: "several accounts and regions",
+			Options: Options{
+				Regions: []string{"us-east-1", "eu-west-1"},
+				Accounts: accounts.Options{RoleArns: []string{
+					"arn:aws:iam::111111111111:role/sensu",
+					"arn:aws:iam::222222222222:role/sensu",
+				}},
+				Parallelism: 4,
+			},
+			ExpStatus:  result.Critical,
+			ExpSummary: "111111111111/us-east-1/vol-1: us-east-1",
+			ExpFindings: []result.Finding{
+				{Resource: "111111111111/us-east-1/vol-1", Status: result.Critical, Message: "us-east-1"},
+				{Resource: "111111111111/eu-west-1/vol-2", Status: result.OK, Message: "attached"},
+				{Resource: "111111111111/us-east-1/vol-2", Status: result.OK, Message: "attached"},
+				{Resource: "222222222222/eu-west-1/vol-2", Status: result.OK, Message: "attached"},
+				{Resource: "222222222222/us-east-1/vol-2", Status: result.OK, Message: "attached"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			res := result.New("test")
+			test.Options.Run(res, aws_session.Options{}, func(factory *awsclient.Factory, res *result.Result) {
+				region := aws.StringValue(factory.Session.Config.Region)
+				if region == "us-east-1" && !strings.Contains(factory.RoleArn, "222222222222") {
+					res.Critical("vol-1", "%s", region)
+				}
+				res.OK("vol-2", "attached")
+				res.SetOKMessage("fine")
+			})
+			if got, want := res.Status(), test.ExpStatus; got != want {
+				t.Errorf("bad status: got %s, want %s", got, want)
+			}
+			if got, want := res.Summary(), test.ExpSummary; got != want {
+				t.Errorf("bad summary: got %q, want %q", got, want)
+			}
+			if got, want := res.Findings(), test.ExpFindings; !reflect.DeepEqual(got, want) {
+				t.Errorf("bad findings: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRunAssumesAccountRoles(t *testing.T) {
+	server := awstest.NewServer()
+	defer server.Close()
+	defer server.Install()()
+
+	options := Options{Accounts: accounts.Options{RoleArns: []string{
+		"arn:aws:iam::111111111111:role/sensu",
+		"arn:aws:iam::222222222222:role/sensu",
+	}}}
+	sessionOptions := aws_session.Options{ExternalID: "sensu-external-id", RoleSessionName: "sensu-check", SessionDuration: 900}
+	res := result.New("test")
+	options.Run(res, sessionOptions, func(factory *awsclient.Factory, res *result.Result) {
+		if _, err := factory.EC2(); err != nil {
+			res.Error("", err)
+		}
+	})
+	if got, want := res.Status(), result.OK; got != want {
+		t.Fatalf("bad status: got %s, want %s (%s)", got, want, res.Summary())
+	}
+
+	roleArns := []string{}
+	for _, req := range server.Requests() {
+		if req.Operation != "AssumeRole" {
+			continue
+		}
+		roleArns = append(roleArns, req.Params.Get("RoleArn"))
+		if got, want := req.Params.Get("ExternalId"), "sensu-external-id"; got != want {
+			t.Errorf("bad external id: got %q, want %q", got, want)
+		}
+		if got, want := req.Params.Get("RoleSessionName"), "sensu-check"; got != want {
+			t.Errorf("bad role session name: got %q, want %q", got, want)
+		}
+		if got, want := req.Params.Get("DurationSeconds"), "900"; got != want {
+			t.Errorf("bad duration: got %q, want %q", got, want)
+		}
+	}
+	sort.Strings(roleArns)
+	if got, want := roleArns, options.Accounts.RoleArns; !reflect.DeepEqual(got, want) {
+		t.Errorf("bad roles assumed: got %v, want %v", got, want)
+	}
+}
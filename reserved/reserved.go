@@ -0,0 +1,294 @@
+package reserved
+
+/*
+matches the running EC2 instances of a region to its active reserved
+instances, to tell which reservations are unused and which instances run on
+demand, and collects the utilization of the savings plans of the region.
+Zonal reservations apply first, then regional reservations of the instance
+type, then regional Linux/UNIX reservations to the other sizes of their
+instance family by normalized footprint
+*/
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// LinuxUnix is the platform of instances without licensed software, the
+// only one whose regional reservations apply to every size of their family
+const LinuxUnix = "Linux/UNIX"
+
+// maxFilterValues bounds the image IDs of a DescribeImages filter
+const maxFilterValues = 200
+
+// epsilon absorbs the rounding of footprint shares
+const epsilon = 1e-9
+
+// footprints are the normalization factors of instance sizes, metal sizes
+// differ between families and have none
+var footprints = map[string]float64{
+	"nano":      0.25,
+	"micro":     0.5,
+	"small":     1,
+	"medium":    2,
+	"large":     4,
+	"xlarge":    8,
+	"2xlarge":   16,
+	"3xlarge":   24,
+	"4xlarge":   32,
+	"6xlarge":   48,
+	"8xlarge":   64,
+	"9xlarge":   72,
+	"10xlarge":  80,
+	"12xlarge":  96,
+	"16xlarge":  128,
+	"18xlarge":  144,
+	"24xlarge":  192,
+	"32xlarge":  256,
+	"48xlarge":  384,
+	"56xlarge":  448,
+	"112xlarge": 896,
+}
+
+// Client is implemented by *ec2.EC2
+type Client interface {
+	DescribeReservedInstances(*ec2.DescribeReservedInstancesInput) (*ec2.DescribeReservedInstancesOutput, error)
+}
+
+// ImageDescriber is implemented by *ec2.EC2
+type ImageDescriber interface {
+	DescribeImages(*ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error)
+}
+
+// Active returns the active reserved instances of the region of client,
+// DescribeReservedInstances is not paginated
+func Active(client Client) ([]*ec2.ReservedInstances, error) {
+	output, err := client.DescribeReservedInstances(&ec2.DescribeReservedInstancesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("state"), Values: aws.StringSlice([]string{ec2.ReservedInstanceStateActive})}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.ReservedInstances, nil
+}
+
+// Platforms returns the billing platform of the images of instances by image
+// ID, e.g. Red Hat Enterprise Linux, named like the product descriptions of
+// reserved instances. Images are looked up by filter so that images no
+// longer available are left out instead of failing the call
+func Platforms(client ImageDescriber, instances []*ec2.Instance) (map[string]string, error) {
+	platforms := make(map[string]string)
+	seen := make(map[string]bool)
+	var ids []string
+	for _, instance := range instances {
+		id := aws.StringValue(instance.ImageId)
+		if len(id) > 0 && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	for start := 0; start < len(ids); start += maxFilterValues {
+		end := start + maxFilterValues
+		if end > len(ids) {
+			end = len(ids)
+		}
+		output, err := client.DescribeImages(&ec2.DescribeImagesInput{
+			Filters: []*ec2.Filter{{Name: aws.String("image-id"), Values: aws.StringSlice(ids[start:end])}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, image := range output.Images {
+			platforms[aws.StringValue(image.ImageId)] = aws.StringValue(image.PlatformDetails)
+		}
+	}
+	return platforms, nil
+}
+
+// platform returns the billing platform of instance, from the platform of
+// its image when known, otherwise Windows or Linux/UNIX
+func platform(instance *ec2.Instance, platforms map[string]string) string {
+	if p := platforms[aws.StringValue(instance.ImageId)]; len(p) > 0 {
+		return p
+	}
+	if strings.EqualFold(aws.StringValue(instance.Platform), ec2.PlatformValuesWindows) {
+		return "Windows"
+	}
+	return LinuxUnix
+}
+
+// productPlatform returns the platform of a reserved instance product
+// description, e.g. Linux/UNIX for Linux/UNIX (Amazon VPC)
+func productPlatform(description string) string {
+	return strings.TrimSuffix(description, " (Amazon VPC)")
+}
+
+// Footprint returns the family of instanceType and the normalization factor
+// of its size, 0 when the size has none
+func Footprint(instanceType string) (string, float64) {
+	parts := strings.SplitN(instanceType, ".", 2)
+	if len(parts) < 2 {
+		return instanceType, 0
+	}
+	return parts[0], footprints[parts[1]]
+}
+
+// Usage is the number of instances running on a reservation
+type Usage struct {
+	Reservation *ec2.ReservedInstances
+	// Used counts reserved instances in use, an instance of another size of
+	// the family uses its share of the footprint of the reserved size
+	Used float64
+}
+
+// Count returns the number of instances reserved
+func (u *Usage) Count() int64 {
+	return aws.Int64Value(u.Reservation.InstanceCount)
+}
+
+// Unused returns the number of reserved instances no instance runs on
+func (u *Usage) Unused() float64 {
+	unused := float64(u.Count()) - u.Used
+	if unused < epsilon {
+		return 0
+	}
+	return unused
+}
+
+// Utilization returns the percentage of reserved instances used
+func (u *Usage) Utilization() float64 {
+	if u.Count() == 0 {
+		return 0
+	}
+	return 100 * u.Used / float64(u.Count())
+}
+
+// Zonal returns whether the reservation is for a single availability zone
+func (u *Usage) Zonal() bool {
+	return aws.StringValue(u.Reservation.Scope) == ec2.ScopeAvailabilityZone
+}
+
+// Scope returns the availability zone of a zonal reservation, or "regional"
+func (u *Usage) Scope() string {
+	if u.Zonal() {
+		return aws.StringValue(u.Reservation.AvailabilityZone)
+	}
+	return "regional"
+}
+
+// Flexible returns whether the reservation applies to every size of its
+// instance family: regional Linux/UNIX reservations with default tenancy
+func (u *Usage) Flexible() bool {
+	r := u.Reservation
+	tenancy := aws.StringValue(r.InstanceTenancy)
+	return !u.Zonal() && productPlatform(aws.StringValue(r.ProductDescription)) == LinuxUnix &&
+		(len(tenancy) == 0 || tenancy == ec2.TenancyDefault)
+}
+
+// matches returns whether instance, running platform, can use the
+// reservation: same instance type, or any size of the family when anySize is
+// set and the reservation is flexible, same tenancy, platform and, for zonal
+// reservations, availability zone
+func (u *Usage) matches(instance *ec2.Instance, platform string, anySize bool) bool {
+	r := u.Reservation
+	reservedType, instanceType := aws.StringValue(r.InstanceType), aws.StringValue(instance.InstanceType)
+	if anySize {
+		reservedFamily, reservedSize := Footprint(reservedType)
+		family, size := Footprint(instanceType)
+		if !u.Flexible() || reservedFamily != family || reservedSize == 0 || size == 0 {
+			return false
+		}
+	} else if reservedType != instanceType {
+		return false
+	}
+	tenancy, zone := ec2.TenancyDefault, ""
+	if instance.Placement != nil {
+		if len(aws.StringValue(instance.Placement.Tenancy)) > 0 {
+			tenancy = aws.StringValue(instance.Placement.Tenancy)
+		}
+		zone = aws.StringValue(instance.Placement.AvailabilityZone)
+	}
+	if r.InstanceTenancy != nil && aws.StringValue(r.InstanceTenancy) != tenancy {
+		return false
+	}
+	if productPlatform(aws.StringValue(r.ProductDescription)) != platform {
+		return false
+	}
+	return !u.Zonal() || aws.StringValue(r.AvailabilityZone) == zone
+}
+
+// apply runs share of an instance of instanceType on the reservation as far
+// as it has room, and returns the share of the instance it covers
+func (u *Usage) apply(instanceType string, share float64) float64 {
+	free := u.Unused()
+	if free == 0 {
+		return 0
+	}
+	// reserved instances used per instance
+	ratio := 1.0
+	if reservedType := aws.StringValue(u.Reservation.InstanceType); reservedType != instanceType {
+		_, reservedSize := Footprint(reservedType)
+		_, size := Footprint(instanceType)
+		ratio = size / reservedSize
+	}
+	if need := share * ratio; need <= free+epsilon {
+		u.Used += need
+		return share
+	}
+	u.Used += free
+	return free / ratio
+}
+
+// Match runs instances on reservations and returns the usage of every
+// reservation ordered by end date and the instances not fully covered by a
+// reservation. platforms holds the billing platform of images as returned
+// by Platforms. Spot and scheduled instances are billed by their own terms
+// and left out
+func Match(reservations []*ec2.ReservedInstances, instances []*ec2.Instance, platforms map[string]string) ([]*Usage, []*ec2.Instance) {
+	onDemandInstances := []*ec2.Instance{}
+	for _, instance := range instances {
+		if instance.InstanceLifecycle == nil {
+			onDemandInstances = append(onDemandInstances, instance)
+		}
+	}
+	instances = onDemandInstances
+
+	usages := make([]*Usage, len(reservations))
+	for i, reservation := range reservations {
+		usages[i] = &Usage{Reservation: reservation}
+	}
+	sort.SliceStable(usages, func(i, j int) bool {
+		return aws.TimeValue(usages[i].Reservation.End).Before(aws.TimeValue(usages[j].Reservation.End))
+	})
+
+	// uncovered is the share of every instance running on demand
+	uncovered := make([]float64, len(instances))
+	for i := range uncovered {
+		uncovered[i] = 1
+	}
+	passes := []struct{ zonal, anySize bool }{{true, false}, {false, false}, {false, true}}
+	for _, pass := range passes {
+		for i, instance := range instances {
+			instancePlatform := platform(instance, platforms)
+			for _, usage := range usages {
+				if uncovered[i] < epsilon {
+					break
+				}
+				if usage.Zonal() == pass.zonal && usage.matches(instance, instancePlatform, pass.anySize) {
+					uncovered[i] -= usage.apply(aws.StringValue(instance.InstanceType), uncovered[i])
+				}
+			}
+		}
+	}
+
+	var onDemand []*ec2.Instance
+	for i, instance := range instances {
+		if uncovered[i] >= epsilon {
+			onDemand = append(onDemand, instance)
+		}
+	}
+	return usages, onDemand
+}
@@ -0,0 +1,282 @@
+package reserved
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/savingsplans"
+)
+
+func reservation(id string, instanceType string, zone string, count int64) *ec2.ReservedInstances {
+	r := &ec2.ReservedInstances{
+		ReservedInstancesId: aws.String(id),
+		InstanceType:        aws.String(instanceType),
+		InstanceCount:       aws.Int64(count),
+		InstanceTenancy:     aws.String(ec2.TenancyDefault),
+		ProductDescription:  aws.String("Linux/UNIX"),
+		Scope:               aws.String(ec2.ScopeRegion),
+		End:                 aws.Time(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)),
+	}
+	if len(zone) > 0 {
+		r.Scope = aws.String(ec2.ScopeAvailabilityZone)
+		r.AvailabilityZone = aws.String(zone)
+	}
+	return r
+}
+
+func instance(id string, instanceType string, zone string) *ec2.Instance {
+	return &ec2.Instance{
+		InstanceId:   aws.String(id),
+		InstanceType: aws.String(instanceType),
+		Placement:    &ec2.Placement{AvailabilityZone: aws.String(zone), Tenancy: aws.String(ec2.TenancyDefault)},
+	}
+}
+
+func TestMatch(t *testing.T) {
+	windows := instance("i-windows", "m5.large", "eu-west-1a")
+	windows.Platform = aws.String("windows")
+	windowsXlarge := instance("i-windows-xlarge", "m5.xlarge", "eu-west-1a")
+	windowsXlarge.Platform = aws.String("windows")
+	dedicated := instance("i-dedicated", "m5.large", "eu-west-1a")
+	dedicated.Placement.Tenancy = aws.String(ec2.TenancyDedicated)
+	rhel := instance("i-rhel", "m5.large", "eu-west-1a")
+	rhel.ImageId = aws.String("ami-rhel")
+	rhelReservation := reservation("r-rhel", "m5.large", "", 1)
+	rhelReservation.ProductDescription = aws.String("Red Hat Enterprise Linux (Amazon VPC)")
+	windowsReservation := reservation("r-windows", "m5.large", "", 2)
+	windowsReservation.ProductDescription = aws.String("Windows")
+	spot := instance("i-spot", "m5.large", "eu-west-1a")
+	spot.InstanceLifecycle = aws.String(ec2.InstanceLifecycleTypeSpot)
+	scheduled := instance("i-scheduled", "m5.large", "eu-west-1a")
+	scheduled.InstanceLifecycle = aws.String(ec2.InstanceLifecycleTypeScheduled)
+	platforms := map[string]string{"ami-rhel": "Red Hat Enterprise Linux"}
+
+	tests := []struct {
+		Name         string
+		Reservations []*ec2.ReservedInstances
+		Instances    []*ec2.Instance
+		ExpUsed      []float64
+		ExpOnDemand  string
+	}{
+		{
+			Name:         "zonal before regional",
+			Reservations: []*ec2.ReservedInstances{reservation("r-regional", "m5.large", "", 2), reservation("r-zonal", "m5.large", "eu-west-1a", 1)},
+			Instances:    []*ec2.Instance{instance("i-1", "m5.large", "eu-west-1a"), instance("i-2", "m5.large", "eu-west-1b")},
+			ExpUsed:      []float64{1, 1},
+		},
+		{
+			Name:         "on demand",
+			Reservations: []*ec2.ReservedInstances{reservation("r-zonal", "m5.large", "eu-west-1a", 1)},
+			Instances:    []*ec2.Instance{instance("i-1", "m5.large", "eu-west-1b"), instance("i-2", "t3.micro", "eu-west-1a"), instance("i-3", "m5.large", "eu-west-1a")},
+			ExpUsed:      []float64{1},
+			ExpOnDemand:  "i-1,i-2",
+		},
+		{
+			Name:         "platform and tenancy",
+			Reservations: []*ec2.ReservedInstances{reservation("r-regional", "m5.large", "", 3)},
+			Instances:    []*ec2.Instance{windows, dedicated},
+			ExpUsed:      []float64{0},
+			ExpOnDemand:  "i-windows,i-dedicated",
+		},
+		{
+			Name:         "exact platform",
+			Reservations: []*ec2.ReservedInstances{rhelReservation, reservation("r-linux", "m5.large", "", 1), windowsReservation},
+			Instances:    []*ec2.Instance{rhel, windows, instance("i-linux", "m5.large", "eu-west-1a")},
+			ExpUsed:      []float64{1, 1, 1},
+		},
+		{
+			Name:         "rhel is not linux",
+			Reservations: []*ec2.ReservedInstances{reservation("r-linux", "m5.large", "", 1)},
+			Instances:    []*ec2.Instance{rhel},
+			ExpUsed:      []float64{0},
+			ExpOnDemand:  "i-rhel",
+		},
+		{
+			Name:         "size flexibility",
+			Reservations: []*ec2.ReservedInstances{reservation("r-large", "m5.large", "", 4)},
+			Instances:    []*ec2.Instance{instance("i-1", "m5.xlarge", "eu-west-1a"), instance("i-2", "m5.medium", "eu-west-1b"), instance("i-3", "m5.medium", "eu-west-1b")},
+			ExpUsed:      []float64{3},
+		},
+		{
+			Name:         "partial coverage",
+			Reservations: []*ec2.ReservedInstances{reservation("r-large", "m5.large", "", 1)},
+			Instances:    []*ec2.Instance{instance("i-1", "m5.xlarge", "eu-west-1a")},
+			ExpUsed:      []float64{1},
+			ExpOnDemand:  "i-1",
+		},
+		{
+			Name:         "exact size first",
+			Reservations: []*ec2.ReservedInstances{reservation("r-large", "m5.large", "", 1), reservation("r-xlarge", "m5.xlarge", "", 1)},
+			Instances:    []*ec2.Instance{instance("i-1", "m5.xlarge", "eu-west-1a"), instance("i-2", "m5.large", "eu-west-1a")},
+			ExpUsed:      []float64{1, 1},
+		},
+		{
+			Name:         "no flexibility",
+			Reservations: []*ec2.ReservedInstances{reservation("r-zonal", "m5.large", "eu-west-1a", 2), windowsReservation, reservation("r-c5", "c5.large", "", 2)},
+			Instances:    []*ec2.Instance{instance("i-1", "m5.xlarge", "eu-west-1a"), windowsXlarge},
+			ExpUsed:      []float64{0, 0, 0},
+			ExpOnDemand:  "i-1,i-windows-xlarge",
+		},
+		{
+			Name:         "spot and scheduled",
+			Reservations: []*ec2.ReservedInstances{reservation("r-regional", "m5.large", "", 1)},
+			Instances:    []*ec2.Instance{spot, scheduled, instance("i-1", "m5.large", "eu-west-1a")},
+			ExpUsed:      []float64{1},
+		},
+		{
+			Name:         "unused",
+			Reservations: []*ec2.ReservedInstances{reservation("r-regional", "c5.large", "", 4)},
+			ExpUsed:      []float64{0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			usages, onDemand := Match(test.Reservations, test.Instances, platforms)
+			used := map[string]float64{}
+			for _, usage := range usages {
+				used[*usage.Reservation.ReservedInstancesId] = usage.Used
+			}
+			for i, reservation := range test.Reservations {
+				if got, want := used[*reservation.ReservedInstancesId], test.ExpUsed[i]; got != want {
+					t.Errorf("bad use of %s: got %v, want %v", *reservation.ReservedInstancesId, got, want)
+				}
+			}
+			var ids []string
+			for _, instance := range onDemand {
+				ids = append(ids, *instance.InstanceId)
+			}
+			if got, want := strings.Join(ids, ","), test.ExpOnDemand; got != want {
+				t.Errorf("bad on demand instances: got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestUsage(t *testing.T) {
+	usage := &Usage{Reservation: reservation("r-1", "m5.large", "eu-west-1a", 4), Used: 3}
+	if got, want := usage.Unused(), 1.0; got != want {
+		t.Errorf("bad unused: got %v, want %v", got, want)
+	}
+	if got, want := usage.Utilization(), 75.0; got != want {
+		t.Errorf("bad utilization: got %v, want %v", got, want)
+	}
+	if got, want := usage.Scope(), "eu-west-1a"; got != want {
+		t.Errorf("bad scope: got %q, want %q", got, want)
+	}
+}
+
+func TestFootprint(t *testing.T) {
+	tests := []struct {
+		Type         string
+		ExpFamily    string
+		ExpFootprint float64
+	}{
+		{"t3.nano", "t3", 0.25},
+		{"m5.2xlarge", "m5", 16},
+		{"c5n.18xlarge", "c5n", 144},
+		{"m5.metal", "m5", 0},
+		{"unknown", "unknown", 0},
+	}
+	for _, test := range tests {
+		family, footprint := Footprint(test.Type)
+		if family != test.ExpFamily || footprint != test.ExpFootprint {
+			t.Errorf("bad footprint of %s: got %s %v, want %s %v", test.Type, family, footprint, test.ExpFamily, test.ExpFootprint)
+		}
+	}
+}
+
+type images []*ec2.Image
+
+func (i images) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	output := &ec2.DescribeImagesOutput{}
+	for _, image := range i {
+		for _, id := range input.Filters[0].Values {
+			if *id == *image.ImageId {
+				output.Images = append(output.Images, image)
+			}
+		}
+	}
+	return output, nil
+}
+
+func TestPlatforms(t *testing.T) {
+	client := images{{ImageId: aws.String("ami-suse"), PlatformDetails: aws.String("SUSE Linux")}}
+	suse, gone := instance("i-suse", "m5.large", "eu-west-1a"), instance("i-gone", "m5.large", "eu-west-1a")
+	suse.ImageId, gone.ImageId = aws.String("ami-suse"), aws.String("ami-gone")
+
+	platforms, err := Platforms(client, []*ec2.Instance{suse, gone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := platform(suse, platforms), "SUSE Linux"; got != want {
+		t.Errorf("bad platform: got %q, want %q", got, want)
+	}
+	if got, want := platform(gone, platforms), LinuxUnix; got != want {
+		t.Errorf("bad platform of an instance without image: got %q, want %q", got, want)
+	}
+}
+
+type plans []*savingsplans.SavingsPlan
+
+func (p plans) DescribeSavingsPlans(*savingsplans.DescribeSavingsPlansInput) (*savingsplans.DescribeSavingsPlansOutput, error) {
+	return &savingsplans.DescribeSavingsPlansOutput{SavingsPlans: p}, nil
+}
+
+type utilization []*costexplorer.SavingsPlansUtilizationDetail
+
+func (u utilization) GetSavingsPlansUtilizationDetailsPages(input *costexplorer.GetSavingsPlansUtilizationDetailsInput, fn func(*costexplorer.GetSavingsPlansUtilizationDetailsOutput, bool) bool) error {
+	fn(&costexplorer.GetSavingsPlansUtilizationDetailsOutput{SavingsPlansUtilizationDetails: u}, true)
+	return nil
+}
+
+func TestSavingsPlans(t *testing.T) {
+	plan := func(id string, region string, end string) *savingsplans.SavingsPlan {
+		return &savingsplans.SavingsPlan{
+			SavingsPlanId:  aws.String(id),
+			SavingsPlanArn: aws.String("arn:aws:savingsplans::123456789012:savingsplan/" + id),
+			Region:         aws.String(region),
+			End:            aws.String(end),
+		}
+	}
+	client := plans{
+		plan("sp-compute", "", "2023-06-01T00:00:00.000Z"),
+		plan("sp-east", "us-east-1", "2022-01-01T00:00:00.000Z"),
+		plan("sp-west", "eu-west-1", "2022-01-01T00:00:00.000Z"),
+	}
+	costs := utilization{{
+		SavingsPlanArn: aws.String("arn:aws:savingsplans::123456789012:savingsplan/sp-east"),
+		Utilization:    &costexplorer.SavingsPlansUtilization{UtilizationPercentage: aws.String("82.5"), UnusedCommitment: aws.String("12.6")},
+	}}
+
+	usages, err := SavingsPlans(nil, client, costs, "us-east-1", UtilizationPeriod(time.Date(2021, 3, 8, 12, 0, 0, 0, time.UTC), 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(usages), 2; got != want {
+		t.Fatalf("bad savings plan count: got %d, want %d", got, want)
+	}
+	if got, want := *usages[0].Plan.SavingsPlanId, "sp-east"; got != want {
+		t.Errorf("bad first savings plan: got %q, want %q", got, want)
+	}
+	if percentage, ok := usages[0].Percentage(); !ok || percentage != 82.5 || usages[0].Unused() != 12.6 {
+		t.Errorf("bad utilization: got %v %v %v", percentage, ok, usages[0].Unused())
+	}
+	if _, ok := usages[1].Percentage(); ok {
+		t.Error("expected no utilization data for sp-compute")
+	}
+	if end, err := usages[1].End(); err != nil || end.Year() != 2023 {
+		t.Errorf("bad end: got %v (%v)", end, err)
+	}
+}
+
+func TestUtilizationPeriod(t *testing.T) {
+	period := UtilizationPeriod(time.Date(2021, 3, 8, 12, 0, 0, 0, time.UTC), 7)
+	if got, want := *period.Start+"/"+*period.End, "2021-03-01/2021-03-08"; got != want {
+		t.Errorf("bad period: got %q, want %q", got, want)
+	}
+}
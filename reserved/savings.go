@@ -0,0 +1,121 @@
+package reserved
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/savingsplans"
+	"github.com/sensu/sensu-aws/utils"
+)
+
+// globalRegion is where savings plans without a region are reported, the
+// Savings Plans and Cost Explorer APIs are served from it
+const globalRegion = "us-east-1"
+
+// SavingsPlanUsage is a savings plan and its utilization over a period
+type SavingsPlanUsage struct {
+	Plan *savingsplans.SavingsPlan
+	// Utilization is nil until Cost Explorer has data for the plan
+	Utilization *costexplorer.SavingsPlansUtilization
+}
+
+// Percentage returns the percentage of the commitment used, false when there
+// is no utilization data yet
+func (u *SavingsPlanUsage) Percentage() (float64, bool) {
+	if u.Utilization == nil {
+		return 0, false
+	}
+	percentage, err := strconv.ParseFloat(aws.StringValue(u.Utilization.UtilizationPercentage), 64)
+	return percentage, err == nil
+}
+
+// Unused returns the commitment not used over the period, in the currency of
+// the plan
+func (u *SavingsPlanUsage) Unused() float64 {
+	if u.Utilization == nil {
+		return 0
+	}
+	unused, _ := strconv.ParseFloat(aws.StringValue(u.Utilization.UnusedCommitment), 64)
+	return unused
+}
+
+// End returns the end of the term of the plan
+func (u *SavingsPlanUsage) End() (time.Time, error) {
+	return time.Parse(time.RFC3339, aws.StringValue(u.Plan.End))
+}
+
+// SavingsPlanRegion returns the region of plan, us-east-1 for plans that
+// apply to every region and have none
+func SavingsPlanRegion(plan *savingsplans.SavingsPlan) string {
+	if region := aws.StringValue(plan.Region); len(region) > 0 {
+		return region
+	}
+	return globalRegion
+}
+
+// UtilizationPeriod returns the Cost Explorer period of the days before now,
+// the current day has no data yet
+func UtilizationPeriod(now time.Time, days int) *costexplorer.DateInterval {
+	end := now.UTC()
+	return &costexplorer.DateInterval{
+		Start: aws.String(end.AddDate(0, 0, -days).Format("2006-01-02")),
+		End:   aws.String(end.Format("2006-01-02")),
+	}
+}
+
+// SavingsPlans returns the active savings plans of region ordered by end
+// date, with their utilization over period. Cost Explorer has no data for a
+// plan during its first day
+func SavingsPlans(pager *utils.Pager, plansClient utils.SavingsPlanDescriber, costClient utils.SavingsPlansUtilizationGetter, region string, period *costexplorer.DateInterval) ([]*SavingsPlanUsage, error) {
+	plans, err := pager.SavingsPlans(plansClient, &savingsplans.DescribeSavingsPlansInput{
+		States: aws.StringSlice([]string{savingsplans.SavingsPlanStateActive}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	usages := []*SavingsPlanUsage{}
+	byArn := make(map[string]*SavingsPlanUsage)
+	for _, plan := range plans {
+		if SavingsPlanRegion(plan) != region {
+			continue
+		}
+		usage := &SavingsPlanUsage{Plan: plan}
+		usages = append(usages, usage)
+		byArn[aws.StringValue(plan.SavingsPlanArn)] = usage
+	}
+	if len(usages) == 0 {
+		return usages, nil
+	}
+
+	arns := []string{}
+	for arn := range byArn {
+		arns = append(arns, arn)
+	}
+	sort.Strings(arns)
+	details, err := pager.SavingsPlansUtilizationDetails(costClient, &costexplorer.GetSavingsPlansUtilizationDetailsInput{
+		TimePeriod: period,
+		Filter: &costexplorer.Expression{Dimensions: &costexplorer.DimensionValues{
+			Key:    aws.String(costexplorer.DimensionSavingsPlanArn),
+			Values: aws.StringSlice(arns),
+		}},
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == costexplorer.ErrCodeDataUnavailableException {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, detail := range details {
+		if usage, ok := byArn[aws.StringValue(detail.SavingsPlanArn)]; ok {
+			usage.Utilization = detail.Utilization
+		}
+	}
+	sort.SliceStable(usages, func(i, j int) bool {
+		return aws.StringValue(usages[i].Plan.End) < aws.StringValue(usages[j].Plan.End)
+	})
+	return usages, nil
+}
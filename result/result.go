@@ -0,0 +1,247 @@
+package result
+
+/*
+collects per-resource findings for a check run, picks the worst
+severity and renders a Sensu compatible status line and exit code
+*/
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Status is a Sensu check status, its value is the process exit code
+type Status int
+
+const (
+	OK Status = iota
+	Warning
+	Critical
+	Unknown
+)
+
+// exit is replaced in tests
+var exit = os.Exit
+
+func (s Status) String() string {
+	switch s {
+	case OK:
+		return "OK"
+	case Warning:
+		return "WARNING"
+	case Critical:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ParseStatus returns the status named s in any case: ok, warning, critical
+// or unknown
+func ParseStatus(s string) (Status, error) {
+	for _, status := range []Status{OK, Warning, Critical, Unknown} {
+		if strings.EqualFold(s, status.String()) {
+			return status, nil
+		}
+	}
+	return Unknown, fmt.Errorf("invalid status %q, expected ok, warning, critical or unknown", s)
+}
+
+// severity orders statuses so that CRITICAL > WARNING > UNKNOWN > OK
+func (s Status) severity() int {
+	switch s {
+	case OK:
+		return 0
+	case Unknown:
+		return 1
+	case Warning:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// Worse reports whether s is more severe than other
+func (s Status) Worse(other Status) bool {
+	return s.severity() > other.severity()
+}
+
+// Finding is the outcome of evaluating a single resource
+type Finding struct {
+	Resource string
+	Status   Status
+	Message  string
+}
+
+func (f Finding) String() string {
+	if len(f.Resource) == 0 {
+		return f.Message
+	}
+	return fmt.Sprintf("%s: %s", f.Resource, f.Message)
+}
+
+// Result accumulates findings, it is safe for concurrent use
+type Result struct {
+	Name      string
+	mu        sync.Mutex
+	findings  []Finding
+	okMessage string
+}
+
+func New(name string) *Result {
+	return &Result{Name: name}
+}
+
+// Add records a finding for resource with the given status
+func (r *Result) Add(status Status, resource string, format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.findings = append(r.findings, Finding{
+		Resource: resource,
+		Status:   status,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (r *Result) OK(resource string, format string, args ...interface{}) {
+	r.Add(OK, resource, format, args...)
+}
+
+func (r *Result) Warning(resource string, format string, args ...interface{}) {
+	r.Add(Warning, resource, format, args...)
+}
+
+func (r *Result) Critical(resource string, format string, args ...interface{}) {
+	r.Add(Critical, resource, format, args...)
+}
+
+func (r *Result) Unknown(resource string, format string, args ...interface{}) {
+	r.Add(Unknown, resource, format, args...)
+}
+
+// Error records err as an UNKNOWN finding for resource
+func (r *Result) Error(resource string, err error) {
+	r.Unknown(resource, "%v", err)
+}
+
+// SetOKMessage sets the summary used when no finding is worse than OK
+func (r *Result) SetOKMessage(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.okMessage = fmt.Sprintf(format, args...)
+}
+
+// Merge copies the findings of other into r
+func (r *Result) Merge(other *Result) {
+	for _, finding := range other.Findings() {
+		r.Add(finding.Status, finding.Resource, "%s", finding.Message)
+	}
+}
+
+// MergePrefixed copies the findings of other into r with prefix prepended
+// to their resource, findings without a resource use prefix alone
+func (r *Result) MergePrefixed(prefix string, other *Result) {
+	for _, finding := range other.Findings() {
+		resource := prefix
+		if len(finding.Resource) > 0 {
+			resource = fmt.Sprintf("%s/%s", prefix, finding.Resource)
+		}
+		r.Add(finding.Status, resource, "%s", finding.Message)
+	}
+}
+
+// Findings returns the recorded findings, most severe first
+func (r *Result) Findings() []Finding {
+	r.mu.Lock()
+	findings := make([]Finding, len(r.findings))
+	copy(findings, r.findings)
+	r.mu.Unlock()
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Status.Worse(findings[j].Status)
+	})
+	return findings
+}
+
+// Status returns the worst status of all findings, OK when there are none
+func (r *Result) Status() Status {
+	status := OK
+	for _, finding := range r.Findings() {
+		if finding.Status.Worse(status) {
+			status = finding.Status
+		}
+	}
+	return status
+}
+
+// Summary returns the text of the status line without the check name and status
+func (r *Result) Summary() string {
+	findings := r.Findings()
+	status := r.Status()
+	if status == OK {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if len(r.okMessage) > 0 {
+			return r.okMessage
+		}
+		if len(findings) == 1 {
+			return findings[0].String()
+		}
+		return fmt.Sprintf("%d resource(s) checked", len(findings))
+	}
+
+	counts := make(map[Status]int)
+	for _, finding := range findings {
+		counts[finding.Status]++
+	}
+	if counts[status] == 1 {
+		return findings[0].String()
+	}
+	parts := []string{}
+	for _, s := range []Status{Critical, Warning, Unknown} {
+		if counts[s] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[s], strings.ToLower(s.String())))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Err returns nil when the result is OK, otherwise an error carrying the summary
+func (r *Result) Err() error {
+	if r.Status() == OK {
+		return nil
+	}
+	return errors.New(r.Summary())
+}
+
+// Write renders the status line followed by one line per finding
+func (r *Result) Write(w io.Writer) error {
+	summary := r.Summary()
+	line := fmt.Sprintf("%s: %s", r.Status(), summary)
+	if len(r.Name) > 0 {
+		line = fmt.Sprintf("%s %s", r.Name, line)
+	}
+	if _, err := fmt.Fprintln(w, line); err != nil {
+		return err
+	}
+	findings := r.Findings()
+	if len(findings) == 1 && findings[0].String() == summary {
+		return nil
+	}
+	for _, finding := range findings {
+		if _, err := fmt.Fprintf(w, "%s %s\n", finding.Status, finding); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Exit writes the result to stdout and exits with the result status
+func (r *Result) Exit() {
+	_ = r.Write(os.Stdout)
+	exit(int(r.Status()))
+}
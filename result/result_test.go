@@ -0,0 +1,170 @@
+package result
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		Name      string
+		Findings  []Finding
+		ExpStatus Status
+	}{
+		{
+			Name:      "no findings",
+			ExpStatus: OK,
+		},
+		{
+			Name: "only ok findings",
+			Findings: []Finding{
+				{Resource: "a", Status: OK},
+				{Resource: "b", Status: OK},
+			},
+			ExpStatus: OK,
+		},
+		{
+			Name: "warning beats unknown",
+			Findings: []Finding{
+				{Resource: "a", Status: Unknown},
+				{Resource: "b", Status: Warning},
+			},
+			ExpStatus: Warning,
+		},
+		{
+			Name: "critical beats everything",
+			Findings: []Finding{
+				{Resource: "a", Status: Warning},
+				{Resource: "b", Status: Critical},
+				{Resource: "c", Status: Unknown},
+				{Resource: "d", Status: OK},
+			},
+			ExpStatus: Critical,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := New("test")
+			for _, finding := range test.Findings {
+				r.Add(finding.Status, finding.Resource, "message")
+			}
+			if got, want := r.Status(), test.ExpStatus; got != want {
+				t.Errorf("bad status: got %s, want %s", got, want)
+			}
+			if got, want := r.Err() != nil, test.ExpStatus != OK; got != want {
+				t.Errorf("conflicting error expectations: got (err != nil) == %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	tests := []struct {
+		Name      string
+		ExpStatus Status
+		ExpErr    bool
+	}{
+		{Name: "ok", ExpStatus: OK},
+		{Name: "Warning", ExpStatus: Warning},
+		{Name: "CRITICAL", ExpStatus: Critical},
+		{Name: "unknown", ExpStatus: Unknown},
+		{Name: "error", ExpStatus: Unknown, ExpErr: true},
+		{Name: "", ExpStatus: Unknown, ExpErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			status, err := ParseStatus(test.Name)
+			if got, want := err != nil, test.ExpErr; got != want {
+				t.Fatalf("bad error: got %v", err)
+			}
+			if got, want := status, test.ExpStatus; got != want {
+				t.Errorf("bad status: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Setup  func(*Result)
+		Output string
+	}{
+		{
+			Name:   "no findings",
+			Setup:  func(*Result) {},
+			Output: "check OK: 0 resource(s) checked\n",
+		},
+		{
+			Name: "ok message",
+			Setup: func(r *Result) {
+				r.SetOKMessage("all %d buckets found", 2)
+			},
+			Output: "check OK: all 2 buckets found\n",
+		},
+		{
+			Name: "single finding",
+			Setup: func(r *Result) {
+				r.Critical("bucket-a", "bucket not found")
+			},
+			Output: "check CRITICAL: bucket-a: bucket not found\n",
+		},
+		{
+			Name: "several findings",
+			Setup: func(r *Result) {
+				r.OK("i-1", "healthy")
+				r.Warning("i-2", "degraded")
+				r.Critical("i-3", "down")
+				r.Error("", errors.New("throttled"))
+			},
+			Output: "check CRITICAL: i-3: down\n" +
+				"CRITICAL i-3: down\n" +
+				"WARNING i-2: degraded\n" +
+				"UNKNOWN throttled\n" +
+				"OK i-1: healthy\n",
+		},
+		{
+			Name: "several findings with the same status",
+			Setup: func(r *Result) {
+				r.Critical("i-1", "down")
+				r.Critical("i-2", "down")
+				r.Warning("i-3", "degraded")
+			},
+			Output: "check CRITICAL: 2 critical, 1 warning\n" +
+				"CRITICAL i-1: down\n" +
+				"CRITICAL i-2: down\n" +
+				"WARNING i-3: degraded\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := New("check")
+			test.Setup(r)
+			var buf bytes.Buffer
+			if err := r.Write(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), test.Output; got != want {
+				t.Errorf("bad output: got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestExit(t *testing.T) {
+	var code int
+	exit = func(c int) { code = c }
+	defer func() { exit = os.Exit }()
+
+	r := New("check")
+	r.Warning("vol-1", "burst balance low")
+	r.Exit()
+	if got, want := code, 1; got != want {
+		t.Errorf("bad exit code: got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,269 @@
+package threshold
+
+/*
+parses Nagios style threshold ranges and maps a value onto a Sensu status
+
+  10        alert if value < 0 or value > 10
+  10:       alert if value < 10
+  ~:10      alert if value > 10
+  10:20     alert if value < 10 or value > 20
+  @10:20    alert if 10 <= value <= 20
+  (10:20]   bounds are inclusive unless wrapped in '(' or ')'
+  80%       bounds are a percentage of a total, see Thresholds.StatusOf
+*/
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/sensu/sensu-aws/result"
+)
+
+// Range is a parsed threshold range
+type Range struct {
+	Start          float64
+	End            float64
+	StartExclusive bool
+	EndExclusive   bool
+	// Inside alerts when the value is inside the range instead of outside
+	Inside bool
+	// Percent marks bounds expressed as a percentage of a total
+	Percent bool
+}
+
+// Parse parses a range in Nagios threshold syntax
+func Parse(spec string) (*Range, error) {
+	s := strings.TrimSpace(spec)
+	if len(s) == 0 {
+		return nil, fmt.Errorf("empty threshold range")
+	}
+	r := &Range{}
+	if strings.HasPrefix(s, "@") {
+		r.Inside = true
+		s = s[1:]
+	}
+	if strings.Contains(s, "%") {
+		r.Percent = true
+		s = strings.Replace(s, "%", "", -1)
+	}
+	if strings.HasPrefix(s, "(") {
+		r.StartExclusive = true
+		s = s[1:]
+	} else if strings.HasPrefix(s, "[") {
+		s = s[1:]
+	}
+	if strings.HasSuffix(s, ")") {
+		r.EndExclusive = true
+		s = s[:len(s)-1]
+	} else if strings.HasSuffix(s, "]") {
+		s = s[:len(s)-1]
+	}
+
+	start, end := "0", s
+	if i := strings.Index(s, ":"); i >= 0 {
+		start, end = s[:i], s[i+1:]
+	}
+
+	var err error
+	switch strings.TrimSpace(start) {
+	case "~":
+		r.Start = math.Inf(-1)
+	case "":
+		r.Start = 0
+	default:
+		if r.Start, err = strconv.ParseFloat(strings.TrimSpace(start), 64); err != nil {
+			return nil, fmt.Errorf("invalid threshold range %q: %v", spec, err)
+		}
+	}
+	if len(strings.TrimSpace(end)) == 0 {
+		r.End = math.Inf(1)
+	} else if r.End, err = strconv.ParseFloat(strings.TrimSpace(end), 64); err != nil {
+		return nil, fmt.Errorf("invalid threshold range %q: %v", spec, err)
+	}
+	if r.Start > r.End {
+		return nil, fmt.Errorf("invalid threshold range %q: start is greater than end", spec)
+	}
+	return r, nil
+}
+
+// Operator builds the range that alerts when value <op> threshold holds.
+// Supported operators: equal, not, greater, greater_equal, less, less_equal
+func Operator(op string, threshold float64) (*Range, error) {
+	switch op {
+	case "equal":
+		return &Range{Start: threshold, End: threshold, Inside: true}, nil
+	case "not":
+		return &Range{Start: threshold, End: threshold}, nil
+	case "greater":
+		return &Range{Start: math.Inf(-1), End: threshold}, nil
+	case "greater_equal":
+		return &Range{Start: math.Inf(-1), End: threshold, EndExclusive: true}, nil
+	case "less":
+		return &Range{Start: threshold, End: math.Inf(1)}, nil
+	case "less_equal":
+		return &Range{Start: threshold, End: math.Inf(1), StartExclusive: true}, nil
+	}
+	return nil, fmt.Errorf("invalid compare operator %q", op)
+}
+
+// Contains reports whether value lies within the bounds of r
+func (r *Range) Contains(value float64) bool {
+	if value < r.Start || (r.StartExclusive && value == r.Start) {
+		return false
+	}
+	if value > r.End || (r.EndExclusive && value == r.End) {
+		return false
+	}
+	return true
+}
+
+// Alert reports whether value violates r
+func (r *Range) Alert(value float64) bool {
+	if r.Inside {
+		return r.Contains(value)
+	}
+	return !r.Contains(value)
+}
+
+func (r *Range) String() string {
+	var b strings.Builder
+	if r.Inside {
+		b.WriteString("@")
+	}
+	if r.StartExclusive {
+		b.WriteString("(")
+	}
+	if r.Start == r.End && !r.StartExclusive && !r.EndExclusive {
+		b.WriteString(r.bound(r.Start))
+		b.WriteString(":")
+		b.WriteString(r.bound(r.End))
+		return b.String()
+	}
+	if math.IsInf(r.Start, -1) {
+		b.WriteString("~")
+	} else {
+		b.WriteString(r.bound(r.Start))
+	}
+	b.WriteString(":")
+	if !math.IsInf(r.End, 1) {
+		b.WriteString(r.bound(r.End))
+	}
+	if r.EndExclusive {
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+func (r *Range) bound(value float64) string {
+	s := strconv.FormatFloat(value, 'f', -1, 64)
+	if r.Percent {
+		s += "%"
+	}
+	return s
+}
+
+// Thresholds pairs an optional warning and critical range
+type Thresholds struct {
+	Warning  *Range
+	Critical *Range
+}
+
+// New parses warning and critical ranges, an empty string leaves the range unset
+func New(warning string, critical string) (Thresholds, error) {
+	var t Thresholds
+	err := t.Override(warning, critical)
+	return t, err
+}
+
+// FromOperator builds thresholds alerting when value <op> warning or value <op> critical
+func FromOperator(op string, warning float64, critical float64) (Thresholds, error) {
+	var t Thresholds
+	var err error
+	if t.Warning, err = Operator(op, warning); err != nil {
+		return t, err
+	}
+	if t.Critical, err = Operator(op, critical); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// Over alerts when the value is greater than warning or critical
+func Over(warning float64, critical float64) Thresholds {
+	t, _ := FromOperator("greater", warning, critical)
+	return t
+}
+
+// Under alerts when the value is less than warning or critical
+func Under(warning float64, critical float64) Thresholds {
+	t, _ := FromOperator("less", warning, critical)
+	return t
+}
+
+// Override replaces the warning and/or critical range with the non empty specs
+func (t *Thresholds) Override(warning string, critical string) error {
+	if len(strings.TrimSpace(warning)) > 0 {
+		r, err := Parse(warning)
+		if err != nil {
+			return err
+		}
+		t.Warning = r
+	}
+	if len(strings.TrimSpace(critical)) > 0 {
+		r, err := Parse(critical)
+		if err != nil {
+			return err
+		}
+		t.Critical = r
+	}
+	return nil
+}
+
+// Status returns Critical if value violates the critical range, otherwise
+// Warning if it violates the warning range, otherwise OK
+func (t Thresholds) Status(value float64) result.Status {
+	return t.status(value, value)
+}
+
+// StatusOf is Status for a value that is part of total, percent ranges are
+// compared against value as a percentage of total
+func (t Thresholds) StatusOf(value float64, total float64) result.Status {
+	var percent float64
+	if total != 0 {
+		percent = value / total * 100
+	}
+	return t.status(value, percent)
+}
+
+func (t Thresholds) status(value float64, percent float64) result.Status {
+	if alert(t.Critical, value, percent) {
+		return result.Critical
+	}
+	if alert(t.Warning, value, percent) {
+		return result.Warning
+	}
+	return result.OK
+}
+
+// Range returns the range that produced status, nil for OK
+func (t Thresholds) Range(status result.Status) *Range {
+	switch status {
+	case result.Critical:
+		return t.Critical
+	case result.Warning:
+		return t.Warning
+	}
+	return nil
+}
+
+func alert(r *Range, value float64, percent float64) bool {
+	if r == nil {
+		return false
+	}
+	if r.Percent {
+		return r.Alert(percent)
+	}
+	return r.Alert(value)
+}
@@ -0,0 +1,147 @@
+package threshold
+
+import (
+	"testing"
+
+	"github.com/sensu/sensu-aws/result"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		Spec     string
+		Alerts   []float64
+		NoAlerts []float64
+		String   string
+		ExpError bool
+	}{
+		{
+			Spec:     "10",
+			Alerts:   []float64{-1, 10.5},
+			NoAlerts: []float64{0, 5, 10},
+			String:   "0:10",
+		},
+		{
+			Spec:     "10:",
+			Alerts:   []float64{9.9},
+			NoAlerts: []float64{10, 1000},
+			String:   "10:",
+		},
+		{
+			Spec:     "~:20",
+			Alerts:   []float64{20.1},
+			NoAlerts: []float64{-1000, 20},
+			String:   "~:20",
+		},
+		{
+			Spec:     "@5:10",
+			Alerts:   []float64{5, 7, 10},
+			NoAlerts: []float64{4.9, 10.1},
+			String:   "@5:10",
+		},
+		{
+			Spec:     "(5:10)",
+			Alerts:   []float64{5, 10},
+			NoAlerts: []float64{5.1, 9.9},
+			String:   "(5:10)",
+		},
+		{
+			Spec:     "80%:",
+			Alerts:   []float64{79},
+			NoAlerts: []float64{80},
+			String:   "80%:",
+		},
+		{
+			Spec:     "",
+			ExpError: true,
+		},
+		{
+			Spec:     "20:10",
+			ExpError: true,
+		},
+		{
+			Spec:     "a:b",
+			ExpError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Spec, func(t *testing.T) {
+			r, err := Parse(test.Spec)
+			if got, want := err != nil, test.ExpError; got != want {
+				t.Fatalf("conflicting error expectations: got (err != nil) == %v, want %v", got, want)
+			}
+			if err != nil {
+				return
+			}
+			for _, value := range test.Alerts {
+				if !r.Alert(value) {
+					t.Errorf("expected %v to alert", value)
+				}
+			}
+			for _, value := range test.NoAlerts {
+				if r.Alert(value) {
+					t.Errorf("expected %v not to alert", value)
+				}
+			}
+			if got, want := r.String(), test.String; got != want {
+				t.Errorf("bad string: got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestThresholds(t *testing.T) {
+	tests := []struct {
+		Name      string
+		Op        string
+		Warning   float64
+		Critical  float64
+		Value     float64
+		ExpStatus result.Status
+	}{
+		{Name: "greater critical", Op: "greater", Warning: 5, Critical: 10, Value: 11, ExpStatus: result.Critical},
+		{Name: "greater warning", Op: "greater", Warning: 5, Critical: 10, Value: 10, ExpStatus: result.Warning},
+		{Name: "greater ok", Op: "greater", Warning: 5, Critical: 10, Value: 5, ExpStatus: result.OK},
+		{Name: "greater_equal", Op: "greater_equal", Warning: 5, Critical: 10, Value: 10, ExpStatus: result.Critical},
+		{Name: "less", Op: "less", Warning: 5, Critical: 2, Value: 3, ExpStatus: result.Warning},
+		{Name: "less_equal", Op: "less_equal", Warning: 5, Critical: 2, Value: 2, ExpStatus: result.Critical},
+		{Name: "equal", Op: "equal", Warning: 2, Critical: 1, Value: 1, ExpStatus: result.Critical},
+		{Name: "not reports critical only", Op: "not", Warning: 2, Critical: 1, Value: 3, ExpStatus: result.Critical},
+		{Name: "not ok", Op: "not", Warning: 1, Critical: 1, Value: 1, ExpStatus: result.OK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			th, err := FromOperator(test.Op, test.Warning, test.Critical)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := th.Status(test.Value), test.ExpStatus; got != want {
+				t.Errorf("bad status: got %s, want %s", got, want)
+			}
+		})
+	}
+
+	if _, err := FromOperator("bigger", 1, 2); err == nil {
+		t.Error("expected an error for an unknown operator")
+	}
+}
+
+func TestStatusOf(t *testing.T) {
+	th, err := New("50%:", "25%:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := th.StatusOf(1, 5), result.Critical; got != want {
+		t.Errorf("bad status: got %s, want %s", got, want)
+	}
+	if got, want := th.StatusOf(2, 4), result.OK; got != want {
+		t.Errorf("bad status: got %s, want %s", got, want)
+	}
+	if err := th.Override("", "3:"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := th.StatusOf(2, 5), result.Critical; got != want {
+		t.Errorf("bad status: got %s, want %s", got, want)
+	}
+}
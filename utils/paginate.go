@@ -0,0 +1,385 @@
+package utils
+
+/*
+collects every page of the Describe/List calls used by the plugins, with an
+optional cap on the number of items collected per call
+*/
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/costexplorer"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elb"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/savingsplans"
+	"github.com/sensu-community/sensu-plugin-sdk/sensu"
+	"github.com/sensu/sensu-aws/result"
+)
+
+// Pager follows NextToken/Marker until the last page, a nil Pager collects
+// every page without limit. It is safe for concurrent use
+type Pager struct {
+	// MaxItems caps the items collected per call, 0 disables the cap
+	MaxItems int
+
+	mu        sync.Mutex
+	truncated []string
+}
+
+// PluginConfigOptions returns the max-items option
+func (p *Pager) PluginConfigOptions() []*sensu.PluginConfigOption {
+	return []*sensu.PluginConfigOption{
+		{Path: "max-items", Env: "AWS_MAX_ITEMS", Argument: "max-items", Default: 0, Usage: "Maximum number of items collected from each paginated AWS API call, 0 for no limit", Value: &p.MaxItems},
+	}
+}
+
+// Truncated returns the calls whose results were cut at MaxItems
+func (p *Pager) Truncated() []string {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.truncated...)
+}
+
+// Report adds a WARNING finding to res for every truncated call
+func (p *Pager) Report(res *result.Result) {
+	for _, call := range p.Truncated() {
+		res.Warning("", "%s results truncated to %d items", call, p.MaxItems)
+	}
+}
+
+// keep returns how many of the n items of a page fit under the cap given the
+// items already collected, and whether the next page should be requested
+func (p *Pager) keep(call string, collected, n int, lastPage bool) (int, bool) {
+	if p == nil || p.MaxItems <= 0 {
+		return n, true
+	}
+	remaining := p.MaxItems - collected
+	if remaining < 0 {
+		remaining = 0
+	}
+	if n < remaining || (n == remaining && lastPage) {
+		return n, true
+	}
+	if n > remaining || !lastPage {
+		p.mu.Lock()
+		p.truncated = append(p.truncated, call)
+		p.mu.Unlock()
+	}
+	return remaining, false
+}
+
+// InstanceDescriber is implemented by *ec2.EC2
+type InstanceDescriber interface {
+	DescribeInstancesPages(*ec2.DescribeInstancesInput, func(*ec2.DescribeInstancesOutput, bool) bool) error
+}
+
+// Reservations returns the reservations of every page of input
+func (p *Pager) Reservations(client InstanceDescriber, input *ec2.DescribeInstancesInput) ([]*ec2.Reservation, error) {
+	var reservations []*ec2.Reservation
+	err := client.DescribeInstancesPages(input, func(output *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		n, more := p.keep("DescribeInstances", len(reservations), len(output.Reservations), lastPage)
+		reservations = append(reservations, output.Reservations[:n]...)
+		return more
+	})
+	return reservations, err
+}
+
+// InstanceStatusDescriber is implemented by *ec2.EC2
+type InstanceStatusDescriber interface {
+	DescribeInstanceStatusPages(*ec2.DescribeInstanceStatusInput, func(*ec2.DescribeInstanceStatusOutput, bool) bool) error
+}
+
+// InstanceStatuses returns the instance statuses of every page of input
+func (p *Pager) InstanceStatuses(client InstanceStatusDescriber, input *ec2.DescribeInstanceStatusInput) ([]*ec2.InstanceStatus, error) {
+	var statuses []*ec2.InstanceStatus
+	err := client.DescribeInstanceStatusPages(input, func(output *ec2.DescribeInstanceStatusOutput, lastPage bool) bool {
+		n, more := p.keep("DescribeInstanceStatus", len(statuses), len(output.InstanceStatuses), lastPage)
+		statuses = append(statuses, output.InstanceStatuses[:n]...)
+		return more
+	})
+	return statuses, err
+}
+
+// VolumeDescriber is implemented by *ec2.EC2
+type VolumeDescriber interface {
+	DescribeVolumesPages(*ec2.DescribeVolumesInput, func(*ec2.DescribeVolumesOutput, bool) bool) error
+}
+
+// Volumes returns the volumes of every page of input
+func (p *Pager) Volumes(client VolumeDescriber, input *ec2.DescribeVolumesInput) ([]*ec2.Volume, error) {
+	var volumes []*ec2.Volume
+	err := client.DescribeVolumesPages(input, func(output *ec2.DescribeVolumesOutput, lastPage bool) bool {
+		n, more := p.keep("DescribeVolumes", len(volumes), len(output.Volumes), lastPage)
+		volumes = append(volumes, output.Volumes[:n]...)
+		return more
+	})
+	return volumes, err
+}
+
+// VolumeStatusDescriber is implemented by *ec2.EC2
+type VolumeStatusDescriber interface {
+	DescribeVolumeStatusPages(*ec2.DescribeVolumeStatusInput, func(*ec2.DescribeVolumeStatusOutput, bool) bool) error
+}
+
+// VolumeStatuses returns the volume statuses of every page of input
+func (p *Pager) VolumeStatuses(client VolumeStatusDescriber, input *ec2.DescribeVolumeStatusInput) ([]*ec2.VolumeStatusItem, error) {
+	var statuses []*ec2.VolumeStatusItem
+	err := client.DescribeVolumeStatusPages(input, func(output *ec2.DescribeVolumeStatusOutput, lastPage bool) bool {
+		n, more := p.keep("DescribeVolumeStatus", len(statuses), len(output.VolumeStatuses), lastPage)
+		statuses = append(statuses, output.VolumeStatuses[:n]...)
+		return more
+	})
+	return statuses, err
+}
+
+// SnapshotDescriber is implemented by *ec2.EC2
+type SnapshotDescriber interface {
+	DescribeSnapshotsPages(*ec2.DescribeSnapshotsInput, func(*ec2.DescribeSnapshotsOutput, bool) bool) error
+}
+
+// Snapshots returns the snapshots of every page of input
+func (p *Pager) Snapshots(client SnapshotDescriber, input *ec2.DescribeSnapshotsInput) ([]*ec2.Snapshot, error) {
+	var snapshots []*ec2.Snapshot
+	err := client.DescribeSnapshotsPages(input, func(output *ec2.DescribeSnapshotsOutput, lastPage bool) bool {
+		n, more := p.keep("DescribeSnapshots", len(snapshots), len(output.Snapshots), lastPage)
+		snapshots = append(snapshots, output.Snapshots[:n]...)
+		return more
+	})
+	return snapshots, err
+}
+
+// TagDescriber is implemented by *ec2.EC2
+type TagDescriber interface {
+	DescribeTagsPages(*ec2.DescribeTagsInput, func(*ec2.DescribeTagsOutput, bool) bool) error
+}
+
+// Tags returns the tags of every page of input
+func (p *Pager) Tags(client TagDescriber, input *ec2.DescribeTagsInput) ([]*ec2.TagDescription, error) {
+	var tags []*ec2.TagDescription
+	err := client.DescribeTagsPages(input, func(output *ec2.DescribeTagsOutput, lastPage bool) bool {
+		n, more := p.keep("DescribeTags", len(tags), len(output.Tags), lastPage)
+		tags = append(tags, output.Tags[:n]...)
+		return more
+	})
+	return tags, err
+}
+
+// AlarmDescriber is implemented by *cloudwatch.CloudWatch
+type AlarmDescriber interface {
+	DescribeAlarmsPages(*cloudwatch.DescribeAlarmsInput, func(*cloudwatch.DescribeAlarmsOutput, bool) bool) error
+}
+
+// Alarms returns the metric alarms of every page of input
+func (p *Pager) Alarms(client AlarmDescriber, input *cloudwatch.DescribeAlarmsInput) ([]*cloudwatch.MetricAlarm, error) {
+	var alarms []*cloudwatch.MetricAlarm
+	err := client.DescribeAlarmsPages(input, func(output *cloudwatch.DescribeAlarmsOutput, lastPage bool) bool {
+		n, more := p.keep("DescribeAlarms", len(alarms), len(output.MetricAlarms), lastPage)
+		alarms = append(alarms, output.MetricAlarms[:n]...)
+		return more
+	})
+	return alarms, err
+}
+
+// CompositeAlarms returns the composite alarms of every page of input, which
+// must request the CompositeAlarm alarm type
+func (p *Pager) CompositeAlarms(client AlarmDescriber, input *cloudwatch.DescribeAlarmsInput) ([]*cloudwatch.CompositeAlarm, error) {
+	var alarms []*cloudwatch.CompositeAlarm
+	err := client.DescribeAlarmsPages(input, func(output *cloudwatch.DescribeAlarmsOutput, lastPage bool) bool {
+		n, more := p.keep("DescribeAlarms", len(alarms), len(output.CompositeAlarms), lastPage)
+		alarms = append(alarms, output.CompositeAlarms[:n]...)
+		return more
+	})
+	return alarms, err
+}
+
+// AlarmHistoryDescriber is implemented by *cloudwatch.CloudWatch
+type AlarmHistoryDescriber interface {
+	DescribeAlarmHistoryPages(*cloudwatch.DescribeAlarmHistoryInput, func(*cloudwatch.DescribeAlarmHistoryOutput, bool) bool) error
+}
+
+// AlarmHistory returns the alarm history items of every page of input
+func (p *Pager) AlarmHistory(client AlarmHistoryDescriber, input *cloudwatch.DescribeAlarmHistoryInput) ([]*cloudwatch.AlarmHistoryItem, error) {
+	var items []*cloudwatch.AlarmHistoryItem
+	err := client.DescribeAlarmHistoryPages(input, func(output *cloudwatch.DescribeAlarmHistoryOutput, lastPage bool) bool {
+		n, more := p.keep("DescribeAlarmHistory", len(items), len(output.AlarmHistoryItems), lastPage)
+		items = append(items, output.AlarmHistoryItems[:n]...)
+		return more
+	})
+	return items, err
+}
+
+// AnomalyDetectorDescriber is implemented by *cloudwatch.CloudWatch, the SDK
+// has no Pages variant for this call so the NextToken is followed here
+type AnomalyDetectorDescriber interface {
+	DescribeAnomalyDetectors(*cloudwatch.DescribeAnomalyDetectorsInput) (*cloudwatch.DescribeAnomalyDetectorsOutput, error)
+}
+
+// AnomalyDetectors returns the anomaly detectors of every page of input
+func (p *Pager) AnomalyDetectors(client AnomalyDetectorDescriber, input *cloudwatch.DescribeAnomalyDetectorsInput) ([]*cloudwatch.AnomalyDetector, error) {
+	var detectors []*cloudwatch.AnomalyDetector
+	for {
+		output, err := client.DescribeAnomalyDetectors(input)
+		if err != nil {
+			return detectors, err
+		}
+		lastPage := len(aws.StringValue(output.NextToken)) == 0
+		n, more := p.keep("DescribeAnomalyDetectors", len(detectors), len(output.AnomalyDetectors), lastPage)
+		detectors = append(detectors, output.AnomalyDetectors[:n]...)
+		if lastPage || !more {
+			return detectors, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// SavingsPlanDescriber is implemented by *savingsplans.SavingsPlans, the SDK
+// has no Pages variant for this call so the NextToken is followed here
+type SavingsPlanDescriber interface {
+	DescribeSavingsPlans(*savingsplans.DescribeSavingsPlansInput) (*savingsplans.DescribeSavingsPlansOutput, error)
+}
+
+// SavingsPlans returns the savings plans of every page of input
+func (p *Pager) SavingsPlans(client SavingsPlanDescriber, input *savingsplans.DescribeSavingsPlansInput) ([]*savingsplans.SavingsPlan, error) {
+	var plans []*savingsplans.SavingsPlan
+	for {
+		output, err := client.DescribeSavingsPlans(input)
+		if err != nil {
+			return plans, err
+		}
+		lastPage := len(aws.StringValue(output.NextToken)) == 0
+		n, more := p.keep("DescribeSavingsPlans", len(plans), len(output.SavingsPlans), lastPage)
+		plans = append(plans, output.SavingsPlans[:n]...)
+		if lastPage || !more {
+			return plans, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// SavingsPlansUtilizationGetter is implemented by *costexplorer.CostExplorer
+type SavingsPlansUtilizationGetter interface {
+	GetSavingsPlansUtilizationDetailsPages(*costexplorer.GetSavingsPlansUtilizationDetailsInput, func(*costexplorer.GetSavingsPlansUtilizationDetailsOutput, bool) bool) error
+}
+
+// SavingsPlansUtilizationDetails returns the utilization details of every
+// page of input
+func (p *Pager) SavingsPlansUtilizationDetails(client SavingsPlansUtilizationGetter, input *costexplorer.GetSavingsPlansUtilizationDetailsInput) ([]*costexplorer.SavingsPlansUtilizationDetail, error) {
+	var details []*costexplorer.SavingsPlansUtilizationDetail
+	err := client.GetSavingsPlansUtilizationDetailsPages(input, func(output *costexplorer.GetSavingsPlansUtilizationDetailsOutput, lastPage bool) bool {
+		n, more := p.keep("GetSavingsPlansUtilizationDetails", len(details), len(output.SavingsPlansUtilizationDetails), lastPage)
+		details = append(details, output.SavingsPlansUtilizationDetails[:n]...)
+		return more
+	})
+	return details, err
+}
+
+// ObjectLister is implemented by *s3.S3
+type ObjectLister interface {
+	ListObjectsPages(*s3.ListObjectsInput, func(*s3.ListObjectsOutput, bool) bool) error
+}
+
+// Objects returns the objects of every page of input
+func (p *Pager) Objects(client ObjectLister, input *s3.ListObjectsInput) ([]*s3.Object, error) {
+	var objects []*s3.Object
+	err := client.ListObjectsPages(input, func(output *s3.ListObjectsOutput, lastPage bool) bool {
+		n, more := p.keep("ListObjects", len(objects), len(output.Contents), lastPage)
+		objects = append(objects, output.Contents[:n]...)
+		return more
+	})
+	return objects, err
+}
+
+// DBInstanceDescriber is implemented by *rds.RDS
+type DBInstanceDescriber interface {
+	DescribeDBInstancesPages(*rds.DescribeDBInstancesInput, func(*rds.DescribeDBInstancesOutput, bool) bool) error
+}
+
+// DBInstances returns the DB instances of every page of input
+func (p *Pager) DBInstances(client DBInstanceDescriber, input *rds.DescribeDBInstancesInput) ([]*rds.DBInstance, error) {
+	var instances []*rds.DBInstance
+	err := client.DescribeDBInstancesPages(input, func(output *rds.DescribeDBInstancesOutput, lastPage bool) bool {
+		n, more := p.keep("DescribeDBInstances", len(instances), len(output.DBInstances), lastPage)
+		instances = append(instances, output.DBInstances[:n]...)
+		return more
+	})
+	return instances, err
+}
+
+// DBClusterDescriber is implemented by *rds.RDS
+type DBClusterDescriber interface {
+	DescribeDBClustersPages(*rds.DescribeDBClustersInput, func(*rds.DescribeDBClustersOutput, bool) bool) error
+}
+
+// DBClusters returns the DB clusters of every page of input
+func (p *Pager) DBClusters(client DBClusterDescriber, input *rds.DescribeDBClustersInput) ([]*rds.DBCluster, error) {
+	var clusters []*rds.DBCluster
+	err := client.DescribeDBClustersPages(input, func(output *rds.DescribeDBClustersOutput, lastPage bool) bool {
+		n, more := p.keep("DescribeDBClusters", len(clusters), len(output.DBClusters), lastPage)
+		clusters = append(clusters, output.DBClusters[:n]...)
+		return more
+	})
+	return clusters, err
+}
+
+// EventDescriber is implemented by *rds.RDS
+type EventDescriber interface {
+	DescribeEventsPages(*rds.DescribeEventsInput, func(*rds.DescribeEventsOutput, bool) bool) error
+}
+
+// Events returns the events of every page of input
+func (p *Pager) Events(client EventDescriber, input *rds.DescribeEventsInput) ([]*rds.Event, error) {
+	var events []*rds.Event
+	err := client.DescribeEventsPages(input, func(output *rds.DescribeEventsOutput, lastPage bool) bool {
+		n, more := p.keep("DescribeEvents", len(events), len(output.Events), lastPage)
+		events = append(events, output.Events[:n]...)
+		return more
+	})
+	return events, err
+}
+
+// PendingMaintenanceDescriber is implemented by *rds.RDS, the SDK has no
+// Pages variant for this call so the Marker is followed here
+type PendingMaintenanceDescriber interface {
+	DescribePendingMaintenanceActions(*rds.DescribePendingMaintenanceActionsInput) (*rds.DescribePendingMaintenanceActionsOutput, error)
+}
+
+// PendingMaintenanceActions returns the pending maintenance actions of every page of input
+func (p *Pager) PendingMaintenanceActions(client PendingMaintenanceDescriber, input *rds.DescribePendingMaintenanceActionsInput) ([]*rds.ResourcePendingMaintenanceActions, error) {
+	var actions []*rds.ResourcePendingMaintenanceActions
+	for {
+		output, err := client.DescribePendingMaintenanceActions(input)
+		if err != nil {
+			return actions, err
+		}
+		lastPage := len(aws.StringValue(output.Marker)) == 0
+		n, more := p.keep("DescribePendingMaintenanceActions", len(actions), len(output.PendingMaintenanceActions), lastPage)
+		actions = append(actions, output.PendingMaintenanceActions[:n]...)
+		if lastPage || !more {
+			return actions, nil
+		}
+		input.Marker = output.Marker
+	}
+}
+
+// LoadBalancerDescriber is implemented by *elb.ELB
+type LoadBalancerDescriber interface {
+	DescribeLoadBalancersPages(*elb.DescribeLoadBalancersInput, func(*elb.DescribeLoadBalancersOutput, bool) bool) error
+}
+
+// LoadBalancers returns the load balancers of every page of input
+func (p *Pager) LoadBalancers(client LoadBalancerDescriber, input *elb.DescribeLoadBalancersInput) ([]*elb.LoadBalancerDescription, error) {
+	var loadBalancers []*elb.LoadBalancerDescription
+	err := client.DescribeLoadBalancersPages(input, func(output *elb.DescribeLoadBalancersOutput, lastPage bool) bool {
+		n, more := p.keep("DescribeLoadBalancers", len(loadBalancers), len(output.LoadBalancerDescriptions), lastPage)
+		loadBalancers = append(loadBalancers, output.LoadBalancerDescriptions[:n]...)
+		return more
+	})
+	return loadBalancers, err
+}
@@ -0,0 +1,195 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/savingsplans"
+	"github.com/sensu/sensu-aws/result"
+)
+
+// volumePages serves its pages in order, stopping when the callback does
+type volumePages [][]string
+
+func (v volumePages) DescribeVolumesPages(input *ec2.DescribeVolumesInput, fn func(*ec2.DescribeVolumesOutput, bool) bool) error {
+	for i, page := range v {
+		output := &ec2.DescribeVolumesOutput{}
+		for _, id := range page {
+			output.Volumes = append(output.Volumes, &ec2.Volume{VolumeId: aws.String(id)})
+		}
+		if !fn(output, i == len(v)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestPagerVolumes(t *testing.T) {
+	pages := volumePages{{"a", "b"}, {"c", "d"}, {"e"}}
+
+	tests := []struct {
+		Name         string
+		Pager        *Pager
+		ExpVolumes   int
+		ExpTruncated bool
+	}{
+		{
+			Name:       "nil pager collects every page",
+			ExpVolumes: 5,
+		},
+		{
+			Name:       "no cap",
+			Pager:      &Pager{},
+			ExpVolumes: 5,
+		},
+		{
+			Name:       "cap above the item count",
+			Pager:      &Pager{MaxItems: 10},
+			ExpVolumes: 5,
+		},
+		{
+			Name:       "cap equal to the item count",
+			Pager:      &Pager{MaxItems: 5},
+			ExpVolumes: 5,
+		},
+		{
+			Name:         "cap within a page",
+			Pager:        &Pager{MaxItems: 3},
+			ExpVolumes:   3,
+			ExpTruncated: true,
+		},
+		{
+			Name:         "cap at a page boundary",
+			Pager:        &Pager{MaxItems: 4},
+			ExpVolumes:   4,
+			ExpTruncated: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			volumes, err := test.Pager.Volumes(pages, &ec2.DescribeVolumesInput{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := len(volumes), test.ExpVolumes; got != want {
+				t.Errorf("bad volume count: got %d, want %d", got, want)
+			}
+			res := result.New("test")
+			test.Pager.Report(res)
+			if got, want := res.Status() == result.Warning, test.ExpTruncated; got != want {
+				t.Errorf("bad truncation warning: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// detectorPages serves its pages by NextToken, the token of a page is its
+// index
+type detectorPages [][]string
+
+func (d detectorPages) DescribeAnomalyDetectors(input *cloudwatch.DescribeAnomalyDetectorsInput) (*cloudwatch.DescribeAnomalyDetectorsOutput, error) {
+	page := 0
+	if input.NextToken != nil {
+		fmt.Sscan(*input.NextToken, &page)
+	}
+	output := &cloudwatch.DescribeAnomalyDetectorsOutput{}
+	for _, name := range d[page] {
+		output.AnomalyDetectors = append(output.AnomalyDetectors, &cloudwatch.AnomalyDetector{MetricName: aws.String(name)})
+	}
+	if page < len(d)-1 {
+		output.NextToken = aws.String(fmt.Sprint(page + 1))
+	}
+	return output, nil
+}
+
+func TestPagerAnomalyDetectors(t *testing.T) {
+	pages := detectorPages{{"a", "b"}, {"c"}, {"d", "e"}}
+
+	tests := []struct {
+		Name         string
+		Pager        *Pager
+		ExpDetectors string
+		ExpTruncated bool
+	}{
+		{
+			Name:         "every page",
+			Pager:        &Pager{},
+			ExpDetectors: "abcde",
+		},
+		{
+			Name:         "cap within a page",
+			Pager:        &Pager{MaxItems: 4},
+			ExpDetectors: "abcd",
+			ExpTruncated: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			detectors, err := test.Pager.AnomalyDetectors(pages, &cloudwatch.DescribeAnomalyDetectorsInput{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := ""
+			for _, detector := range detectors {
+				names += *detector.MetricName
+			}
+			if got, want := names, test.ExpDetectors; got != want {
+				t.Errorf("bad detectors: got %q, want %q", got, want)
+			}
+			if got, want := len(test.Pager.Truncated()) > 0, test.ExpTruncated; got != want {
+				t.Errorf("bad truncation: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+// planPages serves its pages by NextToken like detectorPages
+type planPages [][]string
+
+func (p planPages) DescribeSavingsPlans(input *savingsplans.DescribeSavingsPlansInput) (*savingsplans.DescribeSavingsPlansOutput, error) {
+	page := 0
+	if input.NextToken != nil {
+		fmt.Sscan(*input.NextToken, &page)
+	}
+	output := &savingsplans.DescribeSavingsPlansOutput{}
+	for _, id := range p[page] {
+		output.SavingsPlans = append(output.SavingsPlans, &savingsplans.SavingsPlan{SavingsPlanId: aws.String(id)})
+	}
+	if page < len(p)-1 {
+		output.NextToken = aws.String(fmt.Sprint(page + 1))
+	}
+	return output, nil
+}
+
+func TestPagerSavingsPlans(t *testing.T) {
+	pages := planPages{{"a"}, {"b", "c"}}
+
+	plans, err := (&Pager{}).SavingsPlans(pages, &savingsplans.DescribeSavingsPlansInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := ""
+	for _, plan := range plans {
+		ids += *plan.SavingsPlanId
+	}
+	if got, want := ids, "abc"; got != want {
+		t.Errorf("bad savings plans: got %q, want %q", got, want)
+	}
+
+	pager := &Pager{MaxItems: 2}
+	plans, err = pager.SavingsPlans(pages, &savingsplans.DescribeSavingsPlansInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(plans), 2; got != want {
+		t.Errorf("bad capped savings plans: got %d, want %d", got, want)
+	}
+	if len(pager.Truncated()) == 0 {
+		t.Error("expected the capped call to be reported as truncated")
+	}
+}
@@ -4,15 +4,8 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
-func GetReservations(ec2Client *ec2.EC2, filters []*ec2.Filter) ([]*ec2.Reservation, error) {
-	input := &ec2.DescribeInstancesInput{
-		Filters: filters,
-	}
-
-	result, err := ec2Client.DescribeInstances(input)
-	if err != nil {
-		return nil, err
-	}
-
-	return result.Reservations, nil
+// GetReservations returns the reservations of every page matching filters
+func GetReservations(ec2Client InstanceDescriber, filters []*ec2.Filter) ([]*ec2.Reservation, error) {
+	var pager *Pager
+	return pager.Reservations(ec2Client, &ec2.DescribeInstancesInput{Filters: filters})
 }